@@ -2,8 +2,10 @@ package engine
 
 import (
 	"context"
+	"fmt"
 	"time"
 
+	"github.com/containers/fetchit/pkg/engine/utils"
 	"github.com/containers/podman/v4/pkg/bindings/containers"
 	"github.com/containers/podman/v4/pkg/specgen"
 	"github.com/go-git/go-git/v5/plumbing"
@@ -11,13 +13,20 @@ import (
 	"github.com/opencontainers/runtime-spec/specs-go"
 )
 
-const ansibleMethod = "ansible"
+const (
+	ansibleMethod = "ansible"
+	// Values of the connection field of the ansible method
+	ansibleConnectionSSH   = "ssh"
+	ansibleConnectionLocal = "local"
+)
 
 // Ansible to place and run ansible playbooks
 type Ansible struct {
 	CommonMethod `mapstructure:",squash"`
 	// SshDirectory for ansible to connect to host
 	SshDirectory string `mapstructure:"sshDirectory"`
+	// Connection is ssh, the default, or local to run playbooks without ssh
+	Connection string `mapstructure:"connection"`
 }
 
 func (ans *Ansible) GetKind() string {
@@ -25,29 +34,30 @@ func (ans *Ansible) GetKind() string {
 }
 
 func (ans *Ansible) Process(ctx, conn context.Context, skew int) {
+	log := methodLogger(ans)
 	time.Sleep(time.Duration(skew) * time.Millisecond)
 	target := ans.GetTarget()
 	target.mu.Lock()
 	defer target.mu.Unlock()
 
-	tag := []string{"yaml", "yml"}
+	tags := ans.getTags(ansibleMethod)
 	if ans.initialRun {
 		err := getRepo(target)
 		if err != nil {
-			logger.Errorf("Failed to clone repository %s: %v", target.url, err)
+			log.Errorf("Failed to clone repository %s: %v", target.url, err)
 			return
 		}
 
-		err = zeroToCurrent(ctx, conn, ans, target, &tag)
+		err = zeroToCurrent(ctx, conn, ans, target, tags)
 		if err != nil {
-			logger.Errorf("Error moving to current: %v", err)
+			log.Errorf("Error moving to current: %v", err)
 			return
 		}
 	}
 
-	err := currentToLatest(ctx, conn, ans, target, &tag)
+	err := currentToLatest(ctx, conn, ans, target, tags)
 	if err != nil {
-		logger.Errorf("Error moving current to latest: %v", err)
+		log.Errorf("Error moving current to latest: %v", err)
 		return
 	}
 
@@ -59,7 +69,7 @@ func (ans *Ansible) MethodEngine(ctx context.Context, conn context.Context, chan
 }
 
 func (ans *Ansible) Apply(ctx, conn context.Context, currentState, desiredState plumbing.Hash, tags *[]string) error {
-	changeMap, err := applyChanges(ctx, ans.GetTarget(), ans.GetTargetPath(), ans.Glob, currentState, desiredState, tags)
+	changeMap, err := ans.targetChanges(ctx, currentState, desiredState, tags)
 	if err != nil {
 		return err
 	}
@@ -70,41 +80,30 @@ func (ans *Ansible) Apply(ctx, conn context.Context, currentState, desiredState
 }
 
 func (ans *Ansible) ansiblePodman(ctx, conn context.Context, path string) error {
+	log := methodLogger(ans)
 	// TODO: add logic to remove
 	if path == deleteFile {
 		return nil
 	}
-	logger.Infof("Deploying Ansible playbook %s", path)
+	log.Infof("Deploying Ansible playbook %s", path)
 
 	copyFile := ("/opt/" + path)
 	sshImage := "quay.io/fetchit/fetchit-ansible:latest"
 
-	logger.Infof("Identifying if fetchit-ansible image exists locally")
+	log.Infof("Identifying if fetchit-ansible image exists locally")
 	if err := detectOrFetchImage(conn, sshImage, true); err != nil {
 		return err
 	}
 
-	s := specgen.NewSpecGenerator(sshImage, false)
-	s.Name = "ansible" + "-" + ans.Name
-	s.Privileged = true
-	s.PidNS = specgen.Namespace{
-		NSMode: "host",
-		Value:  "",
-	}
-
-	// TODO: Remove rcook entries
-	s.Command = []string{"sh", "-c", "/usr/bin/ansible-playbook -e ansible_connection=ssh " + copyFile}
-	s.Mounts = []specs.Mount{{Source: ans.SshDirectory, Destination: "/root/.ssh", Type: "bind", Options: []string{"rw"}}}
-	s.Volumes = []*specgen.NamedVolume{{Name: fetchitVolume, Dest: "/opt", Options: []string{"ro"}}}
-	s.NetNS = specgen.Namespace{
-		NSMode: "host",
-		Value:  "",
+	s, err := ans.generateSpec(sshImage, copyFile)
+	if err != nil {
+		return err
 	}
 	createResponse, err := containers.CreateWithSpec(conn, s, nil)
 	if err != nil {
 		return err
 	}
-	logger.Infof("Container created.")
+	log.Infof("Container created.")
 	if err := containers.Start(conn, createResponse.ID, nil); err != nil {
 		return err
 	}
@@ -113,6 +112,34 @@ func (ans *Ansible) ansiblePodman(ctx, conn context.Context, path string) error
 	if err != nil {
 		return err
 	}
-	logger.Infof("Container started....Requeuing")
+	log.Infof("Container started....Requeuing")
 	return nil
 }
+
+// generateSpec returns the spec of the helper running a playbook. The ssh
+// directory is only mounted for ssh connections.
+func (ans *Ansible) generateSpec(image, playbook string) (*specgen.SpecGenerator, error) {
+	connection := ans.Connection
+	if connection == "" {
+		connection = ansibleConnectionSSH
+	}
+	if connection != ansibleConnectionSSH && connection != ansibleConnectionLocal {
+		return nil, &utils.ValidationError{Err: fmt.Errorf("invalid ansible connection %s, must be %s or %s", ans.Connection, ansibleConnectionSSH, ansibleConnectionLocal)}
+	}
+
+	s := specgen.NewSpecGenerator(image, false)
+	s.Name = "ansible" + "-" + ans.containerName()
+	s.Labels = helperLabels(ansibleMethod, targetName(ans.GetTarget()))
+	setHelperPrivileges(s)
+
+	s.Command = []string{"sh", "-c", "/usr/bin/ansible-playbook -e ansible_connection=" + connection + " " + playbook}
+	if connection == ansibleConnectionSSH {
+		s.Mounts = []specs.Mount{{Source: ans.SshDirectory, Destination: "/root/.ssh", Type: "bind", Options: []string{"rw"}}}
+	}
+	s.Volumes = []*specgen.NamedVolume{{Name: fetchitVolume, Dest: "/opt", Options: []string{"ro"}}}
+	s.NetNS = specgen.Namespace{
+		NSMode: "host",
+		Value:  "",
+	}
+	return s, nil
+}
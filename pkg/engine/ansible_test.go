@@ -0,0 +1,44 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/containers/fetchit/pkg/engine/utils"
+)
+
+func TestAnsibleGenerateSpec(t *testing.T) {
+	target := &Target{name: "hosts"}
+	ans := &Ansible{CommonMethod: CommonMethod{Name: "ans-ex", target: target}, SshDirectory: "/root/.ssh"}
+	s, err := ans.generateSpec("quay.io/fetchit/fetchit-ansible:latest", "/opt/repo/playbook.yaml")
+	if err != nil {
+		t.Fatalf("Failed: unexpected error: %v", err)
+	}
+	expected := []string{"sh", "-c", "/usr/bin/ansible-playbook -e ansible_connection=ssh /opt/repo/playbook.yaml"}
+	if !reflect.DeepEqual(s.Command, expected) {
+		t.Fatalf("Failed: ssh command: %v != %v", s.Command, expected)
+	}
+	if len(s.Mounts) != 1 || s.Mounts[0].Destination != "/root/.ssh" {
+		t.Fatalf("Failed: ssh directory is not mounted: %v", s.Mounts)
+	}
+
+	ans.Connection = ansibleConnectionLocal
+	s, err = ans.generateSpec("quay.io/fetchit/fetchit-ansible:latest", "/opt/repo/playbook.yaml")
+	if err != nil {
+		t.Fatalf("Failed: unexpected error: %v", err)
+	}
+	expected = []string{"sh", "-c", "/usr/bin/ansible-playbook -e ansible_connection=local /opt/repo/playbook.yaml"}
+	if !reflect.DeepEqual(s.Command, expected) {
+		t.Fatalf("Failed: local command: %v != %v", s.Command, expected)
+	}
+	if len(s.Mounts) != 0 {
+		t.Fatalf("Failed: ssh directory is mounted for a local connection: %v", s.Mounts)
+	}
+
+	ans.Connection = "winrm"
+	if _, err := ans.generateSpec("quay.io/fetchit/fetchit-ansible:latest", "/opt/repo/playbook.yaml"); err == nil {
+		t.Fatalf("Failed: expected an error for connection winrm")
+	} else if _, ok := err.(*utils.ValidationError); !ok {
+		t.Fatalf("Failed: expected a validation error, got %v", err)
+	}
+}
@@ -7,16 +7,16 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"os"
+	"io/ioutil"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/containers/fetchit/pkg/engine/utils"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
-	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
 	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
 	"github.com/gobwas/glob"
 	gitsign "github.com/sigstore/gitsign/pkg/git"
@@ -27,9 +27,20 @@ import (
 const (
 	defaultRekorURL = "https://rekor.sigstore.dev"
 	hashReportLen   = 9
+	// defaultFetchReuse is how long the head fetched for a target is shared by
+	// the methods of that target, so methods scheduled together fetch once per run
+	defaultFetchReuse = 30 * time.Second
 )
 
-func applyChanges(ctx context.Context, target *Target, targetPath string, globPattern *string, currentState, desiredState plumbing.Hash, tags *[]string) (map[*object.Change]string, error) {
+// fetchHead fetches the head of a target's branch, tests replace it to count fetches
+var fetchHead = fetchLatest
+
+// checkoutHash checks out a commit in the worktree, tests replace it to count checkouts
+var checkoutHash = func(wt *git.Worktree, hash plumbing.Hash) error {
+	return wt.Checkout(&git.CheckoutOptions{Hash: hash})
+}
+
+func applyChanges(ctx context.Context, target *Target, targetPath string, globPattern *string, include, exclude []string, currentState, desiredState plumbing.Hash, tags *[]string) (map[*object.Change]string, error) {
 	if desiredState.IsZero() {
 		return nil, errors.New("Cannot run Apply if desired state is empty")
 	}
@@ -37,15 +48,15 @@ func applyChanges(ctx context.Context, target *Target, targetPath string, globPa
 
 	currentTree, err := getSubTreeFromHash(directory, currentState, targetPath)
 	if err != nil {
-		return nil, utils.WrapErr(err, "Error getting tree from hash %s", currentState)
+		return nil, &utils.GitError{Err: utils.WrapErr(err, "Error getting tree from hash %s", currentState)}
 	}
 
 	desiredTree, err := getSubTreeFromHash(directory, desiredState, targetPath)
 	if err != nil {
-		return nil, utils.WrapErr(err, "Error getting tree from hash %s", desiredState)
+		return nil, &utils.GitError{Err: utils.WrapErr(err, "Error getting tree from hash %s", desiredState)}
 	}
 
-	changeMap, err := getFilteredChangeMap(directory, targetPath, globPattern, currentTree, desiredTree, tags)
+	changeMap, err := getFilteredChangeMap(directory, targetPath, globPattern, include, exclude, currentTree, desiredTree, tags)
 	if err != nil {
 		return nil, utils.WrapErr(err, "Error getting filtered change map from %s to %s", currentState, desiredState)
 	}
@@ -53,38 +64,87 @@ func applyChanges(ctx context.Context, target *Target, targetPath string, globPa
 	return changeMap, nil
 }
 
-//getLatest will get the head of the branch in the repository specified by the target's url
+// getLatest will get the head of the branch in the repository specified by the target's url,
+// reusing the last fetch of the target when it is more recent than fetchReuse.
+// Callers must hold target.mu.
 func getLatest(target *Target) (plumbing.Hash, error) {
+	if fetchReuse > 0 && !target.latest.IsZero() && time.Since(target.fetchedAt) < fetchReuse {
+		return target.latest, nil
+	}
+	latest, err := fetchHead(target)
+	if err != nil {
+		return plumbing.Hash{}, err
+	}
+	target.latest = latest
+	target.fetchedAt = time.Now()
+	return latest, nil
+}
+
+// fetchLatest fetches the branch of the target and checks out its head, the
+// worktree is left as it is when the head is already checked out
+func fetchLatest(target *Target) (plumbing.Hash, error) {
 	ctx := context.Background()
 	directory := getDirectory(target)
 
+	repo, branch, err := fetchBranch(target)
+	if err != nil {
+		return plumbing.Hash{}, err
+	}
+
+	wt, err := repo.Worktree()
+	if err != nil {
+		return plumbing.Hash{}, utils.WrapErr(err, "Error getting reference to worktree for repository %s", directory)
+	}
+
+	hashStr := branch.Hash().String()[:hashReportLen]
+	// a head on the branch moves with the fetch, only a detached head is at the checked out commit
+	if head, err := repo.Head(); err != nil || head.Name() != plumbing.HEAD || head.Hash() != branch.Hash() {
+		if err := checkoutHash(wt, branch.Hash()); err != nil {
+			return plumbing.Hash{}, utils.WrapErr(err, "Error checking out %s on branch %s", hashStr, target.branch)
+		}
+	}
+
+	if target.gitsignVerify {
+		commit, err := repo.CommitObject(branch.Hash())
+		if err != nil {
+			return plumbing.Hash{}, utils.WrapErr(err, "Error getting verified commit at hash %s from repository %s", hashStr, directory)
+		}
+		if err := VerifyGitsign(ctx, commit, hashStr, directory, target.gitsignRekorURL); err != nil {
+			return plumbing.Hash{}, utils.WrapErr(err, "Requested verified commit signatures, but commit %s from repository %s failed verification", hashStr, directory)
+		}
+	}
+	return branch.Hash(), err
+}
+
+// fetchBranch fetches the branch of the target without checking it out,
+// returning the repository and the reference to the head of the branch
+func fetchBranch(target *Target) (*git.Repository, *plumbing.Reference, error) {
+	directory := getDirectory(target)
+
 	repo, err := git.PlainOpen(directory)
 	if err != nil {
-		return plumbing.Hash{}, utils.WrapErr(err, "Error opening repository %s to fetch latest commit", directory)
+		return nil, nil, utils.WrapErr(err, "Error opening repository %s to fetch latest commit", directory)
 	}
 	if target.envSecret != "" {
 		logger.Infof("Using the envSecret %s", target.envSecret)
-		target.pat = os.Getenv(target.envSecret)
-	}
-	if target.pat != "" {
-		target.username = "fetchit"
-		target.password = target.pat
 	}
 
+	// only the tracked branch is fetched, forced so that a rewritten branch is followed
 	refSpec := config.RefSpec(fmt.Sprintf("+refs/heads/%s:refs/heads/%s", target.branch, target.branch))
 
+	refSpecs := []config.RefSpec{refSpec}
+	if target.fetchTags {
+		refSpecs = append(refSpecs, "+refs/tags/*:refs/tags/*")
+	}
+
 	// default to using existing http method
 	fOptions := &git.FetchOptions{
-		RemoteName: "",
-		RefSpecs:   []config.RefSpec{refSpec, "HEAD:refs/heads/HEAD"},
-		Depth:      0,
-		Auth: &githttp.BasicAuth{
-			Username: target.username,
-			Password: target.password,
-		},
+		RemoteName:      "",
+		RefSpecs:        refSpecs,
+		Depth:           0,
+		Auth:            target.basicAuth(),
 		Progress:        nil,
 		Tags:            0,
-		Force:           true,
 		InsecureSkipTLS: false,
 		CABundle:        []byte{},
 	}
@@ -94,39 +154,19 @@ func getLatest(target *Target) (plumbing.Hash, error) {
 		authValue, err := ssh.NewPublicKeysFromFile("git", target.sshKey, target.password)
 		if err != nil {
 			logger.Infof("generate publickeys failed: %s", err.Error())
-			return plumbing.Hash{}, err
+			return nil, nil, err
 		}
 		fOptions.Auth = authValue
 	}
 	if err = repo.Fetch(fOptions); err != nil && err != git.NoErrAlreadyUpToDate && !target.disconnected {
-		return plumbing.Hash{}, utils.WrapErr(err, "Error fetching branch %s from remote repository %s", target.branch, target.url)
+		return nil, nil, &utils.GitError{Err: utils.WrapErr(err, "Error fetching branch %s from remote repository %s", target.branch, target.url)}
 	}
 
 	branch, err := repo.Reference(plumbing.ReferenceName(fmt.Sprintf("refs/heads/%s", target.branch)), false)
 	if err != nil {
-		return plumbing.Hash{}, utils.WrapErr(err, "Error getting reference to branch %s", target.branch)
-	}
-
-	wt, err := repo.Worktree()
-	if err != nil {
-		return plumbing.Hash{}, utils.WrapErr(err, "Error getting reference to worktree for repository", directory)
-	}
-
-	hashStr := branch.Hash().String()[:hashReportLen]
-	if err := wt.Checkout(&git.CheckoutOptions{Hash: branch.Hash()}); err != nil {
-		return plumbing.Hash{}, utils.WrapErr(err, "Error checking out %s on branch %s", hashStr, target.branch)
-	}
-
-	if target.gitsignVerify {
-		commit, err := repo.CommitObject(branch.Hash())
-		if err != nil {
-			return plumbing.Hash{}, utils.WrapErr(err, "Error getting verified commit at hash %s from repository %s", hashStr, directory)
-		}
-		if err := VerifyGitsign(ctx, commit, hashStr, directory, target.gitsignRekorURL); err != nil {
-			return plumbing.Hash{}, utils.WrapErr(err, "Requested verified commit signatures, but commit %s from repository %s failed verification", hashStr, directory)
-		}
+		return nil, nil, utils.WrapErr(err, "Error getting reference to branch %s", target.branch)
 	}
-	return branch.Hash(), err
+	return repo, branch, nil
 }
 
 // VerifyGitsign verifies any commit signed using sigstore/gitsign & rekor
@@ -189,7 +229,7 @@ func certHexFingerprint(cert *x509.Certificate) string {
 
 func getCurrent(target *Target, methodType, methodName string) (plumbing.Hash, error) {
 	directory := getDirectory(target)
-	tagName := fmt.Sprintf("current-%s-%s", methodType, methodName)
+	tagName := stateTag(methodType, methodName)
 
 	repo, err := git.PlainOpen(directory)
 	if err != nil {
@@ -209,7 +249,7 @@ func getCurrent(target *Target, methodType, methodName string) (plumbing.Hash, e
 
 func updateCurrent(ctx context.Context, target *Target, newCurrent plumbing.Hash, methodType, methodName string) error {
 	directory := getDirectory(target)
-	tagName := fmt.Sprintf("current-%s-%s", methodType, methodName)
+	tagName := stateTag(methodType, methodName)
 
 	repo, err := git.PlainOpen(directory)
 	if err != nil {
@@ -224,10 +264,13 @@ func updateCurrent(ctx context.Context, target *Target, newCurrent plumbing.Hash
 	if _, err := repo.CreateTag(tagName, newCurrent, nil); err != nil {
 		return utils.WrapErr(err, "Error creating new current tag with hash %s", newCurrent)
 	}
+	observeCurrent(target, methodType, methodName, newCurrent)
 
 	return nil
 }
 
+// getSubTreeFromHash returns the tree at targetPath in the commit at hash. A
+// target path missing from the commit is an empty tree.
 func getSubTreeFromHash(directory string, hash plumbing.Hash, targetPath string) (*object.Tree, error) {
 	if hash.IsZero() {
 		return &object.Tree{}, nil
@@ -247,8 +290,17 @@ func getSubTreeFromHash(directory string, hash plumbing.Hash, targetPath string)
 	if err != nil {
 		return nil, utils.WrapErr(err, "Error getting tree from commit at hash %s from repository %s", hash, directory)
 	}
+	if targetPath == "" {
+		return tree, nil
+	}
 
 	subTree, err := tree.Tree(targetPath)
+	if err == object.ErrDirectoryNotFound {
+		// a mistyped path or a directory that is not pushed yet has no files,
+		// its files are applied once the directory appears
+		logger.Warnf("Target path %s not found in repository %s at commit %s, will retry", targetPath, directory, hash.String()[:hashReportLen])
+		return &object.Tree{}, nil
+	}
 	if err != nil {
 		return nil, utils.WrapErr(err, "Error getting sub tree at %s from commit at %s from repository %s", targetPath, hash, directory)
 	}
@@ -256,10 +308,102 @@ func getSubTreeFromHash(directory string, hash plumbing.Hash, targetPath string)
 	return subTree, nil
 }
 
+// fileMatcher selects the files in a target path that a method processes
+type fileMatcher struct {
+	glob    glob.Glob
+	include []glob.Glob
+	exclude []glob.Glob
+}
+
+// newFileMatcher compiles the method's glob along with its include and
+// exclude patterns. A file must match the glob and, if any are given, one
+// of the include patterns. Files matching an exclude pattern are skipped.
+func newFileMatcher(globPattern *string, include, exclude []string) (*fileMatcher, error) {
+	pattern := "**"
+	if globPattern != nil {
+		pattern = *globPattern
+	}
+	g, err := glob.Compile(pattern)
+	if err != nil {
+		return nil, utils.WrapErr(err, "Error compiling glob for pattern %s", pattern)
+	}
+	m := &fileMatcher{glob: g}
+	for _, p := range include {
+		g, err := glob.Compile(p)
+		if err != nil {
+			return nil, utils.WrapErr(err, "Error compiling include pattern %s", p)
+		}
+		m.include = append(m.include, g)
+	}
+	for _, p := range exclude {
+		g, err := glob.Compile(p)
+		if err != nil {
+			return nil, utils.WrapErr(err, "Error compiling exclude pattern %s", p)
+		}
+		m.exclude = append(m.exclude, g)
+	}
+	return m, nil
+}
+
+func (m *fileMatcher) match(name string) bool {
+	if !m.glob.Match(name) {
+		return false
+	}
+	for _, g := range m.exclude {
+		if g.Match(name) {
+			return false
+		}
+	}
+	if len(m.include) == 0 {
+		return true
+	}
+	for _, g := range m.include {
+		if g.Match(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// redeploySentinel is a file that, when committed to the target path, makes the
+// methods of the target path reapply every file they manage
+const redeploySentinel = ".fetchit-redeploy"
+
+// withRedeploy returns the changes with a change reapplying each file of
+// desiredTree that the changes leave as it is, when the changes add or update
+// the redeploy sentinel. A reapplied file is changed from itself to itself.
+func withRedeploy(changes object.Changes, desiredTree *object.Tree) (object.Changes, error) {
+	redeploy := false
+	changed := map[string]bool{}
+	for _, change := range changes {
+		if change.To.Name == redeploySentinel {
+			redeploy = true
+		}
+		changed[change.To.Name] = true
+	}
+	if !redeploy {
+		return changes, nil
+	}
+	all, err := (&object.Tree{}).Diff(desiredTree)
+	if err != nil {
+		return nil, err
+	}
+	for _, change := range all {
+		if changed[change.To.Name] {
+			continue
+		}
+		change.From = change.To
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
 func getFilteredChangeMap(
 	directory,
 	targetPath string,
 	globPattern *string,
+	include,
+	exclude []string,
 	currentTree,
 	desiredTree *object.Tree,
 	tags *[]string,
@@ -267,47 +411,112 @@ func getFilteredChangeMap(
 
 	changes, err := currentTree.Diff(desiredTree)
 	if err != nil {
-		return nil, utils.WrapErr(err, "Error getting diff between current and latest", targetPath)
+		return nil, utils.WrapErr(err, "Error getting diff between current and latest in %s", targetPath)
+	}
+	changes, err = withRedeploy(changes, desiredTree)
+	if err != nil {
+		return nil, utils.WrapErr(err, "Error listing files to redeploy in %s", targetPath)
 	}
 
-	var g glob.Glob
-	if globPattern == nil {
-		g, err = glob.Compile("**")
-		if err != nil {
-			return nil, utils.WrapErr(err, "Error compiling glob for pattern %s", globPattern)
-		}
-	} else {
-		g, err = glob.Compile(*globPattern)
-		if err != nil {
-			return nil, utils.WrapErr(err, "Error compiling glob for pattern %s", globPattern)
-		}
+	m, err := newFileMatcher(globPattern, include, exclude)
+	if err != nil {
+		return nil, &utils.ValidationError{Err: err}
 	}
 
 	changeMap := make(map[*object.Change]string)
 	for _, change := range changes {
-		if change.To.Name != "" && checkTag(tags, change.To.Name) && g.Match(change.To.Name) {
-			path := filepath.Join(directory, targetPath, change.To.Name)
-			changeMap[change] = path
-		} else if change.From.Name != "" && checkTag(tags, change.From.Name) && g.Match(change.From.Name) {
-			changeMap[change] = deleteFile
+		if change.To.Name == redeploySentinel || change.From.Name == redeploySentinel {
+			// the sentinel is not one of the files the method manages
+			continue
+		}
+		var path string
+		if change.To.Name != "" && checkTag(tags, change.To.Name) && m.match(change.To.Name) {
+			path = filepath.Join(directory, targetPath, change.To.Name)
+		} else if change.From.Name != "" && checkTag(tags, change.From.Name) && m.match(change.From.Name) {
+			path = deleteFile
+		} else {
+			// files of other methods sharing the target are not this method's changes
+			continue
 		}
+		name, size, err := oversizedFile(change, maxFileSize)
+		if err != nil {
+			return nil, &utils.GitError{Err: utils.WrapErr(err, "Error getting files of change in %s", targetPath)}
+		}
+		if name != "" {
+			logger.Warnf("Skipping %s in %s, size %d bytes exceeds maxFileSize of %d bytes", name, targetPath, size, maxFileSize)
+			continue
+		}
+		changeMap[change] = path
 	}
 
 	return changeMap, nil
 }
 
+// oversizedFile returns the name and size of a file in the change that is
+// larger than maxSize, or an empty name when there is none. A maxSize of 0
+// disables the check.
+func oversizedFile(change *object.Change, maxSize int64) (string, int64, error) {
+	if maxSize <= 0 {
+		return "", 0, nil
+	}
+	from, to, err := change.Files()
+	if err != nil {
+		return "", 0, err
+	}
+	for _, f := range []*object.File{to, from} {
+		if f != nil && f.Size > maxSize {
+			return f.Name, f.Size, nil
+		}
+	}
+	return "", 0, nil
+}
+
+// checkTag reports whether a file has one of the extensions in tags, a nil
+// tags matches every file. Tags match whole extensions, with or without the
+// leading dot, so yaml matches config.yaml but not config.notyaml.
 func checkTag(tags *[]string, name string) bool {
 	if tags == nil {
 		return true
 	}
-	for _, suffix := range *tags {
-		if strings.HasSuffix(name, suffix) {
+	for _, tag := range *tags {
+		if tag == "" {
+			continue
+		}
+		if !strings.HasPrefix(tag, ".") {
+			tag = "." + tag
+		}
+		if strings.HasSuffix(name, tag) {
 			return true
 		}
 	}
 	return false
 }
 
+// getChangeContents returns the contents of the file a change moves to, read
+// from its blob at the desired commit rather than from the worktree, so that
+// a checkout of the shared worktree in progress can't change what is applied.
+// The file is read from path when the change has no blob, and deleted files
+// have no contents.
+func getChangeContents(change *object.Change, path string) ([]byte, error) {
+	if path == deleteFile {
+		return nil, nil
+	}
+	if change != nil && change.To.Tree != nil {
+		_, to, err := change.Files()
+		if err != nil {
+			return nil, err
+		}
+		if to != nil {
+			s, err := to.Contents()
+			if err != nil {
+				return nil, err
+			}
+			return []byte(s), nil
+		}
+	}
+	return ioutil.ReadFile(path)
+}
+
 func getChangeString(change *object.Change) (*string, error) {
 	if change != nil {
 		from, _, err := change.Files()
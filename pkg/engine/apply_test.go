@@ -0,0 +1,594 @@
+package engine
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// testRepo is a git repository that tests commit files to
+type testRepo struct {
+	t    *testing.T
+	repo *git.Repository
+	wt   *git.Worktree
+}
+
+// newTestRepo returns an empty repository in memory
+func newTestRepo(t *testing.T) *testRepo {
+	repo, err := git.Init(memory.NewStorage(), memfs.New())
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	return newTestRepoFrom(t, repo)
+}
+
+// newTestRepoAt returns an empty repository at dir, for the code that opens
+// repositories from disk
+func newTestRepoAt(t *testing.T, dir string) *testRepo {
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	return newTestRepoFrom(t, repo)
+}
+
+func newTestRepoFrom(t *testing.T, repo *git.Repository) *testRepo {
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	return &testRepo{t: t, repo: repo, wt: wt}
+}
+
+// commit writes files, removes the files in remove and commits the result
+func (r *testRepo) commit(files map[string]string, remove ...string) plumbing.Hash {
+	for name, content := range files {
+		if err := util.WriteFile(r.wt.Filesystem, name, []byte(content), 0644); err != nil {
+			r.t.Fatalf("Failed: %v", err)
+		}
+		if _, err := r.wt.Add(name); err != nil {
+			r.t.Fatalf("Failed: %v", err)
+		}
+	}
+	for _, name := range remove {
+		if _, err := r.wt.Remove(name); err != nil {
+			r.t.Fatalf("Failed: %v", err)
+		}
+	}
+	hash, err := r.wt.Commit("update", &git.CommitOptions{Author: &object.Signature{Name: "test", When: time.Now()}})
+	if err != nil {
+		r.t.Fatalf("Failed: %v", err)
+	}
+	return hash
+}
+
+// tree returns the tree of the commit hash
+func (r *testRepo) tree(hash plumbing.Hash) *object.Tree {
+	c, err := r.repo.CommitObject(hash)
+	if err != nil {
+		r.t.Fatalf("Failed: %v", err)
+	}
+	tree, err := c.Tree()
+	if err != nil {
+		r.t.Fatalf("Failed: %v", err)
+	}
+	return tree
+}
+
+func TestFileMatcher(t *testing.T) {
+	m, err := newFileMatcher(nil, []string{"**.yaml", "**.yml"}, []string{"test/**", "**/test/**"})
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	for name, expected := range map[string]bool{
+		"app.yaml":           true,
+		"web/app.yml":        true,
+		"README.md":          false,
+		"test/app.yaml":      false,
+		"web/test/app.yaml":  false,
+		"web/tests/app.yaml": true,
+	} {
+		if m.match(name) != expected {
+			t.Errorf("Failed: match(%s) != %v", name, expected)
+		}
+	}
+
+	glob := "web/**"
+	m, err = newFileMatcher(&glob, nil, []string{"**.md"})
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	for name, expected := range map[string]bool{
+		"web/app.yaml":  true,
+		"web/README.md": false,
+		"app.yaml":      false,
+	} {
+		if m.match(name) != expected {
+			t.Errorf("Failed: match(%s) != %v", name, expected)
+		}
+	}
+
+	if _, err := newFileMatcher(nil, []string{"[unclosed"}, nil); err == nil {
+		t.Fatalf("Failed: expected error for invalid include pattern")
+	}
+}
+
+func TestGetFilteredChangeMapSkipsOversizedFiles(t *testing.T) {
+	r := newTestRepo(t)
+	current := r.tree(r.commit(map[string]string{"README": "raw files"}))
+	desired := r.tree(r.commit(map[string]string{"pod.yaml": "Name: colors", "image.yaml": strings.Repeat("x", 2048)}))
+
+	defer func(l *zap.SugaredLogger, size int64) { logger, maxFileSize = l, size }(logger, maxFileSize)
+	core, logs := observer.New(zapcore.WarnLevel)
+	logger = zap.New(core).Sugar()
+	maxFileSize = 1024
+
+	changeMap, err := getFilteredChangeMap("/opt", "raw", nil, nil, nil, current, desired, nil)
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	if len(changeMap) != 1 {
+		t.Fatalf("Failed: expected only pod.yaml, got %d changes", len(changeMap))
+	}
+	for change := range changeMap {
+		if change.To.Name != "pod.yaml" {
+			t.Fatalf("Failed: unexpected change %s", change.To.Name)
+		}
+	}
+	if logs.Len() != 1 || !strings.Contains(logs.All()[0].Message, "Skipping image.yaml") {
+		t.Fatalf("Failed: expected a warning for image.yaml, got %v", logs.All())
+	}
+
+	maxFileSize = 0
+	changeMap, err = getFilteredChangeMap("/opt", "raw", nil, nil, nil, current, desired, nil)
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	if len(changeMap) != 2 {
+		t.Fatalf("Failed: expected no limit with maxFileSize 0, got %d changes", len(changeMap))
+	}
+}
+
+func TestGetFilteredChangeMapIgnoresOtherMethodsFiles(t *testing.T) {
+	r := newTestRepo(t)
+	// raw and systemd methods share the target and its directory
+	current := r.tree(r.commit(map[string]string{"web.yaml": "Name: web", "app.service": "[Service]"}))
+	desired := r.tree(r.commit(map[string]string{"app.service": "[Service]\nRestart=always", "big.service": strings.Repeat("x", 2048)}))
+
+	defer func(l *zap.SugaredLogger, size int64) { logger, maxFileSize = l, size }(logger, maxFileSize)
+	core, logs := observer.New(zapcore.WarnLevel)
+	logger = zap.New(core).Sugar()
+	maxFileSize = 1024
+
+	raw := &Raw{}
+	changeMap, err := getFilteredChangeMap("/opt", "", nil, nil, nil, current, desired, raw.getTags(rawMethod))
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	if len(changeMap) != 0 || logs.Len() != 0 {
+		t.Fatalf("Failed: raw method reported %d changes and %d warnings for a commit of .service files", len(changeMap), logs.Len())
+	}
+
+	sd := &Systemd{}
+	changeMap, err = getFilteredChangeMap("/opt", "", nil, nil, nil, current, desired, sd.getTags(systemdMethod))
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	if len(changeMap) != 1 || logs.Len() != 1 {
+		t.Fatalf("Failed: systemd method expected app.service and a warning for big.service, got %d changes and %v", len(changeMap), logs.All())
+	}
+	for change := range changeMap {
+		if change.To.Name != "app.service" {
+			t.Fatalf("Failed: unexpected change %s", change.To.Name)
+		}
+	}
+}
+
+func TestGetFilteredChangeMapRedeploySentinel(t *testing.T) {
+	r := newTestRepo(t)
+	first := r.tree(r.commit(map[string]string{"web.yaml": "Name: web", "db.yaml": "Name: db", "app.service": "[Service]"}))
+	second := r.tree(r.commit(map[string]string{"web.yaml": "Name: web\nImage: web:v2"}))
+	third := r.tree(r.commit(map[string]string{redeploySentinel: "maintenance window"}))
+
+	changeMap, err := getFilteredChangeMap("/opt", "", nil, nil, nil, first, second, &[]string{".yaml"})
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	if len(changeMap) != 1 {
+		t.Fatalf("Failed: expected only web.yaml without the sentinel, got %d changes", len(changeMap))
+	}
+
+	// the sentinel reapplies every managed file, the changed one included
+	changeMap, err = getFilteredChangeMap("/opt", "", nil, nil, nil, first, third, &[]string{".yaml"})
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	var paths []string
+	for change, path := range changeMap {
+		if change.From.Name != change.To.Name {
+			t.Fatalf("Failed: %s is not reapplied in place, from %q", change.To.Name, change.From.Name)
+		}
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	if want := []string{"/opt/db.yaml", "/opt/web.yaml"}; !reflect.DeepEqual(paths, want) {
+		t.Fatalf("Failed: reapplied %q, expected %q", paths, want)
+	}
+
+	// touching the sentinel again redeploys once more
+	fourth := r.tree(r.commit(map[string]string{redeploySentinel: "another maintenance window"}))
+	changeMap, err = getFilteredChangeMap("/opt", "", nil, nil, nil, third, fourth, &[]string{".yaml"})
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	if len(changeMap) != 2 {
+		t.Fatalf("Failed: expected 2 files reapplied, got %d", len(changeMap))
+	}
+}
+
+func TestGetChangeContentsReadsCommit(t *testing.T) {
+	r := newTestRepo(t)
+	first := r.tree(r.commit(map[string]string{"web.yaml": "Name: web\nImage: web:v1"}))
+	second := r.tree(r.commit(map[string]string{"web.yaml": "Name: web\nImage: web:v2"}))
+
+	directory := t.TempDir()
+	changeMap, err := getFilteredChangeMap(directory, "", nil, nil, nil, first, second, &[]string{".yaml"})
+	if err != nil || len(changeMap) != 1 {
+		t.Fatalf("Failed: expected the change of web.yaml, got %d changes: %v", len(changeMap), err)
+	}
+	// another method sharing the worktree is halfway through checking out a
+	// different commit
+	for change, path := range changeMap {
+		if err := ioutil.WriteFile(path, []byte("Name: we"), 0644); err != nil {
+			t.Fatalf("Failed: %v", err)
+		}
+		b, err := getChangeContents(change, path)
+		if err != nil {
+			t.Fatalf("Failed: unexpected error: %v", err)
+		}
+		if string(b) != "Name: web\nImage: web:v2" {
+			t.Fatalf("Failed: read %q rather than the file at the desired commit", b)
+		}
+
+		var parsed []string
+		if _, err := rawChangeOrder(changeMap, func(name string, b []byte) ([]*RawPod, error) {
+			parsed = append(parsed, string(b))
+			return nil, nil
+		}); err != nil {
+			t.Fatalf("Failed: unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(parsed, []string{"Name: web\nImage: web:v2"}) {
+			t.Fatalf("Failed: raw changes ordered from %q", parsed)
+		}
+
+		// without a change the file is read from disk
+		if b, err := getChangeContents(nil, path); err != nil || string(b) != "Name: we" {
+			t.Fatalf("Failed: read %q from disk: %v", b, err)
+		}
+	}
+	if b, err := getChangeContents(nil, deleteFile); b != nil || err != nil {
+		t.Fatalf("Failed: deleted file has contents %q: %v", b, err)
+	}
+}
+
+func TestNestedTargetPath(t *testing.T) {
+	directory := t.TempDir()
+	files := map[string]string{}
+	for _, name := range []string{"envs/prod/app.yaml", "envs/prod/db/postgres.yaml", "envs/prod/db/replica/postgres.yaml", "envs/dev/app.yaml"} {
+		files[name] = "Name: " + name
+	}
+	hash := newTestRepoAt(t, directory).commit(files)
+
+	for _, targetPath := range []string{"envs/prod", "envs/prod/", "./envs/prod"} {
+		m := &CommonMethod{TargetPath: targetPath}
+		tree, err := getSubTreeFromHash(directory, hash, m.GetTargetPath())
+		if err != nil {
+			t.Fatalf("Failed: target path %s: %v", targetPath, err)
+		}
+		changeMap, err := getFilteredChangeMap(directory, m.GetTargetPath(), nil, nil, nil, &object.Tree{}, tree, nil)
+		if err != nil {
+			t.Fatalf("Failed: target path %s: %v", targetPath, err)
+		}
+		expected := map[string]string{
+			"app.yaml":                 filepath.Join(directory, "envs/prod/app.yaml"),
+			"db/postgres.yaml":         filepath.Join(directory, "envs/prod/db/postgres.yaml"),
+			"db/replica/postgres.yaml": filepath.Join(directory, "envs/prod/db/replica/postgres.yaml"),
+		}
+		if len(changeMap) != len(expected) {
+			t.Fatalf("Failed: target path %s: expected %d changes, got %d", targetPath, len(expected), len(changeMap))
+		}
+		for change, path := range changeMap {
+			if expected[change.To.Name] != path {
+				t.Fatalf("Failed: target path %s: %s mapped to %s, expected %s", targetPath, change.To.Name, path, expected[change.To.Name])
+			}
+		}
+	}
+
+	tree, err := getSubTreeFromHash(directory, hash, cleanTargetPath("."))
+	if err != nil {
+		t.Fatalf("Failed: repository root: %v", err)
+	}
+	if _, err := tree.File("envs/dev/app.yaml"); err != nil {
+		t.Fatalf("Failed: repository root tree: %v", err)
+	}
+}
+
+func TestMissingTargetPath(t *testing.T) {
+	defer func(l *zap.SugaredLogger, dir string) { logger, cacheDir = l, dir }(logger, cacheDir)
+	logger = zap.NewNop().Sugar()
+	cacheDir = t.TempDir()
+	target := &Target{url: "https://github.com/acme/later.git"}
+	directory := getDirectory(target)
+
+	r := newTestRepoAt(t, directory)
+	before := r.commit(map[string]string{"README.md": "Name: app"})
+	after := r.commit(map[string]string{"raw/app.yaml": "Name: app"})
+
+	tree, err := getSubTreeFromHash(directory, before, "raw")
+	if err != nil {
+		t.Fatalf("Failed: expected a missing target path to be an empty tree, got %v", err)
+	}
+	if len(tree.Entries) != 0 {
+		t.Fatalf("Failed: expected an empty tree, got %v", tree.Entries)
+	}
+	changeMap, err := applyChanges(context.Background(), target, "raw", nil, nil, nil, plumbing.ZeroHash, before, nil)
+	if err != nil || len(changeMap) != 0 {
+		t.Fatalf("Failed: expected no changes before the target path exists, got %v: %v", changeMap, err)
+	}
+
+	// the files are applied once the directory is added
+	changeMap, err = applyChanges(context.Background(), target, "raw", nil, nil, nil, before, after, nil)
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	if len(changeMap) != 1 {
+		t.Fatalf("Failed: expected the added file, got %v", changeMap)
+	}
+	for change, path := range changeMap {
+		if changeName(change) != "app.yaml" || path != filepath.Join(directory, "raw/app.yaml") {
+			t.Fatalf("Failed: unexpected change %s mapped to %s", changeName(change), path)
+		}
+	}
+}
+
+func TestCheckTag(t *testing.T) {
+	tags := &[]string{".yaml", "yml"}
+	for name, expected := range map[string]bool{
+		"config.yaml":         true,
+		"web/config.yml":      true,
+		"config.notyaml":      false,
+		"myyaml":              false,
+		"config.yaml.bak":     false,
+		"web/notyml/app.json": false,
+	} {
+		if checkTag(tags, name) != expected {
+			t.Errorf("Failed: checkTag(%s) != %v", name, expected)
+		}
+	}
+	if !checkTag(nil, "README") {
+		t.Fatalf("Failed: nil tags should match every file")
+	}
+}
+
+func TestApplyChangesRepositoryRoot(t *testing.T) {
+	defer func(dir string) { cacheDir = dir }(cacheDir)
+	cacheDir = t.TempDir()
+	target := &Target{url: "https://github.com/acme/config.git"}
+	directory := getDirectory(target)
+
+	r := newTestRepoAt(t, directory)
+	current := r.commit(map[string]string{"app.yaml": "v1", "web/nginx.yaml": "v1", "db/old.yaml": "kind: Pod"})
+	desired := r.commit(map[string]string{"app.yaml": "v2", "cache/redis.yaml": "kind: Service"}, "db/old.yaml")
+
+	m := &CommonMethod{}
+	changeMap, err := applyChanges(context.Background(), target, m.GetTargetPath(), nil, nil, nil, current, desired, nil)
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	expected := map[string]string{
+		"app.yaml":         filepath.Join(directory, "app.yaml"),
+		"cache/redis.yaml": filepath.Join(directory, "cache/redis.yaml"),
+		"db/old.yaml":      deleteFile,
+	}
+	if len(changeMap) != len(expected) {
+		t.Fatalf("Failed: expected %d changes, got %d", len(expected), len(changeMap))
+	}
+	for change, path := range changeMap {
+		if expected[changeName(change)] != path {
+			t.Fatalf("Failed: %s mapped to %s, expected %s", changeName(change), path, expected[changeName(change)])
+		}
+	}
+}
+
+func TestTargetChangesMultiplePaths(t *testing.T) {
+	defer func(dir string) { cacheDir = dir }(cacheDir)
+	cacheDir = t.TempDir()
+	target := &Target{url: "https://github.com/acme/apps.git"}
+	directory := getDirectory(target)
+
+	r := newTestRepoAt(t, directory)
+	current := r.commit(map[string]string{"web/app.yaml": "Name: web", "worker/app.yaml": "Name: worker", "docs/app.yaml": "Name: docs"})
+	webOnly := r.commit(map[string]string{"web/app.yaml": "Name: web\nImage: web:v2", "docs/app.yaml": "Name: docs-v2"})
+	both := r.commit(map[string]string{"web/app.yaml": "Name: web\nImage: web:v3", "worker/app.yaml": "Name: worker\nImage: worker:v2"})
+
+	raw := &Raw{CommonMethod: CommonMethod{Name: "apps", TargetPaths: []string{"web/", "./worker"}, target: target}}
+	if paths := raw.GetTargetPaths(); len(paths) != 2 || paths[0] != "web" || paths[1] != "worker" {
+		t.Fatalf("Failed: unexpected target paths %v", paths)
+	}
+	tests := []struct {
+		name     string
+		from, to plumbing.Hash
+		expected []string
+	}{
+		{"web only", current, webOnly, []string{filepath.Join(directory, "web/app.yaml")}},
+		{"both", webOnly, both, []string{filepath.Join(directory, "web/app.yaml"), filepath.Join(directory, "worker/app.yaml")}},
+	}
+	for _, tt := range tests {
+		changeMap, err := raw.targetChanges(context.Background(), tt.from, tt.to, raw.getTags(rawMethod))
+		if err != nil {
+			t.Fatalf("Failed: %s: %v", tt.name, err)
+		}
+		var paths []string
+		for _, path := range changeMap {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		if !reflect.DeepEqual(paths, tt.expected) {
+			t.Fatalf("Failed: %s: changes %v, expected %v", tt.name, paths, tt.expected)
+		}
+	}
+
+	// an unset targetPath alone is the repository root
+	if paths := (&CommonMethod{}).GetTargetPaths(); len(paths) != 1 || paths[0] != "" {
+		t.Fatalf("Failed: expected the repository root, got %v", paths)
+	}
+}
+
+func TestEnvPathSelectsEnvironment(t *testing.T) {
+	defer func(dir string) { cacheDir = dir }(cacheDir)
+	cacheDir = t.TempDir()
+	target := &Target{url: "https://github.com/acme/envs.git"}
+	directory := getDirectory(target)
+
+	latest := newTestRepoAt(t, directory).commit(map[string]string{
+		"environments/dev/app.yaml":  "Name: app",
+		"environments/prod/app.yaml": "Name: app",
+		"environments/prod/db.yaml":  "Name: app",
+	})
+
+	raw := &Raw{CommonMethod: CommonMethod{Name: "apps", TargetPath: "ignored", EnvPath: "environments/{{ .Env }}/", target: target}}
+	if err := raw.renderEnvPath(&hostFacts{Env: "prod"}); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	if path := raw.GetTargetPath(); path != "environments/prod" {
+		t.Fatalf("Failed: target path %s, expected environments/prod", path)
+	}
+	changeMap, err := raw.targetChanges(context.Background(), plumbing.ZeroHash, latest, raw.getTags(rawMethod))
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	var paths []string
+	for _, path := range changeMap {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	expected := []string{filepath.Join(directory, "environments/prod/app.yaml"), filepath.Join(directory, "environments/prod/db.yaml")}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("Failed: changes %v, expected %v", paths, expected)
+	}
+
+	if err := raw.renderEnvPath(&hostFacts{Hostname: "web1"}); err == nil {
+		t.Fatalf("Failed: expected an error without an environment")
+	}
+	raw.EnvPath = "hosts/{{ .Hostname }}"
+	if err := raw.renderEnvPath(&hostFacts{Hostname: "web1"}); err != nil || raw.GetTargetPath() != "hosts/web1" {
+		t.Fatalf("Failed: target path %s: %v", raw.GetTargetPath(), err)
+	}
+}
+
+func TestGetLatestSharedFetch(t *testing.T) {
+	defer func(f func(*Target) (plumbing.Hash, error)) { fetchHead = f }(fetchHead)
+	fetches := map[*Target]int{}
+	fetchHead = func(target *Target) (plumbing.Hash, error) {
+		fetches[target]++
+		return plumbing.NewHash("0123456789abcdef0123456789abcdef01234567"), nil
+	}
+
+	shared := &Target{url: "https://github.com/acme/config.git"}
+	other := &Target{url: "https://github.com/acme/other.git"}
+	methods := []Method{
+		&Kube{CommonMethod: CommonMethod{target: shared}},
+		&Raw{CommonMethod: CommonMethod{target: shared}},
+		&FileTransfer{CommonMethod: CommonMethod{target: shared}},
+		&Kube{CommonMethod: CommonMethod{target: other}},
+	}
+	for _, m := range methods {
+		if _, err := getLatest(m.GetTarget()); err != nil {
+			t.Fatalf("Failed: %v", err)
+		}
+	}
+	if fetches[shared] != 1 || fetches[other] != 1 {
+		t.Fatalf("Failed: expected one fetch per target, got %d and %d", fetches[shared], fetches[other])
+	}
+
+	shared.fetchedAt = time.Now().Add(-fetchReuse)
+	if _, err := getLatest(shared); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	if fetches[shared] != 2 {
+		t.Fatalf("Failed: expected a new fetch after %s, got %d fetches", fetchReuse, fetches[shared])
+	}
+
+	// with no reuse every run fetches
+	defer func(d time.Duration) { fetchReuse = d }(fetchReuse)
+	fetchReuse = 0
+	for i := 0; i < 2; i++ {
+		if _, err := getLatest(shared); err != nil {
+			t.Fatalf("Failed: %v", err)
+		}
+	}
+	if fetches[shared] != 4 {
+		t.Fatalf("Failed: expected a fetch on every run with fetchReuse 0, got %d fetches", fetches[shared])
+	}
+}
+
+func TestFetchLatestSkipsNoopCheckout(t *testing.T) {
+	defer func(l *zap.SugaredLogger, dir string, c func(*git.Worktree, plumbing.Hash) error) {
+		logger, cacheDir, checkoutHash = l, dir, c
+	}(logger, cacheDir, checkoutHash)
+	logger = zap.NewNop().Sugar()
+	dir := t.TempDir()
+	cacheDir = filepath.Join(dir, "cache")
+
+	remote := filepath.Join(dir, "remote")
+	r := newTestRepoAt(t, remote)
+	first := r.commit(map[string]string{"app.yaml": "Name: v1"})
+	head, err := r.repo.Head()
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	target := &Target{url: remote, branch: head.Name().Short()}
+	if err := getClone(target); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+
+	checkouts := 0
+	checkoutHash = func(wt *git.Worktree, hash plumbing.Hash) error {
+		checkouts++
+		return wt.Checkout(&git.CheckoutOptions{Hash: hash})
+	}
+	fetch := func(expected plumbing.Hash, expectedCheckouts int) {
+		latest, err := fetchLatest(target)
+		if err != nil || latest != expected {
+			t.Fatalf("Failed: latest %s, expected %s: %v", latest, expected, err)
+		}
+		if checkouts != expectedCheckouts {
+			t.Fatalf("Failed: %d checkouts, expected %d", checkouts, expectedCheckouts)
+		}
+	}
+
+	fetch(first, 1)
+	fetch(first, 1)
+	second := r.commit(map[string]string{"app.yaml": "Name: v2"})
+	fetch(second, 2)
+	fetch(second, 2)
+	b, err := ioutil.ReadFile(filepath.Join(getDirectory(target), "app.yaml"))
+	if err != nil || string(b) != "Name: v2" {
+		t.Fatalf("Failed: checked out %q: %v", b, err)
+	}
+}
@@ -0,0 +1,154 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containers/fetchit/pkg/engine/utils"
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/containers/image/v5/types"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// artifactDir is where artifact files are staged in the fetchit volume before
+// they are copied to the destination directory
+const artifactDir = "artifacts"
+
+// pullArtifactPodman pulls the files of an OCI artifact and copies them to the
+// destination directory on the host. Nothing is copied when the artifact
+// manifest is unchanged since the last run.
+func (i *Image) pullArtifactPodman(ctx, conn context.Context) error {
+	log := methodLogger(i)
+	ref, err := artifactReference(i.Artifact)
+	if err != nil {
+		return &utils.ValidationError{Err: err}
+	}
+	src, err := ref.NewImageSource(ctx, systemContext(ref))
+	if err != nil {
+		return utils.WrapErr(err, "Error opening artifact %s", i.Artifact)
+	}
+	defer src.Close()
+
+	b, mimeType, err := src.GetManifest(ctx, nil)
+	if err != nil {
+		return utils.WrapErr(err, "Error getting manifest of artifact %s", i.Artifact)
+	}
+	if mimeType != imgspecv1.MediaTypeImageManifest {
+		return &utils.ValidationError{Err: fmt.Errorf("artifact %s has manifest type %s, only %s is supported", i.Artifact, mimeType, imgspecv1.MediaTypeImageManifest)}
+	}
+	d, err := manifest.Digest(b)
+	if err != nil {
+		return err
+	}
+	if d.String() == i.artifactDigest {
+		log.Infof("Artifact %s is up to date", i.Artifact)
+		return nil
+	}
+	m, err := manifest.OCI1FromManifest(b)
+	if err != nil {
+		return utils.WrapErr(err, "Error parsing manifest of artifact %s", i.Artifact)
+	}
+
+	staging := filepath.Join(cacheDir, artifactDir, i.containerName())
+	if err := os.RemoveAll(staging); err != nil {
+		return err
+	}
+	layers := m.LayerInfos()
+	blobs := make([]types.BlobInfo, len(layers))
+	for n, l := range layers {
+		blobs[n] = l.BlobInfo
+	}
+	err = writeArtifactFiles(staging, blobs, func(blob types.BlobInfo) (io.ReadCloser, error) {
+		r, _, err := src.GetBlob(ctx, blob, none.NoCache)
+		return r, err
+	})
+	if err != nil {
+		return utils.WrapErr(err, "Error pulling artifact %s", i.Artifact)
+	}
+
+	// The trailing slash copies the contents of the staging directory
+	copyFile := filepath.Join("/opt", staging) + "/ " + i.DestinationDirectory
+	s := generateSpec(imageMethod, targetName(i.GetTarget()), artifactDir, copyFile, i.DestinationDirectory, i.containerName())
+	createResponse, err := createAndStartContainer(conn, s)
+	if err != nil {
+		return err
+	}
+	if err := waitAndRemoveContainer(conn, createResponse.ID); err != nil {
+		return err
+	}
+	i.artifactDigest = d.String()
+	log.Infof("Artifact %s placed in %s", i.Artifact, i.DestinationDirectory)
+	return nil
+}
+
+// artifactReference parses a registry reference with an optional docker:// prefix
+func artifactReference(artifact string) (types.ImageReference, error) {
+	if artifact == "" {
+		return nil, fmt.Errorf("artifact reference is empty")
+	}
+	if strings.Contains(artifact, "://") && !strings.HasPrefix(artifact, "docker://") {
+		return nil, fmt.Errorf("artifact %s must be a registry reference", artifact)
+	}
+	ref, err := docker.ParseReference("//" + strings.TrimPrefix(artifact, "docker://"))
+	if err != nil {
+		return nil, utils.WrapErr(err, "Invalid artifact reference %s", artifact)
+	}
+	return ref, nil
+}
+
+// artifactFileName returns the file name of an artifact layer, taken from its
+// title annotation as set by tools such as oras, or its digest otherwise
+func artifactFileName(blob types.BlobInfo) (string, error) {
+	name := blob.Annotations[imgspecv1.AnnotationTitle]
+	if name == "" {
+		return blob.Digest.Encoded(), nil
+	}
+	if name != filepath.Base(name) || name == "." || name == ".." {
+		return "", fmt.Errorf("artifact file name %s must not contain a path", name)
+	}
+	return name, nil
+}
+
+// writeArtifactFiles writes each artifact layer to a file in dir
+func writeArtifactFiles(dir string, blobs []types.BlobInfo, getBlob func(types.BlobInfo) (io.ReadCloser, error)) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, blob := range blobs {
+		name, err := artifactFileName(blob)
+		if err != nil {
+			return &utils.ValidationError{Err: err}
+		}
+		if err := writeArtifactFile(filepath.Join(dir, name), blob, getBlob); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeArtifactFile(path string, blob types.BlobInfo, getBlob func(types.BlobInfo) (io.ReadCloser, error)) error {
+	r, err := getBlob(blob)
+	if err != nil {
+		return utils.WrapErr(err, "Error getting blob %s", blob.Digest)
+	}
+	defer r.Close()
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	verifier := blob.Digest.Verifier()
+	if _, err := io.Copy(f, io.TeeReader(r, verifier)); err != nil {
+		return utils.WrapErr(err, "Error writing %s", path)
+	}
+	if !verifier.Verified() {
+		return fmt.Errorf("digest of %s does not match %s", path, blob.Digest)
+	}
+	return nil
+}
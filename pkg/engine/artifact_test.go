@@ -0,0 +1,77 @@
+package engine
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestArtifactReference(t *testing.T) {
+	for _, artifact := range []string{"quay.io/fetchit/config:v1", "docker://quay.io/fetchit/config:v1"} {
+		ref, err := artifactReference(artifact)
+		if err != nil {
+			t.Fatalf("Failed: unable to parse %s: %v", artifact, err)
+		}
+		if ref.DockerReference().String() != "quay.io/fetchit/config:v1" {
+			t.Fatalf("Failed: reference: %s", ref.DockerReference())
+		}
+	}
+	ref, err := artifactReference("localhost:5000/wasm/module")
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	if ref.DockerReference().String() != "localhost:5000/wasm/module:latest" {
+		t.Fatalf("Failed: reference should default to latest: %s", ref.DockerReference())
+	}
+	for _, artifact := range []string{"", "oci:/tmp/layout", "quay.io/Fetchit/config"} {
+		if _, err := artifactReference(artifact); err == nil {
+			t.Fatalf("Failed: expected error for %q", artifact)
+		}
+	}
+}
+
+func TestWriteArtifactFiles(t *testing.T) {
+	content := map[digest.Digest][]byte{}
+	blob := func(title string, b []byte) types.BlobInfo {
+		d := digest.FromBytes(b)
+		content[d] = b
+		info := types.BlobInfo{Digest: d, Size: int64(len(b))}
+		if title != "" {
+			info.Annotations = map[string]string{imgspecv1.AnnotationTitle: title}
+		}
+		return info
+	}
+	getBlob := func(info types.BlobInfo) (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(content[info.Digest])), nil
+	}
+
+	dir := filepath.Join(t.TempDir(), "artifacts")
+	untitled := blob("", []byte("untitled"))
+	blobs := []types.BlobInfo{blob("app.conf", []byte("color: blue")), untitled}
+	if err := writeArtifactFiles(dir, blobs, getBlob); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "app.conf"))
+	if err != nil || string(b) != "color: blue" {
+		t.Fatalf("Failed: app.conf: %q %v", b, err)
+	}
+	if _, err := ioutil.ReadFile(filepath.Join(dir, untitled.Digest.Encoded())); err != nil {
+		t.Fatalf("Failed: untitled layer should be named by digest: %v", err)
+	}
+
+	if err := writeArtifactFiles(dir, []types.BlobInfo{blob("../app.conf", []byte("escape"))}, getBlob); err == nil {
+		t.Fatalf("Failed: expected error for title with a path")
+	}
+
+	tampered := blob("tampered.conf", []byte("original"))
+	content[tampered.Digest] = []byte("tampered")
+	if err := writeArtifactFiles(dir, []types.BlobInfo{tampered}, getBlob); err == nil {
+		t.Fatalf("Failed: expected error for digest mismatch")
+	}
+}
@@ -0,0 +1,165 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/containers/fetchit/pkg/engine/utils"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/spf13/cobra"
+)
+
+const (
+	auditSuccess = "success"
+	auditFailure = "failure"
+)
+
+var (
+	// defaultAuditLog is the append-only record of every apply, one json object per line
+	defaultAuditLog = filepath.Join("/opt", "mount", "audit.jsonl")
+	// auditMu serializes the writes of methods applying at the same time
+	auditMu sync.Mutex
+	// historyLines is the number of audit entries the history command prints
+	historyLines int
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Show the most recent applies",
+	Long:  `Print the most recent entries of the audit log, one json object per line, oldest first`,
+	Run: func(cmd *cobra.Command, args []string) {
+		entries, err := tailAudit(defaultAuditLog, historyLines)
+		cobra.CheckErr(err)
+		enc := json.NewEncoder(cmd.OutOrStdout())
+		for _, entry := range entries {
+			cobra.CheckErr(enc.Encode(entry))
+		}
+	},
+}
+
+// auditEntry records one apply of a method
+type auditEntry struct {
+	Time   time.Time `json:"time"`
+	Target string    `json:"target"`
+	Method string    `json:"method"`
+	Name   string    `json:"name"`
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Files  []string  `json:"files,omitempty"`
+	Result string    `json:"result"`
+	Error  string    `json:"error,omitempty"`
+	// Reason is the kind of error a failed apply returned: git, podman, validation or unknown
+	Reason string `json:"reason,omitempty"`
+}
+
+// newAuditEntry returns the record of an apply of m from current to latest
+// that returned applyErr
+func newAuditEntry(m Method, current, latest plumbing.Hash, files []string, applyErr error) auditEntry {
+	entry := auditEntry{
+		Time:   time.Now().UTC(),
+		Target: m.GetTarget().url,
+		Method: m.GetKind(),
+		Name:   m.GetName(),
+		From:   current.String(),
+		To:     latest.String(),
+		Files:  files,
+		Result: auditSuccess,
+	}
+	if applyErr != nil {
+		entry.Result = auditFailure
+		entry.Error = applyErr.Error()
+		entry.Reason = utils.Classify(applyErr)
+	}
+	return entry
+}
+
+// auditFiles returns the paths of the files an apply from current to latest
+// changes, methods that don't apply files from git have none
+func auditFiles(m Method, current, latest plumbing.Hash) []string {
+	gm, ok := m.(gitMethod)
+	if !ok {
+		return nil
+	}
+	entries, err := planMethod(gm, current, latest)
+	if err != nil {
+		logger.Debugf("Unable to list the files changed by %s %s for the audit log: %v", m.GetKind(), m.GetName(), err)
+		return nil
+	}
+	files := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		files = append(files, entry.Path)
+	}
+	return files
+}
+
+// writeAudit appends an entry to the audit log at path
+func writeAudit(path string, entry auditEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return utils.WrapErr(err, "Error encoding audit entry")
+	}
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return utils.WrapErr(err, "Error opening audit log %s", path)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return utils.WrapErr(err, "Error writing audit log %s", path)
+	}
+	return nil
+}
+
+// recordApply counts an apply and writes it to the audit log, a failed write is only logged
+// so that it does not fail the apply
+func recordApply(m Method, current, latest plumbing.Hash, files []string, applyErr error) {
+	observeApply(m, applyErr)
+	if applyErr != nil {
+		methodLogger(m).Warnf("Apply of %s %s from %s to %s failed with a %s error", m.GetKind(), m.GetName(), current.String()[:hashReportLen], latest.String()[:hashReportLen], utils.Classify(applyErr))
+	}
+	if err := writeAudit(defaultAuditLog, newAuditEntry(m, current, latest, files, applyErr)); err != nil {
+		logger.Warnf("Unable to record apply of %s %s: %v", m.GetKind(), m.GetName(), err)
+	}
+}
+
+// tailAudit returns the last n entries of the audit log at path, oldest
+// first. A missing audit log has no entries and lines that can't be parsed,
+// such as one cut short by a crash, are skipped.
+func tailAudit(path string, n int) ([]auditEntry, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, utils.WrapErr(err, "Error reading audit log %s", path)
+	}
+	var entries []auditEntry
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(b)+1)
+	for scanner.Scan() {
+		var entry auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, utils.WrapErr(err, "Error reading audit log %s", path)
+	}
+	if n > 0 && len(entries) > n {
+		entries = entries[len(entries)-n:]
+	}
+	return entries, nil
+}
+
+func init() {
+	historyCmd.Flags().IntVarP(&historyLines, "lines", "n", 20, "number of entries to show, 0 for all")
+	fetchitCmd.AddCommand(historyCmd)
+}
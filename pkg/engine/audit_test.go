@@ -0,0 +1,64 @@
+package engine
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+func TestWriteAudit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	m := &Raw{CommonMethod: CommonMethod{Name: "web"}}
+	m.target = &Target{url: "https://github.com/a/web"}
+	from := plumbing.NewHash("1111111111111111111111111111111111111111")
+	to := plumbing.NewHash("2222222222222222222222222222222222222222")
+
+	if err := writeAudit(path, newAuditEntry(m, from, to, []string{"raw/web.yaml"}, nil)); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	if err := writeAudit(path, newAuditEntry(m, to, from, nil, errors.New("image not found"))); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+
+	entries, err := tailAudit(path, 0)
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("Failed: expected 2 entries, got %d: %v", len(entries), err)
+	}
+	ok := entries[0]
+	if ok.Target != m.target.url || ok.Method != rawMethod || ok.Name != "web" || ok.From != from.String() || ok.To != to.String() ||
+		len(ok.Files) != 1 || ok.Files[0] != "raw/web.yaml" || ok.Result != auditSuccess || ok.Error != "" || ok.Time.IsZero() {
+		t.Fatalf("Failed: unexpected success entry %+v", ok)
+	}
+	if failed := entries[1]; failed.Result != auditFailure || failed.Error != "image not found" || failed.Reason != "unknown" {
+		t.Fatalf("Failed: unexpected failure entry %+v", failed)
+	}
+}
+
+func TestTailAudit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	if entries, err := tailAudit(path, 5); err != nil || len(entries) != 0 {
+		t.Fatalf("Failed: missing audit log should have no entries: %v %v", entries, err)
+	}
+
+	log := `{"name":"one","result":"success"}
+{"name":"two","result":"success"}
+{"name":"three","res
+{"name":"four","result":"failure"}
+`
+	if err := os.WriteFile(path, []byte(log), 0600); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	entries, err := tailAudit(path, 2)
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Name != "two" || entries[1].Name != "four" {
+		t.Fatalf("Failed: expected the last two valid entries, got %+v", entries)
+	}
+	if entries, _ := tailAudit(path, 0); len(entries) != 3 {
+		t.Fatalf("Failed: expected every valid entry, got %+v", entries)
+	}
+}
@@ -0,0 +1,189 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/containers/fetchit/pkg/engine/utils"
+	"github.com/containers/podman/v4/libpod/define"
+	"github.com/containers/podman/v4/pkg/bindings/containers"
+	"github.com/containers/podman/v4/pkg/specgen"
+)
+
+const (
+	// greenSuffix names the new container while it is verified
+	greenSuffix = "-green"
+	// blueSuffix names the stopped running container while a container
+	// publishing ports replaces it, so that it can be restored
+	blueSuffix           = "-blue"
+	defaultHealthTimeout = time.Minute
+	healthInterval       = 2 * time.Second
+	// noHealthGrace is how long a container without a health check must keep
+	// running to be considered healthy
+	noHealthGrace = 5 * time.Second
+)
+
+// containerOps are the podman calls of a blue/green deploy
+type containerOps struct {
+	exists func(name string) (bool, error)
+	// create creates and starts a container
+	create  func(s *specgen.SpecGenerator) error
+	healthy func(name string) error
+	remove  func(name string) error
+	rename  func(name, newName string) error
+	stop    func(name string) error
+	start   func(name string) error
+}
+
+func podmanContainerOps(conn context.Context, healthTimeout time.Duration) *containerOps {
+	return &containerOps{
+		exists: func(name string) (bool, error) {
+			return containers.Exists(conn, name, nil)
+		},
+		create: func(s *specgen.SpecGenerator) error {
+			_, err := createAndStartContainer(conn, s)
+			return err
+		},
+		healthy: func(name string) error {
+			return waitHealthy(conn, name, healthTimeout)
+		},
+		remove: func(name string) error {
+			return deleteContainer(conn, name, nil)
+		},
+		rename: func(name, newName string) error {
+			return containers.Rename(conn, name, new(containers.RenameOptions).WithName(newName))
+		},
+		stop: func(name string) error {
+			return containers.Stop(conn, name, nil)
+		},
+		start: func(name string) error {
+			return containers.Start(conn, name, nil)
+		},
+	}
+}
+
+// blueGreenDeploy replaces the running (blue) container with a new (green)
+// container only once the green container is healthy, otherwise the green
+// container is removed and the blue container keeps running. Host ports can't
+// be published by both containers, so a green container publishing ports is
+// verified without them, then the blue container is stopped and the container
+// is created with them. If that container is not healthy the blue container
+// is started again.
+func blueGreenDeploy(ops *containerOps, s *specgen.SpecGenerator) error {
+	name := s.Name
+	blue, err := ops.exists(name)
+	if err != nil {
+		return &utils.PodmanError{Err: utils.WrapErr(err, "Error checking for container %s", name)}
+	}
+	if !blue {
+		return ops.create(s)
+	}
+
+	green := name + greenSuffix
+	// a green container left by an interrupted deploy is replaced
+	if exists, err := ops.exists(green); err == nil && exists {
+		if err := ops.remove(green); err != nil {
+			return &utils.PodmanError{Err: utils.WrapErr(err, "Error removing stale container %s", green)}
+		}
+	}
+	g := *s
+	g.Name = green
+	g.PortMappings = nil
+	if err := ops.create(&g); err != nil {
+		ops.remove(green)
+		return err
+	}
+	if err := ops.healthy(green); err != nil {
+		if rmErr := ops.remove(green); rmErr != nil {
+			logger.Warnf("Unable to remove unhealthy container %s: %v", green, rmErr)
+		}
+		return utils.WrapErr(err, "Container %s failed its health check, %s was kept", green, name)
+	}
+	logger.Infof("Container %s is healthy, replacing %s", green, name)
+
+	if len(s.PortMappings) == 0 {
+		if err := ops.remove(name); err != nil {
+			return &utils.PodmanError{Err: utils.WrapErr(err, "Error removing container %s", name)}
+		}
+		if err := ops.rename(green, name); err != nil {
+			return &utils.PodmanError{Err: utils.WrapErr(err, "Error renaming container %s to %s", green, name)}
+		}
+		return nil
+	}
+	if err := ops.remove(green); err != nil {
+		return &utils.PodmanError{Err: utils.WrapErr(err, "Error removing container %s", green)}
+	}
+	return replacePublishing(ops, s)
+}
+
+// replacePublishing replaces the running container with s, which publishes
+// host ports the running container holds. The running container is stopped
+// and kept as <name>-blue until s is healthy, and restored otherwise.
+func replacePublishing(ops *containerOps, s *specgen.SpecGenerator) error {
+	name := s.Name
+	blue := name + blueSuffix
+	// a blue container left by an interrupted deploy is replaced
+	if exists, err := ops.exists(blue); err == nil && exists {
+		if err := ops.remove(blue); err != nil {
+			return &utils.PodmanError{Err: utils.WrapErr(err, "Error removing stale container %s", blue)}
+		}
+	}
+	if err := ops.stop(name); err != nil {
+		return &utils.PodmanError{Err: utils.WrapErr(err, "Error stopping container %s", name)}
+	}
+	if err := ops.rename(name, blue); err != nil {
+		if startErr := ops.start(name); startErr != nil {
+			logger.Warnf("Unable to start container %s again: %v", name, startErr)
+		}
+		return &utils.PodmanError{Err: utils.WrapErr(err, "Error renaming container %s to %s", name, blue)}
+	}
+	err := ops.create(s)
+	if err == nil {
+		err = ops.healthy(name)
+	}
+	if err != nil {
+		if exists, exErr := ops.exists(name); exErr == nil && exists {
+			if rmErr := ops.remove(name); rmErr != nil {
+				logger.Warnf("Unable to remove unhealthy container %s: %v", name, rmErr)
+			}
+		}
+		if rnErr := ops.rename(blue, name); rnErr != nil {
+			return utils.WrapErr(err, "Container %s failed its health check and %s could not be restored: %v", name, blue, rnErr)
+		}
+		if startErr := ops.start(name); startErr != nil {
+			return utils.WrapErr(err, "Container %s failed its health check and could not be restarted: %v", name, startErr)
+		}
+		return utils.WrapErr(err, "Container %s failed its health check, the previous container was restored", name)
+	}
+	if err := ops.remove(blue); err != nil {
+		logger.Warnf("Unable to remove replaced container %s: %v", blue, err)
+	}
+	return nil
+}
+
+// waitHealthy waits for a container's health check to pass. A container
+// without a health check must keep running for noHealthGrace.
+func waitHealthy(conn context.Context, name string, timeout time.Duration) error {
+	start := time.Now()
+	for {
+		data, err := containers.Inspect(conn, name, nil)
+		if err != nil {
+			return &utils.PodmanError{Err: utils.WrapErr(err, "Error inspecting container %s", name)}
+		}
+		if data.State == nil || !data.State.Running {
+			return fmt.Errorf("container %s is not running", name)
+		}
+		if data.Config == nil || data.Config.Healthcheck == nil {
+			if time.Since(start) >= noHealthGrace {
+				return nil
+			}
+		} else if result, err := containers.RunHealthCheck(conn, name, nil); err == nil && result.Status == define.HealthCheckHealthy {
+			return nil
+		}
+		if time.Since(start) >= timeout {
+			return fmt.Errorf("container %s was not healthy after %s", name, timeout)
+		}
+		time.Sleep(healthInterval)
+	}
+}
@@ -0,0 +1,168 @@
+package engine
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/containers/common/libnetwork/types"
+	"github.com/containers/podman/v4/pkg/specgen"
+	"go.uber.org/zap"
+)
+
+// fakeContainerOps records the podman calls of a blue/green deploy
+type fakeContainerOps struct {
+	running   map[string]bool
+	unhealthy bool
+	// unhealthyPorts fails only the health check of containers publishing ports
+	unhealthyPorts bool
+	ports          map[string]bool
+	calls          []string
+}
+
+func (f *fakeContainerOps) ops() *containerOps {
+	return &containerOps{
+		exists: func(name string) (bool, error) {
+			return f.running[name], nil
+		},
+		create: func(s *specgen.SpecGenerator) error {
+			call := "create " + s.Name
+			if len(s.PortMappings) > 0 {
+				call += " with ports"
+			}
+			f.calls = append(f.calls, call)
+			f.running[s.Name] = true
+			f.ports[s.Name] = len(s.PortMappings) > 0
+			return nil
+		},
+		healthy: func(name string) error {
+			f.calls = append(f.calls, "healthy "+name)
+			if f.unhealthy || (f.unhealthyPorts && f.ports[name]) {
+				return errors.New("health check failed")
+			}
+			return nil
+		},
+		remove: func(name string) error {
+			f.calls = append(f.calls, "remove "+name)
+			delete(f.running, name)
+			return nil
+		},
+		rename: func(name, newName string) error {
+			f.calls = append(f.calls, "rename "+name+" "+newName)
+			delete(f.running, name)
+			f.running[newName] = true
+			return nil
+		},
+		stop: func(name string) error {
+			f.calls = append(f.calls, "stop "+name)
+			return nil
+		},
+		start: func(name string) error {
+			f.calls = append(f.calls, "start "+name)
+			return nil
+		},
+	}
+}
+
+func TestBlueGreenDeploy(t *testing.T) {
+	defer func(l *zap.SugaredLogger) { logger = l }(logger)
+	logger = zap.NewNop().Sugar()
+
+	ports := []types.PortMapping{{HostPort: 8080, ContainerPort: 8080}}
+	tests := []struct {
+		name           string
+		running        []string
+		ports          []types.PortMapping
+		unhealthy      bool
+		unhealthyPorts bool
+		calls          []string
+		wantErr        bool
+		after          []string
+	}{
+		{
+			name:  "first deploy",
+			calls: []string{"create web"},
+			after: []string{"web"},
+		},
+		{
+			name:    "healthy without ports",
+			running: []string{"web"},
+			calls:   []string{"create web-green", "healthy web-green", "remove web", "rename web-green web"},
+			after:   []string{"web"},
+		},
+		{
+			name:    "healthy with ports",
+			running: []string{"web"},
+			ports:   ports,
+			calls: []string{"create web-green", "healthy web-green", "remove web-green",
+				"stop web", "rename web web-blue", "create web with ports", "healthy web", "remove web-blue"},
+			after: []string{"web"},
+		},
+		{
+			name:           "unhealthy with ports restores the running container",
+			running:        []string{"web"},
+			ports:          ports,
+			unhealthyPorts: true,
+			calls: []string{"create web-green", "healthy web-green", "remove web-green",
+				"stop web", "rename web web-blue", "create web with ports", "healthy web", "remove web",
+				"rename web-blue web", "start web"},
+			wantErr: true,
+			after:   []string{"web"},
+		},
+		{
+			name:    "stale green replaced",
+			running: []string{"web", "web-green"},
+			calls:   []string{"remove web-green", "create web-green", "healthy web-green", "remove web", "rename web-green web"},
+			after:   []string{"web"},
+		},
+		{
+			name:      "unhealthy rolls back",
+			running:   []string{"web"},
+			ports:     ports,
+			unhealthy: true,
+			calls:     []string{"create web-green", "healthy web-green", "remove web-green"},
+			wantErr:   true,
+			after:     []string{"web"},
+		},
+	}
+	for _, tt := range tests {
+		f := &fakeContainerOps{running: map[string]bool{}, ports: map[string]bool{}, unhealthy: tt.unhealthy, unhealthyPorts: tt.unhealthyPorts}
+		for _, name := range tt.running {
+			f.running[name] = true
+		}
+		s := specgen.NewSpecGenerator("quay.io/fetchit/web:latest", false)
+		s.Name = "web"
+		s.PortMappings = tt.ports
+
+		err := blueGreenDeploy(f.ops(), s)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("Failed: %s: unexpected error result: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(f.calls, tt.calls) {
+			t.Fatalf("Failed: %s: calls %q, expected %q", tt.name, f.calls, tt.calls)
+		}
+		var after []string
+		for name := range f.running {
+			after = append(after, name)
+		}
+		if !reflect.DeepEqual(after, tt.after) {
+			t.Fatalf("Failed: %s: containers %q, expected %q", tt.name, after, tt.after)
+		}
+	}
+}
+
+func TestCreateSpecGenHealthCmd(t *testing.T) {
+	raw := RawPod{Image: "quay.io/fetchit/web:latest", Name: "web", HealthCmd: "curl -f http://localhost:8080/"}
+	s, err := createSpecGen(raw)
+	if err != nil {
+		t.Fatalf("Failed: unable to create spec: %v", err)
+	}
+	if s.HealthConfig == nil || !reflect.DeepEqual(s.HealthConfig.Test, []string{"CMD-SHELL", raw.HealthCmd}) {
+		t.Fatalf("Failed: health config: %+v", s.HealthConfig)
+	}
+
+	raw.HealthCmd = ""
+	if s, _ := createSpecGen(raw); s.HealthConfig != nil {
+		t.Fatalf("Failed: expected no health config, got %+v", s.HealthConfig)
+	}
+}
@@ -0,0 +1,150 @@
+package engine
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/containers/fetchit/pkg/engine/utils"
+	"github.com/spf13/cobra"
+)
+
+// bundleDir is the directory the bundle command writes the repository bundles to
+var bundleDir string
+
+var bundleCmd = &cobra.Command{
+	Use:   "bundle",
+	Short: "Export the current commit of each target for disconnected hosts",
+	Long: `Clone each git target in the config at the head of its branch and write it as a zip bundle, one per
+repository, in the format disconnected targets extract, so it can be served or carried to an offline host`,
+	Run: func(cmd *cobra.Command, args []string) {
+		f, err := loadTargets()
+		cobra.CheckErr(err)
+		bundles, err := bundleTargets(bundleTargetsOf(f), bundleDir)
+		for _, bundle := range bundles {
+			fmt.Fprintln(cmd.OutOrStdout(), bundle)
+		}
+		cobra.CheckErr(err)
+	},
+}
+
+// bundleTargetsOf returns the git targets of the methods, one per repository and branch
+func bundleTargetsOf(f *Fetchit) []*Target {
+	seen := map[string]struct{}{}
+	var targets []*Target
+	for method := range f.methodTargetScheds {
+		target := method.GetTarget()
+		// disconnected targets are already read from a bundle or a device
+		if target.url == "" || target.disconnected {
+			continue
+		}
+		key := target.url + "@" + target.branch
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		targets = append(targets, target)
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].url+"@"+targets[i].branch < targets[j].url+"@"+targets[j].branch
+	})
+	return targets
+}
+
+// bundleTargets clones the targets at the head of their branch and writes each
+// repository, with its .git directory, as a zip in dir. It returns the paths of
+// the bundles written, targets that fail are reported after the others are written.
+func bundleTargets(targets []*Target, dir string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, utils.WrapErr(err, "Error creating bundle directory %s", dir)
+	}
+	var bundles []string
+	errs := &utils.MultiError{}
+	for _, target := range targets {
+		if err := getClone(target); err != nil {
+			errs.Append(utils.WrapErr(err, "Error cloning %s", target.url))
+			continue
+		}
+		if _, err := fetchLatest(target); err != nil {
+			errs.Append(utils.WrapErr(err, "Error fetching %s", target.url))
+			continue
+		}
+		bundle := filepath.Join(dir, bundleName(target))
+		if err := zipDirectory(getDirectory(target), bundle); err != nil {
+			errs.Append(utils.WrapErr(err, "Error bundling %s", target.url))
+			continue
+		}
+		bundles = append(bundles, bundle)
+	}
+	return bundles, errs.ErrorOrNil()
+}
+
+// bundleName returns the file name of the bundle of a target's repository and branch
+func bundleName(target *Target) string {
+	name := strings.TrimSuffix(filepath.Base(target.url), ".git")
+	return name + "-" + strings.ReplaceAll(target.branch, "/", "-") + ".zip"
+}
+
+// zipDirectory writes the files below dir to a zip at zipPath, with paths
+// relative to dir. Directories are written before their files so that they are
+// extracted with their own permissions. Symlinks are skipped.
+func zipDirectory(dir, zipPath string) (err error) {
+	out, err := os.Create(zipPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	zw := zip.NewWriter(out)
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			header.Name += "/"
+			_, err = zw.CreateHeader(header)
+			return err
+		}
+		header.Method = zip.Deflate
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(w, f)
+		return err
+	})
+	if err != nil {
+		zw.Close()
+		return err
+	}
+	return zw.Close()
+}
+
+func init() {
+	bundleCmd.Flags().StringVarP(&bundleDir, "output", "o", ".", "directory to write the bundles to")
+	fetchitCmd.AddCommand(bundleCmd)
+}
@@ -0,0 +1,59 @@
+package engine
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"go.uber.org/zap"
+)
+
+func TestBundleTargets(t *testing.T) {
+	defer func(l *zap.SugaredLogger, c string) { logger, cacheDir = l, c }(logger, cacheDir)
+	logger = zap.NewNop().Sugar()
+	dir := t.TempDir()
+	cacheDir = filepath.Join(dir, "cache")
+
+	remote := filepath.Join(dir, "remote")
+	r := newTestRepoAt(t, remote)
+	hash := r.commit(map[string]string{"examples/raw/web.yaml": "image: web\n"})
+	head, err := r.repo.Head()
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+
+	target := &Target{url: remote, branch: head.Name().Short()}
+	bundles, err := bundleTargets([]*Target{target}, filepath.Join(dir, "bundles"))
+	if err != nil || len(bundles) != 1 {
+		t.Fatalf("Failed: bundles %q: %v", bundles, err)
+	}
+	if name := filepath.Base(bundles[0]); name != "remote-"+target.branch+".zip" {
+		t.Fatalf("Failed: bundle named %s", name)
+	}
+
+	// the bundle extracts to a clone at the head of the branch
+	extracted := filepath.Join(dir, "extracted")
+	if err := unzipFile(bundles[0], extracted); err != nil {
+		t.Fatalf("Failed: unable to extract bundle: %v", err)
+	}
+	clone, err := git.PlainOpen(extracted)
+	if err != nil {
+		t.Fatalf("Failed: bundle is not a repository: %v", err)
+	}
+	ref, err := clone.Head()
+	if err != nil || ref.Hash() != hash {
+		t.Fatalf("Failed: head of bundle is %v, expected %s: %v", ref, hash, err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(extracted, "examples", "raw", "web.yaml"))
+	if err != nil || string(b) != "image: web\n" {
+		t.Fatalf("Failed: bundled file is %q: %v", b, err)
+	}
+
+	// a target that can't be cloned is reported without a bundle
+	missing := &Target{url: filepath.Join(dir, "missing"), branch: "main"}
+	bundles, err = bundleTargets([]*Target{missing, target}, filepath.Join(dir, "bundles"))
+	if err == nil || len(bundles) != 1 {
+		t.Fatalf("Failed: bundles %q: %v", bundles, err)
+	}
+}
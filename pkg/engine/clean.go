@@ -28,6 +28,7 @@ func (p *Prune) GetName() string {
 }
 
 func (p *Prune) Process(ctx, conn context.Context, skew int) {
+	log := methodLogger(p)
 	target := p.GetTarget()
 	time.Sleep(time.Duration(skew) * time.Millisecond)
 	target.mu.Lock()
@@ -40,7 +41,7 @@ func (p *Prune) Process(ctx, conn context.Context, skew int) {
 
 	err := p.prunePodman(ctx, conn, opts)
 	if err != nil {
-		logger.Debugf("Repository: %s Method: %s encountered error: %v, resetting...", target.url, pruneMethod, err)
+		log.Debugf("Repository: %s Method: %s encountered error: %v, resetting...", target.url, pruneMethod, err)
 	}
 
 }
@@ -54,28 +55,29 @@ func (p *Prune) Apply(ctx, conn context.Context, currentState, desiredState plum
 }
 
 func (p *Prune) prunePodman(ctx, conn context.Context, opts system.PruneOptions) error {
-	logger.Info("Pruning system")
+	log := methodLogger(p)
+	log.Info("Pruning system")
 	report, err := system.Prune(conn, &opts)
 	if err != nil {
 		return utils.WrapErr(err, "Error pruning system")
 	}
 	for _, report := range report.ContainerPruneReports {
-		logger.Infof("Pruned container of size %v with id: %s", report.Size, report.Id)
+		log.Infof("Pruned container of size %v with id: %s", report.Size, report.Id)
 	}
 
 	for _, report := range report.ImagePruneReports {
-		logger.Infof("Pruned image of size %v with id: %s", report.Size, report.Id)
+		log.Infof("Pruned image of size %v with id: %s", report.Size, report.Id)
 	}
 
 	for _, report := range report.PodPruneReport {
-		logger.Infof("Pruned pod with id: %s", report.Id)
+		log.Infof("Pruned pod with id: %s", report.Id)
 	}
 
 	for _, report := range report.VolumePruneReports {
-		logger.Infof("Pruned volume of size %v with id: %s", report.Size, report.Id)
+		log.Infof("Pruned volume of size %v with id: %s", report.Size, report.Id)
 	}
 
-	logger.Infof("Reclaimed %vB", report.ReclaimedSpace)
+	log.Infof("Reclaimed %vB", report.ReclaimedSpace)
 
 	return nil
 }
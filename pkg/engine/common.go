@@ -2,11 +2,17 @@ package engine
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/containers/fetchit/pkg/engine/utils"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
 )
@@ -19,13 +25,71 @@ type CommonMethod struct {
 	Schedule string `mapstructure:"schedule"`
 	// Number of seconds to skew the schedule by
 	Skew *int `mapstructure:"skew"`
+	// Timeout bounds a single run of the method, such as 10m, no limit if unset
+	Timeout time.Duration `mapstructure:"timeout"`
+	// RunOnce runs the method a single time when it is scheduled instead of on the Schedule
+	RunOnce bool `mapstructure:"runOnce"`
+	// ContinueOnError moves the method to the new commit when only some of its files fail to apply
+	ContinueOnError bool `mapstructure:"continueOnError"`
+	// Overlap is skip, wait or allow, overriding the overlap policy of the config for the method
+	Overlap string `mapstructure:"overlap"`
+	// Priority orders the first runs of the methods that set one, lower priorities run first
+	Priority *int `mapstructure:"priority"`
 	// Where in the git repository to fetch a file or directory (to fetch all files in directory)
 	TargetPath string `mapstructure:"targetPath"`
+	// TargetPaths are further paths in the git repository the method watches along with TargetPath
+	TargetPaths []string `mapstructure:"targetPaths"`
+	// EnvPath is a template of the path, such as environments/{{ .Env }}, rendered with the host
+	// facts on each run and used in place of TargetPath
+	EnvPath string `mapstructure:"envPath"`
 	// A glob to pattern match files in the target path directory
 	Glob *string `mapstructure:"glob"`
+	// Globs of files in the target path directory to include, all files matching Glob are included if empty
+	Include []string `mapstructure:"include"`
+	// Globs of files in the target path directory to skip, takes precedence over Include
+	Exclude []string `mapstructure:"exclude"`
+	// Tags are the file extensions to process, overriding the defaults of the method
+	Tags []string `mapstructure:"tags"`
+	// Enabled set to false keeps the method from being scheduled without removing it from the config
+	Enabled *bool `mapstructure:"enabled"`
 	// initialRun is set by fetchit
 	initialRun bool
 	target     *Target
+	// envTargetPath is the rendered EnvPath
+	envTargetPath string
+	// lastSync is the outcome of the last sync, guarded by the mu of the target
+	lastSync syncResult
+}
+
+// defaultTags are the file extensions each method processes by default,
+// methods that are not listed process every file
+var defaultTags = map[string][]string{
+	ansibleMethod: {".yaml", ".yml"},
+	kubeMethod:    {".yaml", ".yml"},
+	networkMethod: {".json", ".yaml", ".yml"},
+	rawMethod:     {".json", ".yaml", ".yml"},
+	systemdMethod: {".service"},
+	volumeMethod:  {".json", ".yaml", ".yml"},
+}
+
+// getTags returns the file extensions a method of the given kind processes,
+// nil to process every file
+func (m *CommonMethod) getTags(kind string) *[]string {
+	tags := m.Tags
+	if len(tags) == 0 {
+		defaults, ok := defaultTags[kind]
+		if !ok {
+			return nil
+		}
+		tags = defaults
+	}
+	tags = append([]string{}, tags...)
+	return &tags
+}
+
+// isEnabled returns whether the method is scheduled, methods are enabled unless set to false
+func (m *CommonMethod) isEnabled() bool {
+	return m.Enabled == nil || *m.Enabled
 }
 
 func (m *CommonMethod) GetName() string {
@@ -36,77 +100,296 @@ func (m *CommonMethod) SchedInfo() SchedInfo {
 	return SchedInfo{
 		schedule: m.Schedule,
 		skew:     m.Skew,
+		timeout:  m.Timeout,
+		runOnce:  m.RunOnce,
+		overlap:  m.Overlap,
 	}
 }
 
 func (m *CommonMethod) GetTargetPath() string {
+	return cleanTargetPath(m.targetPath())
+}
+
+// targetPath returns the rendered EnvPath of the method when it has one, its TargetPath otherwise
+func (m *CommonMethod) targetPath() string {
+	if m.EnvPath != "" {
+		return m.envTargetPath
+	}
 	return m.TargetPath
 }
 
+// renderEnvPath renders the EnvPath of the method with the host facts. A path
+// using the Env fact of a host without $FETCHIT_ENV is an error, so that the
+// method does not apply the files of every environment.
+func (m *CommonMethod) renderEnvPath(facts *hostFacts) error {
+	if m.EnvPath == "" {
+		return nil
+	}
+	if facts.Env == "" && strings.Contains(m.EnvPath, ".Env") {
+		return &utils.ValidationError{Err: fmt.Errorf("envPath %s of %s needs $FETCHIT_ENV to be set", m.EnvPath, m.Name)}
+	}
+	p, err := renderTemplate(m.Name, []byte(m.EnvPath), facts)
+	if err != nil {
+		return err
+	}
+	m.envTargetPath = string(p)
+	return nil
+}
+
+// resolveEnvPath renders the EnvPath of a method before its changes are
+// computed, methods without one are left as they are
+func resolveEnvPath(conn context.Context, m Method) error {
+	gm, ok := m.(gitMethod)
+	if !ok || gm.common().EnvPath == "" {
+		return nil
+	}
+	facts, err := gatherHostFacts(conn)
+	if err != nil {
+		return err
+	}
+	return gm.common().renderEnvPath(facts)
+}
+
+// GetTargetPaths returns every path the method watches, TargetPath first. An
+// unset TargetPath is the repository root only when TargetPaths is empty.
+func (m *CommonMethod) GetTargetPaths() []string {
+	paths := []string{}
+	seen := map[string]struct{}{}
+	for _, p := range append([]string{m.targetPath()}, m.TargetPaths...) {
+		if p == "" && len(m.TargetPaths) > 0 {
+			continue
+		}
+		p = cleanTargetPath(p)
+		if _, ok := seen[p]; ok {
+			continue
+		}
+		seen[p] = struct{}{}
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+// targetChanges returns the changes between two commits under every target
+// path of the method, merged into one change map
+func (m *CommonMethod) targetChanges(ctx context.Context, currentState, desiredState plumbing.Hash, tags *[]string) (map[*object.Change]string, error) {
+	changeMap := make(map[*object.Change]string)
+	for _, targetPath := range m.GetTargetPaths() {
+		changes, err := applyChanges(ctx, m.GetTarget(), targetPath, m.Glob, m.Include, m.Exclude, currentState, desiredState, tags)
+		if err != nil {
+			return nil, err
+		}
+		for change, path := range changes {
+			changeMap[change] = path
+		}
+	}
+	return changeMap, nil
+}
+
+// cleanTargetPath normalizes a target path to the form git trees are looked
+// up by, a relative path without ./ or trailing slashes. The root of the
+// repository is the empty path.
+func cleanTargetPath(targetPath string) string {
+	return strings.TrimPrefix(path.Clean("/"+targetPath), "/")
+}
+
 func (m *CommonMethod) GetTarget() *Target {
 	return m.target
 }
 
-func zeroToCurrent(ctx, conn context.Context, m Method, target *Target, tag *[]string) error {
+func (m *CommonMethod) common() *CommonMethod {
+	return m
+}
+
+// containerNameSep separates the target and method names in the names of
+// helper containers, target names may not contain it
+const containerNameSep = "_"
+
+// containerName returns the name used for the helper containers of the method.
+// The target name is included so that methods sharing a name in different
+// targets do not collide.
+func (m *CommonMethod) containerName() string {
+	if m.target == nil || m.target.name == "" {
+		return m.Name
+	}
+	return m.target.name + containerNameSep + m.Name
+}
+
+// checkTargetName returns an error for a target name that would make the
+// container names of its methods ambiguous with those of another target
+func checkTargetName(name string) error {
+	if strings.Contains(name, containerNameSep) {
+		return &utils.ValidationError{Err: fmt.Errorf("target name %s may not contain %q, it separates the target and method names of containers", name, containerNameSep)}
+	}
+	return nil
+}
+
+// targetName returns the name of a target, or its url when it has no name
+func targetName(target *Target) string {
+	if target == nil {
+		return ""
+	}
+	if target.name == "" {
+		return target.url
+	}
+	return target.name
+}
+
+func zeroToCurrent(ctx, conn context.Context, m Method, target *Target, tag *[]string) (err error) {
+	defer func() { setSyncResult(m, err) }()
+	if err := resolveEnvPath(conn, m); err != nil {
+		return fmt.Errorf("Failed to render envPath: %w", err)
+	}
 	current, err := getCurrent(target, m.GetKind(), m.GetName())
 	if err != nil {
-		return fmt.Errorf("Failed to get current commit: %v", err)
+		return fmt.Errorf("Failed to get current commit: %w", err)
 	}
 
 	if current != plumbing.ZeroHash {
 		err = m.Apply(ctx, conn, plumbing.ZeroHash, current, tag)
 		if err != nil {
-			return fmt.Errorf("Failed to apply changes: %v", err)
+			return fmt.Errorf("Failed to apply changes: %w", err)
 		}
 
-		logger.Infof("Moved %s to commit %s for git target %s", m.GetName(), current.String()[:hashReportLen], target.url)
+		methodLogger(m).Infof("Moved %s to commit %s for git target %s", m.GetName(), current.String()[:hashReportLen], target.url)
 	}
 
 	return nil
 }
 
+// getDirectory returns the directory a target's repository is cloned into.
+// The directory is keyed by a hash of the full url so that repositories
+// sharing a basename do not collide. Repositories copied from a device are
+// keyed by the device and the target's name instead.
 func getDirectory(target *Target) string {
+	if target.disconnected && len(target.device) > 0 {
+		sum := sha256.Sum256([]byte(target.device + "\x00" + target.name))
+		return filepath.Join(cacheDir, "device-"+hex.EncodeToString(sum[:])[:hashReportLen])
+	}
 	trimDir := strings.TrimSuffix(target.url, path.Ext(target.url))
-	return filepath.Base(trimDir)
+	sum := sha256.Sum256([]byte(target.url))
+	return filepath.Join(cacheDir, filepath.Base(trimDir)+"-"+hex.EncodeToString(sum[:])[:hashReportLen])
 }
 
-func currentToLatest(ctx, conn context.Context, m Method, target *Target, tag *[]string) error {
+// legacyDirectory returns the directory a target's repository was cloned into
+// before clone directories were keyed by url
+func legacyDirectory(target *Target) string {
+	return filepath.Base(strings.TrimSuffix(target.url, path.Ext(target.url)))
+}
+
+func currentToLatest(ctx, conn context.Context, m Method, target *Target, tag *[]string) (err error) {
+	defer func() { setSyncResult(m, err) }()
 	directory := getDirectory(target)
 	if target.disconnected {
 		if len(target.url) > 0 {
-			extractZip(target.url)
+			if err := extractZip(directory, target.url, target.publicKey); err != nil {
+				return fmt.Errorf("Failed to extract disconnected archive: %w", err)
+			}
 		} else if len(target.device) > 0 {
-			localDevicePull(directory, target.device, "", false)
+			localDevicePull(targetName(target), directory, "", target.device, target.filesystemType, target.mountOptions, "", false)
 		}
 	}
+	if err := resolveEnvPath(conn, m); err != nil {
+		return fmt.Errorf("Failed to render envPath: %w", err)
+	}
 	latest, err := getLatest(target)
 	if err != nil {
-		return fmt.Errorf("Failed to get latest commit: %v", err)
+		return fmt.Errorf("Failed to get latest commit: %w", err)
 	}
 
 	current, err := getCurrent(target, m.GetKind(), m.GetName())
 	if err != nil {
-		return fmt.Errorf("Failed to get current commit: %v", err)
+		return fmt.Errorf("Failed to get current commit: %w", err)
 	}
 
 	if latest != current {
-		if err := m.Apply(ctx, conn, current, latest, tag); err != nil {
-			return fmt.Errorf("Failed to apply changes: %v", err)
+		files := auditFiles(m, current, latest)
+		err := m.Apply(ctx, conn, current, latest, tag)
+		recordApply(m, current, latest, files, err)
+		if err != nil && !continuesOnError(m, err) {
+			return fmt.Errorf("Failed to apply changes: %w", err)
 		}
 		updateCurrent(ctx, target, latest, m.GetKind(), m.GetName())
-		logger.Infof("Moved %s from %s to %s for git target %s", m.GetName(), current.String()[:hashReportLen], latest, target.url)
+		methodLogger(m).Infof("Moved %s from %s to %s for git target %s", m.GetName(), current.String()[:hashReportLen], latest, target.url)
+		if err != nil {
+			// the failed files are applied again when they next change
+			return fmt.Errorf("Failed to apply some changes: %w", err)
+		}
 	} else {
-		logger.Infof("No changes applied to git target %s this run, %s currently at %s", directory, m.GetKind(), current.String()[:hashReportLen])
+		methodLogger(m).Infof("No changes applied to git target %s this run, %s currently at %s", directory, m.GetKind(), current.String()[:hashReportLen])
 	}
+	recordSync(time.Now())
 
 	return nil
 }
 
+// runChanges applies every change, continuing past failures so that all
+// failed files are reported at once
 func runChanges(ctx context.Context, conn context.Context, m Method, changeMap map[*object.Change]string) error {
-	for change, changePath := range changeMap {
-		if err := m.MethodEngine(ctx, conn, change, changePath); err != nil {
-			return err
+	return runChangesInOrder(ctx, conn, m, changeMap, sortedChanges(changeMap))
+}
+
+// sortedChanges returns the changes of a change map sorted by file path, so
+// that changes are applied in the same order on every run
+func sortedChanges(changeMap map[*object.Change]string) []*object.Change {
+	changes := make([]*object.Change, 0, len(changeMap))
+	for change := range changeMap {
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changeName(changes[i]) < changeName(changes[j])
+	})
+	return changes
+}
+
+// runChangesInOrder runs the changes of a change map in the given order. When
+// only some of the changes fail, the error is a partialApplyError.
+func runChangesInOrder(ctx context.Context, conn context.Context, m Method, changeMap map[*object.Change]string, changes []*object.Change) error {
+	errs := &utils.MultiError{}
+	applied := 0
+	for _, change := range changes {
+		if err := m.MethodEngine(ctx, conn, change, changeMap[change]); err != nil {
+			errs.Append(fmt.Errorf("%s: %w", changeName(change), err))
+			continue
 		}
+		applied++
 	}
-	return nil
+	if len(errs.Errors) > 0 && applied > 0 {
+		return &partialApplyError{applied: applied, errs: errs}
+	}
+	return errs.ErrorOrNil()
+}
+
+// partialApplyError is returned when some of the changes of an apply failed
+// while the others were applied
+type partialApplyError struct {
+	applied int
+	errs    *utils.MultiError
+}
+
+func (e *partialApplyError) Error() string {
+	return fmt.Sprintf("%d of %d files failed: %v", len(e.errs.Errors), len(e.errs.Errors)+e.applied, e.errs)
+}
+
+func (e *partialApplyError) Unwrap() error {
+	return e.errs
+}
+
+// continuesOnError returns whether a method set to continueOnError moves to
+// the new commit after an apply that failed with err, which it does as long
+// as some of its files were applied
+func continuesOnError(m Method, err error) bool {
+	gm, ok := m.(gitMethod)
+	if !ok || !gm.common().ContinueOnError {
+		return false
+	}
+	var partial *partialApplyError
+	return errors.As(err, &partial)
+}
+
+// changeName returns the path of the file a change applies to
+func changeName(change *object.Change) string {
+	if change.To.Name != "" {
+		return change.To.Name
+	}
+	return change.From.Name
 }
@@ -0,0 +1,168 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func TestGetDirectory(t *testing.T) {
+	acme := getDirectory(&Target{url: "https://github.com/acme/app.git"})
+	other := getDirectory(&Target{url: "https://github.com/other/app.git"})
+	if acme == other {
+		t.Fatalf("Failed: repositories with the same basename share directory %s", acme)
+	}
+	if filepath.Base(acme)[:4] != "app-" {
+		t.Fatalf("Failed: directory %s is not named after the repository", acme)
+	}
+	if again := getDirectory(&Target{url: "https://github.com/acme/app.git"}); again != acme {
+		t.Fatalf("Failed: directory: %s != %s", again, acme)
+	}
+
+	oldCacheDir := cacheDir
+	cacheDir = "repos"
+	defer func() { cacheDir = oldCacheDir }()
+	if dir := getDirectory(&Target{url: "https://github.com/acme/app.git"}); dir != filepath.Join("repos", acme) {
+		t.Fatalf("Failed: directory: %s != %s", dir, filepath.Join("repos", acme))
+	}
+
+	field := getDirectory(&Target{name: "field", device: "/dev/sdb1", disconnected: true})
+	if filepath.Dir(field) != "repos" || !strings.HasPrefix(filepath.Base(field), "device-") {
+		t.Fatalf("Failed: device directory %s is not below the cacheDir", field)
+	}
+	if again := getDirectory(&Target{name: "field", device: "/dev/sdb1", disconnected: true}); again != field {
+		t.Fatalf("Failed: device directory: %s != %s", again, field)
+	}
+	for _, other := range []*Target{
+		{name: "lab", device: "/dev/sdb1", disconnected: true},
+		{name: "field", device: "/dev/sdc1", disconnected: true},
+	} {
+		if dir := getDirectory(other); dir == field {
+			t.Fatalf("Failed: device target %s on %s shares directory %s", other.name, other.device, dir)
+		}
+	}
+}
+
+func TestGetDirectorySameRepoNameOnDifferentHosts(t *testing.T) {
+	github := getDirectory(&Target{url: "https://github.com/a/deploy"})
+	gitlab := getDirectory(&Target{url: "https://gitlab.com/b/deploy"})
+	if github == gitlab {
+		t.Fatalf("Failed: repositories on different hosts share directory %s", github)
+	}
+
+	github = getDirectory(&Target{url: "https://github.com/a/deploy.zip", disconnected: true})
+	gitlab = getDirectory(&Target{url: "https://gitlab.com/b/deploy.zip", disconnected: true})
+	if github == gitlab {
+		t.Fatalf("Failed: disconnected archives on different hosts share directory %s", github)
+	}
+}
+
+// recordMethod records the order in which changes are applied, failing the
+// changes of the files in fail
+type recordMethod struct {
+	CommonMethod
+	applied []string
+	fail    map[string]bool
+}
+
+func (m *recordMethod) GetKind() string {
+	return "record"
+}
+
+func (m *recordMethod) Process(ctx, conn context.Context, skew int) {}
+
+func (m *recordMethod) MethodEngine(ctx, conn context.Context, change *object.Change, path string) error {
+	if m.fail[changeName(change)] {
+		return errors.New("invalid manifest")
+	}
+	m.applied = append(m.applied, changeName(change))
+	return nil
+}
+
+func (m *recordMethod) Apply(ctx, conn context.Context, currentState, desiredState plumbing.Hash, tags *[]string) error {
+	return nil
+}
+
+func TestRunChangesOrder(t *testing.T) {
+	changeMap := map[*object.Change]string{
+		{To: object.ChangeEntry{Name: "web/nginx.yaml"}}:   "repo/web/nginx.yaml",
+		{From: object.ChangeEntry{Name: "db/old.yaml"}}:    deleteFile,
+		{To: object.ChangeEntry{Name: "app.yaml"}}:         "repo/app.yaml",
+		{To: object.ChangeEntry{Name: "db/postgres.yaml"}}: "repo/db/postgres.yaml",
+	}
+	expected := []string{"app.yaml", "db/old.yaml", "db/postgres.yaml", "web/nginx.yaml"}
+	for i := 0; i < 10; i++ {
+		m := &recordMethod{}
+		if err := runChanges(context.Background(), context.Background(), m, changeMap); err != nil {
+			t.Fatalf("Failed: unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(m.applied, expected) {
+			t.Fatalf("Failed: changes applied in order %v, expected %v", m.applied, expected)
+		}
+	}
+}
+
+func TestRunChangesContinueOnError(t *testing.T) {
+	changeMap := map[*object.Change]string{
+		{To: object.ChangeEntry{Name: "a.yaml"}}: "repo/a.yaml",
+		{To: object.ChangeEntry{Name: "b.yaml"}}: "repo/b.yaml",
+		{To: object.ChangeEntry{Name: "c.yaml"}}: "repo/c.yaml",
+	}
+	m := &recordMethod{CommonMethod: CommonMethod{ContinueOnError: true}, fail: map[string]bool{"b.yaml": true}}
+	err := runChanges(context.Background(), context.Background(), m, changeMap)
+	if err == nil || !strings.Contains(err.Error(), "b.yaml: invalid manifest") {
+		t.Fatalf("Failed: expected the error of b.yaml, got %v", err)
+	}
+	if expected := []string{"a.yaml", "c.yaml"}; !reflect.DeepEqual(m.applied, expected) {
+		t.Fatalf("Failed: applied %v, expected %v", m.applied, expected)
+	}
+	if !continuesOnError(m, fmt.Errorf("apply: %w", err)) {
+		t.Fatalf("Failed: expected the method to move to the new commit")
+	}
+
+	m.ContinueOnError = false
+	if continuesOnError(m, err) {
+		t.Fatalf("Failed: a method without continueOnError moved to the new commit")
+	}
+
+	// nothing was applied
+	m = &recordMethod{CommonMethod: CommonMethod{ContinueOnError: true}, fail: map[string]bool{"a.yaml": true, "b.yaml": true, "c.yaml": true}}
+	err = runChanges(context.Background(), context.Background(), m, changeMap)
+	if err == nil || continuesOnError(m, err) {
+		t.Fatalf("Failed: expected the method to stay at its commit when every file failed, got %v", err)
+	}
+}
+
+func TestGetTags(t *testing.T) {
+	r := &Raw{}
+	if tags := r.getTags(rawMethod); tags == nil || !reflect.DeepEqual(*tags, []string{".json", ".yaml", ".yml"}) {
+		t.Fatalf("Failed: raw default tags: %v", tags)
+	}
+	sd := &Systemd{}
+	if tags := sd.getTags(systemdMethod); tags == nil || !reflect.DeepEqual(*tags, []string{".service"}) {
+		t.Fatalf("Failed: systemd default tags: %v", tags)
+	}
+	ft := &FileTransfer{}
+	if tags := ft.getTags(filetransferMethod); tags != nil {
+		t.Fatalf("Failed: filetransfer should process every file, got %v", *tags)
+	}
+
+	r = &Raw{CommonMethod: CommonMethod{Tags: []string{".json", ".jsonnet"}}}
+	tags := r.getTags(rawMethod)
+	if tags == nil || !reflect.DeepEqual(*tags, []string{".json", ".jsonnet"}) {
+		t.Fatalf("Failed: raw tags override: %v", tags)
+	}
+	// the defaults can't be modified through the returned tags
+	k := &Kube{}
+	(*k.getTags(kubeMethod))[0] = ".txt"
+	if defaultTags[kubeMethod][0] == ".txt" {
+		t.Fatalf("Failed: kube default tags were modified")
+	}
+}
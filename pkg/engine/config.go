@@ -2,21 +2,34 @@ package engine
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
+	"strings"
 	"time"
 
-	"github.com/containers/podman/v4/pkg/bindings"
+	"github.com/blang/semver/v4"
+	"github.com/containers/fetchit/pkg/engine/utils"
+	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/gobwas/glob"
+	"github.com/sigstore/sigstore/pkg/signature"
 )
 
-const configFileMethod = "config"
+const (
+	configFileMethod = "config"
+	// defaultConfigFile is the path of the config in a configRepo when configPath is not set
+	defaultConfigFile = "config.yaml"
+)
 
 // ConfigReload configures a target for dynamic loading of fetchit config updates
 // $FETCHIT_CONFIG_URL environment variable or a local file with a ConfigReload target
@@ -29,7 +42,25 @@ type ConfigReload struct {
 	ConfigURL    string `mapstructure:"configURL"`
 	Device       string `mapstructure:"device"`
 	ConfigPath   string `mapstructure:"configPath"`
-	GitAuth      `mapstructure:",squash"`
+	// PublicKey is the path to a PEM public key, when set a config downloaded from
+	// the configURL is only applied if the signature at configURL.sig verifies with it
+	PublicKey string `mapstructure:"publicKey"`
+	// ManualApprove stages config updates from the configURL instead of restarting
+	// with them, a staged config is applied once defaultConfigApprove is touched
+	ManualApprove bool `mapstructure:"manualApprove"`
+	// ConfigRepo is a git repository to read the config from instead of the configURL,
+	// the config is read from configPath on configBranch
+	ConfigRepo   string `mapstructure:"configRepo"`
+	ConfigBranch string `mapstructure:"configBranch"`
+	// ApprovedBranches are globs of the branches the configRepo may be read from, such as
+	// main or release-*, any branch is read from if unset
+	ApprovedBranches []string `mapstructure:"approvedBranches"`
+	// ConfigTagRange is a semver range such as ">=1.0.0 <2.0.0", when set the config is only
+	// reloaded at commits of the configRepo tagged with a version in the range
+	ConfigTagRange string `mapstructure:"configTagRange"`
+	// HTTPAuth is sent when downloading the config from the configURL
+	HTTPAuth *HTTPAuth `mapstructure:"httpAuth"`
+	GitAuth  `mapstructure:",squash"`
 }
 
 func (c *ConfigReload) GetKind() string {
@@ -41,6 +72,7 @@ func (c *ConfigReload) GetName() string {
 }
 
 func (c *ConfigReload) Process(ctx, conn context.Context, skew int) {
+	log := methodLogger(c)
 	time.Sleep(time.Duration(skew) * time.Millisecond)
 	// configURL in config file will override the environment variable
 	envURL := os.Getenv("FETCHIT_CONFIG_URL")
@@ -55,24 +87,46 @@ func (c *ConfigReload) Process(ctx, conn context.Context, skew int) {
 	username := fetchit.username
 	password := fetchit.password
 	// If ConfigURL is not populated, warn and leave
-	if envURL == "" && c.Device == "" {
-		logger.Debugf("Fetchit ConfigReload found, but neither $FETCHIT_CONFIG_URL on system nor ConfigReload.ConfigURL are set, exiting without updating the config.")
+	if envURL == "" && c.ConfigRepo == "" && c.Device == "" {
+		log.Debugf("Fetchit ConfigReload found, but neither $FETCHIT_CONFIG_URL on system nor ConfigReload.ConfigURL are set, exiting without updating the config.")
+	}
+	if c.ConfigRepo != "" {
+		restart, err := checkForGitConfigUpdates(c.GetTarget(), c.ConfigPath, c.ApprovedBranches, c.ConfigTagRange)
+		if err != nil {
+			log.Errorf("Error checking %s for config updates: %v", c.ConfigRepo, err)
+		}
+		if !restart {
+			return
+		}
+		log.Info("Updated config processed, restarting with new targets")
+		fetchitConfig.Restart()
+		return
 	}
 	// CheckForConfigUpdates downloads & places config file in defaultConfigPath
 	// if the downloaded config file differs from what's currently on the system.
-	if envURL != "" {
-		restart := checkForConfigUpdates(envURL, true, false, pat, username, password)
+	if envURL != "" && c.ManualApprove {
+		restart, err := checkForApprovedConfigUpdates(envURL, pat, username, password, configHTTPAuth(c), configPublicKey(c))
+		if err != nil {
+			log.Error(err)
+		}
+		if !restart {
+			return
+		}
+		log.Info("Approved config processed, restarting with new targets")
+		fetchitConfig.Restart()
+	} else if envURL != "" {
+		restart := checkForConfigUpdates(envURL, true, false, pat, username, password, configHTTPAuth(c), configPublicKey(c))
 		if !restart {
 			return
 		}
-		logger.Info("Updated config processed, restarting with new targets")
+		log.Info("Updated config processed, restarting with new targets")
 		fetchitConfig.Restart()
 	} else if c.Device != "" {
 		restart := checkForDisconUpdates(c.Device, c.ConfigPath, true, false)
 		if !restart {
 			return
 		}
-		logger.Info("Updated config processed, restarting with new targets")
+		log.Info("Updated config processed, restarting with new targets")
 		fetchitConfig.Restart()
 	}
 
@@ -90,31 +144,167 @@ func (c *ConfigReload) Apply(ctx, conn context.Context, currentState, desiredSta
 // in defaultConfigPath in fetchit container (/opt/mount/config.yaml).
 // This runs with the initial startup as well as with scheduled ConfigReload runs,
 // if $FETCHIT_CONFIG_URL is set.
-func checkForConfigUpdates(envURL string, existsAlready bool, initial bool, pat, username, password string) bool {
+func checkForConfigUpdates(envURL string, existsAlready bool, initial bool, pat, username, password string, auth *HTTPAuth, publicKey string) bool {
 	// envURL is either set by user or set to match a configURL in a configReload
 	if envURL == "" {
 		return false
 	}
-	reset, err := downloadUpdateConfigFile(envURL, existsAlready, initial, pat, username, password)
+	reset, err := downloadUpdateConfigFile(envURL, existsAlready, initial, pat, username, password, auth, publicKey)
 	if err != nil {
 		logger.Info(err)
 	}
 	return reset
 }
 
+// checkForGitConfigUpdates fetches the config repository and places the config
+// file at configPath in defaultConfigPath when the tracked commit changes it.
+// Only commits approved by branches and tagRange are applied.
+func checkForGitConfigUpdates(target *Target, configPath string, branches []string, tagRange string) (bool, error) {
+	target.mu.Lock()
+	defer target.mu.Unlock()
+	if target.branch == "" {
+		return false, &utils.ValidationError{Err: fmt.Errorf("configBranch must be set with configRepo %s", target.url)}
+	}
+	if configPath == "" {
+		configPath = defaultConfigFile
+	}
+	if err := getClone(target); err != nil {
+		return false, &utils.GitError{Err: utils.WrapErr(err, "Error cloning config repository %s", target.url)}
+	}
+	latest, err := getLatest(target)
+	if err != nil {
+		return false, err
+	}
+	current, err := getCurrent(target, configFileMethod, configFileMethod)
+	if err != nil {
+		return false, err
+	}
+	if latest == current {
+		return false, nil
+	}
+	approved, err := approvedConfigCommit(getDirectory(target), target.branch, latest, branches, tagRange)
+	if err != nil || !approved {
+		return false, err
+	}
+
+	tree, err := getSubTreeFromHash(getDirectory(target), latest, "")
+	if err != nil {
+		return false, &utils.GitError{Err: err}
+	}
+	f, err := tree.File(cleanTargetPath(configPath))
+	if err != nil {
+		return false, &utils.GitError{Err: utils.WrapErr(err, "Error finding %s at commit %s", configPath, latest.String()[:hashReportLen])}
+	}
+	contents, err := f.Contents()
+	if err != nil {
+		return false, &utils.GitError{Err: err}
+	}
+	newBytes := []byte(contents)
+
+	restart := false
+	currentConfigBytes, err := ioutil.ReadFile(defaultConfigPath)
+	if err != nil || !bytes.Equal(newBytes, currentConfigBytes) {
+		if err == nil {
+			if err := os.WriteFile(defaultConfigBackup, currentConfigBytes, 0600); err != nil {
+				return false, fmt.Errorf("could not copy %s to path %s: %v", defaultConfigPath, defaultConfigBackup, err)
+			}
+			logger.Infof("Current config backup placed at %s", defaultConfigBackup)
+		}
+		if err := os.WriteFile(defaultConfigPath, newBytes, 0600); err != nil {
+			return false, fmt.Errorf("unable to write new config contents: %v", err)
+		}
+		logger.Infof("Config updates found in %s at commit %s, will load new targets", target.url, latest.String()[:hashReportLen])
+		restart = true
+	}
+	if err := updateCurrent(context.Background(), target, latest, configFileMethod, configFileMethod); err != nil {
+		return restart, err
+	}
+	return restart, nil
+}
+
+// approvedConfigCommit returns whether the config at a commit of branch may be
+// applied. The branch must match one of branches and, with a tagRange, the
+// commit must be tagged with a version in the range. An unapproved branch is
+// an error, while an untagged commit waits for its tag.
+func approvedConfigCommit(directory, branch string, commit plumbing.Hash, branches []string, tagRange string) (bool, error) {
+	if len(branches) > 0 {
+		approved := false
+		for _, pattern := range branches {
+			g, err := glob.Compile(pattern)
+			if err != nil {
+				return false, &utils.ValidationError{Err: utils.WrapErr(err, "Error parsing approved branch %s", pattern)}
+			}
+			if g.Match(branch) {
+				approved = true
+				break
+			}
+		}
+		if !approved {
+			return false, &utils.ValidationError{Err: fmt.Errorf("configBranch %s is not one of the approvedBranches %v", branch, branches)}
+		}
+	}
+	if tagRange == "" {
+		return true, nil
+	}
+	versions, err := semver.ParseRange(tagRange)
+	if err != nil {
+		return false, &utils.ValidationError{Err: utils.WrapErr(err, "Error parsing configTagRange %s", tagRange)}
+	}
+	repo, err := git.PlainOpen(directory)
+	if err != nil {
+		return false, utils.WrapErr(err, "Error opening repository %s to read tags", directory)
+	}
+	tags, err := repo.Tags()
+	if err != nil {
+		return false, &utils.GitError{Err: utils.WrapErr(err, "Error listing tags of repository %s", directory)}
+	}
+	approved := false
+	err = tags.ForEach(func(ref *plumbing.Reference) error {
+		hash := ref.Hash()
+		// annotated tags point at a tag object rather than the commit
+		if tag, err := repo.TagObject(hash); err == nil {
+			hash = tag.Target
+		}
+		if hash != commit {
+			return nil
+		}
+		if v, err := semver.ParseTolerant(ref.Name().Short()); err == nil && versions(v) {
+			approved = true
+			return storer.ErrStop
+		}
+		return nil
+	})
+	if err != nil {
+		return false, &utils.GitError{Err: utils.WrapErr(err, "Error reading tags of repository %s", directory)}
+	}
+	if !approved {
+		logger.Infof("Config at commit %s of %s is not tagged with a version in %s, not reloading", commit.String()[:hashReportLen], branch, tagRange)
+	}
+	return approved, nil
+}
+
+// configPublicKey returns the public key downloaded configs must be signed with,
+// the publicKey of a ConfigReload overrides $FETCHIT_CONFIG_PUBLIC_KEY
+func configPublicKey(c *ConfigReload) string {
+	if c != nil && c.PublicKey != "" {
+		return c.PublicKey
+	}
+	return os.Getenv("FETCHIT_CONFIG_PUBLIC_KEY")
+}
+
 // CheckForDisconUpdates identifies if the device is connected and if a cache file exists
 func checkForDisconUpdates(device, configPath string, existsAlready bool, initial bool) bool {
 	ctx := context.Background()
 	name := "fetchit-config"
 	cache := "/opt/.cache/" + name
 	dest := cache + "/" + "config.yaml"
-	conn, err := bindings.NewConnection(ctx, "unix://run/podman/podman.sock")
+	conn, err := connectPodman(ctx)
 	if err != nil {
 		logger.Error("Failed to create connection to podman")
 		return false
 	}
 	// Ensure that the device is present
-	_, exitCode, err := localDeviceCheck(name, device, "")
+	_, exitCode, err := localDeviceCheck("", name, device, "")
 	if err != nil {
 		logger.Error("Failed to check device")
 		return false
@@ -128,14 +318,16 @@ func checkForDisconUpdates(device, configPath string, existsAlready bool, initia
 		if _, err := os.Stat(dest); os.IsNotExist(err) {
 			// make the cache directory
 			err = os.MkdirAll(cache, 0755)
-			copyFile := ("/mnt/" + configPath + " " + dest)
-			s := generateDeviceSpec(filetransferMethod, "disconnected-", copyFile, device, name)
+			s := generateDeviceSpec(filetransferMethod, "", "disconnected-", "/mnt/"+configPath, dest, device, "", "", name)
 			createResponse, err := createAndStartContainer(conn, s)
 			if err != nil {
 				return false
 			}
 			// Wait for the container to finish
-			waitAndRemoveContainer(conn, createResponse.ID)
+			if err := waitAndRemoveContainer(conn, createResponse.ID); err != nil {
+				logger.Errorf("Failed to copy config from device %s: %v", device, err)
+				return false
+			}
 			logger.Info("container created", createResponse.ID)
 			currentConfigBytes, err := ioutil.ReadFile(defaultConfigPath)
 			newBytes, err := ioutil.ReadFile(dest)
@@ -158,65 +350,192 @@ func checkForDisconUpdates(device, configPath string, existsAlready bool, initia
 }
 
 // downloadUpdateConfig returns true if config was updated in fetchit pod
-func downloadUpdateConfigFile(urlStr string, existsAlready, initial bool, pat, username, password string) (bool, error) {
+func downloadUpdateConfigFile(urlStr string, existsAlready, initial bool, pat, username, password string, auth *HTTPAuth, publicKey string) (bool, error) {
+	newBytes, err := downloadConfig(urlStr, pat, username, password, auth, publicKey)
+	if err != nil {
+		return false, err
+	}
+	if !initial {
+		currentConfigBytes, err := ioutil.ReadFile(defaultConfigPath)
+		if err != nil {
+			logger.Infof("unable to read current config, will try with new downloaded config file: %v", err)
+			existsAlready = false
+		} else {
+			if bytes.Equal(newBytes, currentConfigBytes) {
+				return false, nil
+			}
+		}
+
+		if existsAlready {
+			if err := os.WriteFile(defaultConfigBackup, currentConfigBytes, 0600); err != nil {
+				return false, fmt.Errorf("could not copy %s to path %s: %v", defaultConfigPath, defaultConfigBackup, err)
+			}
+			logger.Infof("Current config backup placed at %s", defaultConfigBackup)
+		}
+	}
+	if err := os.WriteFile(defaultConfigPath, newBytes, 0600); err != nil {
+		return false, fmt.Errorf("unable to write new config contents, reverting to old config: %v", err)
+	}
+
+	logger.Infof("Config updates found from url: %s, will load new targets", urlStr)
+	return true, nil
+}
+
+// downloadConfig downloads the config at urlStr, verifying its signature when publicKey is set.
+// The credentials of auth are sent instead of the git credentials when it is set.
+func downloadConfig(urlStr, pat, username, password string, auth *HTTPAuth, publicKey string) ([]byte, error) {
 	_, err := url.Parse(urlStr)
 	if err != nil {
-		return false, fmt.Errorf("unable to parse config file url %s: %v", urlStr, err)
+		return nil, fmt.Errorf("unable to parse config file url %s: %v", urlStr, err)
 	}
-	client := http.Client{
+	client := &http.Client{
 		CheckRedirect: func(r *http.Request, via []*http.Request) error {
 			r.URL.Opaque = r.URL.Path
 			return nil
 		},
 	}
-	req, err := http.NewRequest("GET", urlStr, nil)
+	req, err := newConfigRequest(urlStr, pat, username, password, auth)
 	if err != nil {
-		return false, fmt.Errorf("unable to create request: %v", err)
-	}
-	if pat != "" {
-		req.Header.Add("Authorization", "token "+pat)
-		req.Header.Add("Accept", "application/vnd.github.v3+json")
-	}
-	if username != "" && password != "" {
-		req.SetBasicAuth(username, password)
+		return nil, err
 	}
 	resp, err := client.Do(req)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 	defer resp.Body.Close()
-	newBytes, err := io.ReadAll(resp.Body)
+	newBytes, err := readConfigBody(resp)
 	if err != nil {
-		return false, fmt.Errorf("error downloading config from %s: %v", err)
+		return nil, fmt.Errorf("error downloading config from %s: %v", urlStr, err)
 	}
 	if newBytes == nil {
 		// if initial, this is the last resort, newBytes should be populated
 		// the only way to get here from initial
 		// is if there is no config file on disk, only a FETCHIT_CONFIG_URL
-		return false, fmt.Errorf("found empty config at %s, unable to update or populate config", urlStr)
+		return nil, fmt.Errorf("found empty config at %s, unable to update or populate config", urlStr)
 	}
-	if !initial {
-		currentConfigBytes, err := ioutil.ReadFile(defaultConfigPath)
+	if publicKey != "" {
+		sig, err := downloadSignature(client, urlStr+".sig", pat, username, password, auth)
 		if err != nil {
-			logger.Infof("unable to read current config, will try with new downloaded config file: %v", err)
-			existsAlready = false
-		} else {
-			if bytes.Equal(newBytes, currentConfigBytes) {
-				return false, nil
-			}
+			return nil, fmt.Errorf("refusing config from %s, unable to download its signature: %v", urlStr, err)
 		}
+		if err := verifySignature(newBytes, sig, publicKey); err != nil {
+			return nil, fmt.Errorf("refusing config from %s, signature verification failed: %v", urlStr, err)
+		}
+		logger.Infof("Verified signature of config from %s", urlStr)
+	}
+	return newBytes, nil
+}
 
-		if existsAlready {
-			if err := os.WriteFile(defaultConfigBackup, currentConfigBytes, 0600); err != nil {
+// checkForApprovedConfigUpdates stages a changed config at defaultConfigPending
+// instead of applying it. A staged config is applied, returning true, once
+// defaultConfigApprove exists, so the config that is applied is the one that
+// was staged when it was approved.
+func checkForApprovedConfigUpdates(envURL, pat, username, password string, auth *HTTPAuth, publicKey string) (bool, error) {
+	if _, err := os.Stat(defaultConfigApprove); err == nil {
+		pending, err := ioutil.ReadFile(defaultConfigPending)
+		if err != nil {
+			return false, fmt.Errorf("%s exists but there is no pending config to approve: %v", defaultConfigApprove, err)
+		}
+		if current, err := ioutil.ReadFile(defaultConfigPath); err == nil {
+			if err := os.WriteFile(defaultConfigBackup, current, 0600); err != nil {
 				return false, fmt.Errorf("could not copy %s to path %s: %v", defaultConfigPath, defaultConfigBackup, err)
 			}
 			logger.Infof("Current config backup placed at %s", defaultConfigBackup)
 		}
+		if err := os.WriteFile(defaultConfigPath, pending, 0600); err != nil {
+			return false, fmt.Errorf("unable to write approved config contents: %v", err)
+		}
+		os.Remove(defaultConfigPending)
+		os.Remove(defaultConfigApprove)
+		logger.Infof("Pending config from %s approved, will load new targets", envURL)
+		return true, nil
 	}
-	if err := os.WriteFile(defaultConfigPath, newBytes, 0600); err != nil {
-		return false, fmt.Errorf("unable to write new config contents, reverting to old config: %v", err)
+
+	newBytes, err := downloadConfig(envURL, pat, username, password, auth, publicKey)
+	if err != nil {
+		return false, err
+	}
+	if current, err := ioutil.ReadFile(defaultConfigPath); err == nil && bytes.Equal(newBytes, current) {
+		// the upstream change was reverted before it was approved
+		os.Remove(defaultConfigPending)
+		return false, nil
+	}
+	if pending, err := ioutil.ReadFile(defaultConfigPending); err == nil && bytes.Equal(newBytes, pending) {
+		return false, nil
+	}
+	if err := os.WriteFile(defaultConfigPending, newBytes, 0600); err != nil {
+		return false, fmt.Errorf("unable to write pending config contents: %v", err)
 	}
+	logger.Warnf("Config updates found from url: %s, staged at %s, touch %s to apply them", envURL, defaultConfigPending, defaultConfigApprove)
+	return false, nil
+}
 
-	logger.Infof("Config updates found from url: %s, will load new targets", urlStr)
-	return true, nil
+func newConfigRequest(urlStr, pat, username, password string, auth *HTTPAuth) (*http.Request, error) {
+	req, err := http.NewRequest("GET", urlStr, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create request: %v", err)
+	}
+	if pat != "" {
+		req.Header.Add("Authorization", "token "+pat)
+		req.Header.Add("Accept", "application/vnd.github.v3+json")
+	}
+	if username != "" && password != "" {
+		req.SetBasicAuth(username, password)
+	}
+	auth.setAuth(req)
+	return req, nil
+}
+
+// downloadSignature downloads the signature published alongside a config or archive
+func downloadSignature(client *http.Client, urlStr, pat, username, password string, auth *HTTPAuth) ([]byte, error) {
+	req, err := newConfigRequest(urlStr, pat, username, password, auth)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", urlStr, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// verifySignature verifies a base64 encoded signature of a config or archive,
+// such as the output of cosign sign-blob, against the PEM public key at keyPath
+func verifySignature(blob, sig []byte, keyPath string) error {
+	verifier, err := signature.LoadVerifierFromPEMFile(keyPath, crypto.SHA256)
+	if err != nil {
+		return utils.WrapErr(err, "Error loading public key %s", keyPath)
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+	if err != nil {
+		return utils.WrapErr(err, "Error decoding signature")
+	}
+	return verifier.VerifySignature(bytes.NewReader(raw), bytes.NewReader(blob))
+}
+
+// readConfigBody reads a downloaded config, decompressing it when it is served
+// gzip encoded or from a .gz url and the http client did not already do so
+func readConfigBody(resp *http.Response) ([]byte, error) {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Uncompressed {
+		return body, nil
+	}
+	gzipped := strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") ||
+		(resp.Request != nil && strings.HasSuffix(resp.Request.URL.Path, ".gz"))
+	if !gzipped {
+		return body, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, utils.WrapErr(err, "Unable to decompress gzip config")
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
 }
@@ -0,0 +1,313 @@
+package engine
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+	"go.uber.org/zap"
+)
+
+func TestDownloadUpdateConfigFileGzip(t *testing.T) {
+	config := []byte("targetConfigs:\n- url: https://github.com/containers/fetchit\n  branch: main\n")
+	gz := &bytes.Buffer{}
+	zw := gzip.NewWriter(gz)
+	zw.Write(config)
+	zw.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/config.yaml" {
+			// served gzip encoded even though the client did not ask for it
+			w.Header().Set("Content-Encoding", "gzip")
+		} else {
+			w.Header().Set("Content-Type", "application/gzip")
+		}
+		w.Write(gz.Bytes())
+	}))
+	defer srv.Close()
+
+	defer func(l *zap.SugaredLogger, p string) { logger, defaultConfigPath = l, p }(logger, defaultConfigPath)
+	logger = zap.NewNop().Sugar()
+
+	for _, path := range []string{"/config.yaml", "/config.yaml.gz"} {
+		defaultConfigPath = filepath.Join(t.TempDir(), "config.yaml")
+		updated, err := downloadUpdateConfigFile(srv.URL+path, false, true, "", "", "", nil, "")
+		if err != nil || !updated {
+			t.Fatalf("Failed: %s: updated %t: %v", path, updated, err)
+		}
+		b, err := ioutil.ReadFile(defaultConfigPath)
+		if err != nil {
+			t.Fatalf("Failed: %v", err)
+		}
+		if !bytes.Equal(b, config) {
+			t.Fatalf("Failed: %s: written config is not decompressed: %q", path, b)
+		}
+	}
+}
+
+func TestDownloadUpdateConfigFileSignature(t *testing.T) {
+	config := []byte("targetConfigs:\n- url: https://github.com/containers/fetchit\n  branch: main\n")
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	pub, err := cryptoutils.MarshalPublicKeyToPEM(key.Public())
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "cosign.pub")
+	if err := ioutil.WriteFile(keyPath, pub, 0600); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	sign := func(b []byte) string {
+		digest := sha256.Sum256(b)
+		sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatalf("Failed: %v", err)
+		}
+		return base64.StdEncoding.EncodeToString(sig)
+	}
+
+	sigs := map[string]string{
+		"/valid.yaml.sig":    sign(config),
+		"/tampered.yaml.sig": sign([]byte("targetConfigs: []\n")),
+		"/garbage.yaml.sig":  "not a signature",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, ".sig") {
+			w.Write(config)
+			return
+		}
+		sig, ok := sigs[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(sig + "\n"))
+	}))
+	defer srv.Close()
+
+	defer func(l *zap.SugaredLogger, p string) { logger, defaultConfigPath = l, p }(logger, defaultConfigPath)
+	logger = zap.NewNop().Sugar()
+
+	tests := []struct {
+		path   string
+		key    string
+		update bool
+	}{
+		{"/valid.yaml", keyPath, true},
+		{"/tampered.yaml", keyPath, false},
+		{"/garbage.yaml", keyPath, false},
+		{"/unsigned.yaml", keyPath, false},
+		{"/unsigned.yaml", "", true},
+	}
+	for _, tt := range tests {
+		defaultConfigPath = filepath.Join(t.TempDir(), "config.yaml")
+		updated, err := downloadUpdateConfigFile(srv.URL+tt.path, false, true, "", "", "", nil, tt.key)
+		if updated != tt.update || (err == nil) != tt.update {
+			t.Fatalf("Failed: %s with key %q: updated %t: %v", tt.path, tt.key, updated, err)
+		}
+		_, statErr := os.Stat(defaultConfigPath)
+		if (statErr == nil) != tt.update {
+			t.Fatalf("Failed: %s with key %q: config written %t", tt.path, tt.key, statErr == nil)
+		}
+	}
+}
+
+func TestCheckForApprovedConfigUpdates(t *testing.T) {
+	current := []byte("targetConfigs: []\n")
+	config := []byte("targetConfigs:\n- url: https://github.com/containers/fetchit\n  branch: main\n")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(config)
+	}))
+	defer srv.Close()
+
+	defer func(l *zap.SugaredLogger, p, b, pending, approve string) {
+		logger, defaultConfigPath, defaultConfigBackup, defaultConfigPending, defaultConfigApprove = l, p, b, pending, approve
+	}(logger, defaultConfigPath, defaultConfigBackup, defaultConfigPending, defaultConfigApprove)
+	logger = zap.NewNop().Sugar()
+	dir := t.TempDir()
+	defaultConfigPath = filepath.Join(dir, "config.yaml")
+	defaultConfigBackup = filepath.Join(dir, "config-backup.yaml")
+	defaultConfigPending = filepath.Join(dir, "config-pending.yaml")
+	defaultConfigApprove = filepath.Join(dir, "config-approve")
+	if err := ioutil.WriteFile(defaultConfigPath, current, 0600); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+
+	expectFile := func(path string, expected []byte) {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed: %v", err)
+		}
+		if !bytes.Equal(b, expected) {
+			t.Fatalf("Failed: %s is %q, expected %q", path, b, expected)
+		}
+	}
+
+	// the change is detected and staged, but not applied
+	for i := 0; i < 2; i++ {
+		restart, err := checkForApprovedConfigUpdates(srv.URL, "", "", "", nil, "")
+		if err != nil || restart {
+			t.Fatalf("Failed: restart %t: %v", restart, err)
+		}
+		expectFile(defaultConfigPath, current)
+		expectFile(defaultConfigPending, config)
+	}
+
+	if err := ioutil.WriteFile(defaultConfigApprove, nil, 0600); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	restart, err := checkForApprovedConfigUpdates(srv.URL, "", "", "", nil, "")
+	if err != nil || !restart {
+		t.Fatalf("Failed: approved config not applied, restart %t: %v", restart, err)
+	}
+	expectFile(defaultConfigPath, config)
+	expectFile(defaultConfigBackup, current)
+	for _, path := range []string{defaultConfigPending, defaultConfigApprove} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("Failed: %s was not removed after approval", path)
+		}
+	}
+
+	restart, err = checkForApprovedConfigUpdates(srv.URL, "", "", "", nil, "")
+	if err != nil || restart {
+		t.Fatalf("Failed: unchanged config restarted %t: %v", restart, err)
+	}
+}
+
+func TestCheckForGitConfigUpdates(t *testing.T) {
+	defer func(l *zap.SugaredLogger, p, b, c string) {
+		logger, defaultConfigPath, defaultConfigBackup, cacheDir = l, p, b, c
+	}(logger, defaultConfigPath, defaultConfigBackup, cacheDir)
+	logger = zap.NewNop().Sugar()
+	dir := t.TempDir()
+	defaultConfigPath = filepath.Join(dir, "config.yaml")
+	defaultConfigBackup = filepath.Join(dir, "config-backup.yaml")
+	cacheDir = filepath.Join(dir, "cache")
+
+	remote := filepath.Join(dir, "remote")
+	r := newTestRepoAt(t, remote)
+	r.commit(map[string]string{"fetchit/config.yaml": "targetConfigs: []\n"})
+	head, err := r.repo.Head()
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+
+	target := &Target{url: remote, branch: head.Name().Short()}
+	check := func(expected bool, config string) {
+		// each check is a new scheduled run, not one sharing the previous fetch
+		target.latest = plumbing.ZeroHash
+		restart, err := checkForGitConfigUpdates(target, "fetchit/config.yaml", nil, "")
+		if err != nil || restart != expected {
+			t.Fatalf("Failed: restart %t, expected %t: %v", restart, expected, err)
+		}
+		b, err := ioutil.ReadFile(defaultConfigPath)
+		if err != nil || string(b) != config {
+			t.Fatalf("Failed: config is %q, expected %q: %v", b, config, err)
+		}
+	}
+
+	check(true, "targetConfigs: []\n")
+	check(false, "targetConfigs: []\n")
+	// a commit that does not change the config does not restart
+	r.commit(map[string]string{"README.md": "fetchit config\n"})
+	check(false, "targetConfigs: []\n")
+	r.commit(map[string]string{"fetchit/config.yaml": "targetConfigs:\n- url: https://github.com/containers/fetchit\n  branch: main\n"})
+	check(true, "targetConfigs:\n- url: https://github.com/containers/fetchit\n  branch: main\n")
+}
+
+func TestCheckForGitConfigUpdatesGated(t *testing.T) {
+	defer func(l *zap.SugaredLogger, p, b, c string) {
+		logger, defaultConfigPath, defaultConfigBackup, cacheDir = l, p, b, c
+	}(logger, defaultConfigPath, defaultConfigBackup, cacheDir)
+	logger = zap.NewNop().Sugar()
+	dir := t.TempDir()
+	defaultConfigPath = filepath.Join(dir, "config.yaml")
+	defaultConfigBackup = filepath.Join(dir, "config-backup.yaml")
+	cacheDir = filepath.Join(dir, "cache")
+
+	remote := filepath.Join(dir, "remote")
+	r := newTestRepoAt(t, remote)
+	first := r.commit(map[string]string{"config.yaml": "targetConfigs: []\n"})
+	head, err := r.repo.Head()
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	target := &Target{url: remote, branch: head.Name().Short(), fetchTags: true}
+	tagRange := ">=1.0.0 <2.0.0"
+	check := func(branches []string, expected bool, config string) error {
+		// each check is a new scheduled run, not one sharing the previous fetch
+		target.latest = plumbing.ZeroHash
+		restart, err := checkForGitConfigUpdates(target, "", branches, tagRange)
+		if restart != expected {
+			t.Fatalf("Failed: restart %t, expected %t: %v", restart, expected, err)
+		}
+		if b, _ := ioutil.ReadFile(defaultConfigPath); string(b) != config {
+			t.Fatalf("Failed: config is %q, expected %q", b, config)
+		}
+		return err
+	}
+
+	// only approved branches are read from
+	if err := check([]string{"release-*"}, false, ""); err == nil {
+		t.Fatalf("Failed: expected an error for a branch that is not approved")
+	}
+	branches := []string{"release-*", target.branch}
+
+	// commits wait for a tag in the range
+	if err := check(branches, false, ""); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	if _, err := r.repo.CreateTag("v0.9.0", first, nil); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	if err := check(branches, false, ""); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	if _, err := r.repo.CreateTag("v1.2.0", first, &git.CreateTagOptions{Tagger: &object.Signature{Name: "test", When: time.Now()}, Message: "release"}); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	if err := check(branches, true, "targetConfigs: []\n"); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+
+	second := r.commit(map[string]string{"config.yaml": "targetConfigs:\n- url: https://github.com/containers/fetchit\n  branch: main\n"})
+	if err := check(branches, false, "targetConfigs: []\n"); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	if _, err := r.repo.CreateTag("v2.0.0", second, nil); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	if err := check(branches, false, "targetConfigs: []\n"); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	if _, err := r.repo.CreateTag("1.3.0", second, nil); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	if err := check(branches, true, "targetConfigs:\n- url: https://github.com/containers/fetchit\n  branch: main\n"); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+
+	tagRange = "not a range"
+	r.commit(map[string]string{"config.yaml": "targetConfigs: []\n"})
+	if err := check(branches, false, "targetConfigs:\n- url: https://github.com/containers/fetchit\n  branch: main\n"); err == nil {
+		t.Fatalf("Failed: expected an error for an invalid tag range")
+	}
+}
@@ -0,0 +1,65 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/containers/fetchit/pkg/engine/utils"
+	"github.com/containers/podman/v4/pkg/bindings"
+)
+
+// PodmanConnection is the podman service fetchit manages, the host's socket
+// mounted into the fetchit container by default
+type PodmanConnection struct {
+	// URI is a unix://, ssh:// or tcp:// podman service uri, such as
+	// ssh://core@edge-01/run/podman/podman.sock
+	URI string `mapstructure:"uri"`
+	// Identity is the path of the ssh private key for an ssh:// uri
+	Identity string `mapstructure:"identity"`
+}
+
+var (
+	// podmanConnection is set from the config, nil for the local socket
+	podmanConnection *PodmanConnection
+	// newConnection connects to a podman service, replaced in tests
+	newConnection = bindings.NewConnectionWithIdentity
+)
+
+// uri returns the podman service uri of the connection
+func (pc *PodmanConnection) uri() string {
+	if pc == nil || pc.URI == "" {
+		return podmanSocketURI
+	}
+	return pc.URI
+}
+
+// validate checks the scheme of the uri so that a typo is reported before connecting
+func (pc *PodmanConnection) validate() error {
+	u, err := url.Parse(pc.uri())
+	if err != nil {
+		return &utils.ValidationError{Err: utils.WrapErr(err, "Error parsing podman uri %s", pc.uri())}
+	}
+	switch u.Scheme {
+	case "unix", "ssh", "tcp":
+		return nil
+	}
+	return &utils.ValidationError{Err: fmt.Errorf("podman uri %s must use the unix, ssh or tcp scheme", pc.uri())}
+}
+
+// connectPodman connects to the podman service of the config
+func connectPodman(ctx context.Context) (context.Context, error) {
+	pc := podmanConnection
+	if err := pc.validate(); err != nil {
+		return nil, err
+	}
+	identity := ""
+	if pc != nil {
+		identity = pc.Identity
+	}
+	conn, err := newConnection(ctx, pc.uri(), identity)
+	if err != nil {
+		return nil, &utils.PodmanError{Err: utils.WrapErr(err, "Error connecting to podman at %s", pc.uri())}
+	}
+	return conn, nil
+}
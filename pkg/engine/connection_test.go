@@ -0,0 +1,50 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/containers/fetchit/pkg/engine/utils"
+)
+
+func TestConnectPodman(t *testing.T) {
+	defer func(pc *PodmanConnection, c func(context.Context, string, string) (context.Context, error)) {
+		podmanConnection, newConnection = pc, c
+	}(podmanConnection, newConnection)
+	var gotURI, gotIdentity string
+	newConnection = func(ctx context.Context, uri, identity string) (context.Context, error) {
+		gotURI, gotIdentity = uri, identity
+		return ctx, nil
+	}
+
+	tests := []struct {
+		name     string
+		pc       *PodmanConnection
+		uri      string
+		identity string
+	}{
+		{"local socket", nil, podmanSocketURI, ""},
+		{"empty uri", &PodmanConnection{}, podmanSocketURI, ""},
+		{"ssh", &PodmanConnection{URI: "ssh://core@edge-01/run/podman/podman.sock", Identity: "/opt/mount/id_ed25519"}, "ssh://core@edge-01/run/podman/podman.sock", "/opt/mount/id_ed25519"},
+		{"tcp", &PodmanConnection{URI: "tcp://10.0.0.5:8888"}, "tcp://10.0.0.5:8888", ""},
+	}
+	for _, tt := range tests {
+		podmanConnection = tt.pc
+		gotURI, gotIdentity = "", ""
+		if _, err := connectPodman(context.Background()); err != nil {
+			t.Fatalf("Failed: %s: unexpected error: %v", tt.name, err)
+		}
+		if gotURI != tt.uri || gotIdentity != tt.identity {
+			t.Fatalf("Failed: %s: connected to %q with identity %q, expected %q and %q", tt.name, gotURI, gotIdentity, tt.uri, tt.identity)
+		}
+	}
+
+	podmanConnection = &PodmanConnection{URI: "http://edge-01:8888"}
+	gotURI = ""
+	_, err := connectPodman(context.Background())
+	var verr *utils.ValidationError
+	if !errors.As(err, &verr) || gotURI != "" {
+		t.Fatalf("Failed: expected validation error without connecting, got %v", err)
+	}
+}
@@ -2,7 +2,10 @@ package engine
 
 import (
 	"context"
+	"fmt"
+	"strings"
 
+	"github.com/containers/fetchit/pkg/engine/utils"
 	"github.com/containers/podman/v4/libpod/define"
 	"github.com/containers/podman/v4/pkg/bindings/containers"
 	"github.com/containers/podman/v4/pkg/bindings/images"
@@ -11,106 +14,343 @@ import (
 	"github.com/opencontainers/runtime-spec/specs-go"
 )
 
-const stopped = define.ContainerStateStopped
+const (
+	stopped = define.ContainerStateStopped
+	// helperLabel marks the short lived containers fetchit runs for methods
+	helperLabel = "fetchit-helper"
+	// managedLabel, targetLabel and methodLabel mark every helper with what
+	// created it, so helpers can be listed by target or kind of method
+	managedLabel = "fetchit.managed"
+	targetLabel  = "fetchit.target"
+	methodLabel  = "fetchit.method"
+)
+
+// Values of the imagePullPolicy config option
+const (
+	pullAlways       = "always"
+	pullIfNotPresent = "ifnotpresent"
+	pullNever        = "never"
+)
 
-func generateSpec(method, file, copyFile, dest string, name string) *specgen.SpecGenerator {
+func generateSpec(method, target, file, copyFile, dest string, name string) *specgen.SpecGenerator {
+	return generateCommandSpec(method, target, file, []string{"sh", "-c", "rsync -avz" + " " + copyFile}, dest, name)
+}
+
+// generateRsyncSpec runs rsync with the given arguments rather than through a shell
+func generateRsyncSpec(method, target, file string, args []string, dest string, name string) *specgen.SpecGenerator {
+	return generateCommandSpec(method, target, file, append([]string{"rsync"}, args...), dest, name)
+}
+
+func generateCommandSpec(method, target, file string, command []string, dest string, name string) *specgen.SpecGenerator {
 	s := specgen.NewSpecGenerator(fetchitImage, false)
 	s.Name = method + "-" + name + "-" + file
-	s.Privileged = true
-	s.PidNS = specgen.Namespace{
-		NSMode: "host",
-		Value:  "",
-	}
-	s.Command = []string{"sh", "-c", "rsync -avz" + " " + copyFile}
+	s.Labels = helperLabels(method, target)
+	setHelperPrivileges(s)
+	s.Command = command
 	s.Mounts = []specs.Mount{{Source: dest, Destination: dest, Type: "bind", Options: []string{"rw"}}}
 	s.Volumes = []*specgen.NamedVolume{{Name: fetchitVolume, Dest: "/opt", Options: []string{"rw"}}}
 	return s
 }
 
-func generateDeviceSpec(method, file, copyFile, device string, name string) *specgen.SpecGenerator {
+// mountArgs returns the arguments of the mount of device, with the filesystem
+// type and comma separated options when they are set. They are passed to the
+// shell of the device helper as positional parameters, never parsed by it.
+func mountArgs(device, fsType, mountOptions string) []string {
+	var args []string
+	if fsType != "" {
+		args = append(args, "-t", fsType)
+	}
+	if mountOptions != "" {
+		args = append(args, "-o", mountOptions)
+	}
+	return append(args, device)
+}
+
+// deviceCopyScript mounts the device and copies src to dest, which are passed
+// to it as positional parameters ahead of the mount arguments
+const deviceCopyScript = `src=$1 dest=$2; shift 2; mount "$@" /mnt/ ; rsync -avz "$src" "$dest"`
+
+// generateDeviceSpec returns the helper copying src on the mounted device to
+// dest. Nothing from the config is parsed by the helper's shell.
+func generateDeviceSpec(method, target, file, src, dest, device, fsType, mountOptions string, name string) *specgen.SpecGenerator {
 	s := specgen.NewSpecGenerator(fetchitImage, false)
 	s.Name = method + "-" + name + "-" + file
+	s.Labels = helperLabels(method, target)
 	s.Privileged = true
 	s.PidNS = specgen.Namespace{
 		NSMode: "host",
 		Value:  "",
 	}
-	s.Command = []string{"sh", "-c", "mount" + " " + device + " " + "/mnt/ ; rsync -avz" + " " + copyFile}
+	s.Command = append([]string{"sh", "-c", deviceCopyScript, "device-copy", src, dest}, mountArgs(device, fsType, mountOptions)...)
 	s.Volumes = []*specgen.NamedVolume{{Name: fetchitVolume, Dest: "/opt", Options: []string{"rw"}}}
 	s.Devices = []specs.LinuxDevice{{Path: device}}
 	return s
 }
 
-func generateDevicePresentSpec(method, file, device string, name string) *specgen.SpecGenerator {
+func generateDevicePresentSpec(method, target, file, device string, name string) *specgen.SpecGenerator {
 	s := specgen.NewSpecGenerator(fetchitImage, false)
 	s.Name = method + "-" + name + "-" + file + "-" + "device-check"
+	s.Labels = helperLabels(method, target)
 	s.Privileged = true
 	s.PidNS = specgen.Namespace{
 		NSMode: "host",
 		Value:  "",
 	}
-	s.Command = []string{"sh", "-c", "if [ ! -b " + device + " ]; then exit 1; fi"}
+	s.Command = []string{"sh", "-c", `if [ ! -b "$1" ]; then exit 1; fi`, "device-check", device}
 	s.Devices = []specs.LinuxDevice{{Path: device}}
 	return s
 }
 
-func generateSpecRemove(method, file, pathToRemove, dest, name string) *specgen.SpecGenerator {
+func generateSpecRemove(method, target, file, pathToRemove, dest, name string) *specgen.SpecGenerator {
 	s := specgen.NewSpecGenerator(fetchitImage, false)
 	s.Name = method + "-" + name + "-" + file
+	s.Labels = helperLabels(method, target)
+	setHelperPrivileges(s)
+	s.Command = []string{"sh", "-c", "rm " + pathToRemove}
+	s.Mounts = []specs.Mount{{Source: dest, Destination: dest, Type: "bind", Options: []string{"rw"}}}
+	s.Volumes = []*specgen.NamedVolume{{Name: fetchitVolume, Dest: "/opt", Options: []string{"ro"}}}
+	return s
+}
+
+// helperLabels returns the labels of a helper run for a kind of method and
+// target, the target label is left out of helpers that serve no target
+func helperLabels(method, target string) map[string]string {
+	labels := map[string]string{
+		"owned-by":   FetchItLabel,
+		helperLabel:  "true",
+		managedLabel: "true",
+		methodLabel:  method,
+	}
+	if target != "" {
+		labels[targetLabel] = target
+	}
+	return labels
+}
+
+// sweepHelpers removes the helper containers left behind when fetchit stopped
+// mid run, as their names would block the next run. Failed helpers are kept
+// when keepFailed is set.
+func sweepHelpers(list func() ([]entities.ListContainer, error), remove func(ID string) error) error {
+	helpers, err := list()
+	if err != nil {
+		return &utils.PodmanError{Err: utils.WrapErr(err, "Error listing helper containers")}
+	}
+	errs := &utils.MultiError{}
+	for _, c := range helpers {
+		if c.Exited && !removeAfterExit(c.ExitCode, keepFailed()) {
+			continue
+		}
+		name := c.ID
+		if len(c.Names) > 0 {
+			name = c.Names[0]
+		}
+		if err := remove(c.ID); err != nil {
+			errs.Append(&utils.PodmanError{Err: utils.WrapErr(err, "Error removing helper container %s", name)})
+			continue
+		}
+		logger.Infof("Removed helper container %s left from a previous run", name)
+	}
+	return errs.ErrorOrNil()
+}
+
+// removeOrphanedHelpers removes the helper containers left behind by a
+// previous run of fetchit
+func removeOrphanedHelpers(conn context.Context) error {
+	return sweepHelpers(
+		func() ([]entities.ListContainer, error) {
+			return containers.List(conn, new(containers.ListOptions).WithAll(true).WithFilters(map[string][]string{"label": {helperLabel}}))
+		},
+		func(ID string) error {
+			_, err := containers.Remove(conn, ID, new(containers.RemoveOptions).WithForce(true))
+			return err
+		})
+}
+
+// helperCapabilities are the capabilities a helper copying or removing files
+// on the host needs to keep their ownership and permissions
+var helperCapabilities = []string{"CAP_CHOWN", "CAP_DAC_OVERRIDE", "CAP_FOWNER", "CAP_FSETID"}
+
+// setHelperPrivileges runs a helper privileged in the host pid namespace, or
+// with only helperCapabilities when leastPrivilege is set. SELinux separation
+// is disabled so the helper can still write to the host paths it mounts.
+func setHelperPrivileges(s *specgen.SpecGenerator) {
+	if leastPrivilege() {
+		s.CapAdd = append([]string{}, helperCapabilities...)
+		s.SelinuxOpts = []string{"disable"}
+		return
+	}
 	s.Privileged = true
 	s.PidNS = specgen.Namespace{
 		NSMode: "host",
 		Value:  "",
 	}
-	s.Command = []string{"sh", "-c", "rm " + pathToRemove}
-	s.Mounts = []specs.Mount{{Source: dest, Destination: dest, Type: "bind", Options: []string{"rw"}}}
-	s.Volumes = []*specgen.NamedVolume{{Name: fetchitVolume, Dest: "/opt", Options: []string{"ro"}}}
-	return s
+}
+
+func leastPrivilege() bool {
+	return fetchit != nil && fetchit.leastPrivilege
 }
 
 func createAndStartContainer(conn context.Context, s *specgen.SpecGenerator) (entities.ContainerCreateResponse, error) {
+	// A helper kept from a failed run would block creating one with the same name
+	if keepFailed() && s.Name != "" {
+		if exists, err := containers.Exists(conn, s.Name, nil); err == nil && exists {
+			logger.Infof("Removing failed container %s kept from a previous run", s.Name)
+			if _, err := containers.Remove(conn, s.Name, new(containers.RemoveOptions).WithForce(true)); err != nil {
+				return entities.ContainerCreateResponse{}, &utils.PodmanError{Err: utils.WrapErr(err, "Error removing container %s", s.Name)}
+			}
+		}
+	}
+
 	createResponse, err := containers.CreateWithSpec(conn, s, nil)
 	if err != nil {
-		return createResponse, err
+		return createResponse, &utils.PodmanError{Err: utils.WrapErr(err, "Error creating container %s", s.Name)}
 	}
 
 	if err := containers.Start(conn, createResponse.ID, nil); err != nil {
-		return createResponse, err
+		return createResponse, &utils.PodmanError{Err: utils.WrapErr(err, "Error starting container %s", s.Name)}
 	}
 
 	return createResponse, nil
 }
 
+// waitAndRemoveContainer waits for a helper container to stop, removes it
+// and returns an error if the container exited with a non-zero code. Failed
+// containers are kept when keepFailed is set.
 func waitAndRemoveContainer(conn context.Context, ID string) error {
-	_, err := containers.Wait(conn, ID, new(containers.WaitOptions).WithCondition([]define.ContainerStatus{stopped}))
+	exitCode, err := containers.Wait(conn, ID, new(containers.WaitOptions).WithCondition([]define.ContainerStatus{stopped}))
 	if err != nil {
 		return err
 	}
 
+	// Dump the output of a failed helper container before it is removed
+	if exitCode != 0 {
+		output, err := containerLogs(conn, ID)
+		if err != nil {
+			logger.Errorf("Container %s exited with code %d, unable to collect logs: %v", ID, exitCode, err)
+		} else {
+			logger.Errorf("Container %s exited with code %d, output:\n%s", ID, exitCode, output)
+		}
+	}
+	exitErr := checkExitCode(ID, exitCode)
+	if !removeAfterExit(exitCode, keepFailed()) {
+		logger.Infof("Keeping failed container %s for debugging", ID)
+		return exitErr
+	}
+
 	_, err = containers.Remove(conn, ID, new(containers.RemoveOptions).WithForce(true))
 	if err != nil {
 		// There's a podman bug somewhere that's causing this
 		if err.Error() == "unexpected end of JSON input" {
-			return nil
+			return exitErr
 		}
 		return err
 	}
 
+	return exitErr
+}
+
+// removeAfterExit reports whether a helper container should be removed once it exits
+func removeAfterExit(exitCode int32, keepFailed bool) bool {
+	return exitCode == 0 || !keepFailed
+}
+
+func keepFailed() bool {
+	return fetchit != nil && fetchit.keepFailed
+}
+
+func checkExitCode(ID string, exitCode int32) error {
+	if exitCode != 0 {
+		return fmt.Errorf("container %s exited with code %d", ID, exitCode)
+	}
 	return nil
 }
 
+// containerLogs returns the combined stdout and stderr of a container
+func containerLogs(conn context.Context, ID string) (string, error) {
+	return collectLogs(func(stdout, stderr chan string) error {
+		opts := new(containers.LogOptions).WithStdout(true).WithStderr(true)
+		return containers.Logs(conn, ID, opts, stdout, stderr)
+	})
+}
+
+// collectLogs drains the stdout and stderr channels written by logs
+// and returns their lines in the order they were received
+func collectLogs(logs func(stdout, stderr chan string) error) (string, error) {
+	lines := make(chan string)
+	done := make(chan struct{})
+	var output []string
+	go func() {
+		for line := range lines {
+			output = append(output, strings.TrimSuffix(line, "\n"))
+		}
+		close(done)
+	}()
+
+	err := logs(lines, lines)
+	close(lines)
+	<-done
+	return strings.Join(output, "\n"), err
+}
+
+// checkNotInUse returns an error naming the containers using a volume or network
+func checkNotInUse(kind, name string, users []entities.ListContainer) error {
+	if len(users) == 0 {
+		return nil
+	}
+	var names []string
+	for _, c := range users {
+		names = append(names, c.Names...)
+	}
+	return &utils.ValidationError{Err: fmt.Errorf("%s %s is in use by %s, not removing", kind, name, strings.Join(names, ", "))}
+}
+
+// checkOwned returns an error unless a volume or network carries the label fetchit
+// creates it with, so that one created by hand is never removed or recreated
+func checkOwned(kind, name string, labels map[string]string) error {
+	if labels["owned-by"] == FetchItLabel {
+		return nil
+	}
+	return &utils.ValidationError{Err: fmt.Errorf("%s %s was not created by fetchit, not removing or recreating it", kind, name)}
+}
+
 func detectOrFetchImage(conn context.Context, imageName string, force bool) error {
-	present, err := images.Exists(conn, imageName, nil)
+	present := false
+	if imagePullPolicy != pullAlways {
+		exists, err := images.Exists(conn, imageName, nil)
+		if err != nil {
+			return err
+		}
+		present = exists
+	}
+
+	pull, err := shouldPull(imagePullPolicy, imageName, present, force)
 	if err != nil {
 		return err
 	}
-
-	if !present || force {
-		_, err = images.Pull(conn, imageName, nil)
-		if err != nil {
+	if pull {
+		if err := pullImage(conn, imageName); err != nil {
 			return err
 		}
 	}
 
 	return nil
 }
+
+// shouldPull reports whether an image is pulled under the configured
+// imagePullPolicy. Without a policy, images are pulled when missing or forced
+// by the caller, such as with the pullImage option of the raw method.
+func shouldPull(policy, imageName string, present, force bool) (bool, error) {
+	switch policy {
+	case pullAlways:
+		return true, nil
+	case pullIfNotPresent:
+		return !present, nil
+	case pullNever:
+		if !present {
+			return false, fmt.Errorf("image %s is not present and imagePullPolicy is %s", imageName, pullNever)
+		}
+		return false, nil
+	default:
+		return !present || force, nil
+	}
+}
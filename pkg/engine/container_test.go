@@ -0,0 +1,250 @@
+package engine
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/containers/podman/v4/pkg/domain/entities"
+	"github.com/containers/podman/v4/pkg/specgen"
+	"go.uber.org/zap"
+)
+
+func TestCollectLogs(t *testing.T) {
+	output, err := collectLogs(func(stdout, stderr chan string) error {
+		stdout <- "sending incremental file list\n"
+		stderr <- "rsync: change_dir \"/opt/missing\" failed: No such file or directory (2)\n"
+		stderr <- "rsync error: some files could not be transferred (code 23)\n"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed: unexpected error: %v", err)
+	}
+	expected := "sending incremental file list\n" +
+		"rsync: change_dir \"/opt/missing\" failed: No such file or directory (2)\n" +
+		"rsync error: some files could not be transferred (code 23)"
+	if output != expected {
+		t.Fatalf("Failed: output: %q != %q", output, expected)
+	}
+
+	logErr := errors.New("no such container")
+	output, err = collectLogs(func(stdout, stderr chan string) error {
+		stderr <- "partial output"
+		return logErr
+	})
+	if err != logErr {
+		t.Fatalf("Failed: err: %v != %v", err, logErr)
+	}
+	if output != "partial output" {
+		t.Fatalf("Failed: output: %q != %q", output, "partial output")
+	}
+}
+
+func TestCheckExitCode(t *testing.T) {
+	if err := checkExitCode("abc123", 0); err != nil {
+		t.Fatalf("Failed: unexpected error for exit code 0: %v", err)
+	}
+
+	err := checkExitCode("abc123", 1)
+	if err == nil {
+		t.Fatalf("Failed: expected error for exit code 1")
+	}
+	expected := "container abc123 exited with code 1"
+	if err.Error() != expected {
+		t.Fatalf("Failed: err: %s != %s", err, expected)
+	}
+}
+
+func TestGenerateSpecNamesAcrossTargets(t *testing.T) {
+	ft1 := &FileTransfer{CommonMethod: CommonMethod{Name: "ft-ex", target: &Target{name: "app1"}}}
+	ft2 := &FileTransfer{CommonMethod: CommonMethod{Name: "ft-ex", target: &Target{name: "app2"}}}
+
+	s1 := generateSpec(filetransferMethod, "", "app.conf", "/opt/app1/app.conf /etc/app", "/etc/app", ft1.containerName())
+	s2 := generateSpec(filetransferMethod, "", "app.conf", "/opt/app2/app.conf /etc/app", "/etc/app", ft2.containerName())
+	if s1.Name == s2.Name {
+		t.Fatalf("Failed: helper containers for different targets share name %s", s1.Name)
+	}
+	expected := "filetransfer-app1_ft-ex-app.conf"
+	if s1.Name != expected {
+		t.Fatalf("Failed: name: %s != %s", s1.Name, expected)
+	}
+
+	// names joining to the same string in different places are told apart
+	ab := &Raw{CommonMethod: CommonMethod{Name: "c", target: &Target{name: "a-b"}}}
+	bc := &Raw{CommonMethod: CommonMethod{Name: "b-c", target: &Target{name: "a"}}}
+	if ab.containerName() == bc.containerName() {
+		t.Fatalf("Failed: methods of targets a-b and a share container name %s", ab.containerName())
+	}
+	if err := checkTargetName("a_b"); err == nil {
+		t.Fatalf("Failed: expected an error for a target name holding %q", containerNameSep)
+	}
+}
+
+func TestRemoveAfterExit(t *testing.T) {
+	if !removeAfterExit(0, true) || !removeAfterExit(0, false) {
+		t.Fatalf("Failed: successful containers should always be removed")
+	}
+	if !removeAfterExit(1, false) {
+		t.Fatalf("Failed: failed container should be removed without keepFailed")
+	}
+	if removeAfterExit(1, true) {
+		t.Fatalf("Failed: failed container should be retained with keepFailed")
+	}
+
+	defer func(f *Fetchit) { fetchit = f }(fetchit)
+	fetchit = newFetchit()
+	fetchit.keepFailed = true
+	if removeAfterExit(2, keepFailed()) {
+		t.Fatalf("Failed: keepFailed config should retain failed container")
+	}
+}
+
+func TestShouldPull(t *testing.T) {
+	tests := []struct {
+		policy  string
+		present bool
+		force   bool
+		pull    bool
+		err     bool
+	}{
+		{"", false, false, true, false},
+		{"", true, false, false, false},
+		{"", true, true, true, false},
+		{pullAlways, true, false, true, false},
+		{pullAlways, false, false, true, false},
+		{pullIfNotPresent, true, true, false, false},
+		{pullIfNotPresent, false, false, true, false},
+		{pullNever, true, true, false, false},
+		{pullNever, false, false, false, true},
+	}
+	for _, tt := range tests {
+		pull, err := shouldPull(tt.policy, "quay.io/fetchit/fetchit:latest", tt.present, tt.force)
+		if (err != nil) != tt.err {
+			t.Fatalf("Failed: policy %q present %t: unexpected error: %v", tt.policy, tt.present, err)
+		}
+		if pull != tt.pull {
+			t.Fatalf("Failed: policy %q present %t force %t: pull %t != %t", tt.policy, tt.present, tt.force, pull, tt.pull)
+		}
+	}
+}
+
+func TestLeastPrivilegeHelpers(t *testing.T) {
+	defer func(f *Fetchit) { fetchit = f }(fetchit)
+
+	fetchit = &Fetchit{leastPrivilege: true}
+	for _, s := range []*specgen.SpecGenerator{
+		generateSpec(filetransferMethod, "", "app.conf", "/opt/repo/app.conf /etc/app", "/etc/app", "app-ft-ex"),
+		generateRsyncSpec(filetransferMethod, "", "app.conf", []string{"-avz", "/opt/repo/app.conf", "/etc/app"}, "/etc/app", "app-ft-ex"),
+		generateSpecRemove(filetransferMethod, "", "app.conf", "/etc/app/app.conf", "/etc/app", "app-ft-ex"),
+	} {
+		if s.Privileged {
+			t.Fatalf("Failed: helper %s is privileged", s.Name)
+		}
+		if s.PidNS.NSMode == specgen.Host {
+			t.Fatalf("Failed: helper %s uses the host pid namespace", s.Name)
+		}
+		if !reflect.DeepEqual(s.CapAdd, helperCapabilities) {
+			t.Fatalf("Failed: helper %s capabilities: %v != %v", s.Name, s.CapAdd, helperCapabilities)
+		}
+	}
+	if s := generateDeviceSpec(filetransferMethod, "", "app.conf", "/mnt/app.conf", "/opt/app", "/dev/sdb1", "", "", "app-ft-ex"); !s.Privileged {
+		t.Fatalf("Failed: device helper %s must stay privileged", s.Name)
+	}
+
+	fetchit = &Fetchit{}
+	if s := generateSpec(filetransferMethod, "", "app.conf", "/opt/repo/app.conf /etc/app", "/etc/app", "app-ft-ex"); !s.Privileged {
+		t.Fatalf("Failed: helper %s is not privileged by default", s.Name)
+	}
+}
+
+func TestGenerateDeviceSpecMount(t *testing.T) {
+	tests := []struct {
+		fsType, options string
+		expected        []string
+	}{
+		{"", "", []string{"/dev/sdb1"}},
+		{"exfat", "", []string{"-t", "exfat", "/dev/sdb1"}},
+		{"exfat", "uid=1000,gid=1000,umask=022", []string{"-t", "exfat", "-o", "uid=1000,gid=1000,umask=022", "/dev/sdb1"}},
+		{"", "ro", []string{"-o", "ro", "/dev/sdb1"}},
+		// the options are a single argument of mount, not parsed by the shell
+		{"", "ro; reboot", []string{"-o", "ro; reboot", "/dev/sdb1"}},
+	}
+	for _, tt := range tests {
+		s := generateDeviceSpec(filetransferMethod, "", "disconnected", "/mnt/app", "/opt/", "/dev/sdb1", tt.fsType, tt.options, "app")
+		expected := append([]string{"sh", "-c", deviceCopyScript, "device-copy", "/mnt/app", "/opt/"}, tt.expected...)
+		if !reflect.DeepEqual(s.Command, expected) {
+			t.Fatalf("Failed: command %q, expected %q", s.Command, expected)
+		}
+	}
+}
+
+func TestSweepHelpers(t *testing.T) {
+	defer func(l *zap.SugaredLogger, f *Fetchit) { logger, fetchit = l, f }(logger, fetchit)
+	logger = zap.NewNop().Sugar()
+	fetchit = &Fetchit{}
+
+	orphans := []entities.ListContainer{
+		{ID: "a1", Names: []string{"filetransfer-app-ft-ex-app.conf"}, State: "running"},
+		{ID: "b2", Names: []string{"systemd-enable-httpd.service-app-sysd-ex"}, Exited: true, ExitCode: 0},
+		{ID: "c3", Names: []string{"ansible-app-ans-ex"}, Exited: true, ExitCode: 2},
+	}
+	list := func() ([]entities.ListContainer, error) { return orphans, nil }
+	removed := []string{}
+	remove := func(ID string) error {
+		removed = append(removed, ID)
+		return nil
+	}
+	if err := sweepHelpers(list, remove); err != nil {
+		t.Fatalf("Failed: unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(removed, []string{"a1", "b2", "c3"}) {
+		t.Fatalf("Failed: orphaned helpers were not removed: %v", removed)
+	}
+
+	// failed helpers are kept for inspection with keepFailed
+	fetchit = &Fetchit{keepFailed: true}
+	removed = []string{}
+	if err := sweepHelpers(list, remove); err != nil {
+		t.Fatalf("Failed: unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(removed, []string{"a1", "b2"}) {
+		t.Fatalf("Failed: removed %v with keepFailed", removed)
+	}
+
+	if s := generateSpec(filetransferMethod, "", "app.conf", "/opt/repo/app.conf /etc/app", "/etc/app", "app-ft-ex"); s.Labels[helperLabel] != "true" {
+		t.Fatalf("Failed: helper %s is missing the %s label", s.Name, helperLabel)
+	}
+}
+
+func TestHelperLabels(t *testing.T) {
+	ans := &Ansible{CommonMethod: CommonMethod{Name: "ans-ex", target: &Target{url: "https://example.com/hosts.git"}}}
+	playbook, err := ans.generateSpec("quay.io/fetchit/fetchit-ansible:latest", "/opt/repo/playbook.yaml")
+	if err != nil {
+		t.Fatalf("Failed: unexpected error: %v", err)
+	}
+	tests := []struct {
+		spec   *specgen.SpecGenerator
+		method string
+		target string
+	}{
+		{generateSpec(filetransferMethod, "app", "app.conf", "/opt/repo/app.conf /etc/app", "/etc/app", "app-ft-ex"), filetransferMethod, "app"},
+		{generateSpecRemove(filetransferMethod, "app", "app.conf", "/etc/app/app.conf", "/etc/app", "app-ft-ex"), filetransferMethod, "app"},
+		{generateDeviceSpec(filetransferMethod, "app", "disconnected", "/mnt/app", "/opt/", "/dev/sdb1", "", "", "app"), filetransferMethod, "app"},
+		{generateDevicePresentSpec(filetransferMethod, "app", "disconnected", "/dev/sdb1", "app"), filetransferMethod, "app"},
+		{playbook, ansibleMethod, "https://example.com/hosts.git"},
+		// the registry CAs are not installed for a target
+		{registryCASpec("/etc/containers/certs.d"), registryMethod, ""},
+	}
+	for _, tt := range tests {
+		labels := tt.spec.Labels
+		if labels[managedLabel] != "true" || labels[helperLabel] != "true" {
+			t.Fatalf("Failed: helper %s is not labeled as managed: %v", tt.spec.Name, labels)
+		}
+		if labels[methodLabel] != tt.method {
+			t.Fatalf("Failed: helper %s method label %q, want %q", tt.spec.Name, labels[methodLabel], tt.method)
+		}
+		if target, ok := labels[targetLabel]; target != tt.target || ok != (tt.target != "") {
+			t.Fatalf("Failed: helper %s target label %q, want %q", tt.spec.Name, target, tt.target)
+		}
+	}
+}
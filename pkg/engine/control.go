@@ -0,0 +1,229 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containers/fetchit/pkg/engine/utils"
+	"github.com/spf13/cobra"
+)
+
+const (
+	reconcileSuccess = "success"
+	reconcileFailure = "failure"
+)
+
+var (
+	// defaultControlSocket is where a running fetchit takes commands such as reconcile
+	defaultControlSocket = filepath.Join("/run", "fetchit", "control.sock")
+	// reconcileTarget limits the reconcile command to the target with this name or url
+	reconcileTarget string
+)
+
+var reconcileCmd = &cobra.Command{
+	Use:   "reconcile",
+	Short: "Reconcile the targets of a running fetchit now",
+	Long: `Ask the running fetchit to sync every method of all targets, or of the target given with --target, right away
+instead of on their schedules, and print the result of each method as json`,
+	Run: func(cmd *cobra.Command, args []string) {
+		results, err := requestReconcile(defaultControlSocket, reconcileTarget)
+		cobra.CheckErr(err)
+		enc := json.NewEncoder(cmd.OutOrStdout())
+		enc.SetIndent("", "  ")
+		cobra.CheckErr(enc.Encode(results))
+		failed := 0
+		for _, r := range results {
+			if r.Result != reconcileSuccess {
+				failed++
+			}
+		}
+		if failed > 0 {
+			cobra.CheckErr(fmt.Errorf("%d of %d methods failed to reconcile", failed, len(results)))
+		}
+	},
+}
+
+// syncResult is the outcome of a sync of a method
+type syncResult struct {
+	at  time.Time
+	err error
+}
+
+// setSyncResult records the outcome of a sync of m, the mu of its target must be held
+func setSyncResult(m Method, err error) {
+	if gm, ok := m.(gitMethod); ok {
+		gm.common().lastSync = syncResult{at: time.Now(), err: err}
+	}
+}
+
+// reconcileResult is the outcome of a method run by reconcile
+type reconcileResult struct {
+	Target string `json:"target"`
+	Method string `json:"method"`
+	Name   string `json:"name"`
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// matchesTarget reports whether a target is the one named, by name or url
+func matchesTarget(target *Target, name string) bool {
+	return name == "" || target.name == name || target.url == name
+}
+
+// reconcile runs the git methods of every target, or of the target with the
+// given name or url, right away and returns the result of each. The methods
+// run the same way as on their schedules, waiting for in-flight runs and
+// concurrency slots.
+func (f *Fetchit) reconcile(name string) ([]reconcileResult, error) {
+	if isPaused() {
+		return nil, &utils.ValidationError{Err: fmt.Errorf("fetchit is paused, nothing is reconciled")}
+	}
+	// the methods and generation are read as a restart may replace them
+	runs.RLock()
+	gen := generation
+	var methods []gitMethod
+	timeouts := map[gitMethod]time.Duration{}
+	for m, info := range f.methodTargetScheds {
+		gm, ok := m.(gitMethod)
+		if !ok || m.GetTarget() == nil || m.GetTarget().url == "" || !matchesTarget(m.GetTarget(), name) {
+			continue
+		}
+		methods = append(methods, gm)
+		timeouts[gm] = info.timeout
+	}
+	runs.RUnlock()
+	if len(methods) == 0 {
+		return nil, &utils.ValidationError{Err: fmt.Errorf("no target named %q to reconcile", name)}
+	}
+
+	results := make([]reconcileResult, len(methods))
+	var wg sync.WaitGroup
+	for i, m := range methods {
+		wg.Add(1)
+		go func(i int, m gitMethod) {
+			defer wg.Done()
+			start := time.Now()
+			runMethod(m, gen, context.Background(), f.conn, 0, timeouts[m])
+			target := m.GetTarget()
+			target.mu.Lock()
+			last := m.common().lastSync
+			target.mu.Unlock()
+			r := reconcileResult{Target: targetName(target), Method: m.GetKind(), Name: m.GetName(), Result: reconcileSuccess}
+			switch {
+			case last.at.Before(start):
+				r.Result = reconcileFailure
+				r.Error = "the method did not sync its target, see the fetchit logs"
+			case last.err != nil:
+				r.Result = reconcileFailure
+				r.Error = last.err.Error()
+			}
+			results[i] = r
+		}(i, m)
+	}
+	wg.Wait()
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Target != b.Target {
+			return a.Target < b.Target
+		}
+		if a.Method != b.Method {
+			return a.Method < b.Method
+		}
+		return a.Name < b.Name
+	})
+	return results, nil
+}
+
+// controlHandler serves POST /reconcile, with an optional target query
+// parameter, for the reconcile command
+func controlHandler(reconcile func(name string) ([]reconcileResult, error)) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/reconcile", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "reconcile must be requested with POST", http.StatusMethodNotAllowed)
+			return
+		}
+		results, err := reconcile(r.URL.Query().Get("target"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(results)
+	})
+	return mux
+}
+
+// startControl serves the commands of a running fetchit on the unix socket
+// at path in the background, a socket left by a previous run is replaced
+func startControl(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return utils.WrapErr(err, "Error creating directory of control socket %s", path)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return utils.WrapErr(err, "Error removing stale control socket %s", path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return utils.WrapErr(err, "Error listening on control socket %s", path)
+	}
+	go func() {
+		handler := controlHandler(func(name string) ([]reconcileResult, error) {
+			runs.RLock()
+			f := fetchit
+			runs.RUnlock()
+			return f.reconcile(name)
+		})
+		if err := http.Serve(l, handler); err != nil {
+			logger.Errorf("Control socket stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+// requestReconcile asks the fetchit serving the control socket at path to
+// reconcile the target with the given name or url, every target if empty
+func requestReconcile(path, target string) ([]reconcileResult, error) {
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+	}
+	u := "http://fetchit/reconcile"
+	if target != "" {
+		u += "?target=" + url.QueryEscape(target)
+	}
+	resp, err := client.Post(u, "", nil)
+	if err != nil {
+		return nil, utils.WrapErr(err, "Error connecting to fetchit at %s, is it running", path)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("fetchit refused to reconcile: %s", strings.TrimSpace(string(msg)))
+	}
+	var results []reconcileResult
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return nil, utils.WrapErr(err, "Error reading reconcile results")
+	}
+	return results, nil
+}
+
+func init() {
+	reconcileCmd.Flags().StringVar(&reconcileTarget, "target", "", "name or url of the target to reconcile, all targets if unset")
+	fetchitCmd.AddCommand(reconcileCmd)
+}
@@ -0,0 +1,76 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// syncingMethod records a sync with err, or no sync at all when skip is set
+type syncingMethod struct {
+	busyMethod
+	err  error
+	skip bool
+}
+
+func (m *syncingMethod) Process(ctx, conn context.Context, skew int) {
+	target := m.GetTarget()
+	target.mu.Lock()
+	defer target.mu.Unlock()
+	if !m.skip {
+		setSyncResult(m, m.err)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	defer func(l *zap.SugaredLogger) { logger = l }(logger)
+	logger = zap.NewNop().Sugar()
+	defer func(f *Fetchit) { fetchit = f }(fetchit)
+
+	fetchit = newFetchit()
+	fetchit.conn = context.Background()
+	web := &Target{name: "web", url: "https://example.com/web.git"}
+	db := &Target{url: "https://example.com/db.git"}
+	for _, m := range []*syncingMethod{
+		{busyMethod: busyMethod{CommonMethod: CommonMethod{Name: "web-raw", target: web}}},
+		{busyMethod: busyMethod{CommonMethod: CommonMethod{Name: "web-kube", target: web}}, err: fmt.Errorf("apply failed")},
+		{busyMethod: busyMethod{CommonMethod: CommonMethod{Name: "db-raw", target: db}}, skip: true},
+	} {
+		fetchit.methodTargetScheds[m] = m.SchedInfo()
+	}
+
+	socket := filepath.Join(t.TempDir(), "control.sock")
+	if err := startControl(socket); err != nil {
+		t.Fatalf("Failed: starting control socket: %v", err)
+	}
+
+	results, err := requestReconcile(socket, "")
+	if err != nil {
+		t.Fatalf("Failed: reconcile of all targets: %v", err)
+	}
+	want := []reconcileResult{
+		{Target: db.url, Method: "busy", Name: "db-raw", Result: reconcileFailure, Error: "the method did not sync its target, see the fetchit logs"},
+		{Target: "web", Method: "busy", Name: "web-kube", Result: reconcileFailure, Error: "apply failed"},
+		{Target: "web", Method: "busy", Name: "web-raw", Result: reconcileSuccess},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Fatalf("Failed: reconcile of all targets got %+v, want %+v", results, want)
+	}
+
+	results, err = requestReconcile(socket, "web")
+	if err != nil {
+		t.Fatalf("Failed: reconcile of target web: %v", err)
+	}
+	if !reflect.DeepEqual(results, want[1:]) {
+		t.Fatalf("Failed: reconcile of target web got %+v, want %+v", results, want[1:])
+	}
+
+	if _, err := requestReconcile(socket, "missing"); err == nil || !strings.Contains(err.Error(), `no target named "missing"`) {
+		t.Fatalf("Failed: reconcile of an unknown target should fail, got %v", err)
+	}
+}
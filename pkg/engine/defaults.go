@@ -0,0 +1,104 @@
+package engine
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-co-op/gocron"
+)
+
+// fallbackSchedule is the schedule of methods when neither they, their
+// targetConfig, the defaults nor defaultSchedule set one
+const fallbackSchedule = "*/5 * * * *"
+
+// Defaults holds values shared by all targetConfigs. A value set on a method
+// takes precedence over one set on its targetConfig, which takes precedence
+// over the defaults.
+type Defaults struct {
+	Url      string  `mapstructure:"url"`
+	Branch   string  `mapstructure:"branch"`
+	Schedule string  `mapstructure:"schedule"`
+	Skew     *int    `mapstructure:"skew"`
+	Glob     *string `mapstructure:"glob"`
+}
+
+// defaultSchedule returns the schedule of the methods that don't set one,
+// fallbackSchedule when schedule is empty
+func defaultSchedule(schedule string) (string, error) {
+	if schedule == "" {
+		return fallbackSchedule, nil
+	}
+	// the scheduler is never started, it only parses the schedule
+	if _, err := gocron.NewScheduler(time.UTC).Cron(schedule).Do(func() {}); err != nil {
+		return "", fmt.Errorf("invalid defaultSchedule %q: %v", schedule, err)
+	}
+	return schedule, nil
+}
+
+// applyDefaults fills the values a targetConfig and its methods leave unset,
+// methods without a schedule anywhere get fallback
+func (tc *TargetConfig) applyDefaults(d *Defaults, fallback string) {
+	if d == nil {
+		d = &Defaults{}
+	}
+	if tc.Url == "" && tc.Device == "" {
+		tc.Url = d.Url
+	}
+	if tc.Branch == "" {
+		tc.Branch = d.Branch
+	}
+
+	schedule := tc.Schedule
+	if schedule == "" {
+		schedule = d.Schedule
+	}
+	if schedule == "" {
+		schedule = fallback
+	}
+	skew := tc.Skew
+	if skew == nil {
+		skew = d.Skew
+	}
+	glob := tc.Glob
+	if glob == nil {
+		glob = d.Glob
+	}
+	for _, m := range tc.commonMethods() {
+		if m.Schedule == "" {
+			m.Schedule = schedule
+		}
+		if m.Skew == nil {
+			m.Skew = skew
+		}
+		if m.Glob == nil {
+			m.Glob = glob
+		}
+	}
+}
+
+// commonMethods returns the common fields of every git method of a targetConfig
+func (tc *TargetConfig) commonMethods() []*CommonMethod {
+	var methods []*CommonMethod
+	for _, m := range tc.Ansible {
+		methods = append(methods, &m.CommonMethod)
+	}
+	for _, m := range tc.FileTransfer {
+		methods = append(methods, &m.CommonMethod)
+	}
+	for _, m := range tc.Kube {
+		methods = append(methods, &m.CommonMethod)
+	}
+	for _, m := range tc.Raw {
+		methods = append(methods, &m.CommonMethod)
+	}
+	for _, m := range tc.Systemd {
+		methods = append(methods, &m.CommonMethod)
+	}
+	for _, m := range tc.Volume {
+		methods = append(methods, &m.CommonMethod)
+	}
+	for _, m := range tc.Network {
+		methods = append(methods, &m.CommonMethod)
+	}
+	return methods
+}
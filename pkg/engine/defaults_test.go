@@ -0,0 +1,125 @@
+package engine
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestApplyDefaultsPrecedence(t *testing.T) {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	err := v.ReadConfig(bytes.NewBufferString(`
+defaults:
+  url: https://github.com/containers/fetchit
+  branch: main
+  schedule: "*/5 * * * *"
+  skew: 1000
+targetConfigs:
+- name: defaulted
+  raw:
+  - name: raw-ex
+    targetPath: examples/raw
+- name: overridden
+  url: https://github.com/containers/fetchit-examples
+  schedule: "*/10 * * * *"
+  raw:
+  - name: raw-ex
+    targetPath: examples/raw
+  kube:
+  - name: kube-ex
+    targetPath: examples/kube
+    schedule: "*/1 * * * *"
+    skew: 0
+`))
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	config := &FetchitConfig{}
+	if err := v.Unmarshal(config); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	for _, tc := range config.TargetConfigs {
+		tc.applyDefaults(config.Defaults, fallbackSchedule)
+	}
+
+	defaulted := config.TargetConfigs[0]
+	if defaulted.Url != "https://github.com/containers/fetchit" || defaulted.Branch != "main" {
+		t.Fatalf("Failed: target defaults not applied: %s %s", defaulted.Url, defaulted.Branch)
+	}
+	raw := defaulted.Raw[0]
+	if raw.Schedule != "*/5 * * * *" || raw.Skew == nil || *raw.Skew != 1000 {
+		t.Fatalf("Failed: method defaults not applied: %s %v", raw.Schedule, raw.Skew)
+	}
+
+	overridden := config.TargetConfigs[1]
+	if overridden.Url != "https://github.com/containers/fetchit-examples" || overridden.Branch != "main" {
+		t.Fatalf("Failed: target value should override defaults: %s %s", overridden.Url, overridden.Branch)
+	}
+	if overridden.Raw[0].Schedule != "*/10 * * * *" {
+		t.Fatalf("Failed: target schedule should override defaults: %s", overridden.Raw[0].Schedule)
+	}
+	kube := overridden.Kube[0]
+	if kube.Schedule != "*/1 * * * *" || kube.Skew == nil || *kube.Skew != 0 {
+		t.Fatalf("Failed: method values should override target and defaults: %s %v", kube.Schedule, kube.Skew)
+	}
+}
+
+func TestApplyDefaultsDevice(t *testing.T) {
+	tc := &TargetConfig{Device: "/dev/sdb1"}
+	tc.applyDefaults(&Defaults{Url: "https://github.com/containers/fetchit"}, fallbackSchedule)
+	if tc.Url != "" {
+		t.Fatalf("Failed: default url should not apply to a device target: %s", tc.Url)
+	}
+	tc = &TargetConfig{}
+	tc.applyDefaults(nil, fallbackSchedule)
+}
+
+func TestApplyDefaultSchedule(t *testing.T) {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	err := v.ReadConfig(bytes.NewBufferString(`
+defaultSchedule: "*/15 * * * *"
+targetConfigs:
+- name: unscheduled
+  url: https://github.com/containers/fetchit
+  branch: main
+  raw:
+  - name: raw-ex
+    targetPath: examples/raw
+  kube:
+  - name: kube-ex
+    targetPath: examples/kube
+    schedule: "*/1 * * * *"
+`))
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	config := &FetchitConfig{}
+	if err := v.Unmarshal(config); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	schedule, err := defaultSchedule(config.DefaultSchedule)
+	if err != nil {
+		t.Fatalf("Failed: unexpected error: %v", err)
+	}
+	tc := config.TargetConfigs[0]
+	tc.applyDefaults(config.Defaults, schedule)
+	if tc.Raw[0].Schedule != "*/15 * * * *" {
+		t.Fatalf("Failed: method without a schedule should inherit defaultSchedule, got %q", tc.Raw[0].Schedule)
+	}
+	if tc.Kube[0].Schedule != "*/1 * * * *" {
+		t.Fatalf("Failed: method schedule should override defaultSchedule, got %q", tc.Kube[0].Schedule)
+	}
+	if err := tc.validate(); err != nil {
+		t.Fatalf("Failed: target with a default schedule is invalid: %v", err)
+	}
+
+	if schedule, err := defaultSchedule(""); err != nil || schedule != fallbackSchedule {
+		t.Fatalf("Failed: unset defaultSchedule should be %q, got %q: %v", fallbackSchedule, schedule, err)
+	}
+	if _, err := defaultSchedule("every so often"); err == nil {
+		t.Fatalf("Failed: expected an error for an invalid defaultSchedule")
+	}
+}
@@ -0,0 +1,131 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// dependencyOrder sorts names so that each name comes after its
+// dependencies, keeping the given order otherwise. Dependencies that are not
+// in names are ignored.
+func dependencyOrder(names []string, deps map[string][]string) ([]string, error) {
+	const (
+		visiting = 1
+		done     = 2
+	)
+	known := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		known[name] = struct{}{}
+	}
+	state := map[string]int{}
+	order := make([]string, 0, len(names))
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case done:
+			return nil
+		case visiting:
+			return fmt.Errorf("dependency cycle: %s", strings.Join(append(path, name), " -> "))
+		}
+		state[name] = visiting
+		for _, dep := range deps[name] {
+			if _, ok := known[dep]; !ok {
+				continue
+			}
+			if err := visit(dep, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = done
+		order = append(order, name)
+		return nil
+	}
+	for _, name := range names {
+		if err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// orderRawPods sorts the containers of a raw file so that each container is
+// started after the containers it depends on
+func orderRawPods(raws []*RawPod) ([]*RawPod, error) {
+	names := make([]string, 0, len(raws))
+	byName := make(map[string]*RawPod, len(raws))
+	deps := map[string][]string{}
+	for _, raw := range raws {
+		names = append(names, raw.Name)
+		byName[raw.Name] = raw
+		deps[raw.Name] = raw.DependsOn
+	}
+	order, err := dependencyOrder(names, deps)
+	if err != nil {
+		return nil, err
+	}
+	ordered := make([]*RawPod, 0, len(raws))
+	for _, name := range order {
+		ordered = append(ordered, byName[name])
+	}
+	return ordered, nil
+}
+
+// rawChangeOrder orders the changes of a raw target so that deleted files are
+// handled first and each file is deployed after the files defining the
+// containers it depends on
+func rawChangeOrder(changeMap map[*object.Change]string, parse func(name string, b []byte) ([]*RawPod, error)) ([]*object.Change, error) {
+	deletes := []*object.Change{}
+	byName := map[string]*object.Change{}
+	names := []string{}
+	// owner maps a container to the file defining it
+	owner := map[string]string{}
+	containerDeps := map[string][]string{}
+	for change, path := range changeMap {
+		if path == deleteFile {
+			deletes = append(deletes, change)
+			continue
+		}
+		name := changeName(change)
+		byName[name] = change
+		names = append(names, name)
+		b, err := getChangeContents(change, path)
+		if err != nil {
+			// the error is reported when the file is deployed
+			continue
+		}
+		raws, err := parse(path, b)
+		if err != nil {
+			continue
+		}
+		for _, raw := range raws {
+			owner[raw.Name] = name
+			containerDeps[name] = append(containerDeps[name], raw.DependsOn...)
+		}
+	}
+
+	deps := map[string][]string{}
+	for name, containers := range containerDeps {
+		for _, c := range containers {
+			if o, ok := owner[c]; ok && o != name {
+				deps[name] = append(deps[name], o)
+			}
+		}
+	}
+	sort.Strings(names)
+	order, err := dependencyOrder(names, deps)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(deletes, func(i, j int) bool {
+		return changeName(deletes[i]) < changeName(deletes[j])
+	})
+	changes := append([]*object.Change{}, deletes...)
+	for _, name := range order {
+		changes = append(changes, byName[name])
+	}
+	return changes, nil
+}
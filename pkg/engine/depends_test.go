@@ -0,0 +1,93 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func TestDependencyOrder(t *testing.T) {
+	names := []string{"app", "cache", "db"}
+	deps := map[string][]string{
+		"app":   {"db", "cache"},
+		"cache": {"db"},
+		// dependencies outside the set are already running
+		"db": {"external"},
+	}
+	order, err := dependencyOrder(names, deps)
+	if err != nil {
+		t.Fatalf("Failed: unexpected error: %v", err)
+	}
+	expected := []string{"db", "cache", "app"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Fatalf("Failed: order: %v != %v", order, expected)
+	}
+
+	deps["db"] = []string{"app"}
+	_, err = dependencyOrder(names, deps)
+	if err == nil || !strings.Contains(err.Error(), "dependency cycle: app -> db -> app") {
+		t.Fatalf("Failed: expected a dependency cycle error, got %v", err)
+	}
+}
+
+func TestRawChangeOrder(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, contents string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatalf("Failed: %v", err)
+		}
+		return path
+	}
+
+	app := &object.Change{To: object.ChangeEntry{Name: "app.yaml"}}
+	db := &object.Change{To: object.ChangeEntry{Name: "db.yaml"}}
+	old := &object.Change{From: object.ChangeEntry{Name: "old.yaml"}}
+	changeMap := map[*object.Change]string{
+		app: write("app.yaml", "Name: app\nImage: quay.io/fetchit/app\nDependsOn:\n- db\n"),
+		db:  write("db.yaml", "Name: db\nImage: quay.io/fetchit/db\n"),
+		old: deleteFile,
+	}
+	order, err := rawChangeOrder(changeMap, parseRawFile)
+	if err != nil {
+		t.Fatalf("Failed: unexpected error: %v", err)
+	}
+	expected := []*object.Change{old, db, app}
+	if !reflect.DeepEqual(order, expected) {
+		t.Fatalf("Failed: changes are not ordered by dependency")
+	}
+
+	changeMap[db] = write("db.yaml", "Name: db\nImage: quay.io/fetchit/db\nDependsOn:\n- app\n")
+	if _, err := rawChangeOrder(changeMap, parseRawFile); err == nil {
+		t.Fatalf("Failed: expected a dependency cycle error")
+	}
+}
+
+func TestOrderRawPods(t *testing.T) {
+	raws, err := rawPodsFromBytes([]byte(`
+Name: web
+Image: quay.io/fetchit/web
+DependsOn: [api]
+---
+Name: api
+Image: quay.io/fetchit/api
+`))
+	if err != nil {
+		t.Fatalf("Failed: unexpected error: %v", err)
+	}
+	ordered, err := orderRawPods(raws)
+	if err != nil {
+		t.Fatalf("Failed: unexpected error: %v", err)
+	}
+	if ordered[0].Name != "api" || ordered[1].Name != "web" {
+		t.Fatalf("Failed: containers are not ordered by dependency: %s, %s", ordered[0].Name, ordered[1].Name)
+	}
+}
+
+func parseRawFile(name string, b []byte) ([]*RawPod, error) {
+	return rawPodsFromBytes(b)
+}
@@ -0,0 +1,122 @@
+package engine
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+
+	"github.com/containers/fetchit/pkg/engine/utils"
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/podman/v4/pkg/bindings/images"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// redeployUpdatedImages redeploys the containers of the current raw files
+// whose image tag now resolves to a different digest in its registry
+func (r *Raw) redeployUpdatedImages(ctx, conn context.Context, tags *[]string) error {
+	log := methodLogger(r)
+	if imagePullPolicy == pullNever || imagePullPolicy == pullIfNotPresent {
+		log.Debugf("Skipping image digest check for %s, imagePullPolicy is %s", r.GetName(), imagePullPolicy)
+		return nil
+	}
+	target := r.GetTarget()
+	directory := getDirectory(target)
+	current, err := getCurrent(target, rawMethod, r.GetName())
+	if err != nil {
+		return err
+	}
+	matcher, err := newFileMatcher(r.Glob, r.Include, r.Exclude)
+	if err != nil {
+		return &utils.ValidationError{Err: err}
+	}
+
+	r.resetFacts()
+	for _, targetPath := range r.GetTargetPaths() {
+		tree, err := getSubTreeFromHash(directory, current, targetPath)
+		if err != nil {
+			return &utils.GitError{Err: err}
+		}
+		if err := r.redeployTreeImages(ctx, conn, tree, filepath.Join(directory, targetPath), matcher, tags); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// redeployTreeImages redeploys the raw files of a target path tree whose
+// images have a new digest, dir is the checkout of the target path
+func (r *Raw) redeployTreeImages(ctx, conn context.Context, tree *object.Tree, dir string, matcher *fileMatcher, tags *[]string) error {
+	log := methodLogger(r)
+	return tree.Files().ForEach(func(f *object.File) error {
+		if !checkTag(tags, f.Name) || !matcher.match(f.Name) {
+			return nil
+		}
+		contents, err := f.Contents()
+		if err != nil {
+			return err
+		}
+		raws, err := r.parseRawPods(conn, f.Name, []byte(contents))
+		if err != nil {
+			log.Warnf("Skipping image digest check for %s: %v", f.Name, err)
+			return nil
+		}
+		updated := false
+		for _, raw := range raws {
+			// the image deployed is checked, rather than the one of the file
+			image, _ := overrideImage(r.GetTarget(), raw.Image)
+			changed, err := imageDigestChanged(ctx, conn, image)
+			if err != nil {
+				log.Warnf("Unable to check digest of image %s: %v", image, err)
+				continue
+			}
+			if changed {
+				log.Infof("Image %s has a new digest, pulling", image)
+				if err := detectOrFetchImage(conn, image, true); err != nil {
+					return err
+				}
+				updated = true
+			}
+		}
+		if !updated {
+			return nil
+		}
+		return r.rawPodman(ctx, conn, filepath.Join(dir, f.Name), []byte(contents), &contents)
+	})
+}
+
+// imageDigestChanged reports whether the registry digest of an image differs
+// from the local copy. Images pinned by digest never change.
+func imageDigestChanged(ctx, conn context.Context, image string) (bool, error) {
+	if strings.Contains(image, "@") {
+		return false, nil
+	}
+	local, err := images.GetImage(conn, image, nil)
+	if err != nil {
+		// a missing image is pulled when the file is next applied
+		return false, nil
+	}
+	ref, err := docker.ParseReference("//" + strings.TrimPrefix(image, "docker://"))
+	if err != nil {
+		return false, utils.WrapErr(err, "Invalid image reference %s", image)
+	}
+	remote, err := docker.GetDigest(ctx, systemContext(ref), ref)
+	if err != nil {
+		return false, utils.WrapErr(err, "Error getting registry digest of %s", image)
+	}
+	return digestChanged(remote.String(), local.RepoDigests), nil
+}
+
+// digestChanged reports whether the remote digest is missing from the repo
+// digests of the local image. Images without repo digests, such as locally
+// built images, are never considered changed.
+func digestChanged(remote string, repoDigests []string) bool {
+	if remote == "" || len(repoDigests) == 0 {
+		return false
+	}
+	for _, rd := range repoDigests {
+		if strings.HasSuffix(rd, "@"+remote) {
+			return false
+		}
+	}
+	return true
+}
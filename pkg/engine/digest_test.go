@@ -0,0 +1,25 @@
+package engine
+
+import "testing"
+
+func TestDigestChanged(t *testing.T) {
+	remote := "sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+	other := "sha256:fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9"
+	tests := []struct {
+		name        string
+		remote      string
+		repoDigests []string
+		expected    bool
+	}{
+		{"unchanged", remote, []string{"quay.io/fetchit/app@" + remote}, false},
+		{"changed", remote, []string{"quay.io/fetchit/app@" + other}, true},
+		{"one of several repo digests", remote, []string{"docker.io/fetchit/app@" + other, "quay.io/fetchit/app@" + remote}, false},
+		{"locally built", remote, nil, false},
+		{"no remote digest", "", []string{"quay.io/fetchit/app@" + other}, false},
+	}
+	for _, tt := range tests {
+		if changed := digestChanged(tt.remote, tt.repoDigests); changed != tt.expected {
+			t.Fatalf("Failed: %s: digestChanged = %v, expected %v", tt.name, changed, tt.expected)
+		}
+	}
+}
@@ -4,20 +4,79 @@ import (
 	"archive/zip"
 	"context"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
-	"path"
 	"path/filepath"
 	"strings"
 
+	"github.com/containers/fetchit/pkg/engine/utils"
 	"github.com/containers/podman/v4/libpod/define"
-	"github.com/containers/podman/v4/pkg/bindings"
 	"github.com/containers/podman/v4/pkg/bindings/containers"
 )
 
-func extractZip(url string) error {
-	trimDir := strings.TrimSuffix(url, path.Ext(url))
-	directory := filepath.Base(trimDir)
+// unzipFile extracts a zip archive, such as a repository bundle, into directory
+func unzipFile(zipPath, directory string) error {
+	r, err := zip.OpenReader(zipPath)
+	if err != nil {
+		logger.Infof("error opening zip file: %s", err)
+		return err
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+
+		fpath := filepath.Join(directory, f.Name)
+		if f.FileInfo().IsDir() {
+			os.MkdirAll(fpath, f.Mode())
+		} else {
+			var fdir string
+			if lastIndex := strings.LastIndex(fpath, string(os.PathSeparator)); lastIndex > -1 {
+				fdir = fpath[:lastIndex]
+			}
+
+			os.MkdirAll(fdir, f.Mode())
+			f, err := os.OpenFile(
+				fpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			_, err = io.Copy(f, rc)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// verifyArchive verifies the zip at zipPath, downloaded from url, against the
+// signature published at url with .sig appended and the PEM public key at keyPath
+func verifyArchive(url, zipPath, keyPath string) error {
+	b, err := ioutil.ReadFile(zipPath)
+	if err != nil {
+		return utils.WrapErr(err, "Error reading archive %s", zipPath)
+	}
+	sig, err := downloadSignature(http.DefaultClient, url+".sig", "", "", "", nil)
+	if err != nil {
+		return utils.WrapErr(err, "Error downloading signature of archive %s", url)
+	}
+	if err := verifySignature(b, sig, keyPath); err != nil {
+		return utils.WrapErr(err, "Error verifying signature of archive %s", url)
+	}
+	return nil
+}
+
+// extractZip downloads the zip of a disconnected target and extracts it into
+// directory. When publicKey is set, a zip whose signature does not verify is
+// refused.
+func extractZip(directory, url, publicKey string) error {
 	cache := "/opt/.cache/" + directory + "/"
 	dest := cache + "HEAD"
 	absPath, err := filepath.Abs(directory)
@@ -45,49 +104,31 @@ func extractZip(url string) error {
 			// Create the destination file
 			os.MkdirAll(directory, 0755)
 
-			outFile, err := os.Create(absPath + "/" + directory + ".zip")
+			zipPath := filepath.Join(absPath, filepath.Base(directory)+".zip")
+			outFile, err := os.Create(zipPath)
 			if err != nil {
-				logger.Error("Failed creating file ", absPath+"/"+directory+".zip")
+				logger.Error("Failed creating file ", zipPath)
 				return err
 			}
 
 			// Write the body to file
 			io.Copy(outFile, data.Body)
+			outFile.Close()
 
-			// Unzip the file
-			r, err := zip.OpenReader(outFile.Name())
-			if err != nil {
-				logger.Infof("error opening zip file: %s", err)
-			}
-			for _, f := range r.File {
-				rc, err := f.Open()
-				if err != nil {
+			if publicKey != "" {
+				if err := verifyArchive(url, outFile.Name(), publicKey); err != nil {
+					logger.Errorf("Refusing disconnected archive from %s: %v", url, err)
+					os.Remove(outFile.Name())
+					// a directory made for this archive is removed, it is empty
+					os.Remove(directory)
 					return err
 				}
-				defer rc.Close()
-
-				fpath := filepath.Join(directory, f.Name)
-				if f.FileInfo().IsDir() {
-					os.MkdirAll(fpath, f.Mode())
-				} else {
-					var fdir string
-					if lastIndex := strings.LastIndex(fpath, string(os.PathSeparator)); lastIndex > -1 {
-						fdir = fpath[:lastIndex]
-					}
-
-					os.MkdirAll(fdir, f.Mode())
-					f, err := os.OpenFile(
-						fpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
-					if err != nil {
-						return err
-					}
-					defer f.Close()
-
-					_, err = io.Copy(f, rc)
-					if err != nil {
-						return err
-					}
-				}
+				logger.Infof("Verified signature of disconnected archive from %s", url)
+			}
+
+			// Unzip the file
+			if err := unzipFile(outFile.Name(), directory); err != nil {
+				return err
 			}
 			err = os.Remove(outFile.Name())
 			if err != nil {
@@ -103,16 +144,20 @@ func extractZip(url string) error {
 	return nil
 }
 
-func localDevicePull(name, device, trimDir string, image bool) (id string, err error) {
+// localDevicePull copies source on the device into the directory name of the
+// fetchit volume, the whole device when source is empty
+func localDevicePull(target, name, source, device, fsType, mountOptions, trimDir string, image bool) (id string, err error) {
 	// Need to use the filetransfer method to populate the directory from the localPath
 	ctx := context.Background()
-	conn, err := bindings.NewConnection(ctx, "unix://run/podman/podman.sock")
+	conn, err := connectPodman(ctx)
 	if err != nil {
 		logger.Error("Failed to create connection to podman")
 		return "", err
 	}
 	// Ensure that the device is present
-	_, exitCode, err := localDeviceCheck(name, device, trimDir)
+	// the directory may be below the cacheDir, the helpers are named after its base
+	base := filepath.Base(name)
+	_, exitCode, err := localDeviceCheck(target, base, device, trimDir)
 	if err != nil {
 		logger.Error("Failed to check device")
 		return "", err
@@ -127,21 +172,27 @@ func localDevicePull(name, device, trimDir string, image bool) (id string, err e
 	}
 	if exitCode == 0 {
 		// List currently running containers to ensure we don't create a duplicate
-		containerName := string(filetransferMethod + "-" + name + "-" + "disconnected" + "-" + trimDir)
+		containerName := string(filetransferMethod + "-" + base + "-" + "disconnected" + "-" + trimDir)
 		inspectData, err := containers.Inspect(conn, containerName, new(containers.InspectOptions).WithSize(true))
 		if err == nil || inspectData == nil {
 			logger.Error("The container already exists..requeuing")
 			return "", err
 		}
 
-		copyFile := ("/mnt/" + name + " " + "/opt" + "/")
-		s := generateDeviceSpec(filetransferMethod, "disconnected"+trimDir, copyFile, device, name)
+		// rsync only creates the last directory of the destination
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			return "", utils.WrapErr(err, "Error creating directory for %s", name)
+		}
+		s := generateDeviceSpec(filetransferMethod, target, "disconnected"+trimDir, filepath.Join("/mnt", source)+"/", filepath.Join("/opt", name)+"/", device, fsType, mountOptions, base)
 		createResponse, err := createAndStartContainer(conn, s)
 		if err != nil {
 			return "", err
 		}
 		// Wait for the container to finish
-		waitAndRemoveContainer(conn, createResponse.ID)
+		if err := waitAndRemoveContainer(conn, createResponse.ID); err != nil {
+			logger.Error("Failed to copy from device ", device)
+			return "", err
+		}
 		if !image {
 			createDiffFile(name)
 		}
@@ -152,10 +203,10 @@ func localDevicePull(name, device, trimDir string, image bool) (id string, err e
 
 // This function is more of a health check to check if the device is present. If the device
 // doesn't exist, it will return an error.
-func localDeviceCheck(name, device, trimDir string) (id string, exitcode int32, err error) {
+func localDeviceCheck(target, name, device, trimDir string) (id string, exitcode int32, err error) {
 	// Need to use the filetransfer method to populate the directory from the localPath
 	ctx := context.Background()
-	conn, err := bindings.NewConnection(ctx, "unix://run/podman/podman.sock")
+	conn, err := connectPodman(ctx)
 	if err != nil {
 		logger.Error("Failed to create connection to podman")
 		return "", 0, err
@@ -168,7 +219,7 @@ func localDeviceCheck(name, device, trimDir string) (id string, exitcode int32,
 		return "", 0, err
 	}
 
-	s := generateDevicePresentSpec(filetransferMethod, "disconnected"+trimDir, device, name)
+	s := generateDevicePresentSpec(filetransferMethod, target, "disconnected"+trimDir, device, name)
 	createResponse, err := createAndStartContainer(conn, s)
 	if err != nil {
 		return "", 0, err
@@ -0,0 +1,114 @@
+package engine
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+	"go.uber.org/zap"
+)
+
+func TestVerifyArchive(t *testing.T) {
+	archive := []byte("PK\x05\x06\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00")
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	pub, err := cryptoutils.MarshalPublicKeyToPEM(key.Public())
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "cosign.pub")
+	if err := ioutil.WriteFile(keyPath, pub, 0600); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	zipPath := filepath.Join(dir, "bundle.zip")
+	if err := ioutil.WriteFile(zipPath, archive, 0600); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	sign := func(b []byte) string {
+		digest := sha256.Sum256(b)
+		sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatalf("Failed: %v", err)
+		}
+		return base64.StdEncoding.EncodeToString(sig)
+	}
+
+	sigs := map[string]string{
+		"/signed.zip.sig":   sign(archive),
+		"/tampered.zip.sig": sign([]byte("another archive")),
+		"/garbage.zip.sig":  "not a signature",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sig, ok := sigs[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(sig + "\n"))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		path    string
+		wantErr bool
+	}{
+		{"/signed.zip", false},
+		{"/tampered.zip", true},
+		{"/garbage.zip", true},
+		{"/unsigned.zip", true},
+	}
+	for _, tt := range tests {
+		err := verifyArchive(srv.URL+tt.path, zipPath, keyPath)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("Failed: %s: unexpected error result: %v", tt.path, err)
+		}
+	}
+}
+
+func TestGetDisconnectedRefusesUnsignedArchive(t *testing.T) {
+	defer func(l *zap.SugaredLogger, dir string) { logger, cacheDir = l, dir }(logger, cacheDir)
+	logger = zap.NewNop().Sugar()
+	dir := t.TempDir()
+	cacheDir = filepath.Join(dir, "cache")
+	keyPath := filepath.Join(dir, "cosign.pub")
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	pub, err := cryptoutils.MarshalPublicKeyToPEM(key.Public())
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	if err := ioutil.WriteFile(keyPath, pub, 0600); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	// the archive is served without a signature
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/bundle.zip" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("PK\x05\x06\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"))
+	}))
+	defer srv.Close()
+
+	target := &Target{url: srv.URL + "/bundle.zip", disconnected: true, publicKey: keyPath}
+	if err := getDisconnected(target); err == nil {
+		t.Fatalf("Failed: expected the unsigned archive to fail the run")
+	}
+	if _, err := os.Stat(getDirectory(target)); !os.IsNotExist(err) {
+		t.Fatalf("Failed: the refused archive left %s behind: %v", getDirectory(target), err)
+	}
+}
@@ -0,0 +1,140 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/containers/podman/v4/pkg/bindings"
+	"github.com/containers/podman/v4/pkg/bindings/images"
+	"github.com/containers/podman/v4/pkg/bindings/volumes"
+	"github.com/spf13/cobra"
+)
+
+const podmanSocketURI = "unix://run/podman/podman.sock"
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check the fetchit environment",
+	Long:  `Check the podman socket, config, fetchit volume, helper images and environment fetchit needs to start`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if failed := runDoctor(cmd.OutOrStdout(), newDoctorEnv()); failed > 0 {
+			cobra.CheckErr(fmt.Errorf("%d check(s) failed", failed))
+		}
+	},
+}
+
+// doctorEnv holds the system calls used by the doctor checks
+type doctorEnv struct {
+	connect      func(ctx context.Context, uri string) (context.Context, error)
+	volumeExists func(conn context.Context, name string) (bool, error)
+	imageExists  func(conn context.Context, name string) (bool, error)
+	readFile     func(name string) ([]byte, error)
+	getenv       func(key string) string
+	rootless     func(conn context.Context) bool
+}
+
+func newDoctorEnv() *doctorEnv {
+	return &doctorEnv{
+		connect: bindings.NewConnection,
+		volumeExists: func(conn context.Context, name string) (bool, error) {
+			return volumes.Exists(conn, name, nil)
+		},
+		imageExists: func(conn context.Context, name string) (bool, error) {
+			return images.Exists(conn, name, nil)
+		},
+		readFile: ioutil.ReadFile,
+		getenv:   os.Getenv,
+		rootless: podmanRootless,
+	}
+}
+
+type doctorCheck struct {
+	name string
+	run  func() error
+}
+
+// runDoctor runs each check, printing its result, and returns the number of
+// failed checks. Checks that need podman are skipped when it is unreachable.
+func runDoctor(out io.Writer, env *doctorEnv) int {
+	failed := 0
+	report := func(name string, err error) {
+		if err != nil {
+			failed++
+			fmt.Fprintf(out, "[FAIL] %s: %v\n", name, err)
+			return
+		}
+		fmt.Fprintf(out, "[PASS] %s\n", name)
+	}
+
+	conn, err := env.connect(context.Background(), podmanSocketURI)
+	report("podman socket "+podmanSocketURI, err)
+	report("config", checkConfig(env))
+	if err != nil {
+		return failed
+	}
+
+	checks := []doctorCheck{
+		{"volume " + fetchitVolume, func() error { return checkVolume(env, conn, fetchitVolume) }},
+		{"image " + fetchitImage, func() error { return checkImage(env, conn, fetchitImage) }},
+		{"image " + systemdImage, func() error { return checkImage(env, conn, systemdImage) }},
+		{"rootless environment", func() error { return checkRootlessEnv(env, env.rootless(conn)) }},
+	}
+	for _, c := range checks {
+		report(c.name, c.run())
+	}
+	return failed
+}
+
+// checkConfig verifies the config file is readable, config fragments exist or a config url is set
+func checkConfig(env *doctorEnv) error {
+	_, err := env.readFile(defaultConfigPath)
+	if err == nil || env.getenv("FETCHIT_CONFIG_URL") != "" {
+		return nil
+	}
+	if fragments, _ := configFragments(); len(fragments) > 0 {
+		return nil
+	}
+	return fmt.Errorf("%s is not readable and FETCHIT_CONFIG_URL is not set: %v", defaultConfigPath, err)
+}
+
+func checkVolume(env *doctorEnv, conn context.Context, name string) error {
+	exists, err := env.volumeExists(conn, name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("volume %s does not exist, create it with podman volume create %s", name, name)
+	}
+	return nil
+}
+
+func checkImage(env *doctorEnv, conn context.Context, name string) error {
+	exists, err := env.imageExists(conn, name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("image %s is not present, fetchit will pull it when needed", name)
+	}
+	return nil
+}
+
+// checkRootlessEnv verifies the variables the systemd method needs with rootless podman
+func checkRootlessEnv(env *doctorEnv, rootless bool) error {
+	if !rootless {
+		return nil
+	}
+	for _, key := range []string{"HOME", "XDG_RUNTIME_DIR"} {
+		if env.getenv(key) == "" {
+			return fmt.Errorf("$%s must be set for rootless podman", key)
+		}
+	}
+	return nil
+}
+
+func init() {
+	fetchitCmd.AddCommand(doctorCmd)
+}
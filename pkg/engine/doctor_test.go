@@ -0,0 +1,95 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func fakeDoctorEnv() *doctorEnv {
+	return &doctorEnv{
+		connect: func(ctx context.Context, uri string) (context.Context, error) {
+			return ctx, nil
+		},
+		volumeExists: func(conn context.Context, name string) (bool, error) { return true, nil },
+		imageExists:  func(conn context.Context, name string) (bool, error) { return true, nil },
+		readFile:     func(name string) ([]byte, error) { return []byte("targetConfigs:"), nil },
+		getenv:       func(key string) string { return "" },
+		rootless:     func(conn context.Context) bool { return false },
+	}
+}
+
+func TestCheckConfig(t *testing.T) {
+	env := fakeDoctorEnv()
+	if err := checkConfig(env); err != nil {
+		t.Fatalf("Failed: readable config: %v", err)
+	}
+	env.readFile = func(name string) ([]byte, error) { return nil, os.ErrNotExist }
+	if err := checkConfig(env); err == nil {
+		t.Fatalf("Failed: expected error without config file or url")
+	}
+	env.getenv = func(key string) string {
+		if key == "FETCHIT_CONFIG_URL" {
+			return "https://example.com/config.yaml"
+		}
+		return ""
+	}
+	if err := checkConfig(env); err != nil {
+		t.Fatalf("Failed: config url: %v", err)
+	}
+}
+
+func TestCheckVolumeAndImage(t *testing.T) {
+	env := fakeDoctorEnv()
+	ctx := context.Background()
+	if err := checkVolume(env, ctx, fetchitVolume); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	if err := checkImage(env, ctx, fetchitImage); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	env.volumeExists = func(conn context.Context, name string) (bool, error) { return false, nil }
+	env.imageExists = func(conn context.Context, name string) (bool, error) { return false, errors.New("connection refused") }
+	if err := checkVolume(env, ctx, fetchitVolume); err == nil {
+		t.Fatalf("Failed: expected error for missing volume")
+	}
+	if err := checkImage(env, ctx, fetchitImage); err == nil {
+		t.Fatalf("Failed: expected error for image check failure")
+	}
+}
+
+func TestCheckRootlessEnv(t *testing.T) {
+	env := fakeDoctorEnv()
+	if err := checkRootlessEnv(env, false); err != nil {
+		t.Fatalf("Failed: rootful podman needs no variables: %v", err)
+	}
+	if err := checkRootlessEnv(env, true); err == nil || !strings.Contains(err.Error(), "$HOME") {
+		t.Fatalf("Failed: expected $HOME error, got %v", err)
+	}
+	env.getenv = func(key string) string { return "/home/fetchit" }
+	if err := checkRootlessEnv(env, true); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+}
+
+func TestRunDoctor(t *testing.T) {
+	out := &bytes.Buffer{}
+	if failed := runDoctor(out, fakeDoctorEnv()); failed != 0 {
+		t.Fatalf("Failed: %d checks failed:\n%s", failed, out.String())
+	}
+
+	env := fakeDoctorEnv()
+	env.connect = func(ctx context.Context, uri string) (context.Context, error) {
+		return nil, errors.New("no such file or directory")
+	}
+	out.Reset()
+	if failed := runDoctor(out, env); failed != 1 {
+		t.Fatalf("Failed: expected only the socket check to fail:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "[FAIL] podman socket") || strings.Contains(out.String(), "volume") {
+		t.Fatalf("Failed: podman checks should be skipped without a socket:\n%s", out.String())
+	}
+}
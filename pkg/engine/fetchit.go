@@ -2,17 +2,20 @@ package engine
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/containers/podman/v4/pkg/bindings"
+	"github.com/containers/fetchit/pkg/engine/utils"
+	units "github.com/docker/go-units"
 	"github.com/go-co-op/gocron"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
-	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
 	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -23,16 +26,63 @@ const (
 	fetchitVolume  = "fetchit-volume"
 	fetchitImage   = "quay.io/fetchit/fetchit:latest"
 	deleteFile     = "delete"
+
+	// defaultMaxFileSize keeps an accidentally committed large file from being read into memory
+	defaultMaxFileSize = 10 * units.MiB
+	// defaultCloneTimeout keeps a slow clone from stalling the targets cloned with it
+	defaultCloneTimeout = 10 * time.Minute
+
+	// overlapSkip drops a run of a method that is due while its last run is in progress
+	overlapSkip = "skip"
+	// overlapWait runs it once the last run is done
+	overlapWait = "wait"
+	// overlapAllow runs it alongside the last run
+	overlapAllow = "allow"
 )
 
 var (
 	defaultConfigPath   = filepath.Join("/opt", "mount", "config.yaml")
 	defaultConfigBackup = filepath.Join("/opt", "mount", "config-backup.yaml")
+	// defaultConfigPending holds a config update waiting for approval with a configReload manualApprove
+	defaultConfigPending = filepath.Join("/opt", "mount", "config-pending.yaml")
+	// defaultConfigApprove is touched to apply the config at defaultConfigPending
+	defaultConfigApprove = filepath.Join("/opt", "mount", "config-approve")
+	// defaultConfigDir holds config fragments, each *.yaml file is merged into the config
+	defaultConfigDir = filepath.Join("/opt", "mount", "config.d")
 
 	fetchitConfig *FetchitConfig
 	fetchit       *Fetchit
+	// cacheDir is where repositories are cloned, relative to the fetchit volume
+	cacheDir string
+	// maxFileSize is the size in bytes above which files in a target are skipped
+	maxFileSize int64 = defaultMaxFileSize
+	// fetchReuse is how long the head fetched for a target is reused, 0 to
+	// fetch on every run
+	fetchReuse = defaultFetchReuse
+	// imagePullPolicy is one of always, ifnotpresent or never, empty for the per method behavior
+	imagePullPolicy string
+	// slots bounds how many methods process at once, nil for no limit
+	slots chan struct{}
+	// runs is held for reading by each method run and for writing by Restart,
+	// so that a restart waits for in-flight applies to finish
+	runs sync.RWMutex
+	// generation is incremented by each Restart, runs scheduled before it are dropped
+	generation uint64
+	// overlap is what happens to runs of methods that don't set their own overlap
+	overlap = overlapSkip
+	// cloneTimeout is how long a clone may take before it is cancelled
+	cloneTimeout = defaultCloneTimeout
 )
 
+// validOverlap reports whether policy is an overlap policy, empty for the default
+func validOverlap(policy string) bool {
+	switch policy {
+	case "", overlapSkip, overlapWait, overlapAllow:
+		return true
+	}
+	return false
+}
+
 type Fetchit struct {
 	// conn holds podman client
 	conn               context.Context
@@ -44,15 +94,32 @@ type Fetchit struct {
 	pat                string
 	envSecret          string
 	restartFetchit     bool
+	rootless           bool
+	keepFailed         bool
+	leastPrivilege     bool
+	strict             bool
+	cleanRemoved       bool
 	scheduler          *gocron.Scheduler
 	methodTargetScheds map[Method]SchedInfo
 	allMethodTypes     map[string]struct{}
+	// jobs are the scheduled jobs of the methods
+	jobs map[Method]*gocron.Job
+	// metricsPush is where metrics are pushed, nil to not push them
+	metricsPush *MetricsPush
+	// order holds back the first runs of methods by priority, nil when unordered
+	order *methodOrder
+	// settings fingerprints the config apart from its targetConfigs
+	settings string
+	// targets are the targetConfigs of the config with their methods, without
+	// the targets fetchit adds for settings such as prune
+	targets []*scheduledTarget
 }
 
 func newFetchit() *Fetchit {
 	return &Fetchit{
 		methodTargetScheds: make(map[Method]SchedInfo),
 		allMethodTypes:     make(map[string]struct{}),
+		jobs:               make(map[Method]*gocron.Job),
 	}
 }
 
@@ -64,7 +131,7 @@ func newFetchitConfig() *FetchitConfig {
 
 // fetchitCmd represents the base command when called without any subcommands
 var fetchitCmd = &cobra.Command{
-	Version: "0.0.0",
+	Version: versionString(),
 	Use:     fetchitService,
 	Short:   "a tool to schedule gitOps workflows",
 	Long:    "Fetchit is a tool to schedule gitOps workflows based on a given configuration file",
@@ -81,14 +148,48 @@ func Execute() {
 
 // restart fetches new targets from an updated config
 // new targets will be added, stale removed, and existing
-// will set last commit as last known.
+// will set last commit as last known. When only targetConfigs
+// changed, the jobs of unchanged targets are left running.
 func (fc *FetchitConfig) Restart() {
-	for mt := range fetchit.allMethodTypes {
-		fetchit.scheduler.RemoveByTags(mt)
+	replaceConfig(fetchit, func() *Fetchit { return fc.InitConfig(false) })
+}
+
+// replaceConfig replaces the running config old with the one load returns.
+// In-flight runs of old finish before load is called, the jobs of old are
+// removed unless its targets can be reloaded in place.
+func replaceConfig(old *Fetchit, load func() *Fetchit) {
+	runs.Lock()
+	// the clones are listed before the config can change the cacheDir
+	var clones map[string]string
+	if old != nil {
+		clones = old.cloneDirectories()
+	}
+	next := load()
+	reloaded := old.reloadTargets(next)
+	if !reloaded {
+		clearJobs(old)
+	}
+	if next.cleanRemoved {
+		removeOrphanedClones(clones, next.cloneDirectories())
+	}
+	if reloaded {
+		// the jobs of unchanged targets keep running, runs are held meanwhile
+		next.removeOrphanedStateTags()
+	}
+	runs.Unlock()
+	if !reloaded {
+		next.startTargets()
 	}
-	fetchit.scheduler.Clear()
-	fetchit = fc.InitConfig(false)
-	fetchit.RunTargets()
+}
+
+// clearJobs removes every scheduled job and drops the runs still waiting to
+// start, runs must be held
+func clearJobs(f *Fetchit) {
+	for mt := range f.allMethodTypes {
+		f.scheduler.RemoveByTags(mt)
+	}
+	f.scheduler.Clear()
+	generation++
 }
 
 func readConfig(v *viper.Viper) (*FetchitConfig, bool, error) {
@@ -105,66 +206,212 @@ func readConfig(v *viper.Viper) (*FetchitConfig, bool, error) {
 			return nil, false, err
 		}
 	}
+
+	fragments, err := configFragments()
+	if err != nil {
+		return nil, false, err
+	}
+	if len(fragments) == 0 {
+		return config, true, nil
+	}
+	// the targetConfigs of every file are kept, other settings of later files
+	// override those of earlier files
+	targetConfigs := config.TargetConfigs
+	for _, path := range fragments {
+		fv := viper.New()
+		fv.SetConfigFile(path)
+		fv.SetConfigType("yaml")
+		if err := fv.ReadInConfig(); err != nil {
+			return nil, false, &utils.ValidationError{Err: utils.WrapErr(err, "Error reading config fragment %s", path)}
+		}
+		fragment := newFetchitConfig()
+		if err := fv.Unmarshal(&fragment); err != nil {
+			return nil, false, &utils.ValidationError{Err: utils.WrapErr(err, "Error with unmarshal of config fragment %s", path)}
+		}
+		targetConfigs = append(targetConfigs, fragment.TargetConfigs...)
+		if err := v.MergeConfigMap(fv.AllSettings()); err != nil {
+			return nil, false, utils.WrapErr(err, "Error merging config fragment %s", path)
+		}
+		logger.Infof("Merged config fragment %s", path)
+	}
+	config = newFetchitConfig()
+	if err := v.Unmarshal(&config); err != nil {
+		return nil, false, utils.WrapErr(err, "Error with unmarshal of merged config")
+	}
+	config.TargetConfigs = targetConfigs
 	return config, true, nil
 }
 
+// configFragments returns the config fragments in defaultConfigDir in name order
+func configFragments() ([]string, error) {
+	fragments, err := filepath.Glob(filepath.Join(defaultConfigDir, "*.yaml"))
+	if err != nil {
+		return nil, utils.WrapErr(err, "Error listing config fragments in %s", defaultConfigDir)
+	}
+	return fragments, nil
+}
+
 func (fc *FetchitConfig) populateFetchit(config *FetchitConfig) *Fetchit {
 	fetchit = newFetchit()
 	ctx := context.Background()
+	podmanConnection = config.PodmanConnection
 	if fc.conn == nil {
 		// TODO: socket directory same for all platforms?
 		// sock_dir := os.Getenv("XDG_RUNTIME_DIR")
 		// socket := "unix:" + sock_dir + "/podman/podman.sock"
-		conn, err := bindings.NewConnection(ctx, "unix://run/podman/podman.sock")
+		conn, err := connectPodman(ctx)
 		if err != nil || conn == nil {
-			cobra.CheckErr(fmt.Errorf("error establishing connection to podman.sock: %v", err))
+			cobra.CheckErr(fmt.Errorf("error establishing connection to podman: %v", err))
 		}
 		fc.conn = conn
 	}
 	fetchit.conn = fc.conn
+	fetchit.rootless = podmanRootless(fc.conn)
+	logger.Infof("Detected rootless podman: %t", fetchit.rootless)
+
+	if config.PullRetries < 0 || config.PullRetryDelay < 0 {
+		cobra.CheckErr(fmt.Errorf("invalid pullRetries %d or pullRetryDelay %s, must not be negative", config.PullRetries, config.PullRetryDelay))
+	}
+	pullRetries = config.PullRetries
+	pullRetryDelay = defaultPullRetryDelay
+	if config.PullRetryDelay > 0 {
+		pullRetryDelay = config.PullRetryDelay
+	}
+	if config.CloneTimeout < 0 {
+		cobra.CheckErr(fmt.Errorf("invalid cloneTimeout %s, must not be negative", config.CloneTimeout))
+	}
+	cloneTimeout = defaultCloneTimeout
+	if config.CloneTimeout > 0 {
+		cloneTimeout = config.CloneTimeout
+	}
+	pullMirror = config.PullMirror
 
 	if err := detectOrFetchImage(fc.conn, fetchitImage, false); err != nil {
 		cobra.CheckErr(err)
 	}
+	if err := ensureFetchitVolume(fc.conn); err != nil {
+		cobra.CheckErr(err)
+	}
+	if err := installRegistryCAs(fc.conn, fetchit.rootless, config.Registries); err != nil {
+		var verr *utils.ValidationError
+		if errors.As(err, &verr) {
+			cobra.CheckErr(err)
+		}
+		logger.Warnf("Pulls from registries with their own CA may fail: %v", err)
+	}
+
+	// Defaults only apply to the targets from the config file, not the internal
+	// targets added below
+	schedule, err := defaultSchedule(config.DefaultSchedule)
+	if err != nil {
+		cobra.CheckErr(err)
+	}
+	for _, tc := range config.TargetConfigs {
+		tc.applyDefaults(config.Defaults, schedule)
+	}
+	fetchit.strict = config.Strict
+	fetchit.cleanRemoved = config.CleanRemovedTargets
+	if err := validateTargetConfigs(config.TargetConfigs); err != nil {
+		if config.Strict {
+			cobra.CheckErr(fmt.Errorf("strict mode, refusing to start: %v", err))
+		}
+		logger.Warnf("Invalid targets will fail when they run: %v", err)
+	}
+	// targets fetchit adds for settings such as configReload and prune follow the
+	// targetConfigs of the config, they are covered by the settings fingerprint
+	fetchit.settings = settingsKey(config)
+	targetKeys := make([]string, len(config.TargetConfigs))
+	for i, tc := range config.TargetConfigs {
+		targetKeys[i] = configKey(tc)
+	}
 
 	// look for a ConfigURL, only find the first
 	// TODO: add logic to merge multiple configs
 	if config.ConfigReload != nil {
-		if config.ConfigReload.ConfigURL != "" || config.ConfigReload.Device != "" {
+		if config.ConfigReload.ConfigURL != "" || config.ConfigReload.ConfigRepo != "" || config.ConfigReload.Device != "" {
 			// reset URL if necessary
 			// ConfigURL set in config file overrides env variable
 			// If the same, this is no change, if diff then the new config has updated the configURL
 			os.Setenv("FETCHIT_CONFIG_URL", config.ConfigReload.ConfigURL)
+			if config.ConfigReload.PublicKey != "" {
+				os.Setenv("FETCHIT_CONFIG_PUBLIC_KEY", config.ConfigReload.PublicKey)
+			}
 			// Convert configReload to a proper target for processing
 			reload := &TargetConfig{
+				Url:          config.ConfigReload.ConfigRepo,
+				Branch:       config.ConfigReload.ConfigBranch,
 				configReload: config.ConfigReload,
 			}
 			config.TargetConfigs = append(config.TargetConfigs, reload)
 		}
 	}
 
-	// Check for GitAuth field
-	if config.GitAuth != nil {
-		// Check for SSH usage
-		if config.GitAuth.SSH {
-			if err := os.Setenv("SSH_KNOWN_HOSTS", "/opt/mount/.ssh/known_hosts"); err != nil {
-				cobra.CheckErr(err)
-			}
-			keyPath := defaultSSHKey
-			// Check for unique ssh key file
-			if config.GitAuth.SSHKeyFile != "" {
-				keyPath = filepath.Join("/opt", "mount", ".ssh", config.GitAuth.SSHKeyFile)
-			}
-			if err := checkForPrivateKey(keyPath); err != nil {
-				cobra.CheckErr(err)
-			}
-			fetchit.ssh = true
-			fetchit.sshKey = keyPath
+	if err := fetchit.setGitAuth(config.GitAuth); err != nil {
+		cobra.CheckErr(err)
+	}
+
+	// Helper containers read cloned files from the fetchit volume mounted at /opt,
+	// so clones must stay within it
+	if filepath.IsAbs(config.CacheDir) {
+		cobra.CheckErr(fmt.Errorf("cacheDir %s must be relative to the fetchit volume", config.CacheDir))
+	}
+	cacheDir = config.CacheDir
+	fetchit.keepFailed = config.KeepFailed
+	fetchit.leastPrivilege = config.LeastPrivilege
+	setConfigPaused(config.Paused)
+	if config.Paused {
+		logger.Info("Fetchit is paused by the config, methods will skip processing")
+	}
+
+	imagePullPolicy = strings.ToLower(config.ImagePullPolicy)
+	switch imagePullPolicy {
+	case "", pullAlways, pullIfNotPresent, pullNever:
+	default:
+		cobra.CheckErr(fmt.Errorf("invalid imagePullPolicy %s, must be one of %s, %s or %s", config.ImagePullPolicy, pullAlways, pullIfNotPresent, pullNever))
+	}
+
+	if config.Health != nil && config.Health.StaleAfter < 0 {
+		cobra.CheckErr(fmt.Errorf("invalid health staleAfter %s, must not be negative", config.Health.StaleAfter))
+	}
+	startHealth(config.Health)
+
+	if config.MetricsPush != nil {
+		if err := config.MetricsPush.validate(); err != nil {
+			cobra.CheckErr(err)
+		}
+	}
+	fetchit.metricsPush = config.MetricsPush
+
+	if !validOverlap(config.Overlap) {
+		cobra.CheckErr(fmt.Errorf("invalid overlap %s, must be one of %s, %s or %s", config.Overlap, overlapSkip, overlapWait, overlapAllow))
+	}
+	overlap = overlapSkip
+	if config.Overlap != "" {
+		overlap = config.Overlap
+	}
+
+	if config.MaxConcurrency < 0 {
+		cobra.CheckErr(fmt.Errorf("invalid maxConcurrency %d, must not be negative", config.MaxConcurrency))
+	}
+	slots = nil
+	if config.MaxConcurrency > 0 {
+		slots = make(chan struct{}, config.MaxConcurrency)
+	}
+
+	maxFileSize = defaultMaxFileSize
+	if config.MaxFileSize != "" {
+		size, err := units.RAMInBytes(config.MaxFileSize)
+		if err != nil || size < 0 {
+			cobra.CheckErr(fmt.Errorf("invalid maxFileSize %s: %v", config.MaxFileSize, err))
+		}
+		maxFileSize = size
+	}
+	fetchReuse = defaultFetchReuse
+	if config.FetchReuse != nil {
+		if *config.FetchReuse < 0 {
+			cobra.CheckErr(fmt.Errorf("invalid fetchReuse %s, must not be negative", *config.FetchReuse))
 		}
-		fetchit.username = config.GitAuth.Username
-		fetchit.password = config.GitAuth.Password
-		fetchit.pat = config.GitAuth.PAT
-		fetchit.envSecret = config.GitAuth.EnvSecret
+		fetchReuse = *config.FetchReuse
 	}
 
 	if config.Prune != nil {
@@ -173,6 +420,12 @@ func (fc *FetchitConfig) populateFetchit(config *FetchitConfig) *Fetchit {
 		}
 		config.TargetConfigs = append(config.TargetConfigs, prune)
 	}
+	if config.SelfUpdate != nil {
+		selfUpdate := &TargetConfig{
+			selfUpdate: config.SelfUpdate,
+		}
+		config.TargetConfigs = append(config.TargetConfigs, selfUpdate)
+	}
 	if config.Images != nil {
 		for _, i := range config.Images {
 			imageLoad := &TargetConfig{
@@ -194,15 +447,24 @@ func (fc *FetchitConfig) populateFetchit(config *FetchitConfig) *Fetchit {
 		fc.scheduler = gocron.NewScheduler(time.UTC)
 	}
 	fetchit.scheduler = fc.scheduler
-	return getMethodTargetScheds(fc.TargetConfigs, fetchit)
+	for i, tc := range fc.TargetConfigs {
+		methods := addTargetConfig(tc, fetchit)
+		if i < len(targetKeys) {
+			fetchit.targets = append(fetchit.targets, &scheduledTarget{key: targetKeys[i], methods: methods})
+		}
+	}
+	return fetchit
 }
 
 // This location will be checked first. This is from a `-v /path/to/config.yaml:/opt/mount/config.yaml`,
+// or config fragments in /opt/mount/config.d.
 // If not initial, this may be overwritten with what is currently in FETCHIT_CONFIG_URL
 func isLocalConfig(v *viper.Viper) (*FetchitConfig, bool, error) {
 	if _, err := os.Stat(defaultConfigPath); err != nil {
-		logger.Infof("Local config file not found: %v", err)
-		return nil, false, err
+		if fragments, _ := configFragments(); len(fragments) == 0 {
+			logger.Infof("Local config file not found: %v", err)
+			return nil, false, err
+		}
 	}
 	return readConfig(v)
 }
@@ -211,6 +473,7 @@ func isLocalConfig(v *viper.Viper) (*FetchitConfig, bool, error) {
 func (fc *FetchitConfig) InitConfig(initial bool) *Fetchit {
 	InitLogger()
 	defer logger.Sync()
+	logger.Infof("Fetchit version %s", versionString())
 	v := viper.New()
 	var err error
 	var isLocal, exists bool
@@ -233,7 +496,7 @@ func (fc *FetchitConfig) InitConfig(initial bool) *Fetchit {
 		// Only run this from initial startup and only after trying to populate the config from a local file.
 		// because CheckForConfigUpdates also runs with each processConfig, so if !initial this is already done
 		// If configURL is passed in, a config file on disk has priority on the initial run.
-		_ = checkForConfigUpdates(envURL, false, true, "", "", "")
+		_ = checkForConfigUpdates(envURL, false, true, "", "", "", configHTTPAuth(nil), configPublicKey(nil))
 	}
 
 	// if config is not yet populated, fc.CheckForConfigUpdates has placed the config
@@ -257,130 +520,359 @@ func (fc *FetchitConfig) InitConfig(initial bool) *Fetchit {
 	return fc.populateFetchit(config)
 }
 
+// disabled returns whether a method is disabled, logging that it is not scheduled
+func disabled(tc *TargetConfig, kind string, m *CommonMethod) bool {
+	if m.isEnabled() {
+		return false
+	}
+	logger.Infof("Target: %s, %s method %s is disabled, not scheduling it", tc.Name, kind, m.Name)
+	return true
+}
+
 // Takes target from user and converts it for internal use
 func getMethodTargetScheds(targetConfigs []*TargetConfig, fetchit *Fetchit) *Fetchit {
 	for _, tc := range targetConfigs {
-		tc.mu.Lock()
-		defer tc.mu.Unlock()
-		internalTarget := &Target{
-			url:    tc.Url,
-			device: tc.Device,
-			pat:    fetchit.pat,
-			// define the environment variable for envSecret
-			envSecret:    fetchit.envSecret,
-			ssh:          fetchit.ssh,
-			sshKey:       fetchit.sshKey,
-			username:     fetchit.username,
-			password:     fetchit.password,
-			branch:       tc.Branch,
-			disconnected: tc.Disconnected,
-		}
+		addTargetConfig(tc, fetchit)
+	}
+	return fetchit
+}
 
-		if tc.VerifyCommitsInfo != nil {
-			internalTarget.gitsignVerify = tc.VerifyCommitsInfo.GitsignVerify
-			internalTarget.gitsignRekorURL = tc.VerifyCommitsInfo.GitsignRekorURL
+// addTargetConfig adds the methods of a targetConfig to fetchit and returns them
+func addTargetConfig(tc *TargetConfig, fetchit *Fetchit) []Method {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	// the methods could take over the containers of another target
+	if err := checkTargetName(tc.Name); err != nil {
+		logger.Errorf("Skipping target: %v", err)
+		return nil
+	}
+	var methods []Method
+	internalTarget := &Target{
+		name:   tc.Name,
+		url:    tc.Url,
+		device: tc.Device,
+		// the filesystem of the device is detected by mount when not set
+		filesystemType: tc.FilesystemType,
+		mountOptions:   tc.MountOptions,
+		pat:            fetchit.pat,
+		// define the environment variable for envSecret
+		envSecret:    fetchit.envSecret,
+		ssh:          fetchit.ssh,
+		sshKey:       fetchit.sshKey,
+		username:     fetchit.username,
+		password:     fetchit.password,
+		branch:       tc.Branch,
+		disconnected: tc.Disconnected,
+		publicKey:    tc.PublicKey,
+	}
+	if len(tc.ImageOverrides) > 0 {
+		internalTarget.imageOverrides = map[string]string{}
+		for _, o := range tc.ImageOverrides {
+			internalTarget.imageOverrides[o.From] = o.To
 		}
+	}
 
-		if tc.configReload != nil {
-			tc.configReload.target = internalTarget
-			tc.configReload.initialRun = true
-			fetchit.methodTargetScheds[tc.configReload] = tc.configReload.SchedInfo()
-			fetchit.allMethodTypes[configFileMethod] = struct{}{}
-		}
+	if tc.VerifyCommitsInfo != nil {
+		internalTarget.gitsignVerify = tc.VerifyCommitsInfo.GitsignVerify
+		internalTarget.gitsignRekorURL = tc.VerifyCommitsInfo.GitsignRekorURL
+	}
 
-		if tc.prune != nil {
-			tc.prune.target = internalTarget
-			fetchit.methodTargetScheds[tc.prune] = tc.prune.SchedInfo()
-			fetchit.allMethodTypes[pruneMethod] = struct{}{}
+	if tc.configReload != nil {
+		// tags pushed to commits that were already fetched gate the reload
+		internalTarget.fetchTags = tc.configReload.ConfigTagRange != ""
+		tc.configReload.target = internalTarget
+		tc.configReload.initialRun = true
+		fetchit.methodTargetScheds[tc.configReload] = tc.configReload.SchedInfo()
+		methods = append(methods, tc.configReload)
+		fetchit.allMethodTypes[configFileMethod] = struct{}{}
+	}
 
-		}
+	if tc.prune != nil {
+		tc.prune.target = internalTarget
+		fetchit.methodTargetScheds[tc.prune] = tc.prune.SchedInfo()
+		methods = append(methods, tc.prune)
+		fetchit.allMethodTypes[pruneMethod] = struct{}{}
 
-		if tc.image != nil {
-			tc.image.target = internalTarget
-			tc.image.initialRun = true
-			fetchit.methodTargetScheds[tc.image] = tc.image.SchedInfo()
-			fetchit.allMethodTypes[imageMethod] = struct{}{}
+	}
 
-		}
+	if tc.selfUpdate != nil {
+		tc.selfUpdate.target = internalTarget
+		fetchit.methodTargetScheds[tc.selfUpdate] = tc.selfUpdate.SchedInfo()
+		methods = append(methods, tc.selfUpdate)
+		fetchit.allMethodTypes[selfUpdateMethod] = struct{}{}
+	}
+
+	if tc.image != nil {
+		tc.image.target = internalTarget
+		tc.image.initialRun = true
+		fetchit.methodTargetScheds[tc.image] = tc.image.SchedInfo()
+		methods = append(methods, tc.image)
+		fetchit.allMethodTypes[imageMethod] = struct{}{}
 
-		if len(tc.Ansible) > 0 {
-			fetchit.allMethodTypes[ansibleMethod] = struct{}{}
-			for _, a := range tc.Ansible {
-				a.initialRun = true
-				a.target = internalTarget
-				fetchit.methodTargetScheds[a] = a.SchedInfo()
+	}
+
+	if len(tc.Ansible) > 0 {
+		fetchit.allMethodTypes[ansibleMethod] = struct{}{}
+		for _, a := range tc.Ansible {
+			if disabled(tc, ansibleMethod, &a.CommonMethod) {
+				continue
+			}
+			a.initialRun = true
+			a.target = internalTarget
+			fetchit.methodTargetScheds[a] = a.SchedInfo()
+			methods = append(methods, a)
+		}
+	}
+	if len(tc.FileTransfer) > 0 {
+		fetchit.allMethodTypes[filetransferMethod] = struct{}{}
+		for _, ft := range tc.FileTransfer {
+			if disabled(tc, filetransferMethod, &ft.CommonMethod) {
+				continue
 			}
+			ft.initialRun = true
+			ft.target = internalTarget
+			fetchit.methodTargetScheds[ft] = ft.SchedInfo()
+			methods = append(methods, ft)
 		}
-		if len(tc.FileTransfer) > 0 {
-			fetchit.allMethodTypes[filetransferMethod] = struct{}{}
-			for _, ft := range tc.FileTransfer {
-				ft.initialRun = true
-				ft.target = internalTarget
-				fetchit.methodTargetScheds[ft] = ft.SchedInfo()
+	}
+	if len(tc.Kube) > 0 {
+		fetchit.allMethodTypes[kubeMethod] = struct{}{}
+		for _, k := range tc.Kube {
+			if disabled(tc, kubeMethod, &k.CommonMethod) {
+				continue
 			}
+			k.initialRun = true
+			k.target = internalTarget
+			fetchit.methodTargetScheds[k] = k.SchedInfo()
+			methods = append(methods, k)
 		}
-		if len(tc.Kube) > 0 {
-			fetchit.allMethodTypes[kubeMethod] = struct{}{}
-			for _, k := range tc.Kube {
-				k.initialRun = true
-				k.target = internalTarget
-				fetchit.methodTargetScheds[k] = k.SchedInfo()
+	}
+	if len(tc.Raw) > 0 {
+		fetchit.allMethodTypes[rawMethod] = struct{}{}
+		for _, r := range tc.Raw {
+			if disabled(tc, rawMethod, &r.CommonMethod) {
+				continue
 			}
+			r.initialRun = true
+			r.target = internalTarget
+			fetchit.methodTargetScheds[r] = r.SchedInfo()
+			methods = append(methods, r)
 		}
-		if len(tc.Raw) > 0 {
-			fetchit.allMethodTypes[rawMethod] = struct{}{}
-			for _, r := range tc.Raw {
-				r.initialRun = true
-				r.target = internalTarget
-				fetchit.methodTargetScheds[r] = r.SchedInfo()
+	}
+	if len(tc.Volume) > 0 {
+		fetchit.allMethodTypes[volumeMethod] = struct{}{}
+		for _, v := range tc.Volume {
+			if disabled(tc, volumeMethod, &v.CommonMethod) {
+				continue
 			}
+			v.initialRun = true
+			v.target = internalTarget
+			fetchit.methodTargetScheds[v] = v.SchedInfo()
+			methods = append(methods, v)
 		}
-		if len(tc.Systemd) > 0 {
-			fetchit.allMethodTypes[systemdMethod] = struct{}{}
-			for _, sd := range tc.Systemd {
-				sd.initialRun = true
-				sd.target = internalTarget
-				fetchit.methodTargetScheds[sd] = sd.SchedInfo()
+	}
+	if len(tc.Network) > 0 {
+		fetchit.allMethodTypes[networkMethod] = struct{}{}
+		for _, n := range tc.Network {
+			if disabled(tc, networkMethod, &n.CommonMethod) {
+				continue
 			}
+			n.initialRun = true
+			n.target = internalTarget
+			fetchit.methodTargetScheds[n] = n.SchedInfo()
+			methods = append(methods, n)
 		}
 	}
-	return fetchit
+	if len(tc.Systemd) > 0 {
+		fetchit.allMethodTypes[systemdMethod] = struct{}{}
+		for _, sd := range tc.Systemd {
+			if disabled(tc, systemdMethod, &sd.CommonMethod) {
+				continue
+			}
+			if sd.Root != nil && *sd.Root && fetchit.rootless {
+				logger.Warnf("Systemd target %s requests root but fetchit is running rootless, it will likely fail", sd.Name)
+			}
+			sd.initialRun = true
+			sd.target = internalTarget
+			fetchit.methodTargetScheds[sd] = sd.SchedInfo()
+			methods = append(methods, sd)
+		}
+	}
+	return methods
 }
 
 func (f *Fetchit) RunTargets() {
-	for method := range f.methodTargetScheds {
-		// ConfigReload, PodmanAutoUpdateAll, Image, Prune methods do not include git URL
-		if method.GetTarget().url != "" {
-			if err := getRepo(method.GetTarget()); err != nil {
-				logger.Debugf("Target: %s, clone error: %v, will retry next scheduled run", method.GetTarget(), err)
-			}
-		}
+	f.startTargets()
+	select {}
+}
+
+// startTargets clones the targets, schedules their methods and starts the scheduler
+func (f *Fetchit) startTargets() {
+	if err := f.cloneTargets(); err != nil && f.strict {
+		cobra.CheckErr(fmt.Errorf("strict mode, refusing to start: %v", err))
 	}
+	// nothing runs before the scheduler starts
+	f.removeOrphanedStateTags()
 
-	s := f.scheduler
+	f.order = newMethodOrder(f.methodTargetScheds)
 	for method, schedInfo := range f.methodTargetScheds {
-		skew := 0
-		if schedInfo.skew != nil {
-			skew = rand.Intn(*schedInfo.skew)
+		f.schedule(method, schedInfo)
+	}
+	startMetricsPush(f.scheduler, f.metricsPush)
+	f.scheduler.StartAsync()
+	// clearJobs removes the heartbeats with the methods, so they are scheduled
+	// again on every restart
+	startWatchdog(f.scheduler)
+}
+
+// schedule adds the job of a method, which runs right away and then on the
+// method's schedule. The job of a runOnce method is removed after its run.
+// Methods that set a priority wait on their first run for the methods with a
+// lower priority. A run that is due while the last run of the method is in
+// progress follows the overlap policy of the method.
+func (f *Fetchit) schedule(method Method, schedInfo SchedInfo) {
+	f.addJob(method, schedInfo, true)
+}
+
+// reschedule adds the job of a method again after the jobs were removed, it
+// only runs on the method's schedule. Run once methods have already run and
+// are not added again.
+func (f *Fetchit) reschedule(method Method, schedInfo SchedInfo) {
+	if schedInfo.runOnce {
+		return
+	}
+	f.addJob(method, schedInfo, false)
+}
+
+// addJob adds the job of a method to the scheduler, which runs it right away
+// when startNow is set
+func (f *Fetchit) addJob(method Method, schedInfo SchedInfo, startNow bool) {
+	skew := 0
+	if schedInfo.skew != nil {
+		skew = rand.Intn(*schedInfo.skew)
+	}
+	mt := method.GetKind()
+	logger.Infof("Processing git target: %s Method: %s Name: %s", method.GetTarget().url, mt, method.GetName())
+	s := f.scheduler
+	if schedInfo.runOnce {
+		// the interval is never reached, gocron removes the job once it has run
+		s = s.Every(1).Day().LimitRunsTo(1)
+	} else {
+		s = s.Cron(schedInfo.schedule)
+	}
+	order, gen, conn := f.order, generation, f.conn
+	run := func() {
+		runMethod(method, gen, context.Background(), conn, skew, schedInfo.timeout)
+	}
+	if order.holds(method) {
+		run = func() {
+			runInOrder(order, method, gen, context.Background(), conn, skew, schedInfo.timeout)
 		}
-		ctx, cancel := context.WithCancel(context.Background())
-		defer cancel()
-		mt := method.GetKind()
-		logger.Infof("Processing git target: %s Method: %s Name: %s", method.GetTarget().url, mt, method.GetName())
-		s.Cron(schedInfo.schedule).Tag(mt).Do(method.Process, ctx, f.conn, skew)
-		s.StartImmediately()
 	}
-	s.StartAsync()
-	select {}
+	policy := schedInfo.overlap
+	if policy == "" {
+		policy = overlap
+	}
+	if schedInfo.runOnce {
+		// a single run can't overlap, and gocron drops a singleton run of a
+		// job it has already removed
+		policy = overlapAllow
+	}
+	switch policy {
+	case overlapSkip:
+		s = s.SingletonMode()
+	case overlapWait:
+		var mu sync.Mutex
+		next := run
+		run = func() {
+			mu.Lock()
+			defer mu.Unlock()
+			next()
+		}
+	}
+	if startNow {
+		s = s.StartImmediately()
+	}
+	job, err := s.Tag(mt).Do(run)
+	if err != nil {
+		logger.Errorf("Unable to schedule %s %s: %v", mt, method.GetName(), err)
+		// the methods after it are not held back by a method that never runs
+		f.order.done(method)
+		return
+	}
+	f.jobs[method] = job
+}
+
+// runMethod waits out the skew and for a free slot when maxConcurrency is
+// set, then processes the method. Nothing but ConfigReload is processed while
+// paused, so that a config unsetting paused is still picked up, and runs
+// scheduled by a config that has since been restarted are dropped.
+func runMethod(method Method, gen uint64, ctx, conn context.Context, skew int, timeout time.Duration) {
+	if isPaused() && method.GetKind() != configFileMethod {
+		logger.Infof("Fetchit is paused, skipping %s %s", method.GetKind(), method.GetName())
+		return
+	}
+	time.Sleep(time.Duration(skew) * time.Millisecond)
+	if s := slots; s != nil {
+		s <- struct{}{}
+		defer func() { <-s }()
+	}
+	// ConfigReload calls Restart and SelfUpdate drains the runs, so they must not hold runs
+	if kind := method.GetKind(); kind != configFileMethod && kind != selfUpdateMethod {
+		runs.RLock()
+		defer runs.RUnlock()
+		if gen != generation {
+			logger.Infof("Config was reloaded, dropping run of %s %s", method.GetKind(), method.GetName())
+			return
+		}
+		if target := method.GetTarget(); target != nil && target.removed {
+			logger.Infof("Target was removed from the config, dropping run of %s %s", method.GetKind(), method.GetName())
+			return
+		}
+	}
+	start := time.Now()
+	processWithTimeout(method, ctx, conn, 0, timeout)
+	observeSync(method, start)
+}
+
+// processWithTimeout runs a method, cancelling its context and podman
+// connection once timeout has passed so the next scheduled run can retry
+func processWithTimeout(method Method, ctx, conn context.Context, skew int, timeout time.Duration) {
+	if timeout <= 0 {
+		method.Process(ctx, conn, skew)
+		return
+	}
+	deadline := time.Now().Add(timeout)
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	// podman bindings send requests with the connection context
+	conn, cancelConn := context.WithDeadline(conn, deadline)
+	defer cancelConn()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		method.Process(ctx, conn, skew)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		<-done
+	}
+	// Process may return on its cancelled connection before ctx is done, so
+	// the deadline is checked once it has returned
+	if !time.Now().Before(deadline) {
+		logger.Errorf("Method %s %s timed out after %s, in-flight operations are cancelled and will be retried next scheduled run", method.GetKind(), method.GetName(), timeout)
+	}
 }
 
 func getRepo(target *Target) error {
 	if target.url != "" && !target.disconnected {
-		getClone(target)
+		return getClone(target)
 	} else if target.disconnected && len(target.url) > 0 {
-		getDisconnected(target)
+		return getDisconnected(target)
 	} else if target.disconnected && len(target.device) > 0 {
-		getDeviceDisconnected(target)
+		return getDeviceDisconnected(target)
 	}
 	return nil
 }
@@ -391,6 +883,7 @@ func getClone(target *Target) error {
 	if err != nil {
 		return err
 	}
+	migrateClone(target, directory)
 	var exists bool
 	if _, err := os.Stat(directory); err == nil {
 		exists = true
@@ -398,26 +891,20 @@ func getClone(target *Target) error {
 		if _, err := os.Stat(directory + "/.git"); err != nil {
 			return fmt.Errorf("%s exists but is not a git repository", directory)
 		}
+		if err := checkRemote(directory, target.url); err != nil {
+			return err
+		}
 	} else if !os.IsNotExist(err) {
 		return err
 	}
 	if !exists {
 		logger.Infof("git clone %s %s --recursive", target.url, target.branch)
-		// if the envSecret is set, use it as variable target.PAT
 		if target.envSecret != "" {
-			target.pat = os.Getenv(target.envSecret)
 			logger.Infof("Using the envSecret %s", target.envSecret)
 		}
-		if target.pat != "" {
-			target.username = "fetchit"
-			target.password = target.pat
-		}
 		// default to using existing http method
 		cOptions := &git.CloneOptions{
-			Auth: &githttp.BasicAuth{
-				Username: target.username, // the value of this field should not matter when using a PAT
-				Password: target.password,
-			},
+			Auth:          target.basicAuth(),
 			URL:           target.url,
 			ReferenceName: plumbing.ReferenceName(fmt.Sprintf("refs/heads/%s", target.branch)),
 			SingleBranch:  true,
@@ -432,8 +919,14 @@ func getClone(target *Target) error {
 			}
 			cOptions.Auth = authValue
 		}
-		_, err := git.PlainClone(absPath, false, cOptions)
+		// a clone cancelled by the timeout is removed and retried on the next run
+		ctx, cancel := context.WithTimeout(context.Background(), cloneTimeout)
+		defer cancel()
+		_, err := git.PlainCloneContext(ctx, absPath, false, cOptions)
 		if err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				err = fmt.Errorf("clone of %s did not finish within cloneTimeout %s: %v", target.url, cloneTimeout, err)
+			}
 			logger.Infof("git clone failed: %s", err.Error())
 			return err
 		}
@@ -454,7 +947,7 @@ func getDisconnected(target *Target) error {
 		return err
 	}
 	if !exists {
-		extractZip(target.url)
+		return extractZip(directory, target.url, target.publicKey)
 	}
 	return nil
 }
@@ -472,7 +965,52 @@ func getDeviceDisconnected(target *Target) error {
 		return err
 	}
 	if !exists {
-		localDevicePull(directory, target.device, "", false)
+		localDevicePull(targetName(target), directory, "", target.device, target.filesystemType, target.mountOptions, "", false)
 	}
 	return nil
 }
+
+// migrateClone moves a clone of the target from its legacy directory to
+// directory, so that its state tags are kept and nothing is applied again.
+// A legacy directory that is a clone of another repository is left alone, and
+// a clone that can't be moved is cloned again.
+func migrateClone(target *Target, directory string) {
+	legacy := legacyDirectory(target)
+	if _, err := os.Stat(directory); !os.IsNotExist(err) {
+		return
+	}
+	if _, err := os.Stat(filepath.Join(legacy, ".git")); err != nil {
+		return
+	}
+	if err := checkRemote(legacy, target.url); err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(directory), 0755); err != nil {
+		logger.Warnf("Unable to move clone %s to %s, it will be cloned again: %v", legacy, directory, err)
+		return
+	}
+	if err := os.Rename(legacy, directory); err != nil {
+		logger.Warnf("Unable to move clone %s to %s, it will be cloned again: %v", legacy, directory, err)
+		return
+	}
+	logger.Infof("Moved clone of %s from %s to %s", target.url, legacy, directory)
+}
+
+// checkRemote ensures an existing clone was made from url, so that a directory
+// holding another repository is never mistaken for the target's clone
+func checkRemote(directory, url string) error {
+	repo, err := git.PlainOpen(directory)
+	if err != nil {
+		return utils.WrapErr(err, "Error opening repository %s", directory)
+	}
+	remote, err := repo.Remote(git.DefaultRemoteName)
+	if err != nil {
+		return utils.WrapErr(err, "Error getting remote of repository %s", directory)
+	}
+	for _, u := range remote.Config().URLs {
+		if u == url {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is a clone of %v, not %s", directory, remote.Config().URLs, url)
+}
@@ -0,0 +1,477 @@
+package engine
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-co-op/gocron"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestCheckRemote(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	if _, err := repo.CreateRemote(&config.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{"https://github.com/a/deploy"},
+	}); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+
+	if err := checkRemote(dir, "https://github.com/a/deploy"); err != nil {
+		t.Fatalf("Failed: unexpected error: %v", err)
+	}
+	if err := checkRemote(dir, "https://gitlab.com/b/deploy"); err == nil {
+		t.Fatalf("Failed: expected error for clone of a different repository")
+	}
+}
+
+func TestMigrateClone(t *testing.T) {
+	defer func(l *zap.SugaredLogger) { logger = l }(logger)
+	logger = zap.NewNop().Sugar()
+	defer func(dir string) { cacheDir = dir }(cacheDir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	cacheDir = "cache"
+
+	target := &Target{url: "https://github.com/a/deploy.git"}
+	other := &Target{url: "https://gitlab.com/b/deploy"}
+	repo, err := git.PlainInit(legacyDirectory(target), false)
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	if _, err := repo.CreateRemote(&config.RemoteConfig{Name: git.DefaultRemoteName, URLs: []string{target.url}}); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+
+	// a clone of another repository with the same basename is not taken
+	migrateClone(other, getDirectory(other))
+	if _, err := os.Stat(getDirectory(other)); !os.IsNotExist(err) {
+		t.Fatalf("Failed: clone of %s moved for %s", target.url, other.url)
+	}
+
+	migrateClone(target, getDirectory(target))
+	if _, err := os.Stat(legacyDirectory(target)); !os.IsNotExist(err) {
+		t.Fatalf("Failed: legacy clone was left behind")
+	}
+	if err := checkRemote(getDirectory(target), target.url); err != nil {
+		t.Fatalf("Failed: clone was not moved: %v", err)
+	}
+}
+
+// slowMethod blocks in Process until its podman connection is cancelled or it
+// finishes its work
+type slowMethod struct {
+	CommonMethod
+	work      time.Duration
+	cancelled bool
+}
+
+func (m *slowMethod) GetKind() string {
+	return "slow"
+}
+
+func (m *slowMethod) Process(ctx, conn context.Context, skew int) {
+	select {
+	case <-conn.Done():
+		m.cancelled = true
+	case <-time.After(m.work):
+	}
+}
+
+func (m *slowMethod) MethodEngine(ctx, conn context.Context, change *object.Change, path string) error {
+	return nil
+}
+
+func (m *slowMethod) Apply(ctx, conn context.Context, currentState, desiredState plumbing.Hash, tags *[]string) error {
+	return nil
+}
+
+func TestProcessWithTimeout(t *testing.T) {
+	defer func(l *zap.SugaredLogger) { logger = l }(logger)
+	core, logs := observer.New(zapcore.ErrorLevel)
+	logger = zap.New(core).Sugar()
+
+	m := &slowMethod{CommonMethod: CommonMethod{Name: "slow-ex"}, work: 10 * time.Second}
+	start := time.Now()
+	processWithTimeout(m, context.Background(), context.Background(), 0, 50*time.Millisecond)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Failed: process was not cancelled, took %s", elapsed)
+	}
+	if !m.cancelled {
+		t.Fatalf("Failed: podman connection was not cancelled")
+	}
+	if logs.Len() != 1 || !strings.Contains(logs.All()[0].Message, "slow slow-ex timed out after 50ms") {
+		t.Fatalf("Failed: expected a timeout error, got %v", logs.All())
+	}
+
+	m = &slowMethod{CommonMethod: CommonMethod{Name: "fast-ex"}, work: time.Millisecond}
+	processWithTimeout(m, context.Background(), context.Background(), 0, time.Second)
+	if m.cancelled || logs.Len() != 1 {
+		t.Fatalf("Failed: method within its timeout should not be cancelled")
+	}
+}
+
+// busyMethod records how many methods are processing at the same time
+type busyMethod struct {
+	CommonMethod
+	running *int32
+	peak    *int32
+}
+
+func (m *busyMethod) GetKind() string {
+	return "busy"
+}
+
+func (m *busyMethod) Process(ctx, conn context.Context, skew int) {
+	n := atomic.AddInt32(m.running, 1)
+	for {
+		peak := atomic.LoadInt32(m.peak)
+		if n <= peak || atomic.CompareAndSwapInt32(m.peak, peak, n) {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	atomic.AddInt32(m.running, -1)
+}
+
+func (m *busyMethod) MethodEngine(ctx, conn context.Context, change *object.Change, path string) error {
+	return nil
+}
+
+func (m *busyMethod) Apply(ctx, conn context.Context, currentState, desiredState plumbing.Hash, tags *[]string) error {
+	return nil
+}
+
+func TestRunMethodConcurrency(t *testing.T) {
+	defer func(s chan struct{}) { slots = s }(slots)
+
+	for _, limit := range []int{1, 3} {
+		slots = make(chan struct{}, limit)
+		var running, peak int32
+		var wg sync.WaitGroup
+		for i := 0; i < 12; i++ {
+			wg.Add(1)
+			m := &busyMethod{running: &running, peak: &peak}
+			go func() {
+				defer wg.Done()
+				runMethod(m, generation, context.Background(), context.Background(), 0, 0)
+			}()
+		}
+		wg.Wait()
+		if peak > int32(limit) {
+			t.Fatalf("Failed: %d methods ran at once with maxConcurrency %d", peak, limit)
+		}
+	}
+}
+
+// blockingMethod processes until release is closed
+type blockingMethod struct {
+	busyMethod
+	started chan struct{}
+	release chan struct{}
+	runs    *int32
+}
+
+func (m *blockingMethod) Process(ctx, conn context.Context, skew int) {
+	atomic.AddInt32(m.runs, 1)
+	close(m.started)
+	<-m.release
+}
+
+func TestRestartWaitsForApply(t *testing.T) {
+	defer func(l *zap.SugaredLogger) { logger = l }(logger)
+	logger = zap.NewNop().Sugar()
+
+	f := newFetchit()
+	f.scheduler = gocron.NewScheduler(time.UTC)
+	f.allMethodTypes["busy"] = struct{}{}
+	var processed int32
+	m := &blockingMethod{started: make(chan struct{}), release: make(chan struct{}), runs: &processed}
+	if _, err := f.scheduler.Every(1).Hour().Tag("busy").Do(func() {}); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+
+	gen := generation
+	go runMethod(m, gen, context.Background(), context.Background(), 0, 0)
+	<-m.started
+
+	next := newFetchit()
+	next.scheduler = gocron.NewScheduler(time.UTC)
+	defer next.scheduler.Stop()
+	drained := make(chan struct{})
+	go func() {
+		replaceConfig(f, func() *Fetchit { return next })
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		t.Fatalf("Failed: restart did not wait for the in-flight apply")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(m.release)
+	<-drained
+
+	if !next.scheduler.IsRunning() {
+		t.Fatalf("Failed: the new config was not started")
+	}
+	if len(f.scheduler.Jobs()) != 0 {
+		t.Fatalf("Failed: %d jobs left after restart", len(f.scheduler.Jobs()))
+	}
+	// a run scheduled by the old config is dropped
+	stale := &blockingMethod{started: make(chan struct{}), release: make(chan struct{}), runs: &processed}
+	runMethod(stale, gen, context.Background(), context.Background(), 0, 0)
+	if processed != 1 {
+		t.Fatalf("Failed: run of the old config processed after restart")
+	}
+}
+
+// countMethod counts its runs
+type countMethod struct {
+	busyMethod
+	runs int32
+	ran  chan struct{}
+}
+
+func (m *countMethod) Process(ctx, conn context.Context, skew int) {
+	atomic.AddInt32(&m.runs, 1)
+	select {
+	case m.ran <- struct{}{}:
+	default:
+	}
+}
+
+func TestScheduleRunOnce(t *testing.T) {
+	defer func(l *zap.SugaredLogger) { logger = l }(logger)
+	logger = zap.NewNop().Sugar()
+
+	f := newFetchit()
+	f.scheduler = gocron.NewScheduler(time.UTC)
+	f.conn = context.Background()
+	m := &countMethod{ran: make(chan struct{}, 1)}
+	m.Name = "seed"
+	m.RunOnce = true
+	m.target = &Target{url: "https://github.com/a/seed"}
+	f.schedule(m, m.SchedInfo())
+	f.scheduler.StartAsync()
+	defer f.scheduler.Stop()
+
+	select {
+	case <-m.ran:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Failed: run once method did not run")
+	}
+	// the job is removed once it has run
+	deadline := time.Now().Add(5 * time.Second)
+	for f.scheduler.Len() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Failed: job of run once method was not removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	f.scheduler.RunAll()
+	time.Sleep(50 * time.Millisecond)
+	if runs := atomic.LoadInt32(&m.runs); runs != 1 {
+		t.Fatalf("Failed: run once method ran %d times", runs)
+	}
+}
+
+// overlapMethod blocks its runs until release is closed, counting its runs
+// and the most that were in progress at once
+type overlapMethod struct {
+	busyMethod
+	started chan struct{}
+	release chan struct{}
+	runs    int32
+}
+
+func (m *overlapMethod) Process(ctx, conn context.Context, skew int) {
+	n := atomic.AddInt32(m.running, 1)
+	for {
+		peak := atomic.LoadInt32(m.peak)
+		if n <= peak || atomic.CompareAndSwapInt32(m.peak, peak, n) {
+			break
+		}
+	}
+	select {
+	case m.started <- struct{}{}:
+	default:
+	}
+	<-m.release
+	atomic.AddInt32(m.running, -1)
+	atomic.AddInt32(&m.runs, 1)
+}
+
+func TestScheduleOverlap(t *testing.T) {
+	defer func(l *zap.SugaredLogger, o string) { logger, overlap = l, o }(logger, overlap)
+	logger = zap.NewNop().Sugar()
+
+	tests := []struct {
+		name     string
+		global   string
+		method   string
+		wantRuns int32
+		wantPeak int32
+	}{
+		{name: "skip by default", global: overlapSkip, wantRuns: 1, wantPeak: 1},
+		{name: "wait", global: overlapWait, wantRuns: 3, wantPeak: 1},
+		{name: "allow", global: overlapAllow, wantRuns: 3, wantPeak: 3},
+		{name: "method overrides config", global: overlapAllow, method: overlapSkip, wantRuns: 1, wantPeak: 1},
+	}
+	for _, tt := range tests {
+		overlap = tt.global
+		f := newFetchit()
+		f.scheduler = gocron.NewScheduler(time.UTC)
+		f.conn = context.Background()
+		var running, peak int32
+		m := &overlapMethod{busyMethod: busyMethod{running: &running, peak: &peak}, started: make(chan struct{}, 1), release: make(chan struct{})}
+		m.Name = "overlap"
+		m.Schedule = "0 0 1 1 *"
+		m.Overlap = tt.method
+		m.target = &Target{url: "https://github.com/a/overlap"}
+		f.schedule(m, m.SchedInfo())
+		f.scheduler.StartAsync()
+
+		select {
+		case <-m.started:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Failed: %s: method did not run", tt.name)
+		}
+		// two more runs are due while the first is in progress
+		f.scheduler.RunAll()
+		f.scheduler.RunAll()
+		time.Sleep(50 * time.Millisecond)
+		close(m.release)
+
+		deadline := time.Now().Add(5 * time.Second)
+		for atomic.LoadInt32(&m.runs) < tt.wantRuns && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		time.Sleep(50 * time.Millisecond)
+		f.scheduler.Stop()
+		if runs := atomic.LoadInt32(&m.runs); runs != tt.wantRuns {
+			t.Fatalf("Failed: %s: method ran %d times, expected %d", tt.name, runs, tt.wantRuns)
+		}
+		if p := atomic.LoadInt32(&peak); p != tt.wantPeak {
+			t.Fatalf("Failed: %s: %d runs were in progress at once, expected %d", tt.name, p, tt.wantPeak)
+		}
+	}
+}
+
+func TestReadConfigFragments(t *testing.T) {
+	defer func(l *zap.SugaredLogger, p, d string) { logger, defaultConfigPath, defaultConfigDir = l, p, d }(logger, defaultConfigPath, defaultConfigDir)
+	logger = zap.NewNop().Sugar()
+	dir := t.TempDir()
+	defaultConfigPath = filepath.Join(dir, "config.yaml")
+	defaultConfigDir = filepath.Join(dir, "config.d")
+	if err := os.Mkdir(defaultConfigDir, 0700); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	files := map[string]string{
+		defaultConfigPath: `
+maxConcurrency: 2
+targetConfigs:
+- name: base
+  url: https://github.com/a/base
+`,
+		filepath.Join(defaultConfigDir, "10-web.yaml"): `
+targetConfigs:
+- name: web
+  url: https://github.com/a/web
+  raw:
+  - name: web
+    targetPath: raw
+`,
+		filepath.Join(defaultConfigDir, "20-db.yaml"): `
+maxConcurrency: 4
+targetConfigs:
+- name: db
+  url: https://github.com/a/db
+`,
+		filepath.Join(defaultConfigDir, "notes.txt"): "not a config",
+	}
+	for path, contents := range files {
+		if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatalf("Failed: %v", err)
+		}
+	}
+
+	config, _, err := readConfig(viper.New())
+	if err != nil {
+		t.Fatalf("Failed: unexpected error: %v", err)
+	}
+	var names []string
+	for _, tc := range config.TargetConfigs {
+		names = append(names, tc.Name)
+	}
+	if strings.Join(names, ",") != "base,web,db" {
+		t.Fatalf("Failed: targets %v, expected base,web,db", names)
+	}
+	if len(config.TargetConfigs[1].Raw) != 1 || config.TargetConfigs[1].Raw[0].TargetPath != "raw" {
+		t.Fatalf("Failed: raw method of fragment not read: %+v", config.TargetConfigs[1].Raw)
+	}
+	if config.MaxConcurrency != 4 {
+		t.Fatalf("Failed: maxConcurrency %d, expected the later fragment's 4", config.MaxConcurrency)
+	}
+
+	// fragments alone are a local config
+	if err := os.Remove(defaultConfigPath); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	config, isLocal, err := isLocalConfig(viper.New())
+	if err != nil || !isLocal || len(config.TargetConfigs) != 2 {
+		t.Fatalf("Failed: config from fragments only: %v %t %+v", err, isLocal, config)
+	}
+}
+
+func TestGetMethodTargetSchedsDisabled(t *testing.T) {
+	defer func(l *zap.SugaredLogger) { logger = l }(logger)
+	logger = zap.NewNop().Sugar()
+
+	off, on := false, true
+	tc := &TargetConfig{
+		Name:   "apps",
+		Url:    "https://github.com/a/apps",
+		Branch: "main",
+		Raw: []*Raw{
+			{CommonMethod: CommonMethod{Name: "web", Schedule: "*/5 * * * *"}},
+			{CommonMethod: CommonMethod{Name: "db", Schedule: "*/5 * * * *", Enabled: &off}},
+			{CommonMethod: CommonMethod{Name: "cache", Schedule: "*/5 * * * *", Enabled: &on}},
+		},
+		Kube: []*Kube{{CommonMethod: CommonMethod{Name: "pods", Schedule: "*/5 * * * *", Enabled: &off}}},
+	}
+	f := getMethodTargetScheds([]*TargetConfig{tc}, newFetchit())
+
+	var names []string
+	for method := range f.methodTargetScheds {
+		names = append(names, method.GetKind()+"/"+method.GetName())
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "raw/cache,raw/web" {
+		t.Fatalf("Failed: scheduled %v, expected raw/cache,raw/web", names)
+	}
+}
@@ -0,0 +1,122 @@
+package engine
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containers/fetchit/pkg/engine/utils"
+	"github.com/containers/podman/v4/pkg/bindings/containers"
+)
+
+const (
+	// rsyncBackend transfers files with rsync in a privileged helper container
+	rsyncBackend = "rsync"
+	// copyBackend copies files into a running container with podman cp
+	copyBackend = "copy"
+)
+
+// copyToContainer extracts a tar archive into dest in a container, replaced in tests
+var copyToContainer = func(conn context.Context, container, dest string, archive io.Reader) error {
+	copyFunc, err := containers.CopyFromArchive(conn, container, dest, archive)
+	if err != nil {
+		return err
+	}
+	return copyFunc()
+}
+
+// transferBackend returns the backend files are transferred with, rsync if unset.
+// The copy backend only places single files, it can't mirror or take rsync flags.
+func (ft *FileTransfer) transferBackend() (string, error) {
+	switch ft.Backend {
+	case "", rsyncBackend:
+		return rsyncBackend, nil
+	case copyBackend:
+		if ft.Container == "" {
+			return "", &utils.ValidationError{Err: fmt.Errorf("filetransfer %s must set a container for the copy backend", ft.GetName())}
+		}
+		if ft.Mirror || len(ft.RsyncFlags) > 0 {
+			return "", &utils.ValidationError{Err: fmt.Errorf("filetransfer %s can only set mirror or rsyncFlags with the rsync backend", ft.GetName())}
+		}
+		return copyBackend, nil
+	}
+	return "", &utils.ValidationError{Err: fmt.Errorf("filetransfer %s has an unknown backend %q", ft.GetName(), ft.Backend)}
+}
+
+// copyPodman copies a file of the target path into dest in the container of
+// the filetransfer, keeping the directories below the target path. Files are
+// only placed, a deleted or renamed file is left in the container.
+func (ft *FileTransfer) copyPodman(conn context.Context, path, dest string, prev *string) error {
+	log := methodLogger(ft)
+	if prev != nil && (path == deleteFile || filepath.Join(getDirectory(ft.GetTarget()), *prev) != path) {
+		log.Warnf("Not removing %s from container %s, the copy backend only places files", *prev, ft.Container)
+	}
+	if path == deleteFile {
+		return nil
+	}
+
+	log.Infof("Copying file %s to %s in container %s", path, dest, ft.Container)
+	_, rel, err := ft.transferSource(path)
+	if err != nil {
+		return err
+	}
+	archive, err := fileArchive(path, rel)
+	if err != nil {
+		return err
+	}
+	if err := copyToContainer(conn, ft.Container, dest, archive); err != nil {
+		return utils.WrapErr(err, "Error copying %s into container %s", rel, ft.Container)
+	}
+	return nil
+}
+
+// fileArchive returns a tar archive holding the file at path as rel, with the
+// directories of rel
+func fileArchive(path, rel string) (*bytes.Buffer, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, utils.WrapErr(err, "Error reading %s", path)
+	}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, utils.WrapErr(err, "Error reading %s", path)
+	}
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	rel = filepath.ToSlash(rel)
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		dir := &tar.Header{
+			Typeflag: tar.TypeDir,
+			Name:     strings.Join(parts[:i], "/") + "/",
+			Mode:     0755,
+			ModTime:  info.ModTime(),
+		}
+		if err := tw.WriteHeader(dir); err != nil {
+			return nil, utils.WrapErr(err, "Error archiving %s", path)
+		}
+	}
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     rel,
+		Mode:     int64(info.Mode().Perm()),
+		Size:     int64(len(contents)),
+		ModTime:  info.ModTime(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return nil, utils.WrapErr(err, "Error archiving %s", path)
+	}
+	if _, err := tw.Write(contents); err != nil {
+		return nil, utils.WrapErr(err, "Error archiving %s", path)
+	}
+	if err := tw.Close(); err != nil {
+		return nil, utils.WrapErr(err, "Error archiving %s", path)
+	}
+	return buf, nil
+}
@@ -2,54 +2,116 @@ package engine
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/containers/fetchit/pkg/engine/utils"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
 )
 
-const filetransferMethod = "filetransfer"
+const (
+	filetransferMethod = "filetransfer"
+	// managedDir holds the manifests of the host files placed by each filetransfer
+	managedDir = "managed"
+)
 
 // FileTransfer to place files on host system
 type FileTransfer struct {
 	CommonMethod `mapstructure:",squash"`
 	// Directory path on the host system in which the target files should be placed
 	DestinationDirectory string `mapstructure:"destinationDirectory"`
+	// DestinationDirectories are further host directories the target files are placed in
+	DestinationDirectories []string `mapstructure:"destinationDirectories"`
+	// RsyncFlags are extra rsync options, such as --exclude=*.bak or --chmod=F644
+	RsyncFlags []string `mapstructure:"rsyncFlags"`
+	// Mirror syncs the whole target path, deleting files in the destination that are not in git
+	Mirror bool `mapstructure:"mirror"`
+	// ReloadSystemd runs systemctl daemon-reload after files are transferred, for unit files and drop-ins
+	ReloadSystemd bool `mapstructure:"reloadSystemd"`
+	// RestartServices are the systemd services restarted after files are transferred
+	RestartServices []string `mapstructure:"restartServices"`
+	// Backend is how files are transferred, rsync in a privileged helper container
+	// by default, or copy to copy them into the running Container with podman cp
+	Backend string `mapstructure:"backend"`
+	// Container is the container files are copied into by the copy backend,
+	// the destination directories are paths in the container
+	Container string `mapstructure:"container"`
+}
+
+// runSystemctl runs a systemctl action with the systemd helper, replaced in tests
+var runSystemctl = func(conn context.Context, sd *Systemd, action, dest, service string) error {
+	return sd.enableRestartSystemdService(conn, action, dest, service)
+}
+
+// rsyncDeniedFlags are rsync options that could run commands or touch paths
+// outside of the destination directory
+var rsyncDeniedFlags = map[string]struct{}{
+	"--rsh":                 {},
+	"--rsync-path":          {},
+	"--remote-option":       {},
+	"--remove-source-files": {},
+	"--files-from":          {},
+	"--include-from":        {},
+	"--exclude-from":        {},
+	"--write-batch":         {},
+	"--only-write-batch":    {},
+	"--read-batch":          {},
+	"--log-file":            {},
+	"--temp-dir":            {},
+	"--backup-dir":          {},
+	"--partial-dir":         {},
+	"--compare-dest":        {},
+	"--copy-dest":           {},
+	"--link-dest":           {},
+	"--password-file":       {},
+	"--daemon":              {},
+	"--config":              {},
 }
 
+// rsyncDeniedShortFlags are the short forms of denied rsync options
+const rsyncDeniedShortFlags = "eMT"
+
 func (ft *FileTransfer) GetKind() string {
 	return filetransferMethod
 }
 
 func (ft *FileTransfer) Process(ctx, conn context.Context, skew int) {
+	log := methodLogger(ft)
 	target := ft.GetTarget()
 	time.Sleep(time.Duration(skew) * time.Millisecond)
 	target.mu.Lock()
 	defer target.mu.Unlock()
 
+	tags := ft.getTags(filetransferMethod)
 	if ft.initialRun {
 		err := getRepo(target)
 		if err != nil {
 			if len(target.url) > 0 {
-				logger.Errorf("Failed to clone repository at %s: %v", target.url, err)
+				log.Errorf("Failed to clone repository at %s: %v", target.url, err)
 				return
 			} else if len(target.localPath) > 0 {
-				logger.Errorf("Failed to clone repository %s: %v", target.localPath, err)
+				log.Errorf("Failed to clone repository %s: %v", target.localPath, err)
 				return
 			}
 		}
 
-		err = zeroToCurrent(ctx, conn, ft, target, nil)
+		err = zeroToCurrent(ctx, conn, ft, target, tags)
 		if err != nil {
-			logger.Errorf("Error moving to current: %v target url is: %s ", err, target.url)
+			log.Errorf("Error moving to current: %v target url is: %s ", err, target.url)
 			return
 		}
 	}
 
-	err := currentToLatest(ctx, conn, ft, target, nil)
+	err := currentToLatest(ctx, conn, ft, target, tags)
 	if err != nil {
-		logger.Errorf("Error moving current to latest: %v", err)
+		log.Errorf("Error moving current to latest: %v", err)
 		return
 	}
 
@@ -57,37 +119,89 @@ func (ft *FileTransfer) Process(ctx, conn context.Context, skew int) {
 }
 
 func (ft *FileTransfer) MethodEngine(ctx, conn context.Context, change *object.Change, path string) error {
+	// The file placed for the previous version of the change is replaced
 	var prev *string = nil
 	if change != nil {
-		if change.To.Name != "" {
-			prev = &change.To.Name
+		if change.From.Name != "" {
+			prev = &change.From.Name
 		}
 	}
-	dest := ft.DestinationDirectory
-	return ft.fileTransferPodman(ctx, conn, path, dest, prev)
+	return ft.forEachDestination(func(dest string) error {
+		return ft.fileTransferPodman(ctx, conn, path, dest, prev)
+	})
 }
 
 func (ft *FileTransfer) Apply(ctx, conn context.Context, currentState, desiredState plumbing.Hash, tags *[]string) error {
-	changeMap, err := applyChanges(ctx, ft.GetTarget(), ft.GetTargetPath(), ft.Glob, currentState, desiredState, tags)
+	changeMap, err := ft.targetChanges(ctx, currentState, desiredState, tags)
 	if err != nil {
 		return err
 	}
+	if len(changeMap) == 0 {
+		return nil
+	}
+	if _, err := ft.transferBackend(); err != nil {
+		return err
+	}
+	if ft.Mirror {
+		// a mirror deletes what is not in its target path, so it can only sync one
+		if len(ft.GetTargetPaths()) > 1 {
+			return &utils.ValidationError{Err: fmt.Errorf("filetransfer %s can only mirror a single target path", ft.GetName())}
+		}
+		if err := ft.forEachDestination(func(dest string) error {
+			return ft.mirrorPodman(ctx, conn, dest)
+		}); err != nil {
+			return err
+		}
+		return ft.reloadSystemd(conn)
+	}
 	if err := runChanges(ctx, conn, ft, changeMap); err != nil {
 		return err
 	}
+	return ft.reloadSystemd(conn)
+}
+
+// reloadSystemd reloads systemd and restarts the configured services once
+// files are transferred, so that placed unit files and drop-ins take effect
+func (ft *FileTransfer) reloadSystemd(conn context.Context) error {
+	if !ft.ReloadSystemd && len(ft.RestartServices) == 0 {
+		return nil
+	}
+	// unit files follow whether fetchit detected a rootful or rootless podman
+	sd := &Systemd{CommonMethod: CommonMethod{Name: ft.Name, target: ft.target}}
+	dest := ft.destinations()[0]
+	if ft.ReloadSystemd {
+		if err := runSystemctl(conn, sd, "daemon-reload", dest, ""); err != nil {
+			return utils.WrapErr(err, "Error reloading systemd for filetransfer %s", ft.Name)
+		}
+	}
+	for _, service := range ft.RestartServices {
+		if err := runSystemctl(conn, sd, "restart", dest, service); err != nil {
+			return utils.WrapErr(err, "Error restarting %s for filetransfer %s", service, ft.Name)
+		}
+	}
 	return nil
 }
 
 func (ft *FileTransfer) fileTransferPodman(ctx, conn context.Context, path, dest string, prev *string) error {
+	log := methodLogger(ft)
+	backend, err := ft.transferBackend()
+	if err != nil {
+		return err
+	}
+	if backend == copyBackend {
+		return ft.copyPodman(conn, path, dest, prev)
+	}
+	manifest := ft.manifestPath()
 	if prev != nil {
-		pathToRemove := filepath.Join(dest, filepath.Base(*prev))
-		s := generateSpecRemove(filetransferMethod, filepath.Base(pathToRemove), pathToRemove, dest, ft.Name)
-		createResponse, err := createAndStartContainer(conn, s)
-		if err != nil {
-			return err
-		}
-
-		err = waitAndRemoveContainer(conn, createResponse.ID)
+		pathToRemove := filepath.Join(dest, *prev)
+		err := removeManaged(manifest, pathToRemove, func(pathToRemove string) error {
+			s := generateSpecRemove(filetransferMethod, targetName(ft.GetTarget()), filepath.Base(pathToRemove), pathToRemove, dest, ft.containerName())
+			createResponse, err := createAndStartContainer(conn, s)
+			if err != nil {
+				return err
+			}
+			return waitAndRemoveContainer(conn, createResponse.ID)
+		})
 		if err != nil {
 			return err
 		}
@@ -97,19 +211,213 @@ func (ft *FileTransfer) fileTransferPodman(ctx, conn context.Context, path, dest
 		return nil
 	}
 
-	logger.Infof("Deploying file(s) %s", path)
+	log.Infof("Deploying file(s) %s", path)
 
 	file := filepath.Base(path)
 
-	source := filepath.Join("/opt", path)
-	copyFile := (source + " " + dest)
+	source, rel, err := ft.transferSource(path)
+	if err != nil {
+		return err
+	}
+	args, err := ft.rsyncArgs(source, dest)
+	if err != nil {
+		return err
+	}
+	if rel != file {
+		// Keep the directories of nested files below the target path
+		args = append([]string{"--relative"}, args...)
+	}
 
-	s := generateSpec(filetransferMethod, file, copyFile, dest, ft.Name)
+	s := generateRsyncSpec(filetransferMethod, targetName(ft.GetTarget()), file, args, dest, ft.containerName())
 	createResponse, err := createAndStartContainer(conn, s)
 	if err != nil {
 		return err
 	}
 
 	// Wait for the container to exit
+	if err := waitAndRemoveContainer(conn, createResponse.ID); err != nil {
+		return err
+	}
+	return recordManaged(manifest, filepath.Join(dest, rel))
+}
+
+// transferSource returns the rsync source of a file in the target path and the
+// path of the file relative to the target path. The source marks the target
+// path with /./ so that rsync --relative only recreates the directories below it.
+func (ft *FileTransfer) transferSource(path string) (string, string, error) {
+	var base, rel string
+	// the deepest target path holding the file is the one it was changed in
+	for _, targetPath := range ft.GetTargetPaths() {
+		b := filepath.Join(getDirectory(ft.GetTarget()), targetPath)
+		r, err := filepath.Rel(b, path)
+		if err != nil || strings.HasPrefix(r, "..") || len(b) <= len(base) {
+			continue
+		}
+		base, rel = b, r
+	}
+	if base == "" {
+		return "", "", fmt.Errorf("file %s is not within the target paths %v of filetransfer %s", path, ft.GetTargetPaths(), ft.GetName())
+	}
+	return filepath.Join("/opt", base) + "/./" + rel, rel, nil
+}
+
+// manifestPath returns the manifest of the host files placed by the filetransfer
+func (ft *FileTransfer) manifestPath() string {
+	return filepath.Join(cacheDir, managedDir, ft.containerName()+".json")
+}
+
+// loadManaged reads a manifest of managed files, a missing manifest is empty
+func loadManaged(manifest string) (map[string]struct{}, error) {
+	files := map[string]struct{}{}
+	b, err := ioutil.ReadFile(manifest)
+	if os.IsNotExist(err) {
+		return files, nil
+	}
+	if err != nil {
+		return nil, utils.WrapErr(err, "Error reading manifest %s", manifest)
+	}
+	list := []string{}
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, utils.WrapErr(err, "Error parsing manifest %s", manifest)
+	}
+	for _, f := range list {
+		files[f] = struct{}{}
+	}
+	return files, nil
+}
+
+func saveManaged(manifest string, files map[string]struct{}) error {
+	list := make([]string, 0, len(files))
+	for f := range files {
+		list = append(list, f)
+	}
+	sort.Strings(list)
+	b, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(manifest), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(manifest, b, 0644); err != nil {
+		return utils.WrapErr(err, "Error writing manifest %s", manifest)
+	}
+	return nil
+}
+
+// recordManaged adds a host file placed by fetchit to a manifest
+func recordManaged(manifest, path string) error {
+	files, err := loadManaged(manifest)
+	if err != nil {
+		return err
+	}
+	if _, ok := files[path]; ok {
+		return nil
+	}
+	files[path] = struct{}{}
+	return saveManaged(manifest, files)
+}
+
+// removeManaged removes a host file with remove only if the manifest records
+// that fetchit placed it, so files that were already on the host are kept
+func removeManaged(manifest, path string, remove func(path string) error) error {
+	files, err := loadManaged(manifest)
+	if err != nil {
+		return err
+	}
+	if _, ok := files[path]; !ok {
+		logger.Warnf("Not removing %s, it was not placed by fetchit", path)
+		return nil
+	}
+	if err := remove(path); err != nil {
+		return err
+	}
+	delete(files, path)
+	return saveManaged(manifest, files)
+}
+
+// destinations returns the destination directories of the transfer, skipping duplicates
+func (ft *FileTransfer) destinations() []string {
+	dests := []string{}
+	seen := map[string]struct{}{}
+	for _, dest := range append([]string{ft.DestinationDirectory}, ft.DestinationDirectories...) {
+		if dest == "" {
+			continue
+		}
+		if _, ok := seen[dest]; ok {
+			continue
+		}
+		seen[dest] = struct{}{}
+		dests = append(dests, dest)
+	}
+	return dests
+}
+
+// forEachDestination runs fn for each destination directory, stopping at the first error
+func (ft *FileTransfer) forEachDestination(fn func(dest string) error) error {
+	dests := ft.destinations()
+	if len(dests) == 0 {
+		return &utils.ValidationError{Err: fmt.Errorf("filetransfer %s has no destination directory", ft.GetName())}
+	}
+	for _, dest := range dests {
+		if err := fn(dest); err != nil {
+			return utils.WrapErr(err, "Error transferring files to %s", dest)
+		}
+	}
+	return nil
+}
+
+// mirrorPodman syncs the whole target path to the destination directory,
+// removing files that are no longer in the target path
+func (ft *FileTransfer) mirrorPodman(ctx, conn context.Context, dest string) error {
+	log := methodLogger(ft)
+	log.Infof("Mirroring %s to %s", ft.GetTargetPath(), dest)
+
+	// The trailing slash syncs the contents of the target path
+	source := filepath.Join("/opt", getDirectory(ft.GetTarget()), ft.GetTargetPath()) + "/"
+	args, err := ft.rsyncArgs(source, dest)
+	if err != nil {
+		return err
+	}
+
+	s := generateRsyncSpec(filetransferMethod, targetName(ft.GetTarget()), "mirror", args, dest, ft.containerName())
+	createResponse, err := createAndStartContainer(conn, s)
+	if err != nil {
+		return err
+	}
 	return waitAndRemoveContainer(conn, createResponse.ID)
 }
+
+// rsyncArgs returns the rsync arguments to copy source to dest
+func (ft *FileTransfer) rsyncArgs(source, dest string) ([]string, error) {
+	if err := validateRsyncFlags(ft.RsyncFlags); err != nil {
+		return nil, &utils.ValidationError{Err: err}
+	}
+	args := []string{"-avz"}
+	if ft.Mirror {
+		args = append(args, "--delete")
+	}
+	args = append(args, ft.RsyncFlags...)
+	return append(args, source, dest), nil
+}
+
+// validateRsyncFlags rejects arguments that are not options, which rsync would
+// treat as extra sources, and options that are denied
+func validateRsyncFlags(flags []string) error {
+	for _, flag := range flags {
+		if !strings.HasPrefix(flag, "-") || flag == "-" || flag == "--" {
+			return fmt.Errorf("rsync flag %q must be an option", flag)
+		}
+		if strings.HasPrefix(flag, "--") {
+			name := strings.SplitN(flag, "=", 2)[0]
+			if _, denied := rsyncDeniedFlags[name]; denied {
+				return fmt.Errorf("rsync flag %s is not allowed", name)
+			}
+			continue
+		}
+		if strings.ContainsAny(flag[1:], rsyncDeniedShortFlags) {
+			return fmt.Errorf("rsync flag %s is not allowed", flag)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+package engine
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/containers/fetchit/pkg/engine/utils"
+	"go.uber.org/zap"
+)
+
+func TestRsyncArgs(t *testing.T) {
+	ft := &FileTransfer{RsyncFlags: []string{"--exclude=*.bak", "--chmod=F644"}, Mirror: true}
+	args, err := ft.rsyncArgs("/opt/repo/etc/", "/etc/app")
+	if err != nil {
+		t.Fatalf("Failed: unexpected error: %v", err)
+	}
+	expected := []string{"-avz", "--delete", "--exclude=*.bak", "--chmod=F644", "/opt/repo/etc/", "/etc/app"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Fatalf("Failed: args: %v != %v", args, expected)
+	}
+
+	s := generateRsyncSpec(filetransferMethod, "", "mirror", args, "/etc/app", ft.containerName())
+	if !reflect.DeepEqual(s.Command, append([]string{"rsync"}, expected...)) {
+		t.Fatalf("Failed: command: %v", s.Command)
+	}
+
+	ft = &FileTransfer{}
+	args, err = ft.rsyncArgs("/opt/repo/etc/app.conf", "/etc/app")
+	if err != nil {
+		t.Fatalf("Failed: unexpected error: %v", err)
+	}
+	expected = []string{"-avz", "/opt/repo/etc/app.conf", "/etc/app"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Fatalf("Failed: args: %v != %v", args, expected)
+	}
+}
+
+func TestValidateRsyncFlags(t *testing.T) {
+	allowed := [][]string{
+		nil,
+		{"--exclude=*.bak"},
+		{"--delete-excluded", "-c", "--chmod=D755,F644"},
+	}
+	for _, flags := range allowed {
+		if err := validateRsyncFlags(flags); err != nil {
+			t.Fatalf("Failed: flags %v rejected: %v", flags, err)
+		}
+	}
+
+	denied := [][]string{
+		{"--rsh=sh -c 'touch /tmp/x'"},
+		{"--rsync-path", "evil"},
+		{"-e", "sh"},
+		{"-ave"},
+		{"--remove-source-files"},
+		{"--log-file=/etc/passwd"},
+		{"/etc/shadow"},
+		{"--"},
+	}
+	for _, flags := range denied {
+		ft := &FileTransfer{RsyncFlags: flags}
+		_, err := ft.rsyncArgs("/opt/repo/app.conf", "/etc/app")
+		if _, ok := err.(*utils.ValidationError); !ok {
+			t.Fatalf("Failed: flags %v were not rejected: %v", flags, err)
+		}
+	}
+}
+
+func TestForEachDestination(t *testing.T) {
+	ft := &FileTransfer{
+		DestinationDirectory:   "/etc/nginx/certs",
+		DestinationDirectories: []string{"/etc/haproxy/certs", "/etc/nginx/certs", "/etc/postfix/certs"},
+	}
+	visited := []string{}
+	err := ft.forEachDestination(func(dest string) error {
+		visited = append(visited, dest)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed: unexpected error: %v", err)
+	}
+	expected := []string{"/etc/nginx/certs", "/etc/haproxy/certs", "/etc/postfix/certs"}
+	if !reflect.DeepEqual(visited, expected) {
+		t.Fatalf("Failed: destinations: %v != %v", visited, expected)
+	}
+
+	ft = &FileTransfer{CommonMethod: CommonMethod{Name: "ft-ex"}}
+	err = ft.forEachDestination(func(dest string) error { return nil })
+	if _, ok := err.(*utils.ValidationError); !ok {
+		t.Fatalf("Failed: expected a validation error without destinations, got %v", err)
+	}
+}
+
+func TestRemoveManaged(t *testing.T) {
+	defer func(l *zap.SugaredLogger) { logger = l }(logger)
+	logger = zap.NewNop().Sugar()
+
+	manifest := filepath.Join(t.TempDir(), managedDir, "filetransfer-ft-ex.json")
+	removed := []string{}
+	remove := func(path string) error {
+		removed = append(removed, path)
+		return nil
+	}
+
+	// A file already on the host is not removed
+	if err := removeManaged(manifest, "/etc/app/app.conf", remove); err != nil {
+		t.Fatalf("Failed: unexpected error: %v", err)
+	}
+	if len(removed) != 0 {
+		t.Fatalf("Failed: unmanaged file was removed: %v", removed)
+	}
+
+	if err := recordManaged(manifest, "/etc/app/app.conf"); err != nil {
+		t.Fatalf("Failed: unexpected error: %v", err)
+	}
+	if err := removeManaged(manifest, "/etc/app/app.conf", remove); err != nil {
+		t.Fatalf("Failed: unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(removed, []string{"/etc/app/app.conf"}) {
+		t.Fatalf("Failed: managed file was not removed: %v", removed)
+	}
+
+	files, err := loadManaged(manifest)
+	if err != nil {
+		t.Fatalf("Failed: unexpected error: %v", err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("Failed: removed file is still managed: %v", files)
+	}
+}
+
+func TestTransferSource(t *testing.T) {
+	target := &Target{url: "https://github.com/acme/config.git"}
+	ft := &FileTransfer{CommonMethod: CommonMethod{TargetPath: "envs/prod/", target: target}}
+	base := filepath.Join(getDirectory(target), "envs/prod")
+
+	source, rel, err := ft.transferSource(filepath.Join(base, "nginx/conf.d/app.conf"))
+	if err != nil {
+		t.Fatalf("Failed: unexpected error: %v", err)
+	}
+	if expected := filepath.Join("/opt", base) + "/./nginx/conf.d/app.conf"; source != expected {
+		t.Fatalf("Failed: source: %s != %s", source, expected)
+	}
+	if rel != "nginx/conf.d/app.conf" {
+		t.Fatalf("Failed: relative path: %s != nginx/conf.d/app.conf", rel)
+	}
+
+	if _, _, err := ft.transferSource(filepath.Join(getDirectory(target), "envs/dev/app.conf")); err == nil {
+		t.Fatalf("Failed: expected an error for a file outside of the target path")
+	}
+}
+
+func TestReloadSystemd(t *testing.T) {
+	defer func(run func(context.Context, *Systemd, string, string, string) error) { runSystemctl = run }(runSystemctl)
+	var calls []string
+	runSystemctl = func(conn context.Context, sd *Systemd, action, dest, service string) error {
+		calls = append(calls, strings.TrimSpace(action+" "+dest+" "+service))
+		return nil
+	}
+
+	ft := &FileTransfer{CommonMethod: CommonMethod{Name: "dropins"}, DestinationDirectory: "/etc/systemd/system/nginx.service.d"}
+	if err := ft.reloadSystemd(nil); err != nil || len(calls) != 0 {
+		t.Fatalf("Failed: expected no systemctl calls when not enabled, got %v: %v", calls, err)
+	}
+
+	ft.ReloadSystemd = true
+	ft.RestartServices = []string{"nginx.service", "haproxy.service"}
+	if err := ft.reloadSystemd(nil); err != nil {
+		t.Fatalf("Failed: unexpected error: %v", err)
+	}
+	expected := []string{
+		"daemon-reload /etc/systemd/system/nginx.service.d",
+		"restart /etc/systemd/system/nginx.service.d nginx.service",
+		"restart /etc/systemd/system/nginx.service.d haproxy.service",
+	}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Fatalf("Failed: systemctl calls %v, expected %v", calls, expected)
+	}
+
+	calls = nil
+	runSystemctl = func(conn context.Context, sd *Systemd, action, dest, service string) error {
+		calls = append(calls, action)
+		return errors.New("unit not found")
+	}
+	if err := ft.reloadSystemd(nil); err == nil || len(calls) != 1 {
+		t.Fatalf("Failed: expected the first failure to stop the restarts, got %v: %v", calls, err)
+	}
+}
+
+func TestFileTransferCopyBackend(t *testing.T) {
+	defer func(l *zap.SugaredLogger) { logger = l }(logger)
+	logger = zap.NewNop().Sugar()
+	defer func(dir string) { cacheDir = dir }(cacheDir)
+	cacheDir = t.TempDir()
+	defer func(c func(context.Context, string, string, io.Reader) error) { copyToContainer = c }(copyToContainer)
+	var container, dest string
+	files := map[string]string{}
+	copyToContainer = func(conn context.Context, c, d string, archive io.Reader) error {
+		container, dest = c, d
+		tr := tar.NewReader(archive)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			b, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return err
+			}
+			files[hdr.Name] = string(b)
+		}
+	}
+
+	target := &Target{url: "https://github.com/acme/config.git"}
+	path := filepath.Join(getDirectory(target), "envs/prod/conf.d/app.conf")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	if err := ioutil.WriteFile(path, []byte("listen 8080;\n"), 0644); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	ft := &FileTransfer{
+		CommonMethod:         CommonMethod{Name: "nginx-conf", TargetPath: "envs/prod", target: target},
+		DestinationDirectory: "/etc/nginx",
+		Backend:              copyBackend,
+		Container:            "nginx",
+	}
+	// the rsync backend would need a podman connection to start its helper
+	if err := ft.MethodEngine(context.Background(), context.Background(), nil, path); err != nil {
+		t.Fatalf("Failed: unexpected error: %v", err)
+	}
+	expected := map[string]string{"conf.d/": "", "conf.d/app.conf": "listen 8080;\n"}
+	if container != "nginx" || dest != "/etc/nginx" || !reflect.DeepEqual(files, expected) {
+		t.Fatalf("Failed: copied %v to %s in container %q", files, dest, container)
+	}
+
+	if backend, err := (&FileTransfer{}).transferBackend(); err != nil || backend != rsyncBackend {
+		t.Fatalf("Failed: default backend %s: %v", backend, err)
+	}
+	for _, invalid := range []*FileTransfer{
+		{Backend: copyBackend},
+		{Backend: copyBackend, Container: "nginx", Mirror: true},
+		{Backend: copyBackend, Container: "nginx", RsyncFlags: []string{"--chmod=F644"}},
+		{Backend: "scp"},
+	} {
+		if _, err := invalid.transferBackend(); err == nil {
+			t.Fatalf("Failed: expected a validation error for %+v", invalid)
+		} else if _, ok := err.(*utils.ValidationError); !ok {
+			t.Fatalf("Failed: expected a validation error for %+v, got %v", invalid, err)
+		}
+	}
+}
@@ -1,11 +1,20 @@
 package engine
 
 import (
+	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
+
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
 )
 
-var defaultSSHKey = filepath.Join("/opt", "mount", ".ssh", "id_rsa")
+var (
+	defaultSSHKey = filepath.Join("/opt", "mount", ".ssh", "id_rsa")
+	// podman secrets passed with `--secret name` are mounted in this directory
+	secretsDir = filepath.Join("/run", "secrets")
+)
 
 // Basic type needed for ssh authentication
 type GitAuth struct {
@@ -14,7 +23,46 @@ type GitAuth struct {
 	Username   string `mapstructure:"username"`
 	Password   string `mapstructure:"password"`
 	PAT        string `mapstructure:"pat"`
-	EnvSecret  string `mapstructure:"envSecret"`
+	// PATFile is the path of a file in the fetchit container holding the PAT
+	PATFile string `mapstructure:"patFile"`
+	// PATSecret is the name of a podman secret holding the PAT, the secret
+	// must be passed to the fetchit container with `--secret <name>`
+	PATSecret string `mapstructure:"patSecret"`
+	// EnvSecret is the name of an environment variable holding the PAT,
+	// it is read each time a repository is cloned or fetched
+	EnvSecret string `mapstructure:"envSecret"`
+}
+
+// setGitAuth sets the credentials used to clone and fetch every target
+func (f *Fetchit) setGitAuth(auth *GitAuth) error {
+	if auth == nil {
+		return nil
+	}
+	// Check for SSH usage
+	if auth.SSH {
+		if err := os.Setenv("SSH_KNOWN_HOSTS", "/opt/mount/.ssh/known_hosts"); err != nil {
+			return err
+		}
+		keyPath := defaultSSHKey
+		// Check for unique ssh key file
+		if auth.SSHKeyFile != "" {
+			keyPath = filepath.Join("/opt", "mount", ".ssh", auth.SSHKeyFile)
+		}
+		if err := checkForPrivateKey(keyPath); err != nil {
+			return err
+		}
+		f.ssh = true
+		f.sshKey = keyPath
+	}
+	f.username = auth.Username
+	f.password = auth.Password
+	pat, err := auth.resolvePAT()
+	if err != nil {
+		return err
+	}
+	f.pat = pat
+	f.envSecret = auth.EnvSecret
+	return nil
 }
 
 // Checks to see if private key exists on given path
@@ -24,3 +72,43 @@ func checkForPrivateKey(path string) error {
 	}
 	return nil
 }
+
+// basicAuth returns the http credentials used to clone and fetch the target.
+// If envSecret is set, the PAT is read from the named environment variable
+// each time so that a token injected or rotated by a secret store is used.
+func (t *Target) basicAuth() *githttp.BasicAuth {
+	pat := t.pat
+	if t.envSecret != "" {
+		pat = os.Getenv(t.envSecret)
+	}
+	if pat != "" {
+		return &githttp.BasicAuth{
+			Username: "fetchit", // the value of this field should not matter when using a PAT
+			Password: pat,
+		}
+	}
+	return &githttp.BasicAuth{
+		Username: t.username,
+		Password: t.password,
+	}
+}
+
+// resolvePAT returns the PAT set in the config, or read from PATFile or PATSecret
+// so that the token does not need to be stored in the config file
+func (g *GitAuth) resolvePAT() (string, error) {
+	if g.PAT != "" {
+		return g.PAT, nil
+	}
+	path := g.PATFile
+	if path == "" && g.PATSecret != "" {
+		path = filepath.Join(secretsDir, g.PATSecret)
+	}
+	if path == "" {
+		return "", nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("unable to read PAT from %s: %v", path, err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
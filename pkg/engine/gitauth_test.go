@@ -0,0 +1,66 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolvePAT(t *testing.T) {
+	dir := t.TempDir()
+	patFile := filepath.Join(dir, "pat")
+	if err := os.WriteFile(patFile, []byte("file-token\n"), 0600); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+
+	auth := &GitAuth{PATFile: patFile}
+	pat, err := auth.resolvePAT()
+	if err != nil || pat != "file-token" {
+		t.Fatalf("Failed: pat: %q, err: %v", pat, err)
+	}
+
+	oldSecretsDir := secretsDir
+	secretsDir = dir
+	defer func() { secretsDir = oldSecretsDir }()
+	if err := os.WriteFile(filepath.Join(dir, "gh-pat"), []byte("secret-token"), 0600); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+
+	auth = &GitAuth{PATSecret: "gh-pat"}
+	pat, err = auth.resolvePAT()
+	if err != nil || pat != "secret-token" {
+		t.Fatalf("Failed: pat: %q, err: %v", pat, err)
+	}
+
+	auth = &GitAuth{PAT: "inline-token", PATSecret: "gh-pat"}
+	pat, err = auth.resolvePAT()
+	if err != nil || pat != "inline-token" {
+		t.Fatalf("Failed: pat: %q, err: %v", pat, err)
+	}
+
+	auth = &GitAuth{PATSecret: "missing"}
+	if _, err := auth.resolvePAT(); err == nil {
+		t.Fatalf("Failed: expected error for missing secret")
+	}
+}
+
+func TestBasicAuthEnvSecret(t *testing.T) {
+	t.Setenv("FETCHIT_TEST_PAT", "env-token")
+
+	target := &Target{pat: "config-token", envSecret: "FETCHIT_TEST_PAT"}
+	auth := target.basicAuth()
+	if auth.Username != "fetchit" || auth.Password != "env-token" {
+		t.Fatalf("Failed: auth: %s:%s != fetchit:env-token", auth.Username, auth.Password)
+	}
+
+	t.Setenv("FETCHIT_TEST_PAT", "rotated-token")
+	if auth := target.basicAuth(); auth.Password != "rotated-token" {
+		t.Fatalf("Failed: password: %s != rotated-token", auth.Password)
+	}
+
+	target = &Target{username: "bob", password: "bobpassword"}
+	auth = target.basicAuth()
+	if auth.Username != "bob" || auth.Password != "bobpassword" {
+		t.Fatalf("Failed: auth: %s:%s != bob:bobpassword", auth.Username, auth.Password)
+	}
+}
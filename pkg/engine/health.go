@@ -0,0 +1,105 @@
+package engine
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultStaleAfter is how long fetchit stays ready without a successful sync
+const defaultStaleAfter = time.Hour
+
+// Health serves liveness and readiness probes over http
+type Health struct {
+	// Address is the address the probes listen on, such as :8080
+	Address string `mapstructure:"address"`
+	// StaleAfter is how long after the last successful sync /readyz reports not ready, 1h if unset
+	StaleAfter time.Duration `mapstructure:"staleAfter"`
+}
+
+var (
+	// lastSync is the unix time in nanoseconds a method last reconciled its target, 0 before the first
+	lastSync int64
+	// staleAfter is the readiness window of the running config
+	staleAfter int64 = int64(defaultStaleAfter)
+	// healthOnce starts the probe server once, config reloads don't move it
+	healthOnce sync.Once
+)
+
+// recordSync marks a successful reconcile of a target at t
+func recordSync(t time.Time) {
+	atomic.StoreInt64(&lastSync, t.UnixNano())
+}
+
+// lastSyncTime returns when a target was last reconciled, the zero time if never
+func lastSyncTime() time.Time {
+	ns := atomic.LoadInt64(&lastSync)
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+// schedulerRunning reports whether the scheduler of the running config is started
+func schedulerRunning() bool {
+	// a restart replaces the running config
+	runs.RLock()
+	f := fetchit
+	runs.RUnlock()
+	return f != nil && f.scheduler != nil && f.scheduler.IsRunning()
+}
+
+// healthHandler serves /healthz, ok while the scheduler runs, and /readyz,
+// ok while a target was reconciled within the staleness window or fetchit is
+// paused, so that a probe does not restart fetchit during maintenance
+func healthHandler(running func() bool) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if !running() {
+			http.Error(w, "scheduler is not running", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if isPaused() {
+			fmt.Fprintln(w, "ok, paused")
+			return
+		}
+		last := lastSyncTime()
+		if last.IsZero() {
+			http.Error(w, "no target has synced yet", http.StatusServiceUnavailable)
+			return
+		}
+		window := time.Duration(atomic.LoadInt64(&staleAfter))
+		if age := time.Since(last); age > window {
+			http.Error(w, fmt.Sprintf("last successful sync %s ago, older than %s", age.Round(time.Second), window), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	return mux
+}
+
+// startHealth sets the staleness window and, the first time it is called
+// with an address, serves the probes in the background
+func startHealth(h *Health) {
+	window := defaultStaleAfter
+	if h != nil && h.StaleAfter > 0 {
+		window = h.StaleAfter
+	}
+	atomic.StoreInt64(&staleAfter, int64(window))
+	if h == nil || h.Address == "" {
+		return
+	}
+	healthOnce.Do(func() {
+		go func() {
+			logger.Infof("Serving health probes on %s", h.Address)
+			if err := http.ListenAndServe(h.Address, healthHandler(schedulerRunning)); err != nil {
+				logger.Errorf("Health probes stopped: %v", err)
+			}
+		}()
+	})
+}
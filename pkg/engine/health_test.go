@@ -0,0 +1,51 @@
+package engine
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthHandler(t *testing.T) {
+	defer func(last, window int64) {
+		atomic.StoreInt64(&lastSync, last)
+		atomic.StoreInt64(&staleAfter, window)
+	}(atomic.LoadInt64(&lastSync), atomic.LoadInt64(&staleAfter))
+	atomic.StoreInt64(&lastSync, 0)
+
+	running := true
+	h := healthHandler(func() bool { return running })
+	probe := func(path string, expected int) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != expected {
+			t.Fatalf("Failed: %s returned %d, expected %d: %s", path, rec.Code, expected, rec.Body.String())
+		}
+	}
+
+	startHealth(&Health{StaleAfter: 10 * time.Minute})
+	probe("/healthz", http.StatusOK)
+	// not ready until a target has synced
+	probe("/readyz", http.StatusServiceUnavailable)
+
+	recordSync(time.Now().Add(-time.Minute))
+	probe("/readyz", http.StatusOK)
+
+	recordSync(time.Now().Add(-time.Hour))
+	probe("/readyz", http.StatusServiceUnavailable)
+
+	// a paused fetchit does not sync, but stays ready
+	setConfigPaused(true)
+	probe("/readyz", http.StatusOK)
+	setConfigPaused(false)
+
+	// the default window applies when staleAfter is unset
+	startHealth(nil)
+	recordSync(time.Now().Add(-30 * time.Minute))
+	probe("/readyz", http.StatusOK)
+
+	running = false
+	probe("/healthz", http.StatusServiceUnavailable)
+}
@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"net/http"
+	"os"
+)
+
+// HTTPAuth holds the credentials sent when downloading a config or image over http,
+// such as from behind an authenticating reverse proxy
+type HTTPAuth struct {
+	// Username and Password are sent as basic auth
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	// Token is sent as a bearer token, it is used instead of a username and password
+	Token string `mapstructure:"token"`
+	// TokenEnv is the name of an environment variable holding the bearer token,
+	// it is read each time a file is downloaded
+	TokenEnv string `mapstructure:"tokenEnv"`
+}
+
+// token returns the bearer token, read from TokenEnv when it is set
+func (a *HTTPAuth) token() string {
+	if a.TokenEnv != "" {
+		if token := os.Getenv(a.TokenEnv); token != "" {
+			return token
+		}
+	}
+	return a.Token
+}
+
+// setAuth sets the Authorization header of req, replacing any set before.
+// A nil HTTPAuth leaves req as it is.
+func (a *HTTPAuth) setAuth(req *http.Request) {
+	if a == nil {
+		return
+	}
+	if token := a.token(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if a.Username != "" {
+		req.SetBasicAuth(a.Username, a.Password)
+	}
+}
+
+// httpGet downloads url with the credentials of auth
+func httpGet(url string, auth *HTTPAuth) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	auth.setAuth(req)
+	return http.DefaultClient.Do(req)
+}
+
+// configHTTPAuth returns the credentials used to download the config from its url,
+// set by the configReload or else read from $FETCHIT_CONFIG_USERNAME,
+// $FETCHIT_CONFIG_PASSWORD and $FETCHIT_CONFIG_TOKEN
+func configHTTPAuth(c *ConfigReload) *HTTPAuth {
+	if c != nil && c.HTTPAuth != nil {
+		return c.HTTPAuth
+	}
+	auth := &HTTPAuth{
+		Username: os.Getenv("FETCHIT_CONFIG_USERNAME"),
+		Password: os.Getenv("FETCHIT_CONFIG_PASSWORD"),
+		Token:    os.Getenv("FETCHIT_CONFIG_TOKEN"),
+	}
+	if auth.Username == "" && auth.Token == "" {
+		return nil
+	}
+	return auth
+}
@@ -0,0 +1,89 @@
+package engine
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+func TestHTTPAuth(t *testing.T) {
+	defer func(l *zap.SugaredLogger, p string) { logger, defaultConfigPath = l, p }(logger, defaultConfigPath)
+	logger = zap.NewNop().Sugar()
+
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Authorization")
+		w.Write([]byte("targetConfigs: []\n"))
+	}))
+	defer srv.Close()
+
+	v := viper.New()
+	v.SetConfigType("yaml")
+	err := v.ReadConfig(bytes.NewBufferString(`
+configReload:
+  configURL: ` + srv.URL + `
+  schedule: "*/5 * * * *"
+  httpAuth:
+    username: fetchit
+    password: secret
+images:
+- name: image-ex
+  url: ` + srv.URL + `/image.tar
+  schedule: "*/5 * * * *"
+  httpAuth:
+    tokenEnv: FETCHIT_TEST_TOKEN
+`))
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	config := &FetchitConfig{}
+	if err := v.Unmarshal(config); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+
+	defaultConfigPath = filepath.Join(t.TempDir(), "config.yaml")
+	if _, err := downloadUpdateConfigFile(srv.URL, false, true, "", "", "", configHTTPAuth(config.ConfigReload), ""); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	req := httptest.NewRequest("GET", srv.URL, nil)
+	req.SetBasicAuth("fetchit", "secret")
+	if want := req.Header.Get("Authorization"); got != want {
+		t.Fatalf("Failed: config downloaded with Authorization %q, want %q", got, want)
+	}
+
+	// the configReload credentials are sent instead of the git credentials
+	if _, err := downloadConfig(srv.URL, "pat", "", "", configHTTPAuth(config.ConfigReload), ""); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	if want := req.Header.Get("Authorization"); got != want {
+		t.Fatalf("Failed: config downloaded with Authorization %q, want %q", got, want)
+	}
+
+	defer os.Unsetenv("FETCHIT_TEST_TOKEN")
+	os.Setenv("FETCHIT_TEST_TOKEN", "abc123")
+	image := config.Images[0]
+	resp, err := httpGet(image.Url, image.HTTPAuth)
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	resp.Body.Close()
+	if got != "Bearer abc123" {
+		t.Fatalf("Failed: image downloaded with Authorization %q", got)
+	}
+
+	// without credentials no Authorization header is sent
+	resp, err = httpGet(srv.URL, configHTTPAuth(nil))
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	resp.Body.Close()
+	if got != "" {
+		t.Fatalf("Failed: unexpected Authorization %q", got)
+	}
+}
@@ -27,6 +27,14 @@ type Image struct {
 	ImagePath string `mapstructure:"imagePath"`
 	// Device is the device that the image is stored(USB)
 	Device string `mapstructure:"device"`
+	// Artifact is a registry reference of an OCI artifact whose files are placed in DestinationDirectory
+	Artifact string `mapstructure:"artifact"`
+	// DestinationDirectory is the directory on the host the artifact files are copied to
+	DestinationDirectory string `mapstructure:"destinationDirectory"`
+	// HTTPAuth is sent when downloading the image from the url
+	HTTPAuth *HTTPAuth `mapstructure:"httpAuth"`
+	// artifactDigest is the manifest digest of the last placed artifact
+	artifactDigest string
 }
 
 func (i *Image) GetKind() string {
@@ -34,20 +42,29 @@ func (i *Image) GetKind() string {
 }
 
 func (i *Image) Process(ctx, conn context.Context, skew int) {
+	log := methodLogger(i)
 	target := i.GetTarget()
 	time.Sleep(time.Duration(skew) * time.Millisecond)
 	target.mu.Lock()
 	defer target.mu.Unlock()
 
-	if len(i.Url) > 0 {
+	if len(i.Artifact) > 0 {
+		if i.DestinationDirectory == "" {
+			log.Errorf("Image %s: destinationDirectory is required to place artifact %s", i.Name, i.Artifact)
+			return
+		}
+		if err := i.pullArtifactPodman(ctx, conn); err != nil {
+			log.Errorf("Image %s: failed to place artifact %s: %v", i.Name, i.Artifact, err)
+		}
+	} else if len(i.Url) > 0 {
 		err := i.loadHTTPPodman(ctx, conn, i.Url)
 		if err != nil {
-			logger.Debugf("Repository: %s Method: %s encountered error: %v, resetting...", target.url, imageMethod, err)
+			log.Debugf("Repository: %s Method: %s encountered error: %v, resetting...", target.url, imageMethod, err)
 		}
 	} else if len(i.ImagePath) > 0 {
 		err := i.loadDevicePodman(ctx, conn)
 		if err != nil {
-			logger.Debugf("Repository: %s Method: %s encountered error: %v, resetting...", target.url, imageMethod, err)
+			log.Debugf("Repository: %s Method: %s encountered error: %v, resetting...", target.url, imageMethod, err)
 		}
 	}
 }
@@ -61,47 +78,48 @@ func (i *Image) Apply(ctx, conn context.Context, currentState, desiredState plum
 }
 
 func (i *Image) loadHTTPPodman(ctx, conn context.Context, url string) error {
+	log := methodLogger(i)
 	imageName := (path.Base(url))
 	pathToLoad := "/opt/" + imageName
-	data, err := http.Get(url)
+	data, err := httpGet(url, i.HTTPAuth)
 	if err != nil {
-		// logger.Info("Failed to get image from url ", url) saving this for if we do various log levels
+		// log.Info("Failed to get image from url ", url) saving this for if we do various log levels
 		// remove the image if it exists
 		if _, err := os.Stat(pathToLoad); err != nil {
-			logger.Info("URL not present...requeuing")
+			log.Info("URL not present...requeuing")
 			return nil
 		}
-		logger.Info("Flushing image from device ", pathToLoad)
+		log.Info("Flushing image from device ", pathToLoad)
 		flushImages(pathToLoad)
 		return nil
 	}
 	if data.StatusCode == http.StatusOK {
 		if _, err := os.Stat(pathToLoad); os.IsNotExist(err) {
-			logger.Infof("Loading image from %s", url)
+			log.Infof("Loading image from %s", url)
 			// Place the data into the placeholder file
 			defer data.Body.Close()
 
 			// Fail early if http error code is not 200
 			if data.StatusCode != http.StatusOK {
-				logger.Error("Failed getting data from ", i.Url)
+				log.Error("Failed getting data from ", i.Url)
 				return err
 			}
 			// Create the file to write the data to
 			file, err := os.Create("/opt/" + imageName)
 			if err != nil {
-				logger.Error("Failed creating file ", file)
+				log.Error("Failed creating file ", file)
 				return err
 			}
 			// Write the data to the file
 			_, err = io.Copy(file, data.Body)
 			if err != nil {
-				logger.Error("Failed writing data to ", file)
+				log.Error("Failed writing data to ", file)
 				return err
 			}
 
 			err = i.podmanImageLoad(ctx, conn, pathToLoad)
 			if err != nil {
-				logger.Error("Failed to load image from device")
+				log.Error("Failed to load image from device")
 				return err
 			}
 			return nil
@@ -113,29 +131,30 @@ func (i *Image) loadHTTPPodman(ctx, conn context.Context, url string) error {
 }
 
 func (i *Image) loadDevicePodman(ctx, conn context.Context) error {
+	log := methodLogger(i)
 	// Define the path to the image
 	trimDir := filepath.Base(i.ImagePath)
 	baseDir := filepath.Dir(i.ImagePath)
 	pathToLoad := "/opt/" + i.ImagePath
-	_, exitCode, err := localDeviceCheck(baseDir, i.Device, trimDir)
+	_, exitCode, err := localDeviceCheck(targetName(i.GetTarget()), baseDir, i.Device, trimDir)
 	if err != nil {
-		logger.Error("Failed to check device")
+		log.Error("Failed to check device")
 		return err
 	}
 	if exitCode != 0 {
-		logger.Info("Device not present...requeuing")
+		log.Info("Device not present...requeuing")
 		// List files to see if anything needs to be flushed
 		if _, err := os.Stat(pathToLoad); err == nil {
-			logger.Info("Flushing image from device ", pathToLoad)
+			log.Info("Flushing image from device ", pathToLoad)
 			flushImages(pathToLoad)
 		}
 		return nil
 	} else if exitCode == 0 {
 		// If file does not exist pull from the device
 		if _, err := os.Stat(pathToLoad); os.IsNotExist(err) {
-			id, err := localDevicePull(baseDir, i.Device, "-"+trimDir, true)
+			id, err := localDevicePull(targetName(i.GetTarget()), baseDir, baseDir, i.Device, "", "", "-"+trimDir, true)
 			if err != nil {
-				logger.Info("Issue pulling image from device ", err)
+				log.Info("Issue pulling image from device ", err)
 			}
 
 			// Wait for the image to be copied into the fetchit container
@@ -143,7 +162,7 @@ func (i *Image) loadDevicePodman(ctx, conn context.Context) error {
 		}
 		err = i.podmanImageLoad(ctx, conn, pathToLoad)
 		if err != nil {
-			logger.Error("Failed to load image ", pathToLoad)
+			log.Error("Failed to load image ", pathToLoad)
 			return err
 		}
 		return nil
@@ -152,13 +171,14 @@ func (i *Image) loadDevicePodman(ctx, conn context.Context) error {
 }
 
 func (i *Image) podmanImageLoad(ctx, conn context.Context, pathToLoad string) error {
+	log := methodLogger(i)
 	// Load image from path on the system using podman load
 	// Read the file that needs to be processed
-	logger.Infof("Loading image from %s", i.ImagePath)
+	log.Infof("Loading image from %s", i.ImagePath)
 
 	file, err := os.Open(pathToLoad)
 	if err != nil {
-		logger.Error("Failed opening file ", pathToLoad)
+		log.Error("Failed opening file ", pathToLoad)
 		return err
 	}
 	defer file.Close()
@@ -168,7 +188,7 @@ func (i *Image) podmanImageLoad(ctx, conn context.Context, pathToLoad string) er
 		return err
 	}
 
-	logger.Infof("Image %s loaded....Requeuing", imported.Names[0])
+	log.Infof("Image %s loaded....Requeuing", imported.Names[0])
 	return nil
 }
 
@@ -0,0 +1,122 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/containers/fetchit/pkg/engine/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// ImageOverride deploys the image To wherever a raw or kube file of the
+// target uses the image From, so the same files can be promoted across
+// environments that run different tags
+type ImageOverride struct {
+	// From is the image as the files reference it, such as myapp:dev
+	From string `mapstructure:"from"`
+	// To is the image deployed instead, such as myapp:prod
+	To string `mapstructure:"to"`
+}
+
+// validateImageOverrides returns the problems of the image overrides of a target
+func validateImageOverrides(target string, overrides []*ImageOverride) error {
+	errs := &utils.MultiError{}
+	seen := map[string]bool{}
+	for _, o := range overrides {
+		if o == nil || o.From == "" || o.To == "" {
+			errs.Append(fmt.Errorf("target %s has an image override without from or to", target))
+			continue
+		}
+		if seen[o.From] {
+			errs.Append(fmt.Errorf("target %s overrides image %s more than once", target, o.From))
+		}
+		seen[o.From] = true
+	}
+	return errs.ErrorOrNil()
+}
+
+// overrideImage returns the image deployed for image, which is image itself
+// unless the target overrides it. Images are matched as they are written.
+func overrideImage(target *Target, image string) (string, bool) {
+	if target == nil {
+		return image, false
+	}
+	to, ok := target.imageOverrides[image]
+	if !ok {
+		return image, false
+	}
+	return to, true
+}
+
+// overrideRawImages replaces the images of raws that the target of r overrides
+func (r *Raw) overrideRawImages(raws []*RawPod) {
+	log := methodLogger(r)
+	for _, raw := range raws {
+		if image, ok := overrideImage(r.GetTarget(), raw.Image); ok {
+			log.Infof("Overriding image %s of container %s with %s", raw.Image, raw.Name, image)
+			raw.Image = image
+		}
+	}
+}
+
+// overrideKubeImages replaces the images of the containers of the pods and
+// deployments in a kube file that the target of k overrides. Files without
+// overridden images are returned as they are.
+func (k *Kube) overrideKubeImages(specs []byte) ([]byte, error) {
+	target := k.GetTarget()
+	if target == nil || len(target.imageOverrides) == 0 {
+		return specs, nil
+	}
+	log := methodLogger(k)
+	var docs []map[string]interface{}
+	overridden := false
+	d := yaml.NewDecoder(bytes.NewReader(specs))
+	for {
+		var doc map[string]interface{}
+		err := d.Decode(&doc)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, utils.WrapErr(err, "Error decoding yaml")
+		}
+		if doc == nil {
+			continue
+		}
+		var spec interface{} = doc["spec"]
+		if doc["kind"] == "Deployment" {
+			spec = nested(spec, "template", "spec")
+		}
+		for _, key := range []string{"initContainers", "containers"} {
+			containers, _ := nested(spec, key).([]interface{})
+			for _, c := range containers {
+				container, ok := c.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				from, _ := container["image"].(string)
+				if image, ok := overrideImage(target, from); ok {
+					log.Infof("Overriding image %s of container %v with %s", from, container["name"], image)
+					container["image"] = image
+					overridden = true
+				}
+			}
+		}
+		docs = append(docs, doc)
+	}
+	if !overridden {
+		return specs, nil
+	}
+	out := &bytes.Buffer{}
+	enc := yaml.NewEncoder(out)
+	for _, doc := range docs {
+		if err := enc.Encode(doc); err != nil {
+			return nil, utils.WrapErr(err, "Error encoding yaml")
+		}
+	}
+	if err := enc.Close(); err != nil {
+		return nil, utils.WrapErr(err, "Error encoding yaml")
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,110 @@
+package engine
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+func TestImageOverrides(t *testing.T) {
+	defer func(l *zap.SugaredLogger) { logger = l }(logger)
+	logger = zap.NewNop().Sugar()
+
+	v := viper.New()
+	v.SetConfigType("yaml")
+	err := v.ReadConfig(bytes.NewBufferString(`
+targetConfigs:
+- name: prod
+  url: https://github.com/containers/fetchit
+  branch: main
+  imageOverrides:
+  - from: quay.io/example/myapp:dev
+    to: quay.io/example/myapp:prod
+  raw:
+  - name: raw-ex
+    targetPath: examples/raw
+    schedule: "*/5 * * * *"
+`))
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	config := &FetchitConfig{}
+	if err := v.Unmarshal(config); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	tc := config.TargetConfigs[0]
+	if err := tc.validate(); err != nil {
+		t.Fatalf("Failed: unexpected error: %v", err)
+	}
+	f := newFetchit()
+	getMethodTargetScheds([]*TargetConfig{tc}, f)
+	target := tc.Raw[0].GetTarget()
+	if target.imageOverrides["quay.io/example/myapp:dev"] != "quay.io/example/myapp:prod" {
+		t.Fatalf("Failed: image overrides not set on the target: %v", target.imageOverrides)
+	}
+
+	raws, err := rawPodsFromBytes([]byte(`Image: quay.io/example/myapp:dev
+Name: myapp
+---
+Image: docker.io/library/redis:7
+Name: cache
+`))
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	tc.Raw[0].overrideRawImages(raws)
+	if raws[0].Image != "quay.io/example/myapp:prod" || raws[1].Image != "docker.io/library/redis:7" {
+		t.Fatalf("Failed: raw images %s and %s", raws[0].Image, raws[1].Image)
+	}
+
+	k := &Kube{CommonMethod: CommonMethod{Name: "kube-ex", target: target}}
+	specs, err := k.overrideKubeImages([]byte(`apiVersion: v1
+kind: Pod
+metadata:
+  name: myapp
+spec:
+  containers:
+  - name: myapp
+    image: quay.io/example/myapp:dev
+  - name: cache
+    image: docker.io/library/redis:7
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: worker
+spec:
+  template:
+    spec:
+      initContainers:
+      - name: migrate
+        image: quay.io/example/myapp:dev
+`))
+	if err != nil {
+		t.Fatalf("Failed: unexpected error: %v", err)
+	}
+	pods, _, err := podFromBytes(specs)
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	if len(pods) != 1 || pods[0].Spec.Containers[0].Image != "quay.io/example/myapp:prod" || pods[0].Spec.Containers[1].Image != "docker.io/library/redis:7" {
+		t.Fatalf("Failed: kube images not overridden: %s", specs)
+	}
+	if strings.Contains(string(specs), "myapp:dev") {
+		t.Fatalf("Failed: deployment image not overridden: %s", specs)
+	}
+
+	// files without overridden images are kept as they are
+	plain := []byte("apiVersion: v1\nkind: Pod\nspec:\n  containers:\n  - image: docker.io/library/redis:7\n")
+	if out, err := k.overrideKubeImages(plain); err != nil || !bytes.Equal(out, plain) {
+		t.Fatalf("Failed: file without overrides rewritten: %s %v", out, err)
+	}
+
+	tc.ImageOverrides = append(tc.ImageOverrides, &ImageOverride{From: "quay.io/example/myapp:dev", To: "quay.io/example/myapp:stage"}, &ImageOverride{From: "myapp:dev"})
+	if err := tc.validate(); err == nil || !strings.Contains(err.Error(), "more than once") || !strings.Contains(err.Error(), "without from or to") {
+		t.Fatalf("Failed: expected errors for duplicate and incomplete overrides, got %v", err)
+	}
+}
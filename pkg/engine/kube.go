@@ -5,15 +5,18 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
-	"io/ioutil"
 	"net/http"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/containers/fetchit/pkg/engine/utils"
 	"github.com/containers/podman/v4/pkg/bindings"
 	"github.com/containers/podman/v4/pkg/bindings/play"
+	"github.com/containers/podman/v4/pkg/bindings/secrets"
+	"github.com/containers/podman/v4/pkg/bindings/volumes"
 	"github.com/containers/podman/v4/pkg/domain/entities"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
@@ -29,6 +32,13 @@ const kubeMethod = "kube"
 // Kube to launch pods using podman kube-play
 type Kube struct {
 	CommonMethod `mapstructure:",squash"`
+	Templating   `mapstructure:",squash"`
+	// Build builds the images of the manifest that have a Containerfile in a
+	// directory of ContextDir named after the image before playing it
+	Build bool `mapstructure:"build"`
+	// ContextDir is the directory, relative to the root of the repository, holding
+	// the build directories of the images, the directory of the manifest if unset
+	ContextDir string `mapstructure:"contextDir"`
 }
 
 func (k *Kube) GetKind() string {
@@ -36,30 +46,31 @@ func (k *Kube) GetKind() string {
 }
 
 func (k *Kube) Process(ctx, conn context.Context, skew int) {
+	log := methodLogger(k)
 	target := k.GetTarget()
 	time.Sleep(time.Duration(skew) * time.Millisecond)
 	target.mu.Lock()
 	defer target.mu.Unlock()
 
 	initial := k.initialRun
-	tag := []string{"yaml", "yml"}
+	tags := k.getTags(kubeMethod)
 	if initial {
 		err := getRepo(target)
 		if err != nil {
-			logger.Errorf("Failed to clone repository %s: %v", target.url, err)
+			log.Errorf("Failed to clone repository %s: %v", target.url, err)
 			return
 		}
 
-		err = zeroToCurrent(ctx, conn, k, target, &tag)
+		err = zeroToCurrent(ctx, conn, k, target, tags)
 		if err != nil {
-			logger.Errorf("Error moving to current: %v", err)
+			log.Errorf("Error moving to current: %v", err)
 			return
 		}
 	}
 
-	err := currentToLatest(ctx, conn, k, target, &tag)
+	err := currentToLatest(ctx, conn, k, target, tags)
 	if err != nil {
-		logger.Errorf("Error moving current to latest: %v", err)
+		log.Errorf("Error moving current to latest: %v", err)
 		return
 	}
 
@@ -71,36 +82,64 @@ func (k *Kube) MethodEngine(ctx context.Context, conn context.Context, change *o
 	if err != nil {
 		return err
 	}
-	return k.kubePodman(ctx, conn, path, prev)
+	kubeYaml, err := getChangeContents(change, path)
+	if err != nil {
+		return utils.WrapErr(err, "Error reading file")
+	}
+	return k.kubePodman(ctx, conn, path, kubeYaml, prev)
 }
 
 func (k *Kube) Apply(ctx, conn context.Context, currentState, desiredState plumbing.Hash, tags *[]string) error {
-	changeMap, err := applyChanges(ctx, k.GetTarget(), k.GetTargetPath(), k.Glob, currentState, desiredState, tags)
+	changeMap, err := k.targetChanges(ctx, currentState, desiredState, tags)
 	if err != nil {
 		return err
 	}
+	k.resetFacts()
 	if err := runChanges(ctx, conn, k, changeMap); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (k *Kube) kubePodman(ctx, conn context.Context, path string, prev *string) error {
+func (k *Kube) kubePodman(ctx, conn context.Context, path string, kubeYaml []byte, prev *string) error {
+	log := methodLogger(k)
 	if path != deleteFile {
-		logger.Infof("Creating podman container from %s using kube method", path)
+		log.Infof("Creating podman container from %s using kube method", path)
 	}
 
 	if prev != nil {
-		err := stopPods(conn, []byte(*prev))
+		prevYaml, err := k.render(conn, path, []byte(*prev))
+		if err != nil {
+			return err
+		}
+		err = stopPods(conn, prevYaml)
 		if err != nil {
 			return utils.WrapErr(err, "Error stopping pods")
 		}
+		if err := removeSecrets(conn, prevYaml); err != nil {
+			return err
+		}
 	}
 
 	if path != deleteFile {
-		kubeYaml, err := ioutil.ReadFile(path)
+		kubeYaml, err := k.render(conn, path, kubeYaml)
 		if err != nil {
-			return utils.WrapErr(err, "Error reading file")
+			return err
+		}
+		kubeYaml, err = translateHostPaths(kubeYaml, k.hostPathResolver(conn, path))
+		if err != nil {
+			return err
+		}
+		kubeYaml, err = k.overrideKubeImages(kubeYaml)
+		if err != nil {
+			return err
+		}
+		// images are built before the running pods are stopped, so a failed
+		// build leaves them running
+		if k.Build {
+			if err := k.buildImages(conn, path, kubeYaml); err != nil {
+				return err
+			}
 		}
 
 		// Try stopping the pods, don't care if they don't exist
@@ -140,7 +179,7 @@ func stopPods(ctx context.Context, podSpec []byte) error {
 }
 
 func createPods(ctx context.Context, path string, specs []byte) error {
-	pod_list, err := podFromBytes(specs)
+	pod_list, secret_list, err := podFromBytes(specs)
 	if err != nil {
 		return utils.WrapErr(err, "Error getting list of pods in spec")
 	}
@@ -148,23 +187,32 @@ func createPods(ctx context.Context, path string, specs []byte) error {
 	for _, pod := range pod_list {
 		err = validatePod(pod)
 		if err != nil {
-			return utils.WrapErr(err, "Error validating pod spec")
+			return &utils.ValidationError{Err: utils.WrapErr(err, "Error validating pod spec")}
 		}
 	}
 
-	_, err = play.Kube(ctx, path, nil)
+	// Secrets must exist before the pods referencing them are played
+	for _, secret := range secret_list {
+		if err := createSecret(ctx, secret); err != nil {
+			return err
+		}
+	}
+
+	_, err = play.KubeWithBody(ctx, bytes.NewReader(specs), nil)
 	if err != nil {
-		return utils.WrapErr(err, "Error playing kube spec")
+		return &utils.PodmanError{Err: utils.WrapErr(err, "Error playing kube spec")}
 	}
 
 	logger.Infof("Created pods from spec in %s", path)
 	return nil
 }
 
-func podFromBytes(input []byte) ([]v1.Pod, error) {
+// podFromBytes returns the pods and secrets defined in a kube yaml file
+func podFromBytes(input []byte) ([]v1.Pod, []v1.Secret, error) {
 	var t metav1.TypeMeta
 	d := yaml.NewDecoder(bytes.NewReader(input))
 	ret := make([]v1.Pod, 0)
+	secret_list := make([]v1.Secret, 0)
 
 	for {
 		var i interface{}
@@ -173,22 +221,31 @@ func podFromBytes(input []byte) ([]v1.Pod, error) {
 			break
 		}
 		if err != nil {
-			return ret, utils.WrapErr(err, "Error decoding yaml")
+			return ret, secret_list, utils.WrapErr(err, "Error decoding yaml")
 		}
 
 		o, err := yaml.Marshal(i)
 		if err != nil {
-			return ret, utils.WrapErr(err, "Error marshalling yaml into object for conversion to json")
+			return ret, secret_list, utils.WrapErr(err, "Error marshalling yaml into object for conversion to json")
 		}
 
 		b, err := k8syaml.YAMLToJSON(o)
 		if err != nil {
-			return ret, utils.WrapErr(err, "Error converting yaml to json")
+			return ret, secret_list, utils.WrapErr(err, "Error converting yaml to json")
 		}
 
 		err = json.Unmarshal(b, &t)
 		if err != nil {
-			return ret, utils.WrapErr(err, "Error unmarshalling json object")
+			return ret, secret_list, utils.WrapErr(err, "Error unmarshalling json object")
+		}
+
+		if t.Kind == "Secret" {
+			secret := v1.Secret{}
+			if err := json.Unmarshal(b, &secret); err != nil {
+				return ret, secret_list, utils.WrapErr(err, "Error unmarshalling json into secret object")
+			}
+			secret_list = append(secret_list, secret)
+			continue
 		}
 
 		if t.Kind != "Pod" {
@@ -198,13 +255,13 @@ func podFromBytes(input []byte) ([]v1.Pod, error) {
 		pod := v1.Pod{}
 		err = json.Unmarshal(b, &pod)
 		if err != nil {
-			return ret, utils.WrapErr(err, "Error unmarshalling json into pod object")
+			return ret, secret_list, utils.WrapErr(err, "Error unmarshalling json into pod object")
 		}
 
 		ret = append(ret, pod)
 	}
 
-	return ret, nil
+	return ret, secret_list, nil
 }
 
 func validatePod(p v1.Pod) error {
@@ -213,5 +270,170 @@ func validatePod(p v1.Pod) error {
 			return errors.New("pod and container within pod cannot share same name for Podman v3")
 		}
 	}
+	for _, volume := range p.Spec.Volumes {
+		if volume.HostPath == nil {
+			continue
+		}
+		if !filepath.IsAbs(volume.HostPath.Path) {
+			return fmt.Errorf("hostPath %s of volume %s must be absolute", volume.HostPath.Path, volume.Name)
+		}
+		// podman mounts the path from the host, not from the fetchit container
+		logger.Infof("Pod %s mounts %s from the host", p.ObjectMeta.Name, volume.HostPath.Path)
+	}
+	return nil
+}
+
+// hostPathResolver returns the function translating the relative hostPaths of
+// the kube file at path into the host path of the directory of the file. The
+// repository is cloned into the fetchit volume, so its path on the host is
+// looked up from podman the first time it is needed.
+func (k *Kube) hostPathResolver(conn context.Context, path string) func(string) (string, error) {
+	var mountpoint string
+	return func(hostPath string) (string, error) {
+		if mountpoint == "" {
+			report, err := volumes.Inspect(conn, fetchitVolume, nil)
+			if err != nil {
+				return "", &utils.PodmanError{Err: utils.WrapErr(err, "Error inspecting volume %s", fetchitVolume)}
+			}
+			mountpoint = report.Mountpoint
+		}
+		return resolveHostPath(mountpoint, getDirectory(k.GetTarget()), path, hostPath)
+	}
+}
+
+// resolveHostPath returns the path on the host of hostPath, relative to the
+// directory of the kube file at path in the repository cloned into repoDir of
+// the fetchit volume mounted from mountpoint. Paths may not leave the repository.
+func resolveHostPath(mountpoint, repoDir, path, hostPath string) (string, error) {
+	repoDir = filepath.Clean(strings.TrimPrefix(repoDir, "/opt/"))
+	p := filepath.Join(filepath.Dir(strings.TrimPrefix(path, "/opt/")), hostPath)
+	if p != repoDir && !strings.HasPrefix(p, repoDir+string(filepath.Separator)) {
+		return "", &utils.ValidationError{Err: fmt.Errorf("hostPath %s of %s is outside of its repository", hostPath, path)}
+	}
+	return filepath.Join(mountpoint, p), nil
+}
+
+// translateHostPaths replaces the relative hostPaths of the pods and
+// deployments in a kube file with the host paths resolve returns, so that
+// files of the repository can be mounted. Absolute paths are paths of the
+// host and are kept. Files without relative hostPaths are returned as they are.
+func translateHostPaths(specs []byte, resolve func(string) (string, error)) ([]byte, error) {
+	var docs []map[string]interface{}
+	translated := false
+	d := yaml.NewDecoder(bytes.NewReader(specs))
+	for {
+		var doc map[string]interface{}
+		err := d.Decode(&doc)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, utils.WrapErr(err, "Error decoding yaml")
+		}
+		if doc == nil {
+			continue
+		}
+		var spec interface{} = doc["spec"]
+		if doc["kind"] == "Deployment" {
+			spec = nested(spec, "template", "spec")
+		}
+		vols, _ := nested(spec, "volumes").([]interface{})
+		for _, v := range vols {
+			hostPath, ok := nested(v, "hostPath").(map[string]interface{})
+			if !ok {
+				continue
+			}
+			p, ok := hostPath["path"].(string)
+			if !ok || p == "" || filepath.IsAbs(p) {
+				continue
+			}
+			resolved, err := resolve(p)
+			if err != nil {
+				return nil, err
+			}
+			hostPath["path"] = resolved
+			translated = true
+		}
+		docs = append(docs, doc)
+	}
+	if !translated {
+		return specs, nil
+	}
+	out := &bytes.Buffer{}
+	enc := yaml.NewEncoder(out)
+	for _, doc := range docs {
+		if err := enc.Encode(doc); err != nil {
+			return nil, utils.WrapErr(err, "Error encoding yaml")
+		}
+	}
+	if err := enc.Close(); err != nil {
+		return nil, utils.WrapErr(err, "Error encoding yaml")
+	}
+	return out.Bytes(), nil
+}
+
+// nested returns the value at keys in nested yaml maps, nil if it is missing
+func nested(v interface{}, keys ...string) interface{} {
+	for _, key := range keys {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[key]
+	}
+	return v
+}
+
+// secretData returns a kube secret in the format podman expects when it
+// resolves secretKeyRef env variables, a json object of base64 values
+func secretData(secret v1.Secret) ([]byte, error) {
+	data := make(map[string][]byte, len(secret.Data)+len(secret.StringData))
+	for k, v := range secret.Data {
+		data[k] = v
+	}
+	// stringData takes precedence over data as in kubernetes
+	for k, v := range secret.StringData {
+		data[k] = []byte(v)
+	}
+	return json.Marshal(data)
+}
+
+// createSecret creates or replaces the podman secret for a kube secret
+func createSecret(conn context.Context, secret v1.Secret) error {
+	if secret.Name == "" {
+		return &utils.ValidationError{Err: errors.New("kube secret must set metadata.name")}
+	}
+	data, err := secretData(secret)
+	if err != nil {
+		return utils.WrapErr(err, "Error encoding secret %s", secret.Name)
+	}
+	// podman secrets can't be updated, so an existing secret is replaced
+	if _, err := secrets.Inspect(conn, secret.Name, nil); err == nil {
+		if err := secrets.Remove(conn, secret.Name); err != nil {
+			return &utils.PodmanError{Err: utils.WrapErr(err, "Error removing secret %s", secret.Name)}
+		}
+	}
+	if _, err := secrets.Create(conn, bytes.NewReader(data), new(secrets.CreateOptions).WithName(secret.Name)); err != nil {
+		return &utils.PodmanError{Err: utils.WrapErr(err, "Error creating secret %s", secret.Name)}
+	}
+	logger.Infof("Secret %s created.", secret.Name)
+	return nil
+}
+
+// removeSecrets removes the podman secrets for the kube secrets in a kube yaml file
+func removeSecrets(conn context.Context, specs []byte) error {
+	_, secretList, err := podFromBytes(specs)
+	if err != nil {
+		return utils.WrapErr(err, "Error getting list of secrets in spec")
+	}
+	for _, secret := range secretList {
+		if _, err := secrets.Inspect(conn, secret.Name, nil); err != nil {
+			continue
+		}
+		if err := secrets.Remove(conn, secret.Name); err != nil {
+			return &utils.PodmanError{Err: utils.WrapErr(err, "Error removing secret %s", secret.Name)}
+		}
+		logger.Infof("Secret %s removed.", secret.Name)
+	}
 	return nil
 }
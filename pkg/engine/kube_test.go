@@ -0,0 +1,131 @@
+package engine
+
+import (
+	"encoding/json"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestPodFromBytesWithSecret(t *testing.T) {
+	pods, secretList, err := podFromBytes([]byte(`
+apiVersion: v1
+kind: Secret
+metadata:
+  name: colors-secret
+data:
+  password: aHVudGVyMg==
+stringData:
+  username: fetchit
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: colors_pod
+spec:
+  containers:
+  - name: colors-kubeplay
+    image: docker.io/mmumshad/simple-webapp-color:latest
+    env:
+    - name: PASSWORD
+      valueFrom:
+        secretKeyRef:
+          name: colors-secret
+          key: password
+`))
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	if len(pods) != 1 || pods[0].Name != "colors_pod" {
+		t.Fatalf("Failed: pods: %+v", pods)
+	}
+	if len(secretList) != 1 || secretList[0].Name != "colors-secret" {
+		t.Fatalf("Failed: secrets: %+v", secretList)
+	}
+
+	b, err := secretData(secretList[0])
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	// podman decodes the secret as a json object of base64 values
+	data := map[string][]byte{}
+	if err := json.Unmarshal(b, &data); err != nil {
+		t.Fatalf("Failed: secret data is not in the format podman expects: %v", err)
+	}
+	if string(data["password"]) != "hunter2" || string(data["username"]) != "fetchit" {
+		t.Fatalf("Failed: secret data: %v", data)
+	}
+}
+
+func TestTranslateHostPaths(t *testing.T) {
+	defer func(l *zap.SugaredLogger) { logger = l }(logger)
+	logger = zap.NewNop().Sugar()
+
+	repoDir := "repos/apps-0123456789"
+	path := repoDir + "/kube/web.yaml"
+	resolve := func(hostPath string) (string, error) {
+		return resolveHostPath("/var/lib/containers/storage/volumes/fetchit-volume/_data", repoDir, path, hostPath)
+	}
+	specs := []byte(`apiVersion: v1
+kind: Pod
+metadata:
+  name: web
+spec:
+  containers:
+  - name: nginx
+    image: docker.io/library/nginx:latest
+    volumeMounts:
+    - name: html
+      mountPath: /usr/share/nginx/html
+    - name: logs
+      mountPath: /var/log/nginx
+  volumes:
+  - name: html
+    hostPath:
+      path: ./html
+  - name: logs
+    hostPath:
+      path: /var/log/web
+`)
+	out, err := translateHostPaths(specs, resolve)
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	pods, _, err := podFromBytes(out)
+	if err != nil || len(pods) != 1 {
+		t.Fatalf("Failed: translated spec: %v", err)
+	}
+	volumes := pods[0].Spec.Volumes
+	if len(volumes) != 2 || volumes[0].HostPath.Path != "/var/lib/containers/storage/volumes/fetchit-volume/_data/"+repoDir+"/kube/html" {
+		t.Fatalf("Failed: relative hostPath translated to %+v", volumes)
+	}
+	if volumes[1].HostPath.Path != "/var/log/web" {
+		t.Fatalf("Failed: absolute hostPath changed to %s", volumes[1].HostPath.Path)
+	}
+	if err := validatePod(pods[0]); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	if len(pods[0].Spec.Containers[0].VolumeMounts) != 2 {
+		t.Fatalf("Failed: volume mounts lost: %+v", pods[0].Spec.Containers[0])
+	}
+
+	// files without relative hostPaths are kept as they are
+	absolute := []byte("kind: Pod\nmetadata:\n  name: logs\nspec:\n  volumes:\n  - name: logs\n    hostPath:\n      path: /var/log\n")
+	if out, err := translateHostPaths(absolute, resolve); err != nil || string(out) != string(absolute) {
+		t.Fatalf("Failed: unchanged spec rewritten to %s: %v", out, err)
+	}
+
+	// paths may not leave the repository
+	escaping := []byte("kind: Deployment\nspec:\n  template:\n    spec:\n      volumes:\n      - name: etc\n        hostPath:\n          path: ../../../etc\n")
+	if _, err := translateHostPaths(escaping, resolve); err == nil {
+		t.Fatalf("Failed: expected an error for a hostPath outside of the repository")
+	}
+
+	pods, _, err = podFromBytes([]byte("kind: Pod\nmetadata:\n  name: web\nspec:\n  volumes:\n  - name: html\n    hostPath:\n      path: html\n"))
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	if err := validatePod(pods[0]); err == nil {
+		t.Fatalf("Failed: expected an error for a relative hostPath")
+	}
+}
@@ -0,0 +1,111 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containers/buildah/define"
+	"github.com/containers/fetchit/pkg/engine/utils"
+	"github.com/containers/podman/v4/pkg/bindings/images"
+	"github.com/containers/podman/v4/pkg/domain/entities"
+	v1 "k8s.io/api/core/v1"
+)
+
+// kubeBuild is an image of a kube manifest that is built from a Containerfile
+type kubeBuild struct {
+	image         string
+	containerfile string
+}
+
+// imageBuildDir returns the name of the directory a Containerfile of image is
+// looked up in, the image without its registry, repository path, tag or digest
+func imageBuildDir(image string) string {
+	name := strings.SplitN(image, "@", 2)[0]
+	name = name[strings.LastIndex(name, "/")+1:]
+	return strings.SplitN(name, ":", 2)[0]
+}
+
+// contextDir returns the directory holding the build directories of the
+// images of the manifest at path, ContextDir relative to the root of the
+// repository or the directory of the manifest when unset
+func (k *Kube) contextDir(path string) (string, error) {
+	if k.ContextDir == "" {
+		return filepath.Dir(path), nil
+	}
+	root := getDirectory(k.GetTarget())
+	dir := filepath.Join(root, k.ContextDir)
+	if filepath.IsAbs(k.ContextDir) || (dir != root && !strings.HasPrefix(dir, root+string(filepath.Separator))) {
+		return "", &utils.ValidationError{Err: fmt.Errorf("contextDir %s must be a path within the repository", k.ContextDir)}
+	}
+	return dir, nil
+}
+
+// kubeBuilds returns the images of the containers of pods that have a
+// Containerfile, or a Dockerfile, in the directory of dir named after the
+// image, as podman kube play --build looks them up
+func kubeBuilds(dir string, pods []v1.Pod) ([]kubeBuild, error) {
+	var builds []kubeBuild
+	seen := map[string]bool{}
+	for _, pod := range pods {
+		for _, c := range append(append([]v1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...) {
+			if c.Image == "" || seen[c.Image] {
+				continue
+			}
+			seen[c.Image] = true
+			for _, file := range []string{"Containerfile", "Dockerfile"} {
+				containerfile := filepath.Join(dir, imageBuildDir(c.Image), file)
+				_, err := os.Stat(containerfile)
+				if err == nil {
+					builds = append(builds, kubeBuild{image: c.Image, containerfile: containerfile})
+					break
+				}
+				if !os.IsNotExist(err) {
+					return nil, utils.WrapErr(err, "Error looking up the build file of image %s", c.Image)
+				}
+			}
+		}
+	}
+	return builds, nil
+}
+
+// buildOptions returns the options building the image of b, with the
+// directory of its Containerfile as the build context
+func buildOptions(b kubeBuild) entities.BuildOptions {
+	return entities.BuildOptions{BuildOptions: define.BuildOptions{
+		ContextDirectory: filepath.Dir(b.containerfile),
+		Output:           b.image,
+		Out:              ioutil.Discard,
+		Err:              ioutil.Discard,
+		ReportWriter:     ioutil.Discard,
+	}}
+}
+
+// buildImages builds the images of the manifest at path that have a
+// Containerfile in the context directory. The podman API plays manifests
+// without building, so fetchit builds the images before playing it.
+func (k *Kube) buildImages(conn context.Context, path string, kubeYaml []byte) error {
+	log := methodLogger(k)
+	dir, err := k.contextDir(path)
+	if err != nil {
+		return err
+	}
+	pods, _, err := podFromBytes(kubeYaml)
+	if err != nil {
+		return utils.WrapErr(err, "Error getting list of pods in spec")
+	}
+	builds, err := kubeBuilds(dir, pods)
+	if err != nil {
+		return err
+	}
+	for _, b := range builds {
+		log.Infof("Building image %s from %s", b.image, b.containerfile)
+		if _, err := images.Build(conn, []string{b.containerfile}, buildOptions(b)); err != nil {
+			return &utils.PodmanError{Err: utils.WrapErr(err, "Error building image %s", b.image)}
+		}
+	}
+	return nil
+}
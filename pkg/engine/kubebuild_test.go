@@ -0,0 +1,102 @@
+package engine
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestKubeBuildOptions(t *testing.T) {
+	defer func(dir string) { cacheDir = dir }(cacheDir)
+	cacheDir = t.TempDir()
+
+	v := viper.New()
+	v.SetConfigType("yaml")
+	err := v.ReadConfig(bytes.NewBufferString(`
+targetConfigs:
+- url: https://github.com/containers/fetchit
+  kube:
+  - name: kube-ex
+    targetPath: examples/kube
+    build: true
+    contextDir: images
+`))
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	config := &FetchitConfig{}
+	if err := v.Unmarshal(config); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	k := config.TargetConfigs[0].Kube[0]
+	if !k.Build || k.ContextDir != "images" {
+		t.Fatalf("Failed: build options not read from config: %t %q", k.Build, k.ContextDir)
+	}
+	k.target = &Target{url: config.TargetConfigs[0].Url}
+
+	root := getDirectory(k.target)
+	path := filepath.Join(root, "examples", "kube", "app.yaml")
+	dir, err := k.contextDir(path)
+	if err != nil {
+		t.Fatalf("Failed: unexpected error: %v", err)
+	}
+	if dir != filepath.Join(root, "images") {
+		t.Fatalf("Failed: context directory %s", dir)
+	}
+	for name, file := range map[string]string{"web": "Containerfile", "worker": "Dockerfile"} {
+		if err := os.MkdirAll(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatalf("Failed: %v", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name, file), []byte("FROM scratch"), 0644); err != nil {
+			t.Fatalf("Failed: %v", err)
+		}
+	}
+
+	pods, _, err := podFromBytes([]byte(`apiVersion: v1
+kind: Pod
+metadata:
+  name: app
+spec:
+  initContainers:
+  - name: migrate
+    image: localhost/worker:latest
+  containers:
+  - name: web
+    image: registry.example.com:5000/team/web:v2
+  - name: db
+    image: docker.io/library/postgres:14
+`))
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	builds, err := kubeBuilds(dir, pods)
+	if err != nil {
+		t.Fatalf("Failed: unexpected error: %v", err)
+	}
+	want := []kubeBuild{
+		{image: "localhost/worker:latest", containerfile: filepath.Join(dir, "worker", "Dockerfile")},
+		{image: "registry.example.com:5000/team/web:v2", containerfile: filepath.Join(dir, "web", "Containerfile")},
+	}
+	if !reflect.DeepEqual(builds, want) {
+		t.Fatalf("Failed: builds %+v, want %+v", builds, want)
+	}
+	opts := buildOptions(builds[1])
+	if opts.Output != "registry.example.com:5000/team/web:v2" || opts.ContextDirectory != filepath.Join(dir, "web") {
+		t.Fatalf("Failed: build options tag %s with context %s", opts.Output, opts.ContextDirectory)
+	}
+
+	// without a contextDir the images are built next to the manifest
+	k.ContextDir = ""
+	if dir, err := k.contextDir(path); err != nil || dir != filepath.Dir(path) {
+		t.Fatalf("Failed: default context directory %s: %v", dir, err)
+	}
+	k.ContextDir = "../outside"
+	if _, err := k.contextDir(path); err == nil {
+		t.Fatalf("Failed: expected an error for a contextDir outside the repository")
+	}
+}
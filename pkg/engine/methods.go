@@ -0,0 +1,124 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+var methodsCmd = &cobra.Command{
+	Use:   "methods",
+	Short: "List supported methods",
+	Long:  `List the methods fetchit supports with the fields used to configure them`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(printMethods(cmd.OutOrStdout()))
+	},
+}
+
+// methodDescriptions describes each method kind listed by the methods command
+var methodDescriptions = map[string]string{
+	ansibleMethod:      "Run ansible playbooks from the target path against the host",
+	filetransferMethod: "Copy files from the target path to a directory on the host",
+	kubeMethod:         "Play kubernetes pod manifests from the target path with podman",
+	rawMethod:          "Run containers defined in podman raw json or yaml files",
+	systemdMethod:      "Place, enable and restart systemd unit files from the target path",
+	volumeMethod:       "Create and remove named podman volumes from json or yaml files",
+	networkMethod:      "Create and remove podman networks from json or yaml files",
+	imageMethod:        "Load image archives from a url or device",
+	pruneMethod:        "Prune unused podman containers, images and volumes",
+	configFileMethod:   "Reload the fetchit config from a url or device",
+	selfUpdateMethod:   "Replace the fetchit container when a new fetchit image is published",
+}
+
+type methodDoc struct {
+	kind    string
+	section string
+	fields  [][2]string
+}
+
+// methodDocs lists the methods that can be set in the config, found from the
+// fields of TargetConfig and FetchitConfig holding a Method
+func methodDocs() []methodDoc {
+	var docs []methodDoc
+	docs = append(docs, configMethodDocs(reflect.TypeOf(TargetConfig{}), "targetConfigs[].")...)
+	docs = append(docs, configMethodDocs(reflect.TypeOf(FetchitConfig{}), "")...)
+	return docs
+}
+
+func configMethodDocs(config reflect.Type, prefix string) []methodDoc {
+	methodType := reflect.TypeOf((*Method)(nil)).Elem()
+	var docs []methodDoc
+	for i := 0; i < config.NumField(); i++ {
+		f := config.Field(i)
+		key := mapstructureKey(f)
+		if key == "" {
+			continue
+		}
+		t := f.Type
+		section := prefix + key
+		if t.Kind() == reflect.Slice {
+			t = t.Elem()
+			section += "[]"
+		}
+		if !t.Implements(methodType) || t.Kind() != reflect.Ptr {
+			continue
+		}
+		m := reflect.New(t.Elem()).Interface().(Method)
+		docs = append(docs, methodDoc{
+			kind:    m.GetKind(),
+			section: section,
+			fields:  structFields(t.Elem()),
+		})
+	}
+	return docs
+}
+
+// structFields returns the config key and type of each field, including the
+// fields of squashed structs
+func structFields(t reflect.Type) [][2]string {
+	var fields [][2]string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("mapstructure")
+		if strings.Contains(tag, "squash") {
+			fields = append(fields, structFields(f.Type)...)
+			continue
+		}
+		key := mapstructureKey(f)
+		if key == "" {
+			continue
+		}
+		fields = append(fields, [2]string{key, strings.TrimPrefix(f.Type.String(), "*")})
+	}
+	return fields
+}
+
+func mapstructureKey(f reflect.StructField) string {
+	if f.PkgPath != "" {
+		return ""
+	}
+	return strings.Split(f.Tag.Get("mapstructure"), ",")[0]
+}
+
+func printMethods(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, d := range methodDocs() {
+		fmt.Fprintf(w, "%s (%s)\n", d.kind, d.section)
+		if desc, ok := methodDescriptions[d.kind]; ok {
+			fmt.Fprintf(w, "  %s\n", desc)
+		}
+		for _, f := range d.fields {
+			fmt.Fprintf(w, "  %s\t%s\n", f[0], f[1])
+		}
+		fmt.Fprintln(w)
+	}
+	return w.Flush()
+}
+
+func init() {
+	fetchitCmd.AddCommand(methodsCmd)
+}
@@ -0,0 +1,26 @@
+package engine
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPrintMethods(t *testing.T) {
+	out := &bytes.Buffer{}
+	methodsCmd.SetOut(out)
+	methodsCmd.Run(methodsCmd, nil)
+
+	kinds := []string{ansibleMethod, filetransferMethod, kubeMethod, rawMethod, systemdMethod, volumeMethod, networkMethod, imageMethod, pruneMethod, configFileMethod, selfUpdateMethod}
+	for _, kind := range kinds {
+		if !strings.Contains(out.String(), "\n"+kind+" (") && !strings.HasPrefix(out.String(), kind+" (") {
+			t.Fatalf("Failed: method %s missing from output:\n%s", kind, out.String())
+		}
+		if _, ok := methodDescriptions[kind]; !ok {
+			t.Fatalf("Failed: method %s has no description", kind)
+		}
+	}
+	if !strings.Contains(out.String(), "targetConfigs[].raw[]") || !strings.Contains(out.String(), "pullImage") {
+		t.Fatalf("Failed: raw section or fields missing from output:\n%s", out.String())
+	}
+}
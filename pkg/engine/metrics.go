@@ -0,0 +1,142 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/containers/fetchit/pkg/engine/utils"
+	"github.com/go-co-op/gocron"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+const (
+	// metricsPushTag tags the push job so it is not mistaken for a method
+	metricsPushTag = "metrics-push"
+	// defaultMetricsJob is the job label of pushed metrics
+	defaultMetricsJob = "fetchit"
+	// defaultMetricsPushInterval is how often metrics are pushed
+	defaultMetricsPushInterval = time.Minute
+)
+
+// MetricsPush pushes the metrics of fetchit to a Prometheus Pushgateway, for
+// hosts that Prometheus can't reach to scrape
+type MetricsPush struct {
+	// Url is the address of the Pushgateway, such as http://pushgateway:9091
+	Url string `mapstructure:"url"`
+	// Job is the job label of the pushed metrics, fetchit if unset
+	Job string `mapstructure:"job"`
+	// Instance is the instance label of the pushed metrics, the hostname if unset
+	Instance string `mapstructure:"instance"`
+	// Interval is how often the metrics are pushed, 1m if unset
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+var (
+	// metricsRegistry holds the metrics of fetchit
+	metricsRegistry = prometheus.NewRegistry()
+	syncsTotal      = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "fetchit_syncs_total",
+		Help: "Runs of each method",
+	}, []string{"kind", "name"})
+	syncDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "fetchit_sync_duration_seconds",
+		Help:    "Time each method takes to run",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"kind", "name"})
+	appliesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "fetchit_applies_total",
+		Help: "Applies of new commits by each method, by result",
+	}, []string{"kind", "name", "result"})
+	applyFailuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "fetchit_apply_failures_total",
+		Help: "Failed applies of new commits by each method, by reason: git, podman, validation or unknown",
+	}, []string{"kind", "name", "reason"})
+	currentCommit = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "fetchit_current_commit",
+		Help: "The commit each method is at, always 1",
+	}, []string{"target", "kind", "name", "commit"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(syncsTotal, syncDuration, appliesTotal, applyFailuresTotal, currentCommit)
+}
+
+// observeSync records a run of a method that started at start
+func observeSync(m Method, start time.Time) {
+	syncsTotal.WithLabelValues(m.GetKind(), m.GetName()).Inc()
+	syncDuration.WithLabelValues(m.GetKind(), m.GetName()).Observe(time.Since(start).Seconds())
+}
+
+// observeApply records the result of an apply
+func observeApply(m Method, applyErr error) {
+	result := auditSuccess
+	if applyErr != nil {
+		result = auditFailure
+		applyFailuresTotal.WithLabelValues(m.GetKind(), m.GetName(), utils.Classify(applyErr)).Inc()
+	}
+	appliesTotal.WithLabelValues(m.GetKind(), m.GetName(), result).Inc()
+}
+
+// observeCurrent records the commit a method of target moved to, replacing
+// the commit it was at
+func observeCurrent(target *Target, kind, name string, commit plumbing.Hash) {
+	currentCommit.DeletePartialMatch(prometheus.Labels{"target": target.url, "kind": kind, "name": name})
+	currentCommit.WithLabelValues(target.url, kind, name, commit.String()).Set(1)
+}
+
+// validate returns the problems of the push settings
+func (mp *MetricsPush) validate() error {
+	if mp.Url == "" {
+		return fmt.Errorf("metricsPush must set a url")
+	}
+	if mp.Interval < 0 {
+		return fmt.Errorf("invalid metricsPush interval %s, must not be negative", mp.Interval)
+	}
+	return nil
+}
+
+// newMetricsPusher returns the pusher of the metrics registry to the gateway
+// of mp, grouped by instance so that hosts don't replace each other's metrics
+func newMetricsPusher(mp *MetricsPush) *push.Pusher {
+	job := mp.Job
+	if job == "" {
+		job = defaultMetricsJob
+	}
+	instance := mp.Instance
+	if instance == "" {
+		instance, _ = os.Hostname()
+	}
+	p := push.New(mp.Url, job).Gatherer(metricsRegistry)
+	if instance != "" {
+		p = p.Grouping("instance", instance)
+	}
+	return p
+}
+
+// pushMetrics pushes the metrics, a failed push is only logged and the
+// metrics are pushed again on the next interval
+func pushMetrics(p *push.Pusher) {
+	if err := p.Push(); err != nil {
+		logger.Warnf("Unable to push metrics: %v", err)
+	}
+}
+
+// startMetricsPush schedules pushes of the metrics on s, nothing is pushed
+// when mp is nil
+func startMetricsPush(s *gocron.Scheduler, mp *MetricsPush) {
+	if mp == nil {
+		return
+	}
+	interval := defaultMetricsPushInterval
+	if mp.Interval > 0 {
+		interval = mp.Interval
+	}
+	if _, err := s.Every(interval).Tag(metricsPushTag).Do(pushMetrics, newMetricsPusher(mp)); err != nil {
+		logger.Warnf("Unable to schedule metrics pushes: %v", err)
+		return
+	}
+	logger.Infof("Pushing metrics to %s every %s", mp.Url, interval)
+}
@@ -0,0 +1,66 @@
+package engine
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestPushMetrics(t *testing.T) {
+	defer func(l *zap.SugaredLogger) { logger = l }(logger)
+	logger = zap.NewNop().Sugar()
+
+	type request struct {
+		method string
+		path   string
+		body   []byte
+	}
+	requests := make(chan request, 1)
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests <- request{method: r.Method, path: r.URL.Path, body: body}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer gateway.Close()
+
+	m := &countMethod{}
+	m.Name = "push-ex"
+	observeSync(m, time.Now().Add(-time.Second))
+	observeApply(m, nil)
+
+	pushMetrics(newMetricsPusher(&MetricsPush{Url: gateway.URL, Instance: "edge-1"}))
+	var req request
+	select {
+	case req = <-requests:
+	default:
+		t.Fatalf("Failed: metrics were not pushed")
+	}
+	if req.method != http.MethodPut {
+		t.Fatalf("Failed: metrics pushed with %s, expected %s", req.method, http.MethodPut)
+	}
+	if want := "/metrics/job/fetchit/instance/edge-1"; req.path != want {
+		t.Fatalf("Failed: metrics pushed to %s, expected %s", req.path, want)
+	}
+	for _, name := range []string{"fetchit_syncs_total", "fetchit_sync_duration_seconds", "fetchit_applies_total", "push-ex"} {
+		if !bytes.Contains(req.body, []byte(name)) {
+			t.Fatalf("Failed: pushed metrics are missing %s", name)
+		}
+	}
+}
+
+func TestMetricsPushValidate(t *testing.T) {
+	if err := (&MetricsPush{}).validate(); err == nil {
+		t.Fatalf("Failed: expected error for metricsPush without a url")
+	}
+	if err := (&MetricsPush{Url: "http://gateway:9091", Interval: -time.Second}).validate(); err == nil {
+		t.Fatalf("Failed: expected error for a negative interval")
+	}
+	if err := (&MetricsPush{Url: "http://gateway:9091"}).validate(); err != nil {
+		t.Fatalf("Failed: unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,277 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/containers/common/libnetwork/types"
+	"github.com/containers/fetchit/pkg/engine/utils"
+	"github.com/containers/podman/v4/pkg/bindings/containers"
+	"github.com/containers/podman/v4/pkg/bindings/network"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	networkMethod        = "network"
+	defaultNetworkDriver = "bridge"
+)
+
+// Network to manage podman networks from json or yaml files
+type Network struct {
+	CommonMethod `mapstructure:",squash"`
+}
+
+/* below is an example network.yaml file:
+Name: app-net
+Driver: bridge
+Subnet: 10.89.10.0/24
+Gateway: 10.89.10.1
+Internal: false
+Labels:
+  app: colors
+*/
+
+type NetworkDef struct {
+	Name     string            `json:"Name" yaml:"Name"`
+	Driver   string            `json:"Driver" yaml:"Driver"`
+	Subnet   string            `json:"Subnet" yaml:"Subnet"`
+	Gateway  string            `json:"Gateway" yaml:"Gateway"`
+	Internal bool              `json:"Internal" yaml:"Internal"`
+	IPv6     bool              `json:"IPv6" yaml:"IPv6"`
+	Labels   map[string]string `json:"Labels" yaml:"Labels"`
+}
+
+func (n *Network) GetKind() string {
+	return networkMethod
+}
+
+func (n *Network) Process(ctx, conn context.Context, skew int) {
+	log := methodLogger(n)
+	time.Sleep(time.Duration(skew) * time.Millisecond)
+	target := n.GetTarget()
+	target.mu.Lock()
+	defer target.mu.Unlock()
+
+	tags := n.getTags(networkMethod)
+
+	if n.initialRun {
+		err := getRepo(target)
+		if err != nil {
+			log.Errorf("Failed to clone repository %s: %v", target.url, err)
+			return
+		}
+
+		err = zeroToCurrent(ctx, conn, n, target, tags)
+		if err != nil {
+			log.Errorf("Error moving to current: %v", err)
+			return
+		}
+	}
+
+	err := currentToLatest(ctx, conn, n, target, tags)
+	if err != nil {
+		log.Errorf("Error moving current to latest: %v", err)
+		return
+	}
+
+	n.initialRun = false
+}
+
+func (n *Network) MethodEngine(ctx context.Context, conn context.Context, change *object.Change, path string) error {
+	prev, err := getChangeString(change)
+	if err != nil {
+		return err
+	}
+	networkFile, err := getChangeContents(change, path)
+	if err != nil {
+		return err
+	}
+	return n.networkPodman(ctx, conn, path, networkFile, prev)
+}
+
+func (n *Network) Apply(ctx, conn context.Context, currentState, desiredState plumbing.Hash, tags *[]string) error {
+	changeMap, err := n.targetChanges(ctx, currentState, desiredState, tags)
+	if err != nil {
+		return err
+	}
+	if err := runChanges(ctx, conn, n, changeMap); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (n *Network) networkPodman(ctx, conn context.Context, path string, networkFile []byte, prev *string) error {
+	log := methodLogger(n)
+	var def *NetworkDef
+	var desired types.Network
+	if path != deleteFile {
+		var err error
+		def, err = networkDefFromBytes(networkFile)
+		if err != nil {
+			return &utils.ValidationError{Err: err}
+		}
+		desired, err = createNetworkSpec(*def)
+		if err != nil {
+			return &utils.ValidationError{Err: err}
+		}
+	}
+
+	// Remove the previous network if it was deleted or renamed
+	if prev != nil {
+		prevDef, err := networkDefFromBytes([]byte(*prev))
+		if err != nil {
+			return &utils.ValidationError{Err: err}
+		}
+		if def == nil || def.Name != prevDef.Name {
+			if err := removeNetwork(conn, prevDef.Name); err != nil {
+				return err
+			}
+		}
+	}
+
+	if def == nil {
+		return nil
+	}
+
+	existing, err := network.Inspect(conn, def.Name, nil)
+	if err == nil {
+		if !networkChanged(desired, existing) {
+			log.Infof("Network %s is up to date", def.Name)
+			return nil
+		}
+		if err := checkOwned(networkMethod, def.Name, existing.Labels); err != nil {
+			return err
+		}
+		// A network can't be modified in place, so it is recreated
+		if err := removeNetwork(conn, def.Name); err != nil {
+			return err
+		}
+	}
+
+	if _, err := network.Create(conn, &desired); err != nil {
+		return &utils.PodmanError{Err: utils.WrapErr(err, "Error creating network %s", def.Name)}
+	}
+	log.Infof("Network %s created.", def.Name)
+	return nil
+}
+
+func createNetworkSpec(def NetworkDef) (types.Network, error) {
+	labels := map[string]string{}
+	for k, v := range def.Labels {
+		labels[k] = v
+	}
+	// add a label to signify ownership of fetchit <--> this network
+	labels["owned-by"] = FetchItLabel
+	driver := def.Driver
+	if driver == "" {
+		driver = defaultNetworkDriver
+	}
+	n := types.Network{
+		Name:        def.Name,
+		Driver:      driver,
+		Internal:    def.Internal,
+		IPv6Enabled: def.IPv6,
+		Labels:      labels,
+	}
+	if def.Subnet != "" {
+		subnet, err := types.ParseCIDR(def.Subnet)
+		if err != nil {
+			return n, utils.WrapErr(err, "Invalid subnet %s for network %s", def.Subnet, def.Name)
+		}
+		s := types.Subnet{Subnet: subnet}
+		if def.Gateway != "" {
+			s.Gateway = net.ParseIP(def.Gateway)
+			if s.Gateway == nil {
+				return n, fmt.Errorf("invalid gateway %s for network %s", def.Gateway, def.Name)
+			}
+		}
+		n.Subnets = []types.Subnet{s}
+	} else if def.Gateway != "" {
+		return n, fmt.Errorf("network %s sets a gateway without a subnet", def.Name)
+	}
+	return n, nil
+}
+
+// networkChanged reports whether an existing network differs from the desired
+// one. Subnets are only compared when set in the definition, as podman assigns
+// one otherwise.
+func networkChanged(desired, existing types.Network) bool {
+	if desired.Driver != existing.Driver || desired.Internal != existing.Internal || desired.IPv6Enabled != existing.IPv6Enabled {
+		return true
+	}
+	if len(desired.Subnets) == 0 {
+		return false
+	}
+	if len(existing.Subnets) != len(desired.Subnets) {
+		return true
+	}
+	for i, s := range desired.Subnets {
+		e := existing.Subnets[i]
+		if s.Subnet.String() != e.Subnet.String() {
+			return true
+		}
+		if s.Gateway != nil && !s.Gateway.Equal(e.Gateway) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeNetwork removes a network created by fetchit unless containers are
+// still attached to it, a network that does not exist is already removed
+func removeNetwork(conn context.Context, name string) error {
+	exists, err := network.Exists(conn, name, nil)
+	if err != nil {
+		return &utils.PodmanError{Err: utils.WrapErr(err, "Error checking for network %s", name)}
+	}
+	if !exists {
+		return nil
+	}
+	existing, err := network.Inspect(conn, name, nil)
+	if err != nil {
+		return &utils.PodmanError{Err: utils.WrapErr(err, "Error inspecting network %s", name)}
+	}
+	if err := checkOwned(networkMethod, name, existing.Labels); err != nil {
+		return err
+	}
+	users, err := containers.List(conn, new(containers.ListOptions).WithAll(true).WithFilters(map[string][]string{"network": {name}}))
+	if err != nil {
+		return &utils.PodmanError{Err: utils.WrapErr(err, "Error listing containers attached to network %s", name)}
+	}
+	if err := checkNotInUse(networkMethod, name, users); err != nil {
+		return err
+	}
+	if _, err := network.Remove(conn, name, nil); err != nil {
+		return &utils.PodmanError{Err: utils.WrapErr(err, "Error removing network %s", name)}
+	}
+	logger.Infof("Network %s removed.", name)
+	return nil
+}
+
+func networkDefFromBytes(b []byte) (*NetworkDef, error) {
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 {
+		return nil, errors.New("network file is empty")
+	}
+	def := NetworkDef{}
+	if b[0] == '{' {
+		if err := json.Unmarshal(b, &def); err != nil {
+			return nil, utils.WrapErr(err, "Unable to unmarshal json")
+		}
+	} else {
+		if err := yaml.Unmarshal(b, &def); err != nil {
+			return nil, utils.WrapErr(err, "Unable to unmarshal yaml")
+		}
+	}
+	if def.Name == "" {
+		return nil, errors.New("network file must set Name")
+	}
+	return &def, nil
+}
@@ -0,0 +1,109 @@
+package engine
+
+import (
+	"net"
+	"testing"
+
+	"github.com/containers/common/libnetwork/types"
+	"github.com/containers/fetchit/pkg/engine/utils"
+	"github.com/containers/podman/v4/pkg/domain/entities"
+)
+
+func TestCreateNetworkSpec(t *testing.T) {
+	def, err := networkDefFromBytes([]byte(`
+Name: app-net
+Subnet: 10.89.10.0/24
+Gateway: 10.89.10.1
+Labels:
+  app: colors
+`))
+	if err != nil {
+		t.Fatalf("Failed: unable to parse network file: %v", err)
+	}
+
+	n, err := createNetworkSpec(*def)
+	if err != nil {
+		t.Fatalf("Failed: unexpected error: %v", err)
+	}
+	if n.Name != "app-net" || n.Driver != defaultNetworkDriver {
+		t.Fatalf("Failed: network: %+v", n)
+	}
+	if len(n.Subnets) != 1 || n.Subnets[0].Subnet.String() != "10.89.10.0/24" || n.Subnets[0].Gateway.String() != "10.89.10.1" {
+		t.Fatalf("Failed: subnets: %+v", n.Subnets)
+	}
+	if n.Labels["app"] != "colors" || n.Labels["owned-by"] != FetchItLabel {
+		t.Fatalf("Failed: labels: %v", n.Labels)
+	}
+
+	if _, err := createNetworkSpec(NetworkDef{Name: "app-net", Subnet: "10.89.10.0"}); err == nil {
+		t.Fatalf("Failed: expected error for invalid subnet")
+	}
+	if _, err := createNetworkSpec(NetworkDef{Name: "app-net", Gateway: "10.89.10.1"}); err == nil {
+		t.Fatalf("Failed: expected error for gateway without subnet")
+	}
+	if _, err := networkDefFromBytes([]byte(`{"Driver": "bridge"}`)); err == nil {
+		t.Fatalf("Failed: expected error for network without name")
+	}
+}
+
+func TestNetworkChanged(t *testing.T) {
+	desired, err := createNetworkSpec(NetworkDef{Name: "app-net", Subnet: "10.89.10.0/24", Gateway: "10.89.10.1"})
+	if err != nil {
+		t.Fatalf("Failed: unexpected error: %v", err)
+	}
+	subnet, _ := types.ParseCIDR("10.89.10.0/24")
+	existing := types.Network{
+		Name:    "app-net",
+		Driver:  defaultNetworkDriver,
+		Subnets: []types.Subnet{{Subnet: subnet, Gateway: net.ParseIP("10.89.10.1")}},
+	}
+	if networkChanged(desired, existing) {
+		t.Fatalf("Failed: network should be up to date")
+	}
+
+	existing.Subnets[0].Gateway = net.ParseIP("10.89.10.254")
+	if !networkChanged(desired, existing) {
+		t.Fatalf("Failed: network gateway changed")
+	}
+
+	existing.Subnets[0].Gateway = net.ParseIP("10.89.10.1")
+	existing.Internal = true
+	if !networkChanged(desired, existing) {
+		t.Fatalf("Failed: network internal flag changed")
+	}
+
+	// a subnet assigned by podman is not a change
+	desired, _ = createNetworkSpec(NetworkDef{Name: "app-net"})
+	existing.Internal = false
+	if networkChanged(desired, existing) {
+		t.Fatalf("Failed: network without subnet should be up to date")
+	}
+}
+
+func TestRemoveNetworkInUse(t *testing.T) {
+	err := checkNotInUse(networkMethod, "app-net", []entities.ListContainer{{Names: []string{"colors1"}}})
+	if err == nil {
+		t.Fatalf("Failed: expected error for network in use")
+	}
+	expected := "network app-net is in use by colors1, not removing"
+	if err.Error() != expected {
+		t.Fatalf("Failed: err: %s != %s", err, expected)
+	}
+	if kind := utils.Classify(err); kind != "validation" {
+		t.Fatalf("Failed: kind: %s != validation", kind)
+	}
+}
+
+func TestCheckNetworkOwned(t *testing.T) {
+	n, err := createNetworkSpec(NetworkDef{Name: "app-net"})
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	if err := checkOwned(networkMethod, "app-net", n.Labels); err != nil {
+		t.Fatalf("Failed: unexpected error for a network created by fetchit: %v", err)
+	}
+	err = checkOwned(networkMethod, "podman", map[string]string{})
+	if err == nil || err.Error() != "network podman was not created by fetchit, not removing or recreating it" {
+		t.Fatalf("Failed: expected an error for a network not created by fetchit, got %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package engine
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// methodOrder holds back the first run of each method that sets a priority
+// until the methods with a lower priority have had their first run
+type methodOrder struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	// pending are the priorities of the methods yet to finish their first run
+	pending map[Method]int
+}
+
+// methodPriority returns the priority of a method and whether it sets one
+func methodPriority(m Method) (int, bool) {
+	gm, ok := m.(gitMethod)
+	if !ok || gm.common().Priority == nil {
+		return 0, false
+	}
+	return *gm.common().Priority, true
+}
+
+// newMethodOrder returns the order of the first runs of methods, nil when
+// fewer than two of them set a priority
+func newMethodOrder(methods map[Method]SchedInfo) *methodOrder {
+	o := &methodOrder{pending: map[Method]int{}}
+	o.cond = sync.NewCond(&o.mu)
+	for m := range methods {
+		if p, ok := methodPriority(m); ok {
+			o.pending[m] = p
+		}
+	}
+	if len(o.pending) < 2 {
+		return nil
+	}
+	return o
+}
+
+// holds reports whether the first run of m is yet to be ordered
+func (o *methodOrder) holds(m Method) bool {
+	if o == nil {
+		return false
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	_, ok := o.pending[m]
+	return ok
+}
+
+// wait blocks until no method with a lower priority than m is pending
+func (o *methodOrder) wait(m Method) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for o.blocked(m) {
+		o.cond.Wait()
+	}
+}
+
+// blocked reports whether a method with a lower priority than m is pending,
+// o.mu must be held
+func (o *methodOrder) blocked(m Method) bool {
+	p, ok := o.pending[m]
+	if !ok {
+		return false
+	}
+	for _, other := range o.pending {
+		if other < p {
+			return true
+		}
+	}
+	return false
+}
+
+// done releases the methods waiting on the first run of m, a nil order or a
+// method that is not pending is ignored
+func (o *methodOrder) done(m Method) {
+	if o == nil {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if _, ok := o.pending[m]; !ok {
+		return
+	}
+	delete(o.pending, m)
+	o.cond.Broadcast()
+}
+
+// runInOrder runs a method once the methods with a lower priority have had
+// their first run, whether it succeeded, failed or was skipped
+func runInOrder(order *methodOrder, method Method, gen uint64, ctx, conn context.Context, skew int, timeout time.Duration) {
+	defer order.done(method)
+	order.wait(method)
+	runMethod(method, gen, ctx, conn, skew, timeout)
+}
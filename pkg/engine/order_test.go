@@ -0,0 +1,77 @@
+package engine
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-co-op/gocron"
+	"go.uber.org/zap"
+)
+
+// orderedMethod records its first run in a log shared with other methods
+type orderedMethod struct {
+	busyMethod
+	work time.Duration
+	mu   *sync.Mutex
+	log  *[]string
+	ran  chan struct{}
+}
+
+func (m *orderedMethod) Process(ctx, conn context.Context, skew int) {
+	time.Sleep(m.work)
+	m.mu.Lock()
+	*m.log = append(*m.log, m.Name)
+	m.mu.Unlock()
+	m.ran <- struct{}{}
+}
+
+func TestStartTargetsPriorityOrder(t *testing.T) {
+	defer func(l *zap.SugaredLogger) { logger = l }(logger)
+	logger = zap.NewNop().Sugar()
+
+	f := newFetchit()
+	f.scheduler = gocron.NewScheduler(time.UTC)
+	f.conn = context.Background()
+	target := &Target{name: "deploy"}
+	var mu sync.Mutex
+	var log []string
+	ran := make(chan struct{}, 4)
+	// the methods with a lower priority take longer, so they would finish
+	// last if they were not waited for
+	for i, name := range []string{"image", "raw", "systemd"} {
+		priority := i
+		m := &orderedMethod{work: time.Duration(3-i) * 20 * time.Millisecond, mu: &mu, log: &log, ran: ran}
+		m.Name = name
+		m.Schedule = "0 0 1 1 *"
+		m.Priority = &priority
+		m.target = target
+		f.methodTargetScheds[m] = m.SchedInfo()
+	}
+	// a method without a priority is not held back
+	free := &orderedMethod{mu: &mu, log: &log, ran: ran}
+	free.Name = "free"
+	free.Schedule = "0 0 1 1 *"
+	free.target = target
+	f.methodTargetScheds[free] = free.SchedInfo()
+
+	f.startTargets()
+	defer f.scheduler.Stop()
+	for i := 0; i < 4; i++ {
+		select {
+		case <-ran:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Failed: only %d of the methods ran", i)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if log[0] != "free" {
+		t.Fatalf("Failed: method without a priority waited, runs %q", log)
+	}
+	if want := []string{"image", "raw", "systemd"}; !reflect.DeepEqual(log[1:], want) {
+		t.Fatalf("Failed: methods ran in order %q, expected %q", log[1:], want)
+	}
+}
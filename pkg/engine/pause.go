@@ -0,0 +1,54 @@
+package engine
+
+import (
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+var (
+	// configPaused is set by the paused config option
+	configPaused int32
+	// signalPaused is toggled by SIGUSR1
+	signalPaused int32
+)
+
+// isPaused reports whether methods skip processing, either because the
+// config sets paused or fetchit was paused with SIGUSR1
+func isPaused() bool {
+	return atomic.LoadInt32(&configPaused) == 1 || atomic.LoadInt32(&signalPaused) == 1
+}
+
+func setConfigPaused(paused bool) {
+	var v int32
+	if paused {
+		v = 1
+	}
+	atomic.StoreInt32(&configPaused, v)
+}
+
+// toggleSignalPaused flips the paused state set by SIGUSR1 and returns the new state
+func toggleSignalPaused() bool {
+	for {
+		old := atomic.LoadInt32(&signalPaused)
+		if atomic.CompareAndSwapInt32(&signalPaused, old, 1-old) {
+			return old == 0
+		}
+	}
+}
+
+// watchPauseSignal pauses or resumes all methods each time fetchit receives SIGUSR1
+func watchPauseSignal() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1)
+	go func() {
+		for range sigs {
+			if toggleSignalPaused() {
+				logger.Info("Received SIGUSR1, pausing, methods will skip processing until resumed")
+			} else {
+				logger.Info("Received SIGUSR1, resuming")
+			}
+		}
+	}()
+}
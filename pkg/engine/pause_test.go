@@ -0,0 +1,99 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+func TestPausedSkipsProcess(t *testing.T) {
+	defer func(l *zap.SugaredLogger) { logger = l }(logger)
+	logger = zap.NewNop().Sugar()
+	defer setConfigPaused(false)
+
+	var running, peak int32
+	m := &busyMethod{CommonMethod: CommonMethod{Name: "busy-ex"}, running: &running, peak: &peak}
+
+	setConfigPaused(true)
+	runMethod(m, generation, context.Background(), context.Background(), 0, 0)
+	if peak != 0 {
+		t.Fatalf("Failed: method processed while paused by the config")
+	}
+
+	setConfigPaused(false)
+	if !toggleSignalPaused() {
+		t.Fatalf("Failed: SIGUSR1 did not pause")
+	}
+	runMethod(m, generation, context.Background(), context.Background(), 0, 0)
+	if peak != 0 {
+		t.Fatalf("Failed: method processed while paused by SIGUSR1")
+	}
+
+	if toggleSignalPaused() {
+		t.Fatalf("Failed: SIGUSR1 did not resume")
+	}
+	runMethod(m, generation, context.Background(), context.Background(), 0, 0)
+	if peak != 1 {
+		t.Fatalf("Failed: method did not process once resumed")
+	}
+}
+
+func TestPausedConfigReloads(t *testing.T) {
+	defer func(l *zap.SugaredLogger, p, pending, approve string) {
+		logger, defaultConfigPath, defaultConfigPending, defaultConfigApprove = l, p, pending, approve
+	}(logger, defaultConfigPath, defaultConfigPending, defaultConfigApprove)
+	logger = zap.NewNop().Sugar()
+	defer func(f *Fetchit) { fetchit = f }(fetchit)
+	fetchit = newFetchit()
+	defer setConfigPaused(false)
+
+	resumed := []byte("paused: false\ntargetConfigs: []\n")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(resumed)
+	}))
+	defer srv.Close()
+	dir := t.TempDir()
+	defaultConfigPath = filepath.Join(dir, "config.yaml")
+	defaultConfigPending = filepath.Join(dir, "config-pending.yaml")
+	defaultConfigApprove = filepath.Join(dir, "config-approve")
+	if err := ioutil.WriteFile(defaultConfigPath, []byte("paused: true\ntargetConfigs: []\n"), 0600); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	config, _, err := readConfig(viper.New())
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	setConfigPaused(config.Paused)
+	if !isPaused() {
+		t.Fatalf("Failed: config did not pause")
+	}
+
+	// the approval keeps the reload from restarting, which needs podman
+	c := &ConfigReload{ConfigURL: srv.URL, ManualApprove: true}
+	runMethod(c, generation, context.Background(), context.Background(), 0, 0)
+	b, err := ioutil.ReadFile(defaultConfigPending)
+	if err != nil || !bytes.Equal(b, resumed) {
+		t.Fatalf("Failed: config was not reloaded while paused: %q %v", b, err)
+	}
+
+	// once applied, the reloaded config lifts the pause
+	if err := os.Rename(defaultConfigPending, defaultConfigPath); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	config, _, err = readConfig(viper.New())
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	setConfigPaused(config.Paused)
+	if isPaused() {
+		t.Fatalf("Failed: reloaded config did not lift the pause")
+	}
+}
@@ -0,0 +1,179 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/containers/fetchit/pkg/engine/utils"
+	units "github.com/docker/go-units"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	changeCreate = "create"
+	changeUpdate = "update"
+	changeRename = "rename"
+	changeDelete = "delete"
+)
+
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Show the file changes pending for each method",
+	Long:  `Fetch each target in the config and print as json the files each method would create, update, rename or delete, without applying them`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(runPlan(cmd.OutOrStdout()))
+	},
+}
+
+// gitMethod is a method that applies the files of a git target
+type gitMethod interface {
+	Method
+	common() *CommonMethod
+}
+
+// planEntry is a file change a method would apply
+type planEntry struct {
+	Target     string `json:"target"`
+	Method     string `json:"method"`
+	Name       string `json:"name"`
+	Path       string `json:"path"`
+	ChangeType string `json:"changeType"`
+}
+
+// changeType returns whether a change creates, updates, renames or deletes a file
+func changeType(change *object.Change) string {
+	switch {
+	case change.From.Name == "":
+		return changeCreate
+	case change.To.Name == "":
+		return changeDelete
+	case change.From.Name != change.To.Name:
+		return changeRename
+	default:
+		return changeUpdate
+	}
+}
+
+// planMethod returns the file changes a method would apply to move from current to latest
+func planMethod(m gitMethod, current, latest plumbing.Hash) ([]planEntry, error) {
+	if current == latest {
+		return nil, nil
+	}
+	c := m.common()
+	var entries []planEntry
+	for _, targetPath := range c.GetTargetPaths() {
+		changeMap, err := applyChanges(context.Background(), m.GetTarget(), targetPath, c.Glob, c.Include, c.Exclude, current, latest, c.getTags(m.GetKind()))
+		if err != nil {
+			return nil, err
+		}
+		for _, change := range sortedChanges(changeMap) {
+			entries = append(entries, planEntry{
+				Target:     m.GetTarget().url,
+				Method:     m.GetKind(),
+				Name:       m.GetName(),
+				Path:       path.Join(targetPath, changeName(change)),
+				ChangeType: changeType(change),
+			})
+		}
+	}
+	return entries, nil
+}
+
+// loadTargets reads the local config and sets up the methods of its targets
+// without connecting to podman, for commands that only read the repositories.
+// Logs go to stderr so that the output of the command can be parsed.
+func loadTargets() (*Fetchit, error) {
+	logger = zap.New(zapcore.NewCore(getEncoder(), zapcore.Lock(os.Stderr), zap.WarnLevel)).Sugar()
+	config, _, err := isLocalConfig(viper.New())
+	if err != nil {
+		return nil, err
+	}
+	schedule, err := defaultSchedule(config.DefaultSchedule)
+	if err != nil {
+		return nil, err
+	}
+	for _, tc := range config.TargetConfigs {
+		tc.applyDefaults(config.Defaults, schedule)
+	}
+	cacheDir = config.CacheDir
+	if config.MaxFileSize != "" {
+		if maxFileSize, err = units.RAMInBytes(config.MaxFileSize); err != nil {
+			return nil, fmt.Errorf("invalid maxFileSize %s: %v", config.MaxFileSize, err)
+		}
+	}
+	f := newFetchit()
+	if err := f.setGitAuth(config.GitAuth); err != nil {
+		return nil, err
+	}
+	return getMethodTargetScheds(config.TargetConfigs, f), nil
+}
+
+// runPlan fetches the targets of the config and writes the plan of every
+// method, methods whose plan fails are reported after the others are written
+func runPlan(out io.Writer) error {
+	f, err := loadTargets()
+	if err != nil {
+		return err
+	}
+
+	plan := []planEntry{}
+	errs := &utils.MultiError{}
+	for method := range f.methodTargetScheds {
+		m, ok := method.(gitMethod)
+		target := method.GetTarget()
+		if !ok || target.url == "" {
+			continue
+		}
+		if err := getClone(target); err != nil {
+			errs.Append(utils.WrapErr(err, "Error cloning %s for %s %s", target.url, m.GetKind(), m.GetName()))
+			continue
+		}
+		_, latest, err := fetchBranch(target)
+		if err != nil {
+			errs.Append(utils.WrapErr(err, "Error fetching %s for %s %s", target.url, m.GetKind(), m.GetName()))
+			continue
+		}
+		current, err := getCurrent(target, m.GetKind(), m.GetName())
+		if err != nil {
+			errs.Append(err)
+			continue
+		}
+		entries, err := planMethod(m, current, latest.Hash())
+		if err != nil {
+			errs.Append(utils.WrapErr(err, "Error planning %s %s", m.GetKind(), m.GetName()))
+			continue
+		}
+		plan = append(plan, entries...)
+	}
+	sort.SliceStable(plan, func(i, j int) bool {
+		a, b := plan[i], plan[j]
+		if a.Target != b.Target {
+			return a.Target < b.Target
+		}
+		if a.Method != b.Method {
+			return a.Method < b.Method
+		}
+		return a.Name < b.Name
+	})
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(plan); err != nil {
+		return err
+	}
+	return errs.ErrorOrNil()
+}
+
+func init() {
+	fetchitCmd.AddCommand(planCmd)
+}
@@ -0,0 +1,54 @@
+package engine
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestPlanMethod(t *testing.T) {
+	defer func(l *zap.SugaredLogger, dir string) { logger, cacheDir = l, dir }(logger, cacheDir)
+	logger = zap.NewNop().Sugar()
+	cacheDir = t.TempDir()
+	target := &Target{url: "https://github.com/acme/config.git"}
+
+	r := newTestRepoAt(t, getDirectory(target))
+	current := r.commit(map[string]string{
+		"raw/web.yaml":   "image: nginx\n",
+		"raw/db.yaml":    "image: postgres\n",
+		"raw/cache.yaml": "image: redis\n",
+		"raw/README.md":  "raw containers\n",
+	})
+	latest := r.commit(map[string]string{
+		"raw/web.yaml":      "image: httpd\n",
+		"raw/app.yaml":      "image: fedora\n",
+		"raw/db/main.yaml":  "image: postgres\n",
+		"raw/README.md":     "raw podman containers\n",
+		"kube/nginx.yaml":   "kind: Pod\n",
+		"raw/cache.yaml.bk": "image: redis:7\n",
+	}, "raw/db.yaml", "raw/cache.yaml")
+
+	m := &Raw{CommonMethod: CommonMethod{Name: "apps", TargetPath: "raw/", target: target}}
+	plan, err := planMethod(m, current, latest)
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	expected := []planEntry{
+		{target.url, rawMethod, "apps", "raw/app.yaml", changeCreate},
+		{target.url, rawMethod, "apps", "raw/cache.yaml", changeDelete},
+		{target.url, rawMethod, "apps", "raw/db/main.yaml", changeRename},
+		{target.url, rawMethod, "apps", "raw/web.yaml", changeUpdate},
+	}
+	if len(plan) != len(expected) {
+		t.Fatalf("Failed: expected %d entries, got %+v", len(expected), plan)
+	}
+	for i := range expected {
+		if plan[i] != expected[i] {
+			t.Fatalf("Failed: entry %d is %+v, expected %+v", i, plan[i], expected[i])
+		}
+	}
+
+	if plan, err := planMethod(m, latest, latest); err != nil || len(plan) != 0 {
+		t.Fatalf("Failed: expected an empty plan at the latest commit, got %+v: %v", plan, err)
+	}
+}
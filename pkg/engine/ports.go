@@ -0,0 +1,140 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/containers/common/libnetwork/types"
+	"github.com/containers/fetchit/pkg/engine/utils"
+	"github.com/containers/podman/v4/pkg/bindings/containers"
+)
+
+// portUser is a running container and the host ports it publishes
+type portUser struct {
+	name  string
+	ports []types.PortMapping
+}
+
+// listPortUsers lists the running containers publishing host ports, replaced in tests
+var listPortUsers = func(conn context.Context) ([]portUser, error) {
+	list, err := containers.List(conn, nil)
+	if err != nil {
+		return nil, err
+	}
+	var users []portUser
+	for _, c := range list {
+		if len(c.Ports) == 0 || len(c.Names) == 0 {
+			continue
+		}
+		users = append(users, portUser{name: c.Names[0], ports: c.Ports})
+	}
+	return users, nil
+}
+
+// portProtocols returns the protocols of a port mapping, tcp if unset
+func portProtocols(p types.PortMapping) []string {
+	if p.Protocol == "" {
+		return []string{"tcp"}
+	}
+	return strings.Split(p.Protocol, ",")
+}
+
+// sharesHostIP reports whether two host IPs bind an address in common, an
+// unset or unspecified IP binds every address
+func sharesHostIP(a, b string) bool {
+	all := func(ip string) bool {
+		return ip == "" || ip == "0.0.0.0" || ip == "::"
+	}
+	return all(a) || all(b) || a == b
+}
+
+// portConflict returns the first host port that both mappings publish on
+// the same protocol and address, 0 when they don't overlap
+func portConflict(a, b types.PortMapping) (uint16, string) {
+	if a.HostPort == 0 || b.HostPort == 0 || !sharesHostIP(a.HostIP, b.HostIP) {
+		return 0, ""
+	}
+	last := func(p types.PortMapping) int {
+		if p.Range > 1 {
+			return int(p.HostPort) + int(p.Range) - 1
+		}
+		return int(p.HostPort)
+	}
+	start := int(a.HostPort)
+	if int(b.HostPort) > start {
+		start = int(b.HostPort)
+	}
+	end := last(a)
+	if last(b) < end {
+		end = last(b)
+	}
+	if start > end {
+		return 0, ""
+	}
+	for _, pa := range portProtocols(a) {
+		for _, pb := range portProtocols(b) {
+			if pa == pb {
+				return uint16(start), pa
+			}
+		}
+	}
+	return 0, ""
+}
+
+// portConflicts returns an error naming each host port of the container name
+// that a running container other than those in skip already publishes
+func portConflicts(name string, ports []types.PortMapping, users []portUser, skip ...string) error {
+	skipped := map[string]bool{}
+	for _, s := range skip {
+		skipped[s] = true
+	}
+	errs := &utils.MultiError{}
+	for _, user := range users {
+		if skipped[user.name] {
+			continue
+		}
+		for _, want := range ports {
+			for _, used := range user.ports {
+				if port, proto := portConflict(want, used); port != 0 {
+					errs.Append(fmt.Errorf("host port %d/%s of container %s is already published by container %s", port, proto, name, user.name))
+				}
+			}
+		}
+	}
+	return errs.ErrorOrNil()
+}
+
+// checkHostPorts returns a ValidationError when a host port that the raw
+// container publishes is already published by a running container, other
+// than the ones the deploy replaces
+func checkHostPorts(conn context.Context, raw *RawPod) error {
+	ports := convertPorts(raw.Ports)
+	if len(ports) == 0 || raw.Pod != "" {
+		// the ports of a pod are published by its infra container
+		return nil
+	}
+	users, err := listPortUsers(conn)
+	if err != nil {
+		// the conflict, if any, is reported when the container starts
+		logger.Debugf("Unable to list the ports of running containers: %v", err)
+		return nil
+	}
+	if err := portConflicts(raw.Name, ports, users, raw.Name, raw.Name+greenSuffix); err != nil {
+		return &utils.ValidationError{Err: err}
+	}
+	return nil
+}
+
+// portInUse returns an error naming the host ports of the container when err
+// is podman failing to bind one of them, nil for other errors
+func portInUse(err error, name string, ports []types.PortMapping) error {
+	if err == nil || !strings.Contains(err.Error(), "address already in use") {
+		return nil
+	}
+	published := make([]string, 0, len(ports))
+	for _, p := range ports {
+		published = append(published, fmt.Sprintf("%d/%s", p.HostPort, strings.Join(portProtocols(p), ",")))
+	}
+	return &utils.PodmanError{Err: fmt.Errorf("a host port of container %s, one of %s, is in use by another process: %v", name, strings.Join(published, " "), err)}
+}
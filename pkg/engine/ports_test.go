@@ -0,0 +1,79 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/containers/common/libnetwork/types"
+	"github.com/containers/fetchit/pkg/engine/utils"
+)
+
+func TestPortConflicts(t *testing.T) {
+	users := []portUser{
+		{name: "api", ports: []types.PortMapping{{HostPort: 8080, ContainerPort: 80}}},
+		{name: "dns", ports: []types.PortMapping{{HostPort: 53, ContainerPort: 53, Protocol: "udp"}}},
+		{name: "local", ports: []types.PortMapping{{HostIP: "127.0.0.1", HostPort: 9000, ContainerPort: 9000}}},
+		{name: "range", ports: []types.PortMapping{{HostPort: 7000, ContainerPort: 7000, Range: 10}}},
+		{name: "web", ports: []types.PortMapping{{HostPort: 443, ContainerPort: 443}}},
+	}
+	tests := []struct {
+		name     string
+		port     types.PortMapping
+		conflict string
+	}{
+		{name: "same port", port: types.PortMapping{HostPort: 8080, ContainerPort: 8080}, conflict: "host port 8080/tcp of container web is already published by container api"},
+		{name: "free port", port: types.PortMapping{HostPort: 8081, ContainerPort: 8080}},
+		{name: "random host port", port: types.PortMapping{ContainerPort: 8080}},
+		{name: "other protocol", port: types.PortMapping{HostPort: 53, ContainerPort: 53}},
+		{name: "shared protocol", port: types.PortMapping{HostPort: 53, ContainerPort: 53, Protocol: "tcp,udp"}, conflict: "53/udp"},
+		{name: "other address", port: types.PortMapping{HostIP: "10.0.0.1", HostPort: 9000, ContainerPort: 9000}},
+		{name: "every address", port: types.PortMapping{HostPort: 9000, ContainerPort: 9000}, conflict: "by container local"},
+		{name: "overlapping range", port: types.PortMapping{HostPort: 7005, ContainerPort: 80, Range: 20}, conflict: "host port 7005/tcp"},
+		// the container the deploy replaces is skipped
+		{name: "replaced container", port: types.PortMapping{HostPort: 443, ContainerPort: 443}},
+	}
+	for _, tt := range tests {
+		err := portConflicts("web", []types.PortMapping{tt.port}, users, "web", "web"+greenSuffix)
+		if tt.conflict == "" {
+			if err != nil {
+				t.Fatalf("Failed: %s: unexpected conflict: %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.conflict) {
+			t.Fatalf("Failed: %s: expected conflict %q, got %v", tt.name, tt.conflict, err)
+		}
+	}
+}
+
+func TestCheckHostPorts(t *testing.T) {
+	defer func(l func(context.Context) ([]portUser, error)) { listPortUsers = l }(listPortUsers)
+	listPortUsers = func(conn context.Context) ([]portUser, error) {
+		return []portUser{{name: "api", ports: []types.PortMapping{{HostPort: 8080, ContainerPort: 80}}}}, nil
+	}
+
+	raw := &RawPod{Name: "web", Ports: []port{{HostPort: 8080, ContainerPort: 8080}}}
+	var verr *utils.ValidationError
+	if err := checkHostPorts(context.Background(), raw); !errors.As(err, &verr) {
+		t.Fatalf("Failed: expected a validation error for a port in use, got %v", err)
+	}
+	raw.Ports[0].HostPort = 8081
+	if err := checkHostPorts(context.Background(), raw); err != nil {
+		t.Fatalf("Failed: unexpected error: %v", err)
+	}
+}
+
+func TestPortInUse(t *testing.T) {
+	ports := []types.PortMapping{{HostPort: 8080, ContainerPort: 8080}, {HostPort: 53, ContainerPort: 53, Protocol: "udp"}}
+	bind := errors.New("rootlessport listen tcp 0.0.0.0:8080: bind: address already in use")
+	err := portInUse(bind, "web", ports)
+	var perr *utils.PodmanError
+	if !errors.As(err, &perr) || !strings.Contains(err.Error(), "container web, one of 8080/tcp 53/udp") {
+		t.Fatalf("Failed: unexpected error for a bind failure: %v", err)
+	}
+	if err := portInUse(errors.New("no such image"), "web", ports); err != nil {
+		t.Fatalf("Failed: expected nil for other errors, got %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/containers/fetchit/pkg/engine/utils"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/podman/v4/pkg/bindings/images"
+)
+
+// defaultPullRetryDelay is the wait between attempts of a failed pull
+const defaultPullRetryDelay = 5 * time.Second
+
+var (
+	// pullRetries is how many times a failed pull is retried
+	pullRetries int
+	// pullRetryDelay is the wait between attempts of a failed pull
+	pullRetryDelay = defaultPullRetryDelay
+	// pullMirror is the registry images are pulled from when their own registry fails
+	pullMirror string
+
+	// podmanPull and podmanTag are replaced in tests
+	podmanPull = func(conn context.Context, imageName string) error {
+		_, err := images.Pull(conn, imageName, pullOptions(imageName))
+		return err
+	}
+	podmanTag = images.Tag
+)
+
+// pullImage pulls an image, retrying failed pulls. When every attempt fails and
+// a mirror is set, the image is pulled from the mirror and tagged with its own
+// name, so that it is found by the name it is referenced with.
+func pullImage(conn context.Context, imageName string) error {
+	err := pullWithRetries(conn, imageName)
+	if err == nil || pullMirror == "" {
+		return err
+	}
+	mirrored, repo, tag, merr := mirrorImage(imageName, pullMirror)
+	if merr != nil {
+		return utils.WrapErr(err, "Error pulling %s, unable to pull it from mirror %s: %v", imageName, pullMirror, merr)
+	}
+	logger.Warnf("Pulling %s failed, pulling %s from mirror: %v", imageName, mirrored, err)
+	if merr := pullWithRetries(conn, mirrored); merr != nil {
+		return utils.WrapErr(err, "Error pulling %s, pulling %s from mirror also failed: %v", imageName, mirrored, merr)
+	}
+	// images pinned by digest are found by their digest
+	if tag == "" {
+		return nil
+	}
+	if err := podmanTag(conn, mirrored, tag, repo, nil); err != nil {
+		return utils.WrapErr(err, "Error tagging %s as %s:%s", mirrored, repo, tag)
+	}
+	return nil
+}
+
+// pullWithRetries pulls an image, trying again pullRetries times
+func pullWithRetries(conn context.Context, imageName string) error {
+	var err error
+	for attempt := 0; attempt <= pullRetries; attempt++ {
+		if attempt > 0 {
+			logger.Infof("Retrying pull of %s in %s, attempt %d of %d: %v", imageName, pullRetryDelay, attempt, pullRetries, err)
+			time.Sleep(pullRetryDelay)
+		}
+		if err = podmanPull(conn, imageName); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// mirrorImage returns the reference of an image in the mirror registry, with
+// the repository and tag of the image to tag it with once pulled. The tag is
+// empty for images pinned by digest.
+func mirrorImage(imageName, mirror string) (mirrored, repo, tag string, err error) {
+	named, err := reference.ParseNormalizedNamed(strings.TrimPrefix(imageName, "docker://"))
+	if err != nil {
+		return "", "", "", err
+	}
+	mirrored = strings.TrimSuffix(mirror, "/") + "/" + reference.Path(named)
+	if digested, ok := named.(reference.Digested); ok {
+		return mirrored + "@" + digested.Digest().String(), named.Name(), "", nil
+	}
+	tagged, ok := reference.TagNameOnly(named).(reference.Tagged)
+	if !ok {
+		return "", "", "", fmt.Errorf("unable to find the tag of %s", imageName)
+	}
+	return mirrored + ":" + tagged.Tag(), named.Name(), tagged.Tag(), nil
+}
@@ -0,0 +1,113 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/containers/podman/v4/pkg/bindings/images"
+	"go.uber.org/zap"
+)
+
+// fakePulls replaces the podman pulls and tags, failing the pulls of the
+// images in failing
+func fakePulls(t *testing.T, failing map[string]bool) (pulls, tags *[]string) {
+	pull, tag, l := podmanPull, podmanTag, logger
+	retries, delay, mirror := pullRetries, pullRetryDelay, pullMirror
+	t.Cleanup(func() {
+		podmanPull, podmanTag, logger = pull, tag, l
+		pullRetries, pullRetryDelay, pullMirror = retries, delay, mirror
+	})
+	logger = zap.NewNop().Sugar()
+	pullRetryDelay = 0
+
+	pulls, tags = &[]string{}, &[]string{}
+	podmanPull = func(conn context.Context, imageName string) error {
+		*pulls = append(*pulls, imageName)
+		if failing[imageName] {
+			return errors.New("registry unavailable")
+		}
+		return nil
+	}
+	podmanTag = func(conn context.Context, nameOrID, tag, repo string, options *images.TagOptions) error {
+		*tags = append(*tags, nameOrID+" "+repo+":"+tag)
+		return nil
+	}
+	return pulls, tags
+}
+
+func TestPullImageRetriesExhausted(t *testing.T) {
+	image := "quay.io/fetchit/web:v1"
+	pulls, tags := fakePulls(t, map[string]bool{image: true})
+	pullRetries = 2
+	pullRetryDelay = time.Millisecond
+
+	if err := pullImage(context.Background(), image); err == nil {
+		t.Fatalf("Failed: expected an error once the retries are exhausted")
+	}
+	if expected := []string{image, image, image}; !reflect.DeepEqual(*pulls, expected) {
+		t.Fatalf("Failed: pulls %q, expected %q", *pulls, expected)
+	}
+	if len(*tags) != 0 {
+		t.Fatalf("Failed: unexpected tags %q", *tags)
+	}
+}
+
+func TestPullImageMirrorFallback(t *testing.T) {
+	image := "quay.io/fetchit/web:v1"
+	pulls, tags := fakePulls(t, map[string]bool{image: true})
+	pullRetries = 1
+	pullMirror = "mirror.example.com/"
+
+	if err := pullImage(context.Background(), image); err != nil {
+		t.Fatalf("Failed: unexpected error: %v", err)
+	}
+	expected := []string{image, image, "mirror.example.com/fetchit/web:v1"}
+	if !reflect.DeepEqual(*pulls, expected) {
+		t.Fatalf("Failed: pulls %q, expected %q", *pulls, expected)
+	}
+	if expected := []string{"mirror.example.com/fetchit/web:v1 quay.io/fetchit/web:v1"}; !reflect.DeepEqual(*tags, expected) {
+		t.Fatalf("Failed: tags %q, expected %q", *tags, expected)
+	}
+
+	// a failing mirror keeps the error of the pull
+	pulls, _ = fakePulls(t, map[string]bool{image: true, "mirror.example.com/fetchit/web:v1": true})
+	pullRetries = 0
+	pullMirror = "mirror.example.com"
+	if err := pullImage(context.Background(), image); err == nil {
+		t.Fatalf("Failed: expected an error when the mirror fails")
+	}
+	if len(*pulls) != 2 {
+		t.Fatalf("Failed: pulls %q, expected one pull of the image and of the mirror", *pulls)
+	}
+}
+
+func TestMirrorImage(t *testing.T) {
+	tests := []struct {
+		image, mirrored, repo, tag string
+	}{
+		{"quay.io/fetchit/web:v1", "mirror.example.com/fetchit/web:v1", "quay.io/fetchit/web", "v1"},
+		{"docker.io/library/nginx", "mirror.example.com/library/nginx:latest", "docker.io/library/nginx", "latest"},
+		{"nginx", "mirror.example.com/library/nginx:latest", "docker.io/library/nginx", "latest"},
+		{
+			"quay.io/fetchit/web@sha256:0000000000000000000000000000000000000000000000000000000000000000",
+			"mirror.example.com/fetchit/web@sha256:0000000000000000000000000000000000000000000000000000000000000000",
+			"quay.io/fetchit/web",
+			"",
+		},
+	}
+	for _, tt := range tests {
+		mirrored, repo, tag, err := mirrorImage(tt.image, "mirror.example.com")
+		if err != nil {
+			t.Fatalf("Failed: %s: %v", tt.image, err)
+		}
+		if mirrored != tt.mirrored || repo != tt.repo || tag != tt.tag {
+			t.Fatalf("Failed: %s: got %s %s %s, expected %s %s %s", tt.image, mirrored, repo, tag, tt.mirrored, tt.repo, tt.tag)
+		}
+	}
+	if _, _, _, err := mirrorImage("Invalid Image", "mirror.example.com"); err == nil {
+		t.Fatalf("Failed: expected an error for an invalid image")
+	}
+}
@@ -4,13 +4,29 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"io/ioutil"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/containers/common/libnetwork/types"
 	"github.com/containers/fetchit/pkg/engine/utils"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/podman/v4/libpod/define"
+	"github.com/containers/podman/v4/pkg/bindings"
 	"github.com/containers/podman/v4/pkg/bindings/containers"
+	"github.com/containers/podman/v4/pkg/bindings/pods"
+	"github.com/containers/podman/v4/pkg/domain/entities"
+	"github.com/containers/podman/v4/pkg/namespaces"
+	"github.com/containers/podman/v4/pkg/signal"
 	"github.com/containers/podman/v4/pkg/specgen"
+	"github.com/containers/podman/v4/pkg/util"
+	units "github.com/docker/go-units"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/opencontainers/runtime-spec/specs-go"
@@ -20,6 +36,14 @@ import (
 const (
 	rawMethod    = "raw"
 	FetchItLabel = "fetchit"
+	// ownerLabel records which target and method deployed a raw container
+	ownerLabel = "fetchit-owner"
+	// failedSuffix names a failed raw container kept with keepFailed
+	failedSuffix = "-failed"
+	// cpuPeriod is the CFS period CPU limits are given in, in microseconds
+	cpuPeriod = 100000
+	// healthCheckInterval is how often the HealthCmd of a container is run
+	healthCheckInterval = 30 * time.Second
 )
 
 // Raw to deploy pods from json or yaml files
@@ -27,6 +51,14 @@ type Raw struct {
 	CommonMethod `mapstructure:",squash"`
 	// Pull images configured in target files each time regardless of if it already exists
 	PullImage bool `mapstructure:"pullImage"`
+	// WatchDigest redeploys containers when their image tag resolves to a new digest in the registry
+	WatchDigest bool `mapstructure:"watchDigest"`
+	Templating  `mapstructure:",squash"`
+	// BlueGreen starts a changed container next to the running one and only
+	// replaces the running container once the new one is healthy
+	BlueGreen bool `mapstructure:"blueGreen"`
+	// HealthTimeout is how long a blue/green container has to become healthy
+	HealthTimeout time.Duration `mapstructure:"healthTimeout"`
 }
 
 func (r *Raw) GetKind() string {
@@ -78,97 +110,251 @@ type RawPod struct {
 	Volumes []namedVolume     `json:"Volumes" yaml:"Volumes"`
 	CapAdd  []string          `json:"CapAdd" yaml:"CapAdd"`
 	CapDrop []string          `json:"CapDrop" yaml:"CapDrop"`
+	// Pod is the name of a pod to run the container in. The pod is created
+	// if it does not exist and removed once its last member is deleted.
+	Pod string `json:"Pod" yaml:"Pod"`
+	// Sysctls are kernel parameters to set in the container, e.g. net.core.somaxconn: "1024"
+	Sysctls map[string]string `json:"Sysctls" yaml:"Sysctls"`
+	// Ulimits are given as name=soft[:hard], e.g. nofile=1024:2048
+	Ulimits []string `json:"Ulimits" yaml:"Ulimits"`
+	// Tmpfs mounts are given as destination[:options], e.g. /run:rw,size=64m
+	Tmpfs []string `json:"Tmpfs" yaml:"Tmpfs"`
+	// DNSServers are the IP addresses of nameservers for the container
+	DNSServers []string `json:"DNSServers" yaml:"DNSServers"`
+	// DNSSearch are the search domains for the container
+	DNSSearch []string `json:"DNSSearch" yaml:"DNSSearch"`
+	// HostAdd are extra /etc/hosts entries given as host:ip
+	HostAdd []string `json:"HostAdd" yaml:"HostAdd"`
+	// DependsOn are the names of containers that are started before this one
+	DependsOn []string `json:"DependsOn" yaml:"DependsOn"`
+	// CDIDevices are fully qualified CDI device names given as vendor/class=name, e.g. nvidia.com/gpu=all
+	CDIDevices []string `json:"CDIDevices" yaml:"CDIDevices"`
+	// Memory is the memory limit of the container, e.g. 512m
+	Memory string `json:"Memory" yaml:"Memory"`
+	// CPUs is the number of CPUs the container may use, e.g. 1.5
+	CPUs float64 `json:"CPUs" yaml:"CPUs"`
+	// HealthCmd is a shell command that exits 0 when the container is healthy
+	HealthCmd string `json:"HealthCmd" yaml:"HealthCmd"`
+	// UserNS is the user namespace of the container as given to podman run --userns,
+	// e.g. auto, keep-id or private, the host's namespace is used if unset
+	UserNS string `json:"UserNS" yaml:"UserNS"`
+	// UIDMap and GIDMap map ids of the container to the host as container:host:size,
+	// e.g. 0:100000:65536. Mappings without a UserNS run in a private namespace.
+	UIDMap []string `json:"UIDMap" yaml:"UIDMap"`
+	GIDMap []string `json:"GIDMap" yaml:"GIDMap"`
+	// StopSignal is the signal that stops the container, e.g. SIGINT, SIGTERM if unset
+	StopSignal string `json:"StopSignal" yaml:"StopSignal"`
+	// StopTimeout is the number of seconds to wait for the container to stop
+	// before it is killed, podman's default of 10 if unset
+	StopTimeout *uint `json:"StopTimeout" yaml:"StopTimeout"`
 }
 
 func (r *Raw) Process(ctx context.Context, conn context.Context, skew int) {
+	log := methodLogger(r)
 	time.Sleep(time.Duration(skew) * time.Millisecond)
 	target := r.GetTarget()
 	target.mu.Lock()
 	defer target.mu.Unlock()
 
-	tag := []string{".json", ".yaml", ".yml"}
+	tags := r.getTags(rawMethod)
 
 	if r.initialRun {
 		err := getRepo(target)
 		if err != nil {
-			logger.Errorf("Failed to clone repository %s: %v", target.url, err)
+			log.Errorf("Failed to clone repository %s: %v", target.url, err)
 			return
 		}
 
-		err = zeroToCurrent(ctx, conn, r, target, &tag)
+		err = zeroToCurrent(ctx, conn, r, target, tags)
 		if err != nil {
-			logger.Errorf("Error moving to current: %v", err)
+			log.Errorf("Error moving to current: %v", err)
 			return
 		}
 	}
 
-	err := currentToLatest(ctx, conn, r, target, &tag)
+	err := currentToLatest(ctx, conn, r, target, tags)
 	if err != nil {
-		logger.Errorf("Error moving current to latest: %v", err)
+		log.Errorf("Error moving current to latest: %v", err)
 		return
 	}
 
+	if r.WatchDigest {
+		if err := r.redeployUpdatedImages(ctx, conn, tags); err != nil {
+			log.Errorf("Error redeploying updated images: %v", err)
+		}
+	}
+
 	r.initialRun = false
 }
 
-func (r *Raw) rawPodman(ctx, conn context.Context, path string, prev *string) error {
+func (r *Raw) rawPodman(ctx, conn context.Context, path string, rawFile []byte, prev *string) error {
+	log := methodLogger(r)
+	var raws []*RawPod
+	if path != deleteFile {
+		log.Infof("Creating podman container from %s", path)
 
-	logger.Infof("Creating podman container from %s", path)
+		var err error
+		raws, err = r.parseRawPods(conn, path, rawFile)
+		if err != nil {
+			return err
+		}
+		r.overrideRawImages(raws)
+		raws, err = orderRawPods(raws)
+		if err != nil {
+			return &utils.ValidationError{Err: err}
+		}
 
-	rawFile, err := ioutil.ReadFile(path)
-	if err != nil {
-		return err
-	}
+		log.Infof("Identifying if image exists locally")
 
-	raw, err := rawPodFromBytes(rawFile)
-	if err != nil {
-		return err
+		for _, raw := range raws {
+			err = detectOrFetchImage(conn, raw.Image, r.PullImage)
+			if err != nil {
+				return err
+			}
+		}
 	}
 
-	logger.Infof("Identifying if image exists locally")
-
-	err = detectOrFetchImage(conn, raw.Image, r.PullImage)
-	if err != nil {
-		return err
+	// containers whose only change is their resource limits are updated in place
+	updates := map[string]bool{}
+	next := map[string]*RawPod{}
+	for _, raw := range raws {
+		next[raw.Name] = raw
 	}
 
 	// Delete previous file's podxz
 	if prev != nil {
-		raw, err := rawPodFromBytes([]byte(*prev))
+		prevRaws, err := r.parseRawPods(conn, path, []byte(*prev))
 		if err != nil {
 			return err
 		}
 
-		err = deleteContainer(conn, raw.Name)
-		if err != nil {
-			return err
+		for _, raw := range prevRaws {
+			// blue/green containers are replaced once their successor is healthy
+			if _, ok := next[raw.Name]; ok && r.BlueGreen {
+				continue
+			}
+			if n, ok := next[raw.Name]; ok && onlyResourcesChanged(raw, n) {
+				updates[raw.Name] = true
+				continue
+			}
+			stopped := raw
+			if n, ok := next[raw.Name]; ok && n.StopTimeout != nil {
+				stopped = n
+			}
+			// the container may have been taken over by another method since
+			err = removeExisting(conn, raw.Name, r.containerName(), stopOptions(stopped))
+			if err != nil {
+				return err
+			}
+
+			log.Infof("Deleted podman container %s", raw.Name)
+
+			if raw.Pod != "" {
+				if err := removePodIfEmpty(conn, raw.Pod); err != nil {
+					return err
+				}
+			}
 		}
-
-		logger.Infof("Deleted podman container %s", raw.Name)
 	}
 
 	if path == deleteFile {
 		return nil
 	}
 
-	err = removeExisting(conn, raw.Name)
+	for _, raw := range raws {
+		if updates[raw.Name] {
+			err := updateContainer(conn, raw)
+			if err == nil {
+				log.Infof("Container %s resources updated in place", raw.Name)
+				continue
+			}
+			log.Warnf("Unable to update container %s in place, recreating it: %v", raw.Name, err)
+		}
+		if err := r.createContainer(conn, raw); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *Raw) createContainer(conn context.Context, raw *RawPod) error {
+	log := methodLogger(r)
+	// a conflict is found before the running container is removed
+	if err := checkHostPorts(conn, raw); err != nil {
+		return err
+	}
+	if r.BlueGreen {
+		return r.blueGreenContainer(conn, raw)
+	}
+	err := removeExisting(conn, raw.Name, r.containerName(), stopOptions(raw))
 	if err != nil {
 		return err
 	}
 
-	s := createSpecGen(*raw)
+	if raw.Pod != "" {
+		if err := createPodIfAbsent(conn, *raw); err != nil {
+			return err
+		}
+	}
+
+	s, err := createSpecGen(*raw)
+	if err != nil {
+		return &utils.ValidationError{Err: err}
+	}
+	s.Labels[ownerLabel] = r.containerName()
 
 	createResponse, err := containers.CreateWithSpec(conn, s, nil)
 	if err != nil {
-		return err
+		if perr := portInUse(err, s.Name, s.PortMappings); perr != nil {
+			return perr
+		}
+		return &utils.PodmanError{Err: utils.WrapErr(err, "Error creating container %s", s.Name)}
 	}
-	logger.Infof("Container %s created.", s.Name)
+	log.Infof("Container %s created.", s.Name)
 
 	if err := containers.Start(conn, createResponse.ID, nil); err != nil {
-		return err
+		if perr := portInUse(err, s.Name, s.PortMappings); perr != nil {
+			return perr
+		}
+		return &utils.PodmanError{Err: utils.WrapErr(err, "Error starting container %s", s.Name)}
+	}
+	log.Infof("Container %s started....Requeuing", s.Name)
+
+	return nil
+}
+
+// blueGreenContainer deploys a raw container without stopping the running
+// container until its replacement is healthy
+func (r *Raw) blueGreenContainer(conn context.Context, raw *RawPod) error {
+	log := methodLogger(r)
+	inspectData, err := containers.Inspect(conn, raw.Name, nil)
+	if err == nil && inspectData != nil && inspectData.Config != nil {
+		if err := checkOwner(raw.Name, inspectData.Config.Labels, r.containerName()); err != nil {
+			return err
+		}
+	}
+
+	if raw.Pod != "" {
+		if err := createPodIfAbsent(conn, *raw); err != nil {
+			return err
+		}
+	}
+
+	s, err := createSpecGen(*raw)
+	if err != nil {
+		return &utils.ValidationError{Err: err}
 	}
-	logger.Infof("Container %s started....Requeuing", s.Name)
+	s.Labels[ownerLabel] = r.containerName()
 
+	timeout := r.HealthTimeout
+	if timeout == 0 {
+		timeout = defaultHealthTimeout
+	}
+	if err := blueGreenDeploy(podmanContainerOps(conn, timeout), s); err != nil {
+		return err
+	}
+	log.Infof("Container %s started....Requeuing", s.Name)
 	return nil
 }
 
@@ -177,15 +363,26 @@ func (r *Raw) MethodEngine(ctx context.Context, conn context.Context, change *ob
 	if err != nil {
 		return err
 	}
-	return r.rawPodman(ctx, conn, path, prev)
+	rawFile, err := getChangeContents(change, path)
+	if err != nil {
+		return err
+	}
+	return r.rawPodman(ctx, conn, path, rawFile, prev)
 }
 
 func (r *Raw) Apply(ctx, conn context.Context, currentState, desiredState plumbing.Hash, tags *[]string) error {
-	changeMap, err := applyChanges(ctx, r.GetTarget(), r.GetTargetPath(), r.Glob, currentState, desiredState, tags)
+	changeMap, err := r.targetChanges(ctx, currentState, desiredState, tags)
 	if err != nil {
 		return err
 	}
-	if err := runChanges(ctx, conn, r, changeMap); err != nil {
+	r.resetFacts()
+	order, err := rawChangeOrder(changeMap, func(name string, b []byte) ([]*RawPod, error) {
+		return r.parseRawPods(conn, name, b)
+	})
+	if err != nil {
+		return &utils.ValidationError{Err: err}
+	}
+	if err := runChangesInOrder(ctx, conn, r, changeMap, order); err != nil {
 		return err
 	}
 	return nil
@@ -233,65 +430,434 @@ func convertVolumes(namedVolumes []namedVolume) []*specgen.NamedVolume {
 	return result
 }
 
-func createSpecGen(raw RawPod) *specgen.SpecGenerator {
+func convertUlimits(ulimits []string) ([]specs.POSIXRlimit, error) {
+	result := []specs.POSIXRlimit{}
+	for _, u := range ulimits {
+		ulimit, err := units.ParseUlimit(u)
+		if err != nil {
+			return nil, utils.WrapErr(err, "Error parsing ulimit %s", u)
+		}
+		toAppend := specs.POSIXRlimit{
+			Type: "RLIMIT_" + strings.ToUpper(ulimit.Name),
+			Hard: uint64(ulimit.Hard),
+			Soft: uint64(ulimit.Soft),
+		}
+		result = append(result, toAppend)
+	}
+	return result, nil
+}
+
+// setUserNS sets the user namespace of a container and its id mappings, a
+// container without them keeps the user namespace of the host
+func setUserNS(s *specgen.SpecGenerator, raw RawPod) error {
+	if raw.UserNS == "" && len(raw.UIDMap) == 0 && len(raw.GIDMap) == 0 {
+		return nil
+	}
+	userNS := raw.UserNS
+	if userNS == "" {
+		userNS = "private"
+	}
+	ns, err := specgen.ParseUserNamespace(userNS)
+	if err != nil {
+		return utils.WrapErr(err, "Error parsing user namespace %s", userNS)
+	}
+	mappings, err := util.ParseIDMapping(namespaces.UsernsMode(userNS), raw.UIDMap, raw.GIDMap, "", "")
+	if err != nil {
+		return utils.WrapErr(err, "Error parsing id mappings of container %s", raw.Name)
+	}
+	if ns.NSMode == specgen.Private && len(mappings.UIDMap) == 0 {
+		return fmt.Errorf("container %s needs a UIDMap or GIDMap with a private user namespace", raw.Name)
+	}
+	s.UserNS = ns
+	s.IDMappings = mappings
+	return nil
+}
+
+func convertTmpfs(tmpfs []string) []specs.Mount {
+	result := []specs.Mount{}
+	for _, t := range tmpfs {
+		parts := strings.SplitN(t, ":", 2)
+		toAppend := specs.Mount{
+			Destination: parts[0],
+			Type:        "tmpfs",
+			Source:      "tmpfs",
+		}
+		if len(parts) == 2 && parts[1] != "" {
+			toAppend.Options = strings.Split(parts[1], ",")
+		}
+		result = append(result, toAppend)
+	}
+	return result
+}
+
+func convertDNSServers(servers []string) ([]net.IP, error) {
+	result := []net.IP{}
+	for _, server := range servers {
+		ip := net.ParseIP(server)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid DNS server address %s", server)
+		}
+		result = append(result, ip)
+	}
+	return result, nil
+}
+
+// cdiDeviceName matches a fully qualified CDI device name, vendor/class=name
+var cdiDeviceName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*/[a-zA-Z0-9][a-zA-Z0-9_-]*=[a-zA-Z0-9][a-zA-Z0-9._:-]*$`)
+
+// convertCDIDevices returns CDI devices as spec devices, which podman resolves
+// through the CDI specs on the host when the container is created
+func convertCDIDevices(devices []string) ([]specs.LinuxDevice, error) {
+	result := []specs.LinuxDevice{}
+	for _, d := range devices {
+		if !cdiDeviceName.MatchString(d) {
+			return nil, fmt.Errorf("invalid CDI device %s, must be vendor/class=name", d)
+		}
+		result = append(result, specs.LinuxDevice{Path: d})
+	}
+	return result, nil
+}
+
+// convertResources returns the memory and CPU limits of a raw container, nil
+// when it has none
+func convertResources(raw RawPod) (*specs.LinuxResources, error) {
+	if raw.Memory == "" && raw.CPUs == 0 {
+		return nil, nil
+	}
+	resources := &specs.LinuxResources{}
+	if raw.Memory != "" {
+		limit, err := units.RAMInBytes(raw.Memory)
+		if err != nil || limit <= 0 {
+			return nil, fmt.Errorf("invalid memory limit %s", raw.Memory)
+		}
+		resources.Memory = &specs.LinuxMemory{Limit: &limit}
+	}
+	if raw.CPUs < 0 {
+		return nil, fmt.Errorf("invalid CPUs %v, must not be negative", raw.CPUs)
+	}
+	if raw.CPUs > 0 {
+		period := uint64(cpuPeriod)
+		quota := int64(raw.CPUs * cpuPeriod)
+		resources.CPU = &specs.LinuxCPU{Period: &period, Quota: &quota}
+	}
+	return resources, nil
+}
+
+// onlyResourcesChanged reports whether the only difference between two
+// definitions of a container is limits that podman can update on the running
+// container. Removing a limit requires the container to be recreated.
+func onlyResourcesChanged(prev, next *RawPod) bool {
+	if (prev.Memory != "" && next.Memory == "") || (prev.CPUs != 0 && next.CPUs == 0) {
+		return false
+	}
+	if prev.Memory == next.Memory && prev.CPUs == next.CPUs {
+		return false
+	}
+	p, n := *prev, *next
+	p.Memory, p.CPUs = "", 0
+	n.Memory, n.CPUs = "", 0
+	return reflect.DeepEqual(p, n)
+}
+
+// updateContainer applies the resource limits of a raw container to the
+// running container. The bindings predate podman update, so the API is called
+// directly; podman before 4.3 returns an error.
+func updateContainer(conn context.Context, raw *RawPod) error {
+	resources, err := convertResources(*raw)
+	if err != nil {
+		return &utils.ValidationError{Err: err}
+	}
+	body, err := json.Marshal(resources)
+	if err != nil {
+		return err
+	}
+	client, err := bindings.GetClient(conn)
+	if err != nil {
+		return utils.WrapErr(err, "Error getting podman connection")
+	}
+	response, err := client.DoRequest(conn, bytes.NewReader(body), http.MethodPost, "/containers/%s/update", nil, nil, raw.Name)
+	if err != nil {
+		return &utils.PodmanError{Err: utils.WrapErr(err, "Error updating container %s", raw.Name)}
+	}
+	defer response.Body.Close()
+	if err := response.Process(nil); err != nil {
+		return &utils.PodmanError{Err: utils.WrapErr(err, "Error updating container %s", raw.Name)}
+	}
+	return nil
+}
+
+func createSpecGen(raw RawPod) (*specgen.SpecGenerator, error) {
+	rlimits, err := convertUlimits(raw.Ulimits)
+	if err != nil {
+		return nil, err
+	}
+	dnsServers, err := convertDNSServers(raw.DNSServers)
+	if err != nil {
+		return nil, err
+	}
+	devices, err := convertCDIDevices(raw.CDIDevices)
+	if err != nil {
+		return nil, err
+	}
+	resources, err := convertResources(raw)
+	if err != nil {
+		return nil, err
+	}
+
 	// Create a new container
 	s := specgen.NewSpecGenerator(raw.Image, false)
 	s.Name = raw.Name
 	s.Env = map[string]string(raw.Env)
-	s.Mounts = convertMounts(raw.Mounts)
-	s.PortMappings = convertPorts(raw.Ports)
+	s.Mounts = append(convertMounts(raw.Mounts), convertTmpfs(raw.Tmpfs)...)
+	// Ports of pod members are published by the pod when it is created
+	if raw.Pod == "" {
+		s.PortMappings = convertPorts(raw.Ports)
+	}
+	s.Pod = raw.Pod
 	s.Volumes = convertVolumes(raw.Volumes)
 	s.CapAdd = []string(raw.CapAdd)
 	s.CapDrop = []string(raw.CapDrop)
+	s.Sysctl = raw.Sysctls
+	s.Rlimits = rlimits
+	s.DNSServers = dnsServers
+	s.DNSSearch = raw.DNSSearch
+	s.HostAdd = raw.HostAdd
+	s.Devices = devices
+	s.ResourceLimits = resources
+	if err := setUserNS(s, raw); err != nil {
+		return nil, err
+	}
+	if raw.StopSignal != "" {
+		sig, err := signal.ParseSignalNameOrNumber(raw.StopSignal)
+		if err != nil {
+			return nil, utils.WrapErr(err, "Error parsing stop signal %s", raw.StopSignal)
+		}
+		s.StopSignal = &sig
+	}
+	s.StopTimeout = raw.StopTimeout
+	if raw.HealthCmd != "" {
+		s.HealthConfig = &manifest.Schema2HealthConfig{
+			Test:     []string{"CMD-SHELL", raw.HealthCmd},
+			Interval: healthCheckInterval,
+			Timeout:  healthCheckInterval,
+			Retries:  3,
+		}
+	}
 	s.RestartPolicy = "always"
 	// add a label to signify ownership of fetchit <--> this container
 	s.Labels = map[string]string{
 		"owned-by": FetchItLabel,
 	}
-	return s
+	return s, nil
+}
+
+func createPodSpec(raw RawPod) *entities.PodSpec {
+	p := specgen.NewPodSpecGenerator()
+	p.Name = raw.Pod
+	p.PortMappings = convertPorts(raw.Ports)
+	p.Labels = map[string]string{
+		"owned-by": FetchItLabel,
+	}
+	return &entities.PodSpec{PodSpecGen: *p}
+}
+
+// createPodIfAbsent creates the pod a raw container is a member of. Ports
+// can only be published when a pod is created, so only the ports of the
+// first member deployed are used.
+func createPodIfAbsent(conn context.Context, raw RawPod) error {
+	exists, err := pods.Exists(conn, raw.Pod, nil)
+	if err != nil {
+		return utils.WrapErr(err, "Error checking for pod %s", raw.Pod)
+	}
+	if exists {
+		if len(raw.Ports) > 0 {
+			logger.Infof("Pod %s already exists, ports of container %s will not be published", raw.Pod, raw.Name)
+		}
+		return nil
+	}
+
+	if _, err := pods.CreatePodFromSpec(conn, createPodSpec(raw)); err != nil {
+		return utils.WrapErr(err, "Error creating pod %s", raw.Pod)
+	}
+	logger.Infof("Pod %s created.", raw.Pod)
+	return nil
+}
+
+// removePodIfEmpty removes a pod created by fetchit once no containers other
+// than its infra container remain
+func removePodIfEmpty(conn context.Context, podName string) error {
+	report, err := pods.Inspect(conn, podName, nil)
+	if err != nil {
+		return utils.WrapErr(err, "Error inspecting pod %s", podName)
+	}
+	if !podRemovable(report.InspectPodData) {
+		return nil
+	}
+
+	if _, err := pods.Remove(conn, podName, new(pods.RemoveOptions).WithForce(true)); err != nil {
+		return utils.WrapErr(err, "Error removing pod %s", podName)
+	}
+	logger.Infof("Removed pod %s, no members remain", podName)
+	return nil
+}
+
+// podRemovable reports whether a pod was created by fetchit and has no
+// containers other than its infra container left. A pod shared by name with
+// one fetchit did not create is never removed.
+func podRemovable(pod *define.InspectPodData) bool {
+	if checkOwned("pod", pod.Name, pod.Labels) != nil {
+		return false
+	}
+	for _, c := range pod.Containers {
+		if c.ID != pod.InfraContainerID {
+			return false
+		}
+	}
+	return true
 }
 
-func deleteContainer(conn context.Context, podName string) error {
-	err := containers.Stop(conn, podName, nil)
+// stopOptions returns the options to stop the container of a raw file with.
+// The stop signal is set on the container when it is created.
+func stopOptions(raw *RawPod) *containers.StopOptions {
+	if raw.StopTimeout == nil {
+		return nil
+	}
+	return new(containers.StopOptions).WithTimeout(*raw.StopTimeout)
+}
+
+func deleteContainer(conn context.Context, podName string, opts *containers.StopOptions) error {
+	err := containers.Stop(conn, podName, opts)
 	if err != nil {
 		return err
 	}
 
-	containers.Remove(conn, podName, new(containers.RemoveOptions).WithForce(true))
+	reports, err := containers.Remove(conn, podName, new(containers.RemoveOptions).WithForce(true))
 	if err != nil {
 		return err
 	}
+	for _, report := range reports {
+		if report.Err != nil {
+			return report.Err
+		}
+	}
 
 	return nil
 }
 
-func rawPodFromBytes(b []byte) (*RawPod, error) {
+// parseRawPods renders a raw file when templating is enabled and parses it,
+// templated container names are resolved either way
+func (r *Raw) parseRawPods(conn context.Context, name string, b []byte) ([]*RawPod, error) {
+	b, err := r.render(conn, name, b)
+	if err != nil {
+		return nil, err
+	}
+	raws, err := rawPodsFromBytes(b)
+	if err != nil {
+		return nil, &utils.ValidationError{Err: err}
+	}
+	if err := r.renderNames(conn, name, raws); err != nil {
+		return nil, err
+	}
+	return raws, nil
+}
+
+// rawPodsFromBytes parses a raw file into the containers it defines. A json
+// file holds a single container, a yaml file may hold several containers as
+// documents separated by ---
+func rawPodsFromBytes(b []byte) ([]*RawPod, error) {
 	b = bytes.TrimSpace(b)
-	raw := RawPod{}
+	if len(b) == 0 {
+		return nil, errors.New("raw file is empty")
+	}
 	if b[0] == '{' {
+		raw := RawPod{}
 		err := json.Unmarshal(b, &raw)
 		if err != nil {
 			return nil, utils.WrapErr(err, "Unable to unmarshal json")
 		}
-	} else {
-		err := yaml.Unmarshal(b, &raw)
+		return []*RawPod{&raw}, nil
+	}
+
+	var raws []*RawPod
+	d := yaml.NewDecoder(bytes.NewReader(b))
+	for {
+		raw := RawPod{}
+		err := d.Decode(&raw)
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
 			return nil, utils.WrapErr(err, "Unable to unmarshal yaml")
 		}
+		// skip empty documents
+		if raw.Name == "" && raw.Image == "" {
+			continue
+		}
+		raws = append(raws, &raw)
 	}
-	return &raw, nil
+	return raws, nil
 }
 
 // Using this might not be necessary
-func removeExisting(conn context.Context, podName string) error {
+func removeExisting(conn context.Context, podName, owner string, opts *containers.StopOptions) error {
 	inspectData, err := containers.Inspect(conn, podName, new(containers.InspectOptions).WithSize(true))
+	if err == nil && inspectData != nil && inspectData.Config != nil {
+		if err := checkOwner(podName, inspectData.Config.Labels, owner); err != nil {
+			return err
+		}
+	}
 	if err == nil || inspectData == nil {
 		logger.Infof("A container named %s already exists. Removing the container before redeploy.", podName)
-		err := deleteContainer(conn, podName)
-		if err != nil {
+		ops := podmanContainerOps(conn, 0)
+		ops.remove = func(name string) error {
+			return deleteContainer(conn, name, opts)
+		}
+		failed := inspectData != nil && containerFailed(inspectData.State)
+		if err := retireContainer(ops, podName, failed); err != nil {
 			return err
 		}
 	}
 
 	return nil
 }
+
+// containerFailed reports whether a container exited non-zero or is being
+// restarted after exiting
+func containerFailed(state *define.InspectContainerState) bool {
+	return state != nil && (state.Restarting || (!state.Running && state.ExitCode != 0))
+}
+
+// retireContainer removes a container before it is replaced. With keepFailed
+// a failed container is stopped and kept as <name>-failed for inspection
+// instead. A container kept by an earlier run is removed, either as the
+// container that replaced it did not fail or to keep the newer failure.
+func retireContainer(ops *containerOps, name string, failed bool) error {
+	kept := name + failedSuffix
+	if exists, err := ops.exists(kept); err == nil && exists {
+		if err := ops.remove(kept); err != nil {
+			return &utils.PodmanError{Err: utils.WrapErr(err, "Error removing failed container %s", kept)}
+		}
+	}
+	if !failed || !keepFailed() {
+		return ops.remove(name)
+	}
+	if err := ops.stop(name); err != nil {
+		return &utils.PodmanError{Err: utils.WrapErr(err, "Error stopping failed container %s", name)}
+	}
+	if err := ops.rename(name, kept); err != nil {
+		return &utils.PodmanError{Err: utils.WrapErr(err, "Error renaming failed container %s to %s", name, kept)}
+	}
+	logger.Warnf("Container %s failed, kept as %s for inspection", name, kept)
+	return nil
+}
+
+// checkOwner returns an error if a container is labeled as deployed by a
+// different target or method. Containers without the label are assumed to
+// predate it and may be replaced.
+func checkOwner(podName string, labels map[string]string, owner string) error {
+	existing, ok := labels[ownerLabel]
+	if !ok || existing == owner {
+		return nil
+	}
+	return fmt.Errorf("container %s is already managed by %s, raw container names must be unique across all targets", podName, existing)
+}
@@ -0,0 +1,389 @@
+package engine
+
+import (
+	"reflect"
+	"syscall"
+	"testing"
+
+	"github.com/containers/podman/v4/libpod/define"
+	"github.com/containers/podman/v4/pkg/specgen"
+	"go.uber.org/zap"
+)
+
+func TestCreateSpecGenPodMembership(t *testing.T) {
+	raws, err := rawPodsFromBytes([]byte(`{
+    "Image": "docker.io/library/nginx:latest",
+    "Name": "web",
+    "Pod": "webapp",
+    "Ports": [{"container_port": 80, "host_port": 8080}]
+}`))
+	if err != nil {
+		t.Fatalf("Failed: unable to parse raw file: %v", err)
+	}
+	raw := raws[0]
+
+	s, err := createSpecGen(*raw)
+	if err != nil {
+		t.Fatalf("Failed: unable to create spec: %v", err)
+	}
+	if s.Pod != "webapp" {
+		t.Fatalf("Failed: pod: %s != %s", s.Pod, "webapp")
+	}
+	if len(s.PortMappings) != 0 {
+		t.Fatalf("Failed: pod member should not publish ports, got %v", s.PortMappings)
+	}
+
+	p := createPodSpec(*raw)
+	if p.PodSpecGen.Name != "webapp" {
+		t.Fatalf("Failed: pod name: %s != %s", p.PodSpecGen.Name, "webapp")
+	}
+	if len(p.PodSpecGen.PortMappings) != 1 || p.PodSpecGen.PortMappings[0].HostPort != 8080 {
+		t.Fatalf("Failed: pod should publish member ports, got %v", p.PodSpecGen.PortMappings)
+	}
+	if p.PodSpecGen.Labels["owned-by"] != FetchItLabel {
+		t.Fatalf("Failed: pod is missing owned-by label")
+	}
+
+	raw.Pod = ""
+	s, err = createSpecGen(*raw)
+	if err != nil {
+		t.Fatalf("Failed: unable to create spec: %v", err)
+	}
+	if s.Pod != "" || len(s.PortMappings) != 1 {
+		t.Fatalf("Failed: standalone container should publish its own ports, got pod %q ports %v", s.Pod, s.PortMappings)
+	}
+}
+
+func TestPodRemovable(t *testing.T) {
+	owned := map[string]string{"owned-by": FetchItLabel}
+	infra := define.InspectPodContainerInfo{ID: "infra"}
+	member := define.InspectPodContainerInfo{ID: "web"}
+	tests := []struct {
+		name     string
+		pod      *define.InspectPodData
+		expected bool
+	}{
+		{"empty", &define.InspectPodData{Name: "webapp", Labels: owned, InfraContainerID: "infra", Containers: []define.InspectPodContainerInfo{infra}}, true},
+		{"members left", &define.InspectPodData{Name: "webapp", Labels: owned, InfraContainerID: "infra", Containers: []define.InspectPodContainerInfo{infra, member}}, false},
+		{"foreign", &define.InspectPodData{Name: "webapp", InfraContainerID: "infra", Containers: []define.InspectPodContainerInfo{infra}}, false},
+	}
+	for _, tt := range tests {
+		if removable := podRemovable(tt.pod); removable != tt.expected {
+			t.Fatalf("Failed: %s pod removable %t, expected %t", tt.name, removable, tt.expected)
+		}
+	}
+}
+
+func TestCreateSpecGenKernelOptions(t *testing.T) {
+	raws, err := rawPodsFromBytes([]byte(`
+Image: docker.io/library/nginx:latest
+Name: web
+Sysctls:
+  net.core.somaxconn: "1024"
+Ulimits:
+- nofile=1024:2048
+Tmpfs:
+- /run:rw,size=64m
+- /tmp
+`))
+	if err != nil {
+		t.Fatalf("Failed: unable to parse raw file: %v", err)
+	}
+	raw := raws[0]
+
+	s, err := createSpecGen(*raw)
+	if err != nil {
+		t.Fatalf("Failed: unable to create spec: %v", err)
+	}
+	if s.Sysctl["net.core.somaxconn"] != "1024" {
+		t.Fatalf("Failed: sysctls: %v", s.Sysctl)
+	}
+	if len(s.Rlimits) != 1 || s.Rlimits[0].Type != "RLIMIT_NOFILE" || s.Rlimits[0].Soft != 1024 || s.Rlimits[0].Hard != 2048 {
+		t.Fatalf("Failed: rlimits: %v", s.Rlimits)
+	}
+	if len(s.Mounts) != 2 {
+		t.Fatalf("Failed: expected 2 tmpfs mounts, got %v", s.Mounts)
+	}
+	run := s.Mounts[0]
+	if run.Destination != "/run" || run.Type != "tmpfs" || len(run.Options) != 2 || run.Options[1] != "size=64m" {
+		t.Fatalf("Failed: tmpfs mount: %v", run)
+	}
+	if s.Mounts[1].Destination != "/tmp" || s.Mounts[1].Options != nil {
+		t.Fatalf("Failed: tmpfs mount: %v", s.Mounts[1])
+	}
+
+	raw.Ulimits = []string{"bogus=1"}
+	if _, err := createSpecGen(*raw); err == nil {
+		t.Fatalf("Failed: expected error for invalid ulimit")
+	}
+}
+
+func TestCreateSpecGenDNS(t *testing.T) {
+	raws, err := rawPodsFromBytes([]byte(`{
+    "Image": "docker.io/library/nginx:latest",
+    "Name": "edge",
+    "DNSServers": ["10.0.0.53", "fd00::53"],
+    "DNSSearch": ["internal.example.com"],
+    "HostAdd": ["registry.internal:10.0.0.10"]
+}`))
+	if err != nil {
+		t.Fatalf("Failed: unable to parse raw file: %v", err)
+	}
+	raw := raws[0]
+
+	s, err := createSpecGen(*raw)
+	if err != nil {
+		t.Fatalf("Failed: unable to create spec: %v", err)
+	}
+	if len(s.DNSServers) != 2 || s.DNSServers[0].String() != "10.0.0.53" || s.DNSServers[1].String() != "fd00::53" {
+		t.Fatalf("Failed: dns servers: %v", s.DNSServers)
+	}
+	if len(s.DNSSearch) != 1 || s.DNSSearch[0] != "internal.example.com" {
+		t.Fatalf("Failed: dns search: %v", s.DNSSearch)
+	}
+	if len(s.HostAdd) != 1 || s.HostAdd[0] != "registry.internal:10.0.0.10" {
+		t.Fatalf("Failed: host add: %v", s.HostAdd)
+	}
+
+	raw.DNSServers = []string{"not-an-ip"}
+	if _, err := createSpecGen(*raw); err == nil {
+		t.Fatalf("Failed: expected error for invalid DNS server")
+	}
+}
+
+func TestCreateSpecGenCDIDevices(t *testing.T) {
+	raws, err := rawPodsFromBytes([]byte(`
+Image: quay.io/fetchit/inference:latest
+Name: inference
+CDIDevices:
+- nvidia.com/gpu=all
+- vendor.example.com/fpga=0
+`))
+	if err != nil {
+		t.Fatalf("Failed: unable to parse raw file: %v", err)
+	}
+	raw := raws[0]
+
+	s, err := createSpecGen(*raw)
+	if err != nil {
+		t.Fatalf("Failed: unable to create spec: %v", err)
+	}
+	if len(s.Devices) != 2 || s.Devices[0].Path != "nvidia.com/gpu=all" || s.Devices[1].Path != "vendor.example.com/fpga=0" {
+		t.Fatalf("Failed: cdi devices: %v", s.Devices)
+	}
+
+	for _, device := range []string{"/dev/nvidia0", "nvidia.com/gpu", "gpu=all"} {
+		raw.CDIDevices = []string{device}
+		if _, err := createSpecGen(*raw); err == nil {
+			t.Fatalf("Failed: expected error for invalid CDI device %s", device)
+		}
+	}
+}
+
+func TestCreateSpecGenResources(t *testing.T) {
+	raw := RawPod{Image: "quay.io/fetchit/web:latest", Name: "web", Memory: "512m", CPUs: 1.5}
+	s, err := createSpecGen(raw)
+	if err != nil {
+		t.Fatalf("Failed: unable to create spec: %v", err)
+	}
+	r := s.ResourceLimits
+	if r == nil || *r.Memory.Limit != 512*1024*1024 || *r.CPU.Quota != 150000 || *r.CPU.Period != 100000 {
+		t.Fatalf("Failed: resource limits: %+v", r)
+	}
+
+	for _, raw := range []RawPod{{Memory: "lots"}, {CPUs: -1}} {
+		if _, err := createSpecGen(raw); err == nil {
+			t.Fatalf("Failed: expected error for invalid limits %+v", raw)
+		}
+	}
+}
+
+func TestCreateSpecGenUserNS(t *testing.T) {
+	raws, err := rawPodsFromBytes([]byte(`
+Image: quay.io/fetchit/web:latest
+Name: tenant
+UIDMap:
+- 0:100000:65536
+GIDMap:
+- 0:200000:65536
+`))
+	if err != nil {
+		t.Fatalf("Failed: unable to parse raw file: %v", err)
+	}
+	raw := raws[0]
+
+	s, err := createSpecGen(*raw)
+	if err != nil {
+		t.Fatalf("Failed: unable to create spec: %v", err)
+	}
+	if s.UserNS.NSMode != specgen.Private {
+		t.Fatalf("Failed: user namespace %+v, expected private", s.UserNS)
+	}
+	m := s.IDMappings
+	if m == nil || m.HostUIDMapping || m.HostGIDMapping || len(m.UIDMap) != 1 || len(m.GIDMap) != 1 {
+		t.Fatalf("Failed: id mappings: %+v", m)
+	}
+	if m.UIDMap[0].ContainerID != 0 || m.UIDMap[0].HostID != 100000 || m.UIDMap[0].Size != 65536 || m.GIDMap[0].HostID != 200000 {
+		t.Fatalf("Failed: id mappings: %+v %+v", m.UIDMap, m.GIDMap)
+	}
+
+	raw.UIDMap, raw.GIDMap, raw.UserNS = nil, nil, "auto:size=65536"
+	if s, err = createSpecGen(*raw); err != nil {
+		t.Fatalf("Failed: unable to create spec: %v", err)
+	}
+	if s.UserNS.NSMode != specgen.Auto || s.IDMappings == nil || !s.IDMappings.AutoUserNs || s.IDMappings.AutoUserNsOpts.Size != 65536 {
+		t.Fatalf("Failed: auto user namespace %+v: %+v", s.UserNS, s.IDMappings)
+	}
+
+	// containers keep the user namespace of the host by default
+	raw.UserNS = ""
+	if s, err = createSpecGen(*raw); err != nil || s.UserNS.NSMode != "" || s.IDMappings != nil {
+		t.Fatalf("Failed: expected the default user namespace, got %+v %+v: %v", s.UserNS, s.IDMappings, err)
+	}
+
+	for _, bad := range []RawPod{{UserNS: "private"}, {UserNS: "bogus"}, {UIDMap: []string{"0:100000"}}} {
+		if _, err := createSpecGen(bad); err == nil {
+			t.Fatalf("Failed: expected error for invalid user namespace %+v", bad)
+		}
+	}
+}
+
+func TestCreateSpecGenStopOptions(t *testing.T) {
+	raws, err := rawPodsFromBytes([]byte(`
+Image: docker.io/library/postgres:latest
+Name: db
+StopSignal: SIGINT
+StopTimeout: 30
+`))
+	if err != nil {
+		t.Fatalf("Failed: unable to parse raw file: %v", err)
+	}
+	raw := raws[0]
+
+	s, err := createSpecGen(*raw)
+	if err != nil {
+		t.Fatalf("Failed: unable to create spec: %v", err)
+	}
+	if s.StopSignal == nil || *s.StopSignal != syscall.SIGINT {
+		t.Fatalf("Failed: stop signal: %v", s.StopSignal)
+	}
+	if s.StopTimeout == nil || *s.StopTimeout != 30 {
+		t.Fatalf("Failed: stop timeout: %v", s.StopTimeout)
+	}
+	opts := stopOptions(raw)
+	if opts == nil || opts.GetTimeout() != 30 {
+		t.Fatalf("Failed: stop options: %+v", opts)
+	}
+
+	// podman's defaults are kept when unset
+	raw.StopSignal, raw.StopTimeout = "", nil
+	if s, err = createSpecGen(*raw); err != nil || s.StopSignal != nil || s.StopTimeout != nil {
+		t.Fatalf("Failed: expected the default stop signal and timeout, got %v %v: %v", s.StopSignal, s.StopTimeout, err)
+	}
+	if opts := stopOptions(raw); opts != nil {
+		t.Fatalf("Failed: expected no stop options, got %+v", opts)
+	}
+
+	raw.StopSignal = "SIGBOGUS"
+	if _, err := createSpecGen(*raw); err == nil {
+		t.Fatalf("Failed: expected error for invalid stop signal")
+	}
+}
+
+func TestOnlyResourcesChanged(t *testing.T) {
+	base := RawPod{Image: "quay.io/fetchit/web:latest", Name: "web", Env: map[string]string{"PORT": "8080"}, Memory: "256m", CPUs: 1}
+	tests := []struct {
+		name   string
+		change func(*RawPod)
+		update bool
+	}{
+		{"memory raised", func(r *RawPod) { r.Memory = "512m" }, true},
+		{"cpus and memory", func(r *RawPod) { r.Memory = "1g"; r.CPUs = 2 }, true},
+		{"unchanged", func(r *RawPod) {}, false},
+		{"memory limit removed", func(r *RawPod) { r.Memory = "" }, false},
+		{"image and memory", func(r *RawPod) { r.Image = "quay.io/fetchit/web:v2"; r.Memory = "512m" }, false},
+		{"env and cpus", func(r *RawPod) { r.Env = map[string]string{"PORT": "9090"}; r.CPUs = 2 }, false},
+	}
+	for _, tt := range tests {
+		prev, next := base, base
+		next.Env = map[string]string{"PORT": "8080"}
+		tt.change(&next)
+		if got := onlyResourcesChanged(&prev, &next); got != tt.update {
+			t.Fatalf("Failed: %s: update in place %t, expected %t", tt.name, got, tt.update)
+		}
+	}
+}
+
+func TestRawPodsFromBytesMultiDocument(t *testing.T) {
+	raws, err := rawPodsFromBytes([]byte(`
+---
+Image: docker.io/library/nginx:latest
+Name: web
+Pod: webapp
+---
+Image: docker.io/mmumshad/simple-webapp-color:latest
+Name: sidecar
+Pod: webapp
+---
+`))
+	if err != nil {
+		t.Fatalf("Failed: unable to parse raw file: %v", err)
+	}
+	if len(raws) != 2 {
+		t.Fatalf("Failed: expected 2 containers, got %d", len(raws))
+	}
+	if raws[0].Name != "web" || raws[1].Name != "sidecar" {
+		t.Fatalf("Failed: names: %s, %s != web, sidecar", raws[0].Name, raws[1].Name)
+	}
+
+	raws, err = rawPodsFromBytes([]byte(`{"Image": "docker.io/library/nginx:latest", "Name": "web"}`))
+	if err != nil || len(raws) != 1 {
+		t.Fatalf("Failed: expected 1 container from json, got %d: %v", len(raws), err)
+	}
+
+	if _, err := rawPodsFromBytes([]byte("  \n")); err == nil {
+		t.Fatalf("Failed: expected error for empty raw file")
+	}
+}
+
+func TestRetireContainerKeepFailed(t *testing.T) {
+	defer func(l *zap.SugaredLogger) { logger = l }(logger)
+	logger = zap.NewNop().Sugar()
+	defer func(f *Fetchit) { fetchit = f }(fetchit)
+	fetchit = newFetchit()
+
+	if containerFailed(&define.InspectContainerState{Running: true}) || containerFailed(&define.InspectContainerState{ExitCode: 0}) {
+		t.Fatalf("Failed: running or successful container reported as failed")
+	}
+	if !containerFailed(&define.InspectContainerState{ExitCode: 1}) || !containerFailed(&define.InspectContainerState{Running: true, Restarting: true}) {
+		t.Fatalf("Failed: exited or crash looping container not reported as failed")
+	}
+
+	// without keepFailed a failed container is removed
+	f := &fakeContainerOps{running: map[string]bool{"web": true}, ports: map[string]bool{}}
+	if err := retireContainer(f.ops(), "web", true); err != nil {
+		t.Fatalf("Failed: unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(f.calls, []string{"remove web"}) {
+		t.Fatalf("Failed: calls %q", f.calls)
+	}
+
+	fetchit.keepFailed = true
+	f = &fakeContainerOps{running: map[string]bool{"web": true}, ports: map[string]bool{}}
+	if err := retireContainer(f.ops(), "web", true); err != nil {
+		t.Fatalf("Failed: unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(f.calls, []string{"stop web", "rename web web-failed"}) || !f.running["web-failed"] {
+		t.Fatalf("Failed: failed container not kept, calls %q", f.calls)
+	}
+
+	// the next container did not fail, so the kept one is cleaned up
+	f.calls = nil
+	f.running["web"] = true
+	if err := retireContainer(f.ops(), "web", false); err != nil {
+		t.Fatalf("Failed: unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(f.calls, []string{"remove web-failed", "remove web"}) || len(f.running) != 0 {
+		t.Fatalf("Failed: kept container not cleaned up, calls %q, left %v", f.calls, f.running)
+	}
+}
@@ -0,0 +1,187 @@
+package engine
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containers/fetchit/pkg/engine/utils"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/types"
+	"github.com/containers/podman/v4/pkg/bindings/images"
+	"github.com/containers/podman/v4/pkg/specgen"
+)
+
+const (
+	registryMethod = "registry"
+	// rootCertsDir is where root podman looks up the CAs of a registry, rootless
+	// podman reads the certs.d directory below $HOME/.config/containers
+	rootCertsDir = "/etc/containers/certs.d"
+)
+
+var (
+	// registries is the trust of the registries in the config
+	registries []*RegistryTrust
+	// registryCADir holds the CAs of the registries in the fetchit volume, in the
+	// layout of a certs.d directory, for the pulls fetchit makes itself and for
+	// the helper installing them on the podman host
+	registryCADir = filepath.Join("/opt", ".registry-ca")
+)
+
+// RegistryTrust configures the TLS trust of a registry images are pulled from
+type RegistryTrust struct {
+	// Registry is the host images are pulled from, with the port if it is not 443, such as registry.example.com:5000
+	Registry string `mapstructure:"registry"`
+	// CAFile is a PEM bundle of the CAs the certificate of the registry is signed by, such as /opt/mount/registry-ca.crt
+	CAFile string `mapstructure:"caFile"`
+	// SkipTLSVerify pulls from the registry without verifying its certificate
+	SkipTLSVerify bool `mapstructure:"skipTLSVerify"`
+}
+
+func (r *RegistryTrust) validate() error {
+	if r.Registry == "" || strings.ContainsAny(r.Registry, "/ ") {
+		return fmt.Errorf("invalid registry %q, must be a host with an optional port", r.Registry)
+	}
+	if r.CAFile == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(r.CAFile)
+	if err != nil {
+		return utils.WrapErr(err, "Error reading CA file of registry %s", r.Registry)
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(b) {
+		return fmt.Errorf("CA file %s of registry %s has no PEM certificates", r.CAFile, r.Registry)
+	}
+	return nil
+}
+
+// caDir returns the directory the CA of the registry is staged in
+func (r *RegistryTrust) caDir() string {
+	return filepath.Join(registryCADir, r.Registry)
+}
+
+// trustOf returns the trust configured for a registry, nil if there is none
+func trustOf(registry string) *RegistryTrust {
+	for _, r := range registries {
+		if r.Registry == registry {
+			return r
+		}
+	}
+	return nil
+}
+
+// pullOptions returns the options of a podman pull of an image
+func pullOptions(imageName string) *images.PullOptions {
+	opts := new(images.PullOptions)
+	named, err := reference.ParseNormalizedNamed(strings.TrimPrefix(imageName, "docker://"))
+	if err != nil {
+		return opts
+	}
+	if r := trustOf(reference.Domain(named)); r != nil && r.SkipTLSVerify {
+		opts.WithSkipTLSVerify(true)
+	}
+	return opts
+}
+
+// systemContext returns the context of a pull fetchit makes itself, which
+// trusts the staged CA of the registry of ref
+func systemContext(ref types.ImageReference) *types.SystemContext {
+	sys := &types.SystemContext{}
+	named := ref.DockerReference()
+	if named == nil {
+		return sys
+	}
+	r := trustOf(reference.Domain(named))
+	if r == nil {
+		return sys
+	}
+	if r.CAFile != "" {
+		sys.DockerCertPath = r.caDir()
+	}
+	if r.SkipTLSVerify {
+		sys.DockerInsecureSkipTLSVerify = types.OptionalBoolTrue
+	}
+	return sys
+}
+
+// setRegistries validates the registries of the config and stages their CAs
+// in registryCADir as <registry>/ca.crt. It returns whether any CA was staged.
+func setRegistries(trust []*RegistryTrust) (bool, error) {
+	errs := &utils.MultiError{}
+	for _, r := range trust {
+		errs.Append(r.validate())
+	}
+	if err := errs.ErrorOrNil(); err != nil {
+		return false, &utils.ValidationError{Err: err}
+	}
+	registries = trust
+
+	if err := os.RemoveAll(registryCADir); err != nil {
+		return false, utils.WrapErr(err, "Error removing staged registry CAs")
+	}
+	staged := false
+	for _, r := range trust {
+		if r.CAFile == "" {
+			continue
+		}
+		b, err := ioutil.ReadFile(r.CAFile)
+		if err != nil {
+			return false, utils.WrapErr(err, "Error reading CA file of registry %s", r.Registry)
+		}
+		if err := os.MkdirAll(r.caDir(), 0755); err != nil {
+			return false, utils.WrapErr(err, "Error staging CA of registry %s", r.Registry)
+		}
+		if err := ioutil.WriteFile(filepath.Join(r.caDir(), "ca.crt"), b, 0644); err != nil {
+			return false, utils.WrapErr(err, "Error staging CA of registry %s", r.Registry)
+		}
+		staged = true
+	}
+	return staged, nil
+}
+
+// certsDir returns the certs.d directory of the podman service
+func certsDir(rootless bool) (string, error) {
+	if !rootless {
+		return rootCertsDir, nil
+	}
+	home := os.Getenv("HOME")
+	if home == "" {
+		return "", fmt.Errorf("HOME must be set to the home directory of the rootless podman user to install registry CAs")
+	}
+	return filepath.Join(home, ".config", "containers", "certs.d"), nil
+}
+
+// registryCASpec returns the helper copying the staged CAs into the certs.d
+// directory of the podman host. The parent of certs.d is mounted as certs.d
+// itself may not exist yet.
+func registryCASpec(dir string) *specgen.SpecGenerator {
+	command := []string{"sh", "-c", "mkdir -p " + dir + " && rsync -avz " + registryCADir + "/ " + dir + "/"}
+	return generateCommandSpec(registryMethod, "", "ca", command, filepath.Dir(dir), "certs")
+}
+
+// installRegistryCAs makes the podman service trust the CAs of the registries
+// of the config. Pulls are made by the podman service, which reads the CAs of
+// a registry from its certs.d directory rather than from the pull options.
+func installRegistryCAs(conn context.Context, rootless bool, trust []*RegistryTrust) error {
+	staged, err := setRegistries(trust)
+	if err != nil || !staged {
+		return err
+	}
+	dir, err := certsDir(rootless)
+	if err != nil {
+		return err
+	}
+	createResponse, err := createAndStartContainer(conn, registryCASpec(dir))
+	if err != nil {
+		return err
+	}
+	if err := waitAndRemoveContainer(conn, createResponse.ID); err != nil {
+		return utils.WrapErr(err, "Error installing registry CAs in %s", dir)
+	}
+	logger.Infof("Installed registry CAs in %s", dir)
+	return nil
+}
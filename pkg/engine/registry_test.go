@@ -0,0 +1,104 @@
+package engine
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/types"
+)
+
+// writeCA writes a self-signed PEM certificate to path
+func writeCA(t *testing.T, path string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "registry CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	b := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	return b
+}
+
+func TestRegistryTrust(t *testing.T) {
+	defer func(r []*RegistryTrust, dir string) { registries, registryCADir = r, dir }(registries, registryCADir)
+	dir := t.TempDir()
+	registryCADir = filepath.Join(dir, "registry-ca")
+	caFile := filepath.Join(dir, "ca.crt")
+	ca := writeCA(t, caFile)
+
+	staged, err := setRegistries([]*RegistryTrust{
+		{Registry: "registry.example.com:5000", CAFile: caFile},
+		{Registry: "lab.example.com", SkipTLSVerify: true},
+	})
+	if err != nil || !staged {
+		t.Fatalf("Failed: staged %t: %v", staged, err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(registryCADir, "registry.example.com:5000", "ca.crt"))
+	if err != nil || string(b) != string(ca) {
+		t.Fatalf("Failed: CA was not staged: %v", err)
+	}
+
+	// podman pulls skip verification only for the registries set to skip it
+	if opts := pullOptions("lab.example.com/app:latest"); !opts.GetSkipTLSVerify() {
+		t.Fatalf("Failed: pull from lab.example.com verifies TLS")
+	}
+	if opts := pullOptions("docker://registry.example.com:5000/app:latest"); opts.SkipTLSVerify != nil {
+		t.Fatalf("Failed: pull from registry.example.com:5000 skips TLS verification")
+	}
+
+	// pulls by fetchit trust the staged CA
+	ref, err := docker.ParseReference("//registry.example.com:5000/app:latest")
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	if sys := systemContext(ref); sys.DockerCertPath != filepath.Join(registryCADir, "registry.example.com:5000") {
+		t.Fatalf("Failed: cert path %q", sys.DockerCertPath)
+	}
+	ref, _ = docker.ParseReference("//lab.example.com/app:latest")
+	if sys := systemContext(ref); sys.DockerCertPath != "" || sys.DockerInsecureSkipTLSVerify != types.OptionalBoolTrue {
+		t.Fatalf("Failed: context of lab.example.com: %+v", sys)
+	}
+
+	// the helper copies the staged CAs into certs.d of the podman host
+	s := registryCASpec(rootCertsDir)
+	if cmd := s.Command[2]; !strings.Contains(cmd, registryCADir+"/ "+rootCertsDir+"/") {
+		t.Fatalf("Failed: helper command %q", cmd)
+	}
+	if len(s.Mounts) != 1 || s.Mounts[0].Source != "/etc/containers" {
+		t.Fatalf("Failed: helper mounts %+v", s.Mounts)
+	}
+
+	if err := ioutil.WriteFile(caFile, []byte("not a certificate"), 0644); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	if _, err := setRegistries([]*RegistryTrust{{Registry: "registry.example.com", CAFile: caFile}}); err == nil {
+		t.Fatalf("Failed: expected error for a CA file without certificates")
+	}
+	if _, err := setRegistries([]*RegistryTrust{{Registry: "https://registry.example.com"}}); err == nil {
+		t.Fatalf("Failed: expected error for a registry url")
+	}
+}
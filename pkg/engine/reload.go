@@ -0,0 +1,145 @@
+package engine
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/go-co-op/gocron"
+)
+
+// scheduledTarget is a targetConfig of the running config with its methods
+type scheduledTarget struct {
+	// key fingerprints the targetConfig, it is unchanged while the config of the target is
+	key     string
+	methods []Method
+}
+
+// configKey returns a fingerprint of the exported fields of v, used to tell
+// which parts of a config a reload changed. It is empty when v can't be
+// encoded, which never matches.
+func configKey(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		logger.Debugf("Unable to fingerprint config, it will be treated as changed: %v", err)
+		return ""
+	}
+	return string(b)
+}
+
+// settingsKey fingerprints a config without its targetConfigs
+func settingsKey(config *FetchitConfig) string {
+	settings := *config
+	settings.TargetConfigs = nil
+	return configKey(&settings)
+}
+
+// cloneDirectories returns the directories the git targets of f are cloned
+// into, with the url of each. Disconnected targets are not clones.
+func (f *Fetchit) cloneDirectories() map[string]string {
+	dirs := map[string]string{}
+	for m := range f.methodTargetScheds {
+		target := m.GetTarget()
+		if target == nil || target.url == "" || target.disconnected {
+			continue
+		}
+		dirs[getDirectory(target)] = target.url
+	}
+	return dirs
+}
+
+// removeOrphanedClones removes the clones of previous that no target of
+// current uses. A directory is only removed while it is still a clone of the
+// url it was recorded with, so that nothing fetchit did not clone is deleted.
+func removeOrphanedClones(previous, current map[string]string) {
+	for dir, url := range previous {
+		if _, ok := current[dir]; ok {
+			continue
+		}
+		if err := checkRemote(dir, url); err != nil {
+			logger.Warnf("Not removing %s of removed target %s: %v", dir, url, err)
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			logger.Warnf("Unable to remove %s of removed target %s: %v", dir, url, err)
+			continue
+		}
+		logger.Infof("Removed %s, the clone of removed target %s", dir, url)
+	}
+}
+
+// reloadTargets moves the jobs of f over to next, the fetchit of an updated
+// config, when the configs only differ in their targetConfigs. Unchanged
+// targets keep their methods, with their state, and their jobs. The jobs of
+// removed and edited targets are removed and the methods of added and edited
+// targets are scheduled. It changes nothing and returns false when the
+// settings changed and every job must be rebuilt. Runs must be held.
+func (f *Fetchit) reloadTargets(next *Fetchit) bool {
+	if f == nil || f.settings == "" || f.settings != next.settings {
+		return false
+	}
+	previous := map[string][]*scheduledTarget{}
+	targetMethods := map[Method]struct{}{}
+	for _, t := range f.targets {
+		previous[t.key] = append(previous[t.key], t)
+		for _, m := range t.methods {
+			targetMethods[m] = struct{}{}
+		}
+	}
+
+	methods := map[Method]SchedInfo{}
+	jobs := map[Method]*gocron.Job{}
+	keep := func(m Method) {
+		methods[m] = f.methodTargetScheds[m]
+		if job, ok := f.jobs[m]; ok {
+			jobs[m] = job
+		}
+	}
+	// the methods fetchit adds for settings are unchanged with the settings
+	for m := range f.methodTargetScheds {
+		if _, ok := targetMethods[m]; !ok {
+			keep(m)
+		}
+	}
+	var added []Method
+	var unchanged int
+	for _, t := range next.targets {
+		if prev := previous[t.key]; t.key != "" && len(prev) > 0 {
+			previous[t.key] = prev[1:]
+			t.methods = prev[0].methods
+			for _, m := range t.methods {
+				keep(m)
+			}
+			unchanged++
+			continue
+		}
+		for _, m := range t.methods {
+			methods[m] = next.methodTargetScheds[m]
+			added = append(added, m)
+		}
+	}
+	var removed int
+	for _, prev := range previous {
+		for _, t := range prev {
+			for _, m := range t.methods {
+				if job, ok := f.jobs[m]; ok {
+					f.scheduler.RemoveByReference(job)
+				}
+				if target := m.GetTarget(); target != nil {
+					target.removed = true
+				}
+			}
+			removed++
+		}
+	}
+
+	for mt := range f.allMethodTypes {
+		next.allMethodTypes[mt] = struct{}{}
+	}
+	next.methodTargetScheds = methods
+	next.jobs = jobs
+	for _, m := range added {
+		next.schedule(m, methods[m])
+	}
+	logger.Infof("Reloaded targets, %d unchanged, %d removed or edited, %d methods scheduled", unchanged, removed, len(added))
+	return true
+}
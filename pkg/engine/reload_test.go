@@ -0,0 +1,260 @@
+package engine
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-co-op/gocron"
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// loadReloadConfig decodes a config and sets up its methods the way
+// populateFetchit does, without connecting to podman
+func loadReloadConfig(t *testing.T, s *gocron.Scheduler, yaml string) *Fetchit {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	if err := v.ReadConfig(bytes.NewBufferString(yaml)); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	config := newFetchitConfig()
+	if err := v.Unmarshal(config); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	f := newFetchit()
+	f.scheduler = s
+	f.settings = settingsKey(config)
+	for _, tc := range config.TargetConfigs {
+		key := configKey(tc)
+		if key == "" {
+			t.Fatalf("Failed: unable to fingerprint target %s", tc.Name)
+		}
+		f.targets = append(f.targets, &scheduledTarget{key: key, methods: addTargetConfig(tc, f)})
+	}
+	return f
+}
+
+func TestConfigKeyMethods(t *testing.T) {
+	defer func(l *zap.SugaredLogger) { logger = l }(logger)
+	logger = zap.NewNop().Sugar()
+	tc := &TargetConfig{
+		Name:         "all",
+		Ansible:      []*Ansible{{}},
+		FileTransfer: []*FileTransfer{{}},
+		Kube:         []*Kube{{}},
+		Raw:          []*Raw{{}},
+		Systemd:      []*Systemd{{}},
+		Volume:       []*Volume{{}},
+		Network:      []*Network{{}},
+	}
+	key := configKey(tc)
+	if key == "" {
+		t.Fatalf("Failed: unable to fingerprint a target with every method")
+	}
+	tc.Raw[0].Schedule = "*/5 * * * *"
+	if configKey(tc) == key {
+		t.Fatalf("Failed: fingerprint did not change with the target")
+	}
+	if settingsKey(&FetchitConfig{Prune: &Prune{}, Images: []*Image{{}}, SelfUpdate: &SelfUpdate{}, ConfigReload: &ConfigReload{}}) == "" {
+		t.Fatalf("Failed: unable to fingerprint the settings")
+	}
+}
+
+// jobsByName returns the scheduled jobs of f by method name
+func jobsByName(f *Fetchit) map[string]*gocron.Job {
+	jobs := map[string]*gocron.Job{}
+	for m, job := range f.jobs {
+		jobs[m.GetName()] = job
+	}
+	return jobs
+}
+
+func TestReloadTargets(t *testing.T) {
+	defer func(l *zap.SugaredLogger) { logger = l }(logger)
+	logger = zap.NewNop().Sugar()
+
+	const config = `
+maxConcurrency: 2
+targetConfigs:
+- name: web
+  url: https://github.com/a/web
+  branch: main
+  raw:
+  - name: web-raw
+    targetPath: raw
+    schedule: "*/5 * * * *"
+- name: db
+  url: https://github.com/a/db
+  branch: main
+  kube:
+  - name: db-kube
+    targetPath: kube
+    schedule: "*/5 * * * *"
+- name: old
+  url: https://github.com/a/old
+  branch: main
+  raw:
+  - name: old-raw
+    targetPath: raw
+    schedule: "*/5 * * * *"
+`
+	s := gocron.NewScheduler(time.UTC)
+	old := loadReloadConfig(t, s, config)
+	for m, info := range old.methodTargetScheds {
+		old.schedule(m, info)
+	}
+	before := jobsByName(old)
+	var web, oldRaw Method
+	for m := range old.methodTargetScheds {
+		switch m.GetName() {
+		case "web-raw":
+			web = m
+		case "old-raw":
+			oldRaw = m
+		}
+	}
+	web.GetTarget().latest[0] = 1
+
+	// db is edited, old is removed and cache is added
+	next := loadReloadConfig(t, s, `
+maxConcurrency: 2
+targetConfigs:
+- name: web
+  url: https://github.com/a/web
+  branch: main
+  raw:
+  - name: web-raw
+    targetPath: raw
+    schedule: "*/5 * * * *"
+- name: db
+  url: https://github.com/a/db
+  branch: main
+  kube:
+  - name: db-kube
+    targetPath: kube
+    schedule: "*/10 * * * *"
+- name: cache
+  url: https://github.com/a/cache
+  branch: main
+  raw:
+  - name: cache-raw
+    targetPath: raw
+    schedule: "*/5 * * * *"
+`)
+	if !old.reloadTargets(next) {
+		t.Fatalf("Failed: targets were not reloaded in place")
+	}
+	after := jobsByName(next)
+	if after["web-raw"] != before["web-raw"] {
+		t.Fatalf("Failed: job of the unchanged target was replaced")
+	}
+	if _, ok := next.methodTargetScheds[web]; !ok || web.GetTarget().latest[0] != 1 {
+		t.Fatalf("Failed: unchanged target lost its method or state")
+	}
+	if after["db-kube"] == nil || after["db-kube"] == before["db-kube"] || after["cache-raw"] == nil {
+		t.Fatalf("Failed: edited and added targets were not scheduled: %v", after)
+	}
+	if _, ok := after["old-raw"]; ok || !oldRaw.GetTarget().removed {
+		t.Fatalf("Failed: removed target is still scheduled")
+	}
+	if len(s.Jobs()) != 3 {
+		t.Fatalf("Failed: %d jobs scheduled, expected 3", len(s.Jobs()))
+	}
+	for _, job := range s.Jobs() {
+		if job == before["db-kube"] || job == before["old-raw"] {
+			t.Fatalf("Failed: job of an edited or removed target was left scheduled")
+		}
+	}
+
+	// a change to the settings rebuilds every job
+	rebuilt := loadReloadConfig(t, s, strings.Replace(config, "maxConcurrency: 2", "maxConcurrency: 3", 1))
+	if next.reloadTargets(rebuilt) || len(s.Jobs()) != 3 {
+		t.Fatalf("Failed: settings change was reloaded in place")
+	}
+}
+
+func TestRemoveOrphanedClones(t *testing.T) {
+	defer func(l *zap.SugaredLogger, dir string) { logger, cacheDir = l, dir }(logger, cacheDir)
+	logger = zap.NewNop().Sugar()
+	cacheDir = t.TempDir()
+
+	s := gocron.NewScheduler(time.UTC)
+	old := loadReloadConfig(t, s, `
+targetConfigs:
+- name: web
+  url: https://github.com/a/web
+  branch: main
+  raw:
+  - name: web-raw
+    schedule: "*/5 * * * *"
+- name: old
+  url: https://github.com/a/old
+  branch: main
+  raw:
+  - name: old-raw
+    schedule: "*/5 * * * *"
+- name: other
+  url: https://github.com/a/other
+  branch: main
+  raw:
+  - name: other-raw
+    schedule: "*/5 * * * *"
+- name: usb
+  url: https://github.com/a/usb.zip
+  disconnected: true
+  raw:
+  - name: usb-raw
+    schedule: "*/5 * * * *"
+`)
+	clone := func(url, remote string) string {
+		dir := getDirectory(&Target{url: url})
+		repo, err := git.PlainInit(dir, false)
+		if err != nil {
+			t.Fatalf("Failed: %v", err)
+		}
+		if _, err := repo.CreateRemote(&gitconfig.RemoteConfig{Name: git.DefaultRemoteName, URLs: []string{remote}}); err != nil {
+			t.Fatalf("Failed: %v", err)
+		}
+		return dir
+	}
+	web := clone("https://github.com/a/web", "https://github.com/a/web")
+	removed := clone("https://github.com/a/old", "https://github.com/a/old")
+	// a directory that is not a clone of its target is kept
+	foreign := clone("https://github.com/a/other", "https://github.com/someone/else")
+	usb := getDirectory(&Target{url: "https://github.com/a/usb.zip", disconnected: true})
+	if err := os.MkdirAll(usb, 0755); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+
+	clones := old.cloneDirectories()
+	if len(clones) != 3 {
+		t.Fatalf("Failed: expected the 3 cloned targets, got %v", clones)
+	}
+	next := loadReloadConfig(t, s, `
+targetConfigs:
+- name: web
+  url: https://github.com/a/web
+  branch: main
+  raw:
+  - name: web-raw
+    schedule: "*/5 * * * *"
+`)
+	if !old.reloadTargets(next) {
+		t.Fatalf("Failed: targets were not reloaded in place")
+	}
+	removeOrphanedClones(clones, next.cloneDirectories())
+
+	if _, err := os.Stat(removed); !os.IsNotExist(err) {
+		t.Fatalf("Failed: clone of the removed target was kept: %v", err)
+	}
+	for _, dir := range []string{web, foreign, usb} {
+		if _, err := os.Stat(dir); err != nil {
+			t.Fatalf("Failed: %s was removed: %v", dir, err)
+		}
+	}
+}
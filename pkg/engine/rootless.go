@@ -0,0 +1,37 @@
+package engine
+
+import (
+	"context"
+	"os"
+
+	"github.com/containers/podman/v4/libpod/define"
+	"github.com/containers/podman/v4/pkg/bindings/system"
+)
+
+// detectRootless reports whether fetchit manages a rootless podman. Inside the
+// fetchit container the euid is 0 even for rootless podman, so the podman
+// connection info takes precedence when it is available.
+func detectRootless(euid int, info *define.Info) bool {
+	if info != nil && info.Host != nil {
+		return info.Host.Security.Rootless
+	}
+	return euid != 0
+}
+
+// podmanRootless detects the rootless mode of the podman service behind conn
+func podmanRootless(conn context.Context) bool {
+	info, err := system.Info(conn, nil)
+	if err != nil {
+		logger.Warnf("Unable to get podman info, detecting rootless mode from euid: %v", err)
+		info = nil
+	}
+	return detectRootless(os.Geteuid(), info)
+}
+
+// boolOrDefault returns the value of an optional config flag, or def when unset
+func boolOrDefault(b *bool, def bool) bool {
+	if b == nil {
+		return def
+	}
+	return *b
+}
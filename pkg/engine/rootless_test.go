@@ -0,0 +1,45 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/containers/podman/v4/libpod/define"
+)
+
+func TestDetectRootless(t *testing.T) {
+	if detectRootless(0, nil) {
+		t.Fatalf("Failed: euid 0 without podman info should be rootful")
+	}
+	if !detectRootless(1000, nil) {
+		t.Fatalf("Failed: euid 1000 without podman info should be rootless")
+	}
+
+	// the fetchit container runs as uid 0 in the user namespace of rootless podman
+	info := &define.Info{Host: &define.HostInfo{Security: define.SecurityInfo{Rootless: true}}}
+	if !detectRootless(0, info) {
+		t.Fatalf("Failed: podman info reports rootless")
+	}
+	info.Host.Security.Rootless = false
+	if detectRootless(1000, info) {
+		t.Fatalf("Failed: podman info reports rootful")
+	}
+}
+
+func TestSystemdRootDefault(t *testing.T) {
+	defer func(f *Fetchit) { fetchit = f }(fetchit)
+	fetchit = newFetchit()
+
+	sd := &Systemd{}
+	if !sd.isRoot() {
+		t.Fatalf("Failed: unset root should follow rootful podman")
+	}
+	fetchit.rootless = true
+	if sd.isRoot() {
+		t.Fatalf("Failed: unset root should follow rootless podman")
+	}
+	root := true
+	sd.Root = &root
+	if !sd.isRoot() {
+		t.Fatalf("Failed: explicit root should override detected mode")
+	}
+}
@@ -0,0 +1,179 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/containers/fetchit/pkg/engine/utils"
+	"github.com/containers/podman/v4/pkg/bindings/containers"
+	"github.com/containers/podman/v4/pkg/bindings/images"
+	"github.com/containers/podman/v4/pkg/specgen"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+const (
+	selfUpdateMethod       = "selfupdate"
+	defaultSelfUpdateImage = "quay.io/podman/stable:latest"
+	podmanSocket           = "/run/podman/podman.sock"
+)
+
+// SelfUpdate configures fetchit to replace its own container when a new fetchit image is published.
+// The fetchit container is recreated by a helper container using the command it was originally
+// created with, so the config and fetchit volumes are preserved.
+type SelfUpdate struct {
+	CommonMethod `mapstructure:",squash"`
+	// ContainerName is the name of the fetchit container, defaults to fetchit
+	ContainerName string `mapstructure:"containerName"`
+	// HelperImage is an image containing the podman cli, used to recreate the fetchit container
+	HelperImage string `mapstructure:"helperImage"`
+}
+
+func (su *SelfUpdate) GetKind() string {
+	return selfUpdateMethod
+}
+
+func (su *SelfUpdate) GetName() string {
+	return selfUpdateMethod
+}
+
+func (su *SelfUpdate) Process(ctx, conn context.Context, skew int) {
+	log := methodLogger(su)
+	time.Sleep(time.Duration(skew) * time.Millisecond)
+	if err := su.selfUpdatePodman(ctx, conn); err != nil {
+		log.Errorf("Self update failed: %v", err)
+	}
+}
+
+func (su *SelfUpdate) MethodEngine(ctx, conn context.Context, change *object.Change, path string) error {
+	return nil
+}
+
+func (su *SelfUpdate) Apply(ctx, conn context.Context, currentState, desiredState plumbing.Hash, tags *[]string) error {
+	return nil
+}
+
+func (su *SelfUpdate) selfUpdatePodman(ctx, conn context.Context) error {
+	log := methodLogger(su)
+	name := su.ContainerName
+	if name == "" {
+		name = fetchitService
+	}
+	if imagePullPolicy == pullNever {
+		return fmt.Errorf("unable to check for fetchit image updates, imagePullPolicy is %s", pullNever)
+	}
+	self, err := containers.Inspect(conn, name, nil)
+	if err != nil {
+		return utils.WrapErr(err, "Error inspecting fetchit container %s", name)
+	}
+
+	if err := pullImage(conn, self.ImageName); err != nil {
+		return utils.WrapErr(err, "Error pulling %s", self.ImageName)
+	}
+	latest, err := images.GetImage(conn, self.ImageName, nil)
+	if err != nil {
+		return utils.WrapErr(err, "Error inspecting %s", self.ImageName)
+	}
+	if !imageChanged(self.Image, latest.ID) {
+		log.Infof("Fetchit image %s is up to date", self.ImageName)
+		return nil
+	}
+	log.Infof("Fetchit image %s updated from %s to %s", self.ImageName, shortID(self.Image), shortID(latest.ID))
+
+	if self.Config == nil || len(self.Config.CreateCommand) == 0 {
+		return fmt.Errorf("unable to self update, container %s has no create command", name)
+	}
+	socket := ""
+	for _, m := range self.Mounts {
+		if m.Destination == podmanSocket {
+			socket = m.Source
+		}
+	}
+	if socket == "" {
+		return fmt.Errorf("unable to self update, %s is not mounted in container %s", podmanSocket, name)
+	}
+
+	helperImage := su.HelperImage
+	if helperImage == "" {
+		helperImage = defaultSelfUpdateImage
+	}
+	if err := detectOrFetchImage(conn, helperImage, false); err != nil {
+		return err
+	}
+
+	// Wait for in-flight applies to finish and remove every job before the
+	// helper replaces this container. Stopping the scheduler would wait for
+	// this job too.
+	runs.Lock()
+	clearJobs(fetchit)
+	runs.Unlock()
+
+	s := specgen.NewSpecGenerator(helperImage, false)
+	s.Name = fetchitService + "-" + selfUpdateMethod
+	s.Remove = true
+	s.Command = []string{"sh", "-c", recreateCommand(name, self.Config.CreateCommand)}
+	s.Mounts = []specs.Mount{{Source: socket, Destination: podmanSocket, Type: "bind", Options: []string{"rw"}}}
+	// the helper has no fetchit-helper label, so the new fetchit doesn't sweep
+	// it while it is still running
+	s.Labels = map[string]string{
+		"owned-by":   FetchItLabel,
+		managedLabel: "true",
+		methodLabel:  selfUpdateMethod,
+	}
+	if _, err := createAndStartContainer(conn, s); err != nil {
+		// fetchit keeps running its current image
+		restoreJobs(fetchit)
+		return err
+	}
+	log.Infof("Self update helper started, fetchit will be recreated with image %s", shortID(latest.ID))
+	return nil
+}
+
+// restoreJobs adds back the jobs removed for a self update that could not
+// start. The methods, self update included, next run on their schedule rather
+// than right away, so a failing helper is not retried in a loop.
+func restoreJobs(f *Fetchit) {
+	runs.Lock()
+	defer runs.Unlock()
+	for method, schedInfo := range f.methodTargetScheds {
+		f.reschedule(method, schedInfo)
+	}
+	startMetricsPush(f.scheduler, f.metricsPush)
+	startWatchdog(f.scheduler)
+}
+
+// imageChanged compares the image ID of the running container with the ID of the latest pulled image
+func imageChanged(running, latest string) bool {
+	if running == "" || latest == "" {
+		return false
+	}
+	return strings.TrimPrefix(running, "sha256:") != strings.TrimPrefix(latest, "sha256:")
+}
+
+func shortID(id string) string {
+	id = strings.TrimPrefix(id, "sha256:")
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+// recreateCommand returns the shell command that replaces the named container
+// using the podman command it was created with. A container run with --rm,
+// as by the systemd units, is already gone once stopped, so stop and rm
+// ignore a missing container.
+func recreateCommand(name string, createCommand []string) string {
+	podman := "podman --url unix://" + podmanSocket
+	args := make([]string, len(createCommand)-1)
+	for i, a := range createCommand[1:] {
+		args[i] = shellQuote(a)
+	}
+	return fmt.Sprintf("%s stop --ignore %s && %s rm -f --ignore %s && %s %s", podman, shellQuote(name), podman, shellQuote(name), podman, strings.Join(args, " "))
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,76 @@
+package engine
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-co-op/gocron"
+	"go.uber.org/zap"
+)
+
+func TestImageChanged(t *testing.T) {
+	running := "sha256:1f2e3d4c5b6a79880123456789abcdef0123456789abcdef0123456789abcdef"
+	cases := []struct {
+		running, latest string
+		expected        bool
+	}{
+		{running, running, false},
+		{running, "1f2e3d4c5b6a79880123456789abcdef0123456789abcdef0123456789abcdef", false},
+		{running, "sha256:aaaa3d4c5b6a79880123456789abcdef0123456789abcdef0123456789abcdef", true},
+		{"", running, false},
+		{running, "", false},
+	}
+	for _, c := range cases {
+		if changed := imageChanged(c.running, c.latest); changed != c.expected {
+			t.Errorf("Failed: imageChanged(%q, %q): %v != %v", c.running, c.latest, changed, c.expected)
+		}
+	}
+}
+
+func TestRecreateCommand(t *testing.T) {
+	cmd := recreateCommand("fetchit", []string{"podman", "run", "-d", "--name", "fetchit", "-e", "MSG=it's", "quay.io/fetchit/fetchit:latest"})
+	expected := "podman --url unix:///run/podman/podman.sock stop --ignore 'fetchit' && " +
+		"podman --url unix:///run/podman/podman.sock rm -f --ignore 'fetchit' && " +
+		`podman --url unix:///run/podman/podman.sock 'run' '-d' '--name' 'fetchit' '-e' 'MSG=it'\''s' 'quay.io/fetchit/fetchit:latest'`
+	if cmd != expected {
+		t.Fatalf("Failed: cmd: %s != %s", cmd, expected)
+	}
+}
+
+func TestRestoreJobsAfterFailedSelfUpdate(t *testing.T) {
+	defer func(l *zap.SugaredLogger) { logger = l }(logger)
+	logger = zap.NewNop().Sugar()
+
+	f := newFetchit()
+	f.scheduler = gocron.NewScheduler(time.UTC)
+	f.conn = context.Background()
+	scheduled := &countMethod{ran: make(chan struct{}, 1)}
+	scheduled.Name = "web"
+	scheduled.Schedule = "*/5 * * * *"
+	scheduled.target = &Target{url: "https://github.com/a/web"}
+	once := &countMethod{ran: make(chan struct{}, 1)}
+	once.Name = "seed"
+	once.RunOnce = true
+	once.target = &Target{url: "https://github.com/a/seed"}
+	for _, m := range []*countMethod{scheduled, once} {
+		f.methodTargetScheds[m] = m.SchedInfo()
+	}
+	f.scheduler.StartAsync()
+	defer f.scheduler.Stop()
+
+	runs.Lock()
+	clearJobs(f)
+	runs.Unlock()
+	restoreJobs(f)
+
+	if f.scheduler.Len() != 1 {
+		t.Fatalf("Failed: expected only the job of the scheduled method back, got %d jobs", f.scheduler.Len())
+	}
+	// the methods wait for their schedule instead of running right away
+	time.Sleep(100 * time.Millisecond)
+	if r := atomic.LoadInt32(&scheduled.runs) + atomic.LoadInt32(&once.runs); r != 0 {
+		t.Fatalf("Failed: %d runs right after the jobs were restored", r)
+	}
+}
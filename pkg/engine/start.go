@@ -17,6 +17,13 @@ var startCmd = &cobra.Command{
 	Long:  `Start fetchit engine`,
 	Run: func(cmd *cobra.Command, args []string) {
 		fetchit = fetchitConfig.InitConfig(true)
+		if err := removeOrphanedHelpers(fetchit.conn); err != nil {
+			logger.Warnf("Unable to remove helper containers left from a previous run: %v", err)
+		}
+		watchPauseSignal()
+		if err := startControl(defaultControlSocket); err != nil {
+			logger.Warnf("The reconcile command is unavailable: %v", err)
+		}
 		fetchit.RunTargets()
 	},
 }
@@ -41,6 +48,12 @@ func InitLogger() {
 	logger.Debug("Fetchit debug logging enabled.")
 }
 
+// methodLogger returns the logger scoped to a method, so that each line it
+// logs carries the target, kind and name of the method
+func methodLogger(m Method) *zap.SugaredLogger {
+	return logger.With("target", targetName(m.GetTarget()), "method", m.GetKind(), "name", m.GetName())
+}
+
 func getEncoder() zapcore.Encoder {
 	cfg := zap.NewProductionEncoderConfig()
 	// The format time can be customized
@@ -0,0 +1,43 @@
+package engine
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestMethodLogger(t *testing.T) {
+	defer func(l *zap.SugaredLogger) { logger = l }(logger)
+	core, logs := observer.New(zapcore.InfoLevel)
+	logger = zap.New(core).Sugar()
+
+	named := &Raw{}
+	named.Name = "raw-ex"
+	named.target = &Target{name: "web", url: "https://github.com/containers/fetchit"}
+	unnamed := &Kube{}
+	unnamed.Name = "kube-ex"
+	unnamed.target = &Target{url: "https://github.com/containers/fetchit"}
+
+	tests := []struct {
+		m    Method
+		want map[string]interface{}
+	}{
+		{named, map[string]interface{}{"target": "web", "method": rawMethod, "name": "raw-ex"}},
+		{unnamed, map[string]interface{}{"target": "https://github.com/containers/fetchit", "method": kubeMethod, "name": "kube-ex"}},
+	}
+	for _, tt := range tests {
+		methodLogger(tt.m).Infof("Creating podman container from %s", "web.yaml")
+		entries := logs.TakeAll()
+		if len(entries) != 1 {
+			t.Fatalf("Failed: expected 1 entry, got %d", len(entries))
+		}
+		fields := entries[0].ContextMap()
+		for key, value := range tt.want {
+			if fields[key] != value {
+				t.Fatalf("Failed: %s %s: field %s is %v, expected %v", tt.m.GetKind(), tt.m.GetName(), key, fields[key], value)
+			}
+		}
+	}
+}
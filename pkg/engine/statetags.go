@@ -0,0 +1,88 @@
+package engine
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/containers/fetchit/pkg/engine/utils"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// stateTagPrefix starts the tags that record the commit each method is at
+const stateTagPrefix = "current-"
+
+// stateTag returns the tag recording the commit a method is at
+func stateTag(methodType, methodName string) string {
+	return stateTagPrefix + methodType + "-" + methodName
+}
+
+// stateTags returns the state tags of the methods of f, by the directory of
+// the clone they are kept in. Targets sharing a repository share a clone.
+func (f *Fetchit) stateTags() map[string]map[string]bool {
+	dirs := map[string]map[string]bool{}
+	for m := range f.methodTargetScheds {
+		target := m.GetTarget()
+		if target == nil || target.url == "" {
+			continue
+		}
+		dir := getDirectory(target)
+		if dirs[dir] == nil {
+			dirs[dir] = map[string]bool{}
+		}
+		dirs[dir][stateTag(m.GetKind(), m.GetName())] = true
+	}
+	return dirs
+}
+
+// removeOrphanedStateTags removes the state tags of the clones of f that no
+// method of f keeps, left behind when a method or its target is renamed or
+// removed. No method may run meanwhile.
+func (f *Fetchit) removeOrphanedStateTags() {
+	for dir, active := range f.stateTags() {
+		removed, err := removeStateTags(dir, active)
+		for _, tag := range removed {
+			logger.Infof("Removed state tag %s from %s, no configured method keeps it", tag, dir)
+		}
+		if err != nil {
+			logger.Warnf("Unable to remove orphaned state tags from %s: %v", dir, err)
+		}
+	}
+}
+
+// removeStateTags removes the state tags of the repository at dir that are
+// not active and returns the tags it removed. A repository that is not
+// cloned yet has none.
+func removeStateTags(dir string, active map[string]bool) ([]string, error) {
+	repo, err := git.PlainOpen(dir)
+	if err == git.ErrRepositoryNotExists {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, utils.WrapErr(err, "Error opening repository %s", dir)
+	}
+	tags, err := repo.Tags()
+	if err != nil {
+		return nil, utils.WrapErr(err, "Error listing tags of %s", dir)
+	}
+	var orphaned []string
+	err = tags.ForEach(func(ref *plumbing.Reference) error {
+		name := ref.Name().Short()
+		if strings.HasPrefix(name, stateTagPrefix) && !active[name] {
+			orphaned = append(orphaned, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, utils.WrapErr(err, "Error listing tags of %s", dir)
+	}
+	sort.Strings(orphaned)
+	var removed []string
+	for _, tag := range orphaned {
+		if err := repo.DeleteTag(tag); err != nil {
+			return removed, utils.WrapErr(err, "Error deleting state tag %s", tag)
+		}
+		removed = append(removed, tag)
+	}
+	return removed, nil
+}
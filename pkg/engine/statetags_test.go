@@ -0,0 +1,65 @@
+package engine
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"go.uber.org/zap"
+)
+
+func TestRemoveOrphanedStateTags(t *testing.T) {
+	defer func(l *zap.SugaredLogger) { logger = l }(logger)
+	logger = zap.NewNop().Sugar()
+	defer func(dir string) { cacheDir = dir }(cacheDir)
+	cacheDir = t.TempDir()
+
+	target := &Target{name: "web", url: "https://github.com/containers/fetchit"}
+	repo, err := git.PlainInit(getDirectory(target), false)
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	hash, err := wt.Commit("init", &git.CommitOptions{AllowEmptyCommits: true, Author: &object.Signature{Name: "test", When: time.Now()}})
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	for _, tag := range []string{stateTag(rawMethod, "web"), stateTag(kubeMethod, "web"), stateTag(rawMethod, "web-old"), "v1.0.0"} {
+		if _, err := repo.CreateTag(tag, hash, nil); err != nil {
+			t.Fatalf("Failed: %v", err)
+		}
+	}
+
+	f := newFetchit()
+	r := &Raw{CommonMethod: CommonMethod{Name: "web", target: target}}
+	k := &Kube{CommonMethod: CommonMethod{Name: "web", target: target}}
+	f.methodTargetScheds[r] = r.SchedInfo()
+	f.methodTargetScheds[k] = k.SchedInfo()
+	// a target that is not cloned yet has no tags to remove
+	missing := &Raw{CommonMethod: CommonMethod{Name: "db", target: &Target{url: "https://github.com/containers/podman"}}}
+	f.methodTargetScheds[missing] = missing.SchedInfo()
+
+	f.removeOrphanedStateTags()
+
+	tags, err := repo.Tags()
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	var kept []string
+	tags.ForEach(func(ref *plumbing.Reference) error {
+		kept = append(kept, ref.Name().Short())
+		return nil
+	})
+	sort.Strings(kept)
+	want := []string{stateTag(kubeMethod, "web"), stateTag(rawMethod, "web"), "v1.0.0"}
+	if !reflect.DeepEqual(kept, want) {
+		t.Fatalf("Failed: kept tags %v, want %v", kept, want)
+	}
+}
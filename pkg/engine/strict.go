@@ -0,0 +1,122 @@
+package engine
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/containers/fetchit/pkg/engine/utils"
+	"github.com/go-co-op/gocron"
+)
+
+var (
+	// mountArg matches a filesystem type or comma separated mount options
+	mountArg = regexp.MustCompile(`^[A-Za-z0-9._,=:-]*$`)
+	// devicePath matches the path of a block device
+	devicePath = regexp.MustCompile(`^/[A-Za-z0-9._/:-]*$`)
+)
+
+// validate returns the problems that would keep the methods of a target from
+// running, once defaults are applied
+func (tc *TargetConfig) validate() error {
+	errs := &utils.MultiError{}
+	methods := tc.commonMethods()
+	errs.Append(checkTargetName(tc.Name))
+	if len(methods) > 0 && tc.Url == "" && tc.Device == "" {
+		errs.Append(fmt.Errorf("target %s must set a url or a device", tc.Name))
+	}
+	if tc.Url != "" && tc.Branch == "" {
+		errs.Append(fmt.Errorf("target %s must set a branch", tc.Name))
+	}
+	if (tc.FilesystemType != "" || tc.MountOptions != "") && tc.Device == "" {
+		errs.Append(fmt.Errorf("target %s sets mount options without a device", tc.Name))
+	}
+	errs.Append(validateImageOverrides(tc.Name, tc.ImageOverrides))
+	if tc.Device != "" && !devicePath.MatchString(tc.Device) {
+		errs.Append(fmt.Errorf("target %s has an invalid device %q", tc.Name, tc.Device))
+	}
+	if !mountArg.MatchString(tc.FilesystemType) {
+		errs.Append(fmt.Errorf("target %s has an invalid filesystemType %q", tc.Name, tc.FilesystemType))
+	}
+	if !mountArg.MatchString(tc.MountOptions) {
+		errs.Append(fmt.Errorf("target %s has invalid mountOptions %q", tc.Name, tc.MountOptions))
+	}
+	// the scheduler is never started, it only parses the schedules
+	s := gocron.NewScheduler(time.UTC)
+	for _, m := range methods {
+		// disabled methods may be left incomplete
+		if !m.isEnabled() {
+			continue
+		}
+		if m.Name == "" {
+			errs.Append(fmt.Errorf("target %s has a method without a name", tc.Name))
+		}
+		if !validOverlap(m.Overlap) {
+			errs.Append(fmt.Errorf("method %s of target %s has an invalid overlap %q", m.Name, tc.Name, m.Overlap))
+		}
+		// runOnce methods run when they are scheduled
+		if m.RunOnce {
+			continue
+		}
+		if m.Schedule == "" {
+			errs.Append(fmt.Errorf("method %s of target %s must set a schedule", m.Name, tc.Name))
+			continue
+		}
+		if _, err := s.Cron(m.Schedule).Do(func() {}); err != nil {
+			errs.Append(fmt.Errorf("method %s of target %s has an invalid schedule %q: %v", m.Name, tc.Name, m.Schedule, err))
+		}
+	}
+	return errs.ErrorOrNil()
+}
+
+// validateTargetConfigs validates every target of the config
+func validateTargetConfigs(targetConfigs []*TargetConfig) error {
+	errs := &utils.MultiError{}
+	for _, tc := range targetConfigs {
+		errs.Append(tc.validate())
+	}
+	if err := errs.ErrorOrNil(); err != nil {
+		return &utils.ValidationError{Err: err}
+	}
+	return nil
+}
+
+// cloneTargets clones the repositories of the git targets, returning the
+// targets that could not be cloned. Repositories are cloned at the same time,
+// so a slow clone only holds back the targets of its repository.
+func (f *Fetchit) cloneTargets() error {
+	// methods sharing a clone share the result of a single clone of the repository
+	type clone struct {
+		once sync.Once
+		err  error
+	}
+	errs := &utils.MultiError{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	clones := map[string]*clone{}
+	for method := range f.methodTargetScheds {
+		// ConfigReload, PodmanAutoUpdateAll, Image, Prune methods do not include git URL
+		target := method.GetTarget()
+		if target.url == "" {
+			continue
+		}
+		dir := getDirectory(target)
+		if clones[dir] == nil {
+			clones[dir] = &clone{}
+		}
+		wg.Add(1)
+		go func(method Method, target *Target, c *clone) {
+			defer wg.Done()
+			c.once.Do(func() { c.err = getRepo(target) })
+			if c.err != nil {
+				logger.Debugf("Target: %s, clone error: %v, will retry next scheduled run", target.url, c.err)
+				mu.Lock()
+				errs.Append(utils.WrapErr(c.err, "Error cloning %s for %s %s", target.url, method.GetKind(), method.GetName()))
+				mu.Unlock()
+			}
+		}(method, target, clones[dir])
+	}
+	wg.Wait()
+	return errs.ErrorOrNil()
+}
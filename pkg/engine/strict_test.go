@@ -0,0 +1,140 @@
+package engine
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containers/fetchit/pkg/engine/utils"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"go.uber.org/zap"
+)
+
+func TestValidateTargetConfigs(t *testing.T) {
+	valid := &TargetConfig{
+		Name:   "web",
+		Url:    "https://github.com/a/web",
+		Branch: "main",
+		Raw:    []*Raw{{CommonMethod: CommonMethod{Name: "web", Schedule: "*/5 * * * *"}}},
+	}
+	if err := validateTargetConfigs([]*TargetConfig{valid}); err != nil {
+		t.Fatalf("Failed: unexpected error for valid target: %v", err)
+	}
+	once := &TargetConfig{
+		Name:   "seed",
+		Url:    "https://github.com/a/seed",
+		Branch: "main",
+		Raw:    []*Raw{{CommonMethod: CommonMethod{Name: "seed", RunOnce: true}}},
+	}
+	if err := validateTargetConfigs([]*TargetConfig{once}); err != nil {
+		t.Fatalf("Failed: run once method without a schedule: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		target *TargetConfig
+		reason string
+	}{
+		{"no source", &TargetConfig{Name: "bad", Kube: []*Kube{{CommonMethod: CommonMethod{Name: "k", Schedule: "* * * * *"}}}}, "url or a device"},
+		{"no branch", &TargetConfig{Name: "bad", Url: "https://github.com/a/bad"}, "branch"},
+		{"no schedule", &TargetConfig{Name: "bad", Device: "/dev/sdb", Raw: []*Raw{{CommonMethod: CommonMethod{Name: "r"}}}}, "schedule"},
+		{"bad schedule", &TargetConfig{Name: "bad", Device: "/dev/sdb", Raw: []*Raw{{CommonMethod: CommonMethod{Name: "r", Schedule: "every minute"}}}}, "invalid schedule"},
+		{"no name", &TargetConfig{Name: "bad", Device: "/dev/sdb", Raw: []*Raw{{CommonMethod: CommonMethod{Schedule: "* * * * *"}}}}, "without a name"},
+		{"mount without device", &TargetConfig{Name: "bad", Url: "https://github.com/a/bad", Branch: "main", FilesystemType: "exfat"}, "without a device"},
+		{"shell in mount options", &TargetConfig{Name: "bad", Device: "/dev/sdb", MountOptions: "ro; reboot"}, "invalid mountOptions"},
+		{"shell in filesystem type", &TargetConfig{Name: "bad", Device: "/dev/sdb", FilesystemType: "exfat$(reboot)"}, "invalid filesystemType"},
+		{"shell in device", &TargetConfig{Name: "bad", Device: "/dev/sdb;reboot"}, "invalid device"},
+		{"ambiguous name", &TargetConfig{Name: "bad_name", Device: "/dev/sdb"}, "may not contain"},
+	}
+	for _, tt := range tests {
+		err := validateTargetConfigs([]*TargetConfig{valid, tt.target})
+		var verr *utils.ValidationError
+		if !errors.As(err, &verr) || !strings.Contains(err.Error(), tt.reason) {
+			t.Fatalf("Failed: %s: expected validation error about %q, got %v", tt.name, tt.reason, err)
+		}
+	}
+}
+
+func TestCloneTargetsStrict(t *testing.T) {
+	defer func(l *zap.SugaredLogger, dir string) { logger, cacheDir = l, dir }(logger, cacheDir)
+	logger = zap.NewNop().Sugar()
+	cacheDir = t.TempDir()
+
+	f := newFetchit()
+	missing := &Raw{CommonMethod: CommonMethod{Name: "web"}}
+	missing.target = &Target{url: filepath.Join(t.TempDir(), "missing"), branch: "main"}
+	f.methodTargetScheds[missing] = missing.SchedInfo()
+	prune := &Prune{}
+	prune.target = &Target{}
+	f.methodTargetScheds[prune] = prune.SchedInfo()
+
+	err := f.cloneTargets()
+	if err == nil || !strings.Contains(err.Error(), "raw web") {
+		t.Fatalf("Failed: expected clone error for raw web, got %v", err)
+	}
+}
+
+func TestCloneTargetsTimeout(t *testing.T) {
+	defer func(l *zap.SugaredLogger, dir string, timeout time.Duration) {
+		logger, cacheDir, cloneTimeout = l, dir, timeout
+	}(logger, cacheDir, cloneTimeout)
+	logger = zap.NewNop().Sugar()
+	dir := t.TempDir()
+	cacheDir = filepath.Join(dir, "cache")
+	cloneTimeout = 200 * time.Millisecond
+
+	// the slow remote never answers, until the clone gives up
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	remote := filepath.Join(dir, "remote")
+	repo, err := git.PlainInit(remote, false)
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	if _, err := wt.Commit("init", &git.CommitOptions{AllowEmptyCommits: true, Author: &object.Signature{Name: "test", When: time.Now()}}); err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+
+	f := newFetchit()
+	slow := &Raw{CommonMethod: CommonMethod{Name: "giant"}}
+	slow.target = &Target{url: srv.URL + "/giant.git", branch: "main"}
+	f.methodTargetScheds[slow] = slow.SchedInfo()
+	fast := &Raw{CommonMethod: CommonMethod{Name: "web"}}
+	fast.target = &Target{url: remote, branch: head.Name().Short()}
+	f.methodTargetScheds[fast] = fast.SchedInfo()
+
+	start := time.Now()
+	err = f.cloneTargets()
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Failed: cloning took %s with a cloneTimeout of %s", elapsed, cloneTimeout)
+	}
+	if err == nil || !strings.Contains(err.Error(), "raw giant") || !strings.Contains(err.Error(), "cloneTimeout") {
+		t.Fatalf("Failed: expected a clone timeout for raw giant, got %v", err)
+	}
+	if strings.Contains(err.Error(), "raw web") {
+		t.Fatalf("Failed: the slow clone kept raw web from cloning: %v", err)
+	}
+	if _, err := git.PlainOpen(getDirectory(fast.target)); err != nil {
+		t.Fatalf("Failed: raw web was not cloned: %v", err)
+	}
+	if _, err := os.Stat(getDirectory(slow.target)); !os.IsNotExist(err) {
+		t.Fatalf("Failed: the cancelled clone of raw giant was left behind: %v", err)
+	}
+}
@@ -30,8 +30,9 @@ const (
 type Systemd struct {
 	CommonMethod `mapstructure:",squash"`
 	// If true, will place unit file in /etc/systemd/system/
-	// If false (default) will place unit file in ~/.config/systemd/user/
-	Root bool `mapstructure:"root"`
+	// If false will place unit file in ~/.config/systemd/user/
+	// If unset, follows whether fetchit detected a rootful or rootless podman
+	Root *bool `mapstructure:"root"`
 	// If true, will enable and start all systemd services from fetched unit files
 	// If true, will reload and restart the services with every scheduled run
 	// Implies Enable=true, will override Enable=false
@@ -55,8 +56,9 @@ type PodmanAutoUpdate struct {
 func (p *PodmanAutoUpdate) AutoUpdateSystemd() []*Systemd {
 	var sysds []*Systemd
 	if p.Root {
+		root := true
 		sd := &Systemd{
-			Root:          true,
+			Root:          &root,
 			autoUpdateAll: true,
 			// Schedule with Autoupdate is no-op
 			CommonMethod: CommonMethod{
@@ -67,8 +69,9 @@ func (p *PodmanAutoUpdate) AutoUpdateSystemd() []*Systemd {
 		sysds = append(sysds, sd)
 	}
 	if p.User {
+		root := false
 		sd := &Systemd{
-			Root:          false,
+			Root:          &root,
 			autoUpdateAll: true,
 			// Schedule with Autoupdate is no-op
 			CommonMethod: CommonMethod{
@@ -81,11 +84,18 @@ func (p *PodmanAutoUpdate) AutoUpdateSystemd() []*Systemd {
 	return sysds
 }
 
+// isRoot reports whether unit files are managed for root, defaulting to the detected podman mode
+func (sd *Systemd) isRoot() bool {
+	rootless := fetchit != nil && fetchit.rootless
+	return boolOrDefault(sd.Root, !rootless)
+}
+
 func (sd *Systemd) GetKind() string {
 	return systemdMethod
 }
 
 func (sd *Systemd) Process(ctx, conn context.Context, skew int) {
+	log := methodLogger(sd)
 	target := sd.GetTarget()
 	time.Sleep(time.Duration(skew) * time.Millisecond)
 	target.mu.Lock()
@@ -94,34 +104,34 @@ func (sd *Systemd) Process(ctx, conn context.Context, skew int) {
 	if sd.autoUpdateAll && !sd.initialRun {
 		return
 	}
-	tag := []string{".service"}
+	tags := sd.getTags(systemdMethod)
 	if sd.Restart {
 		sd.Enable = true
 	}
 	if sd.initialRun {
 		if sd.autoUpdateAll {
 			if err := sd.MethodEngine(ctx, conn, nil, ""); err != nil {
-				logger.Infof("Failed to start podman-auto-update.service: %v", err)
+				log.Infof("Failed to start podman-auto-update.service: %v", err)
 			}
 			sd.initialRun = false
 			return
 		}
 		err := getRepo(target)
 		if err != nil {
-			logger.Errorf("Failed to clone repository %s: %v", target.url, err)
+			log.Errorf("Failed to clone repository %s: %v", target.url, err)
 			return
 		}
 
-		err = zeroToCurrent(ctx, conn, sd, target, &tag)
+		err = zeroToCurrent(ctx, conn, sd, target, tags)
 		if err != nil {
-			logger.Errorf("Error moving to current: %v", err)
+			log.Errorf("Error moving to current: %v", err)
 			return
 		}
 	}
 
-	err := currentToLatest(ctx, conn, sd, target, &tag)
+	err := currentToLatest(ctx, conn, sd, target, tags)
 	if err != nil {
-		logger.Errorf("Error moving current to latest: %v", err)
+		log.Errorf("Error moving current to latest: %v", err)
 		return
 	}
 
@@ -158,7 +168,7 @@ func (sd *Systemd) MethodEngine(ctx context.Context, conn context.Context, chang
 		return fmt.Errorf("Could not determine $HOME for host, must set $HOME on host machine for non-root systemd method")
 	}
 	var dest string
-	if sd.Root {
+	if sd.isRoot() {
 		dest = systemdPathRoot
 	} else {
 		dest = filepath.Join(nonRootHomeDir, ".config", "systemd", "user")
@@ -170,7 +180,7 @@ func (sd *Systemd) MethodEngine(ctx context.Context, conn context.Context, chang
 }
 
 func (sd *Systemd) Apply(ctx, conn context.Context, currentState, desiredState plumbing.Hash, tags *[]string) error {
-	changeMap, err := applyChanges(ctx, sd.GetTarget(), sd.GetTargetPath(), sd.Glob, currentState, desiredState, tags)
+	changeMap, err := sd.targetChanges(ctx, currentState, desiredState, tags)
 	if err != nil {
 		return err
 	}
@@ -181,7 +191,8 @@ func (sd *Systemd) Apply(ctx, conn context.Context, currentState, desiredState p
 }
 
 func (sd *Systemd) systemdPodman(ctx context.Context, conn context.Context, path, dest string, prev *string, curr *string, changeType *string) error {
-	logger.Infof("Deploying systemd file(s) %s", path)
+	log := methodLogger(sd)
+	log.Infof("Deploying systemd file(s) %s", path)
 	if sd.autoUpdateAll {
 		if !sd.initialRun {
 			return nil
@@ -194,7 +205,8 @@ func (sd *Systemd) systemdPodman(ctx context.Context, conn context.Context, path
 	if sd.initialRun {
 		ft := &FileTransfer{
 			CommonMethod: CommonMethod{
-				Name: sd.Name,
+				Name:   sd.Name,
+				target: sd.target,
 			},
 		}
 		if err := ft.fileTransferPodman(ctx, conn, path, dest, prev); err != nil {
@@ -202,7 +214,7 @@ func (sd *Systemd) systemdPodman(ctx context.Context, conn context.Context, path
 		}
 	}
 	if !sd.Enable {
-		logger.Infof("Systemd target %s successfully processed", sd.Name)
+		log.Infof("Systemd target %s successfully processed", sd.Name)
 		return nil
 	}
 	if *changeType == "create" {
@@ -224,22 +236,24 @@ func (sd *Systemd) systemdPodman(ctx context.Context, conn context.Context, path
 	if *changeType == "delete" {
 		return sd.enableRestartSystemdService(conn, "stop", dest, filepath.Base(*prev))
 	}
-	logger.Infof("Systemd target %s %s not processed", sd.Name, *changeType)
+	log.Infof("Systemd target %s %s not processed", sd.Name, *changeType)
 	return nil
 }
 
 func (sd *Systemd) enableRestartSystemdService(conn context.Context, action, dest, service string) error {
+	log := methodLogger(sd)
 	act := action
 	if action == "autoupdate" {
 		act = "enable"
 	}
-	logger.Infof("Systemd target: %s, running systemctl %s %s", sd.Name, act, service)
+	log.Infof("Systemd target: %s, running systemctl %s %s", sd.Name, act, service)
 	if err := detectOrFetchImage(conn, systemdImage, false); err != nil {
 		return err
 	}
 
+	root := sd.isRoot()
 	// TODO: remove
-	if sd.Root {
+	if root {
 		os.Setenv("ROOT", "true")
 	} else {
 		os.Setenv("ROOT", "false")
@@ -249,7 +263,7 @@ func (sd *Systemd) enableRestartSystemdService(conn context.Context, action, des
 	runMountsd := "/run/systemd"
 	runMountc := "/sys/fs/cgroup"
 	xdg := ""
-	if !sd.Root {
+	if !root {
 		// need to document this for non-root usage
 		// can't use user.Current because always root in fetchit container
 		xdg = os.Getenv("XDG_RUNTIME_DIR")
@@ -269,13 +283,14 @@ func (sd *Systemd) enableRestartSystemdService(conn context.Context, action, des
 	} else {
 		s.Mounts = []specs.Mount{{Source: dest, Destination: dest, Type: define.TypeBind, Options: []string{"rw"}}, {Source: runMounttmp, Destination: runMounttmp, Type: define.TypeTmpfs, Options: []string{"rw"}}, {Source: runMountc, Destination: runMountc, Type: define.TypeBind, Options: []string{"ro"}}, {Source: runMountsd, Destination: runMountsd, Type: define.TypeBind, Options: []string{"rw"}}}
 	}
-	s.Name = "systemd-" + act + "-" + service + "-" + sd.Name
+	s.Name = "systemd-" + act + "-" + service + "-" + sd.containerName()
+	s.Labels = helperLabels(systemdMethod, targetName(sd.GetTarget()))
 	envMap := make(map[string]string)
-	envMap["ROOT"] = strconv.FormatBool(sd.Root)
+	envMap["ROOT"] = strconv.FormatBool(root)
 	envMap["SERVICE"] = service
 	envMap["ACTION"] = act
 	envMap["HOME"] = os.Getenv("HOME")
-	if !sd.Root {
+	if !root {
 		envMap["XDG_RUNTIME_DIR"] = xdg
 	}
 	s.Env = envMap
@@ -288,6 +303,6 @@ func (sd *Systemd) enableRestartSystemdService(conn context.Context, action, des
 	if err != nil {
 		return err
 	}
-	logger.Infof("Systemd target %s-%s %s complete", sd.Name, act, service)
+	log.Infof("Systemd target %s-%s %s complete", sd.Name, act, service)
 	return nil
 }
@@ -0,0 +1,133 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/containers/fetchit/pkg/engine/utils"
+	"github.com/containers/podman/v4/pkg/bindings/system"
+)
+
+// Templating renders the files of a method as go templates with the facts of
+// the host before they are applied, so one file can be deployed to many hosts
+type Templating struct {
+	// Template renders each file with text/template, e.g. {{ .Hostname }}
+	Template bool `mapstructure:"template"`
+	// facts are gathered once per run of the method
+	facts *hostFacts
+}
+
+// hostFacts are the values of the host available to templated files
+type hostFacts struct {
+	Hostname string
+	OS       string
+	Arch     string
+	// IP is the first non-loopback IPv4 address, IPs holds every non-loopback
+	// address. They are only the host's addresses when fetchit uses the host network.
+	IP  string
+	IPs []string
+	// Env is the environment of the host, such as dev or prod, from $FETCHIT_ENV
+	Env string
+}
+
+// gatherHostFacts reads the host's name and platform from podman, which
+// runs on the host rather than in the fetchit container
+func gatherHostFacts(conn context.Context) (*hostFacts, error) {
+	info, err := system.Info(conn, nil)
+	if err != nil {
+		return nil, &utils.PodmanError{Err: utils.WrapErr(err, "Error getting host info")}
+	}
+	facts := &hostFacts{
+		Hostname: info.Host.Hostname,
+		OS:       info.Host.OS,
+		Arch:     info.Host.Arch,
+		Env:      os.Getenv("FETCHIT_ENV"),
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, utils.WrapErr(err, "Error listing network addresses")
+	}
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.IsLoopback() {
+			continue
+		}
+		facts.IPs = append(facts.IPs, ipnet.IP.String())
+		if facts.IP == "" && ipnet.IP.To4() != nil {
+			facts.IP = ipnet.IP.String()
+		}
+	}
+	return facts, nil
+}
+
+// renderTemplate executes contents as a text/template with the host facts.
+// Unknown facts are errors so that a typo is not deployed as an empty value.
+func renderTemplate(name string, contents []byte, facts *hostFacts) ([]byte, error) {
+	t, err := template.New(name).Option("missingkey=error").Parse(string(contents))
+	if err != nil {
+		return nil, &utils.ValidationError{Err: utils.WrapErr(err, "Error parsing template %s", name)}
+	}
+	out := &bytes.Buffer{}
+	if err := t.Execute(out, facts); err != nil {
+		return nil, &utils.ValidationError{Err: utils.WrapErr(err, "Error rendering template %s", name)}
+	}
+	return out.Bytes(), nil
+}
+
+// render returns the contents of a file rendered with the host facts, or
+// unchanged when templating is not enabled
+func (t *Templating) render(conn context.Context, name string, contents []byte) ([]byte, error) {
+	if !t.Template {
+		return contents, nil
+	}
+	facts, err := t.hostFacts(conn)
+	if err != nil {
+		return nil, err
+	}
+	return renderTemplate(name, contents, facts)
+}
+
+// hostFacts returns the facts of the host, gathering them on the first use of a run
+func (t *Templating) hostFacts(conn context.Context) (*hostFacts, error) {
+	if t.facts == nil {
+		facts, err := gatherHostFacts(conn)
+		if err != nil {
+			return nil, err
+		}
+		t.facts = facts
+	}
+	return t.facts, nil
+}
+
+// renderNames resolves the templated container names of a raw file that is
+// not rendered as a whole, such as {{ .Hostname }}-web, so that names are
+// unique across hosts without templating the rest of the file
+func (t *Templating) renderNames(conn context.Context, file string, raws []*RawPod) error {
+	if t.Template {
+		return nil
+	}
+	for _, raw := range raws {
+		if !strings.Contains(raw.Name, "{{") {
+			continue
+		}
+		facts, err := t.hostFacts(conn)
+		if err != nil {
+			return err
+		}
+		name, err := renderTemplate(file, []byte(raw.Name), facts)
+		if err != nil {
+			return err
+		}
+		raw.Name = string(name)
+	}
+	return nil
+}
+
+// resetFacts makes the next render gather the host facts again
+func (t *Templating) resetFacts() {
+	t.facts = nil
+}
@@ -0,0 +1,90 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/containers/fetchit/pkg/engine/utils"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	facts := &hostFacts{Hostname: "edge-01", OS: "linux", Arch: "arm64", IP: "10.0.0.5", IPs: []string{"10.0.0.5", "fe80::1"}}
+	file := []byte(`Image: quay.io/acme/agent:{{ .Arch }}
+Name: "agent-{{ .Hostname }}"
+Env:
+  NODE_IP: "{{ .IP }}"
+`)
+
+	r := &Raw{Templating: Templating{Template: true, facts: facts}}
+	raws, err := r.parseRawPods(nil, "agent.yaml", file)
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	if raws[0].Image != "quay.io/acme/agent:arm64" || raws[0].Name != "agent-edge-01" || raws[0].Env["NODE_IP"] != "10.0.0.5" {
+		t.Fatalf("Failed: template not rendered with host facts: %+v", raws[0])
+	}
+
+	// files of methods without templating are applied verbatim, except for their names
+	r = &Raw{Templating: Templating{facts: facts}}
+	raws, err = r.parseRawPods(nil, "agent.yaml", file)
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	if raws[0].Name != "agent-edge-01" || raws[0].Image != "quay.io/acme/agent:{{ .Arch }}" || raws[0].Env["NODE_IP"] != "{{ .IP }}" {
+		t.Fatalf("Failed: file without templating was changed beyond its name: %+v", raws[0])
+	}
+	k := &Kube{}
+	out, err := k.render(nil, "pod.yaml", file)
+	if err != nil || string(out) != string(file) {
+		t.Fatalf("Failed: file without templating was changed: %q: %v", out, err)
+	}
+
+	k = &Kube{Templating: Templating{Template: true, facts: facts}}
+	_, err = k.render(nil, "pod.yaml", []byte("name: {{ .Hostnme }}\n"))
+	var validationErr *utils.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Failed: expected a validation error for an unknown fact, got %v", err)
+	}
+}
+
+func TestTemplatedNames(t *testing.T) {
+	facts := &hostFacts{Hostname: "edge-01"}
+	prev := []byte(`Image: quay.io/acme/web:v1
+Name: "{{ .Hostname }}-web"
+`)
+	next := []byte(`Image: quay.io/acme/web:v2
+Name: "{{ .Hostname }}-web"
+---
+Image: quay.io/acme/web:v2
+Name: web-static
+`)
+	r := &Raw{Templating: Templating{facts: facts}}
+
+	// the container created from the new file
+	raws, err := r.parseRawPods(nil, "web.yaml", next)
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	s, err := createSpecGen(*raws[0])
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	if s.Name != "edge-01-web" || raws[1].Name != "web-static" {
+		t.Fatalf("Failed: created containers %s and %s, expected edge-01-web and web-static", s.Name, raws[1].Name)
+	}
+
+	// the container deleted for the previous file
+	prevRaws, err := r.parseRawPods(nil, "web.yaml", prev)
+	if err != nil {
+		t.Fatalf("Failed: %v", err)
+	}
+	if prevRaws[0].Name != s.Name {
+		t.Fatalf("Failed: deleted container %s, expected the created name %s", prevRaws[0].Name, s.Name)
+	}
+
+	_, err = r.parseRawPods(nil, "web.yaml", []byte(`Name: "{{ .Hostnme }}-web"`))
+	var validationErr *utils.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Failed: expected a validation error for an unknown fact, got %v", err)
+	}
+}
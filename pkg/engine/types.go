@@ -3,6 +3,7 @@ package engine
 import (
 	"context"
 	"sync"
+	"time"
 
 	"github.com/go-co-op/gocron"
 	"github.com/go-git/go-git/v5/plumbing"
@@ -21,35 +22,102 @@ type Method interface {
 // FetchitConfig requires necessary objects to process targets
 type FetchitConfig struct {
 	GitAuth          *GitAuth          `mapstructure:"gitAuth"`
+	Defaults         *Defaults         `mapstructure:"defaults"`
 	TargetConfigs    []*TargetConfig   `mapstructure:"targetConfigs"`
 	ConfigReload     *ConfigReload     `mapstructure:"configReload"`
 	Prune            *Prune            `mapstructure:"prune"`
 	PodmanAutoUpdate *PodmanAutoUpdate `mapstructure:"podmanAutoUpdate"`
 	Images           []*Image          `mapstructure:"images"`
-	conn             context.Context
-	scheduler        *gocron.Scheduler
+	SelfUpdate       *SelfUpdate       `mapstructure:"selfUpdate"`
+	// CacheDir is the directory, relative to the fetchit volume, that repositories are cloned into
+	CacheDir string `mapstructure:"cacheDir"`
+	// KeepFailed leaves helper containers that exit non-zero in place for debugging,
+	// they are removed before the next run of the same helper. Failed raw containers
+	// are kept as <name>-failed when they are replaced.
+	KeepFailed bool `mapstructure:"keepFailed"`
+	// MaxFileSize is the size, such as 512k or 10m, above which files are skipped, 0 disables the limit
+	MaxFileSize string `mapstructure:"maxFileSize"`
+	// FetchReuse is how long the head fetched for a target is shared by its
+	// methods, 30s if unset, 0 fetches on every run
+	FetchReuse *time.Duration `mapstructure:"fetchReuse"`
+	// ImagePullPolicy is always, ifnotpresent or never, overriding the pull behavior of each method
+	ImagePullPolicy string `mapstructure:"imagePullPolicy"`
+	// LeastPrivilege runs file copy and ansible helpers with only the capabilities they
+	// need instead of privileged, helpers for systemd and devices stay privileged
+	LeastPrivilege bool `mapstructure:"leastPrivilege"`
+	// MaxConcurrency is the most methods that process at the same time, 0 for no limit
+	MaxConcurrency int `mapstructure:"maxConcurrency"`
+	// Paused makes every method skip processing until it is unset
+	Paused bool `mapstructure:"paused"`
+	// Strict refuses to start when a target is invalid or can't be cloned
+	Strict bool `mapstructure:"strict"`
+	// CleanRemovedTargets removes the clones of targets a config reload removes
+	CleanRemovedTargets bool `mapstructure:"cleanRemovedTargets"`
+	// PodmanConnection is the podman service to manage, the local socket if unset
+	PodmanConnection *PodmanConnection `mapstructure:"podmanConnection"`
+	// Health serves liveness and readiness probes when its address is set
+	Health *Health `mapstructure:"health"`
+	// Registries configures the TLS trust of registries with their own CA
+	Registries []*RegistryTrust `mapstructure:"registries"`
+	// PullRetries is how many times a failed image pull is retried
+	PullRetries int `mapstructure:"pullRetries"`
+	// PullRetryDelay is the wait between attempts of a failed image pull, 5s if unset
+	PullRetryDelay time.Duration `mapstructure:"pullRetryDelay"`
+	// PullMirror is a registry, such as mirror.example.com, images are pulled from when their registry fails
+	PullMirror string `mapstructure:"pullMirror"`
+	// Overlap is skip, wait or allow, what happens to a run of a method that is due while its
+	// last run is in progress, skip if unset
+	Overlap string `mapstructure:"overlap"`
+	// MetricsPush pushes metrics to a Prometheus Pushgateway when set
+	MetricsPush *MetricsPush `mapstructure:"metricsPush"`
+	// DefaultSchedule is the schedule of the methods that set none, every 5 minutes if unset
+	DefaultSchedule string `mapstructure:"defaultSchedule"`
+	// CloneTimeout is how long cloning a target may take before it is cancelled and
+	// retried on the next run, 10m if unset
+	CloneTimeout time.Duration `mapstructure:"cloneTimeout"`
+
+	conn      context.Context
+	scheduler *gocron.Scheduler
 }
 
 type TargetConfig struct {
-	Name              string             `mapstructure:"name"`
-	Url               string             `mapstructure:"url"`
-	Device            string             `mapstructure:"device"`
+	// Name is included in the names of helper containers, so it should be
+	// unique across all targetConfigs
+	Name   string `mapstructure:"name"`
+	Url    string `mapstructure:"url"`
+	Device string `mapstructure:"device"`
+	// FilesystemType is the type of the filesystem on the device, such as exfat, passed to mount with -t
+	FilesystemType string `mapstructure:"filesystemType"`
+	// MountOptions are the comma separated options the device is mounted with, passed to mount with -o
+	MountOptions      string             `mapstructure:"mountOptions"`
 	Disconnected      bool               `mapstructure:"disconnected"`
 	VerifyCommitsInfo *VerifyCommitsInfo `mapstructure:"verifyCommitsInfo"`
 	Branch            string             `mapstructure:"branch"`
+	Schedule          string             `mapstructure:"schedule"`
+	Skew              *int               `mapstructure:"skew"`
+	Glob              *string            `mapstructure:"glob"`
 	Ansible           []*Ansible         `mapstructure:"ansible"`
 	FileTransfer      []*FileTransfer    `mapstructure:"filetransfer"`
 	Kube              []*Kube            `mapstructure:"kube"`
 	Raw               []*Raw             `mapstructure:"raw"`
 	Systemd           []*Systemd         `mapstructure:"systemd"`
+	Volume            []*Volume          `mapstructure:"volume"`
+	Network           []*Network         `mapstructure:"network"`
+	// PublicKey is the path to a PEM public key, when set the zip of a disconnected
+	// target is only extracted if the signature at its url with .sig appended verifies
+	PublicKey string `mapstructure:"publicKey"`
+	// ImageOverrides replace the images of raw and kube files before they are deployed
+	ImageOverrides []*ImageOverride `mapstructure:"imageOverrides"`
 
 	image        *Image
 	prune        *Prune
+	selfUpdate   *SelfUpdate
 	configReload *ConfigReload
 	mu           sync.Mutex
 }
 
 type Target struct {
+	name            string
 	ssh             bool
 	sshKey          string
 	url             string
@@ -58,17 +126,34 @@ type Target struct {
 	username        string
 	password        string
 	device          string
+	filesystemType  string
+	mountOptions    string
 	localPath       string
 	branch          string
 	mu              sync.Mutex
 	disconnected    bool
 	gitsignVerify   bool
 	gitsignRekorURL string
+	publicKey       string
+	// imageOverrides maps the images of raw and kube files to the images deployed instead
+	imageOverrides map[string]string
+	// fetchTags fetches every tag of the repository along with the branch
+	fetchTags bool
+	// latest is the head fetched at fetchedAt, shared by the methods of the target
+	latest    plumbing.Hash
+	fetchedAt time.Time
+	// removed is set, with runs held, when a config reload removes or edits the target
+	removed bool
 }
 
 type SchedInfo struct {
 	schedule string
 	skew     *int
+	timeout  time.Duration
+	// runOnce runs the method right away and then removes its job
+	runOnce bool
+	// overlap is the overlap policy of the method, empty for the config's
+	overlap string
 }
 
 type VerifyCommitsInfo struct {
@@ -1,8 +1,109 @@
 package utils
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
 
+// WrapErr prefixes e with a formatted message. The returned error wraps e so it
+// can be inspected with errors.Is and errors.As. If e is nil only the message is returned.
 func WrapErr(e error, msg string, args ...interface{}) error {
 	final_msg := fmt.Sprintf(msg, args...)
-	return fmt.Errorf("%s: %s", final_msg, e)
+	if e == nil {
+		return errors.New(final_msg)
+	}
+	return fmt.Errorf("%s: %w", final_msg, e)
+}
+
+// GitError is an error from cloning, fetching or reading a git repository
+type GitError struct {
+	Err error
+}
+
+func (e *GitError) Error() string { return e.Err.Error() }
+func (e *GitError) Unwrap() error { return e.Err }
+
+// PodmanError is an error returned by the podman API
+type PodmanError struct {
+	Err error
+}
+
+func (e *PodmanError) Error() string { return e.Err.Error() }
+func (e *PodmanError) Unwrap() error { return e.Err }
+
+// ValidationError is an error in a config or deployment file
+type ValidationError struct {
+	Err error
+}
+
+func (e *ValidationError) Error() string { return e.Err.Error() }
+func (e *ValidationError) Unwrap() error { return e.Err }
+
+// Classify returns the category of an error: git, podman, validation or unknown
+func Classify(e error) string {
+	var gitErr *GitError
+	var podmanErr *PodmanError
+	var validationErr *ValidationError
+	switch {
+	case errors.As(e, &gitErr):
+		return "git"
+	case errors.As(e, &podmanErr):
+		return "podman"
+	case errors.As(e, &validationErr):
+		return "validation"
+	}
+	return "unknown"
+}
+
+// MultiError aggregates the errors of a batch of operations
+type MultiError struct {
+	Errors []error
+}
+
+// Append adds e to the aggregate if it is not nil
+func (m *MultiError) Append(e error) {
+	if e != nil {
+		m.Errors = append(m.Errors, e)
+	}
+}
+
+// ErrorOrNil returns nil if no errors were appended
+func (m *MultiError) ErrorOrNil() error {
+	if m == nil || len(m.Errors) == 0 {
+		return nil
+	}
+	return m
+}
+
+func (m *MultiError) Error() string {
+	if len(m.Errors) == 1 {
+		return m.Errors[0].Error()
+	}
+	msgs := make([]string, len(m.Errors))
+	for i, e := range m.Errors {
+		msgs[i] = e.Error()
+	}
+	return fmt.Sprintf("%d errors occurred: %s", len(m.Errors), strings.Join(msgs, "; "))
+}
+
+// Is reports whether any of the aggregated errors matches target
+func (m *MultiError) Is(target error) bool {
+	for _, e := range m.Errors {
+		if errors.Is(e, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first of the aggregated errors that matches target, so that
+// errors.As and Classify see through the aggregate
+func (m *MultiError) As(target interface{}) bool {
+	for _, e := range m.Errors {
+		if errors.As(e, target) {
+			return true
+		}
+	}
+	return false
 }
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"errors"
+	"fmt"
 	"testing"
 )
 
@@ -21,4 +22,65 @@ func TestWrapErr(t *testing.T) {
 	if err != expected {
 		t.Fatalf("Failed: err: %s != %s", err, expected)
 	}
+
+	err = WrapErr(nil, msg, "test").Error()
+	expected = "Error test"
+	if err != expected {
+		t.Fatalf("Failed: err: %s != %s", err, expected)
+	}
+
+	wrapped := WrapErr(WrapErr(e, "inner"), "outer")
+	if !errors.Is(wrapped, e) {
+		t.Fatalf("Failed: errors.Is did not find %v in %v", e, wrapped)
+	}
+	var podmanErr *PodmanError
+	wrapped = WrapErr(&PodmanError{Err: e}, "Error creating container")
+	if !errors.As(wrapped, &podmanErr) {
+		t.Fatalf("Failed: errors.As did not find PodmanError in %v", wrapped)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	e := errors.New("other_err")
+	cases := map[string]error{
+		"git":        &GitError{Err: e},
+		"podman":     fmt.Errorf("Error creating container: %w", &PodmanError{Err: e}),
+		"validation": &ValidationError{Err: e},
+		"unknown":    e,
+	}
+	for expected, err := range cases {
+		if kind := Classify(err); kind != expected {
+			t.Fatalf("Failed: kind: %s != %s", kind, expected)
+		}
+	}
+}
+
+func TestMultiError(t *testing.T) {
+	m := &MultiError{}
+	m.Append(nil)
+	if m.ErrorOrNil() != nil {
+		t.Fatalf("Failed: expected nil error")
+	}
+
+	m.Append(errors.New("a.yaml: other_err"))
+	expected := "a.yaml: other_err"
+	if err := m.ErrorOrNil().Error(); err != expected {
+		t.Fatalf("Failed: err: %s != %s", err, expected)
+	}
+
+	m.Append(&PodmanError{Err: errors.New("b.yaml: no such image")})
+	expected = "2 errors occurred: a.yaml: other_err; b.yaml: no such image"
+	if err := m.ErrorOrNil().Error(); err != expected {
+		t.Fatalf("Failed: err: %s != %s", err, expected)
+	}
+	if kind := Classify(m.Errors[1]); kind != "podman" {
+		t.Fatalf("Failed: kind: %s != podman", kind)
+	}
+	wrapped := fmt.Errorf("Failed to apply changes: %w", m.ErrorOrNil())
+	if kind := Classify(wrapped); kind != "podman" {
+		t.Fatalf("Failed: aggregate kind: %s != podman", kind)
+	}
+	if !errors.Is(wrapped, m.Errors[0]) {
+		t.Fatalf("Failed: expected errors.Is to find %v in the aggregate", m.Errors[0])
+	}
 }
@@ -0,0 +1,32 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// Build metadata, set at build time with
+// -ldflags "-X github.com/containers/fetchit/pkg/engine.version=v0.1.0"
+var (
+	version   = "0.0.0"
+	gitCommit = "unknown"
+	buildDate = "unknown"
+)
+
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "Print the fetchit version",
+	Long:  `Print the fetchit version, git commit and build date`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Fprintf(cmd.OutOrStdout(), "fetchit version %s\n", versionString())
+	},
+}
+
+func versionString() string {
+	return fmt.Sprintf("%s (commit: %s, built: %s)", version, gitCommit, buildDate)
+}
+
+func init() {
+	fetchitCmd.AddCommand(versionCmd)
+}
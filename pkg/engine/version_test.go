@@ -0,0 +1,20 @@
+package engine
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestVersionCmd(t *testing.T) {
+	defer func(v, c, d string) { version, gitCommit, buildDate = v, c, d }(version, gitCommit, buildDate)
+	version, gitCommit, buildDate = "v0.1.0", "3f2c1ab", "2022-11-10T12:00:00Z"
+
+	out := &bytes.Buffer{}
+	versionCmd.SetOut(out)
+	versionCmd.Run(versionCmd, nil)
+
+	expected := "fetchit version v0.1.0 (commit: 3f2c1ab, built: 2022-11-10T12:00:00Z)\n"
+	if out.String() != expected {
+		t.Fatalf("Failed: output: %q != %q", out.String(), expected)
+	}
+}
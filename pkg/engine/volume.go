@@ -0,0 +1,266 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"time"
+
+	"github.com/containers/fetchit/pkg/engine/utils"
+	"github.com/containers/podman/v4/pkg/bindings/containers"
+	"github.com/containers/podman/v4/pkg/bindings/volumes"
+	"github.com/containers/podman/v4/pkg/domain/entities"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	volumeMethod        = "volume"
+	defaultVolumeDriver = "local"
+)
+
+// Volume to manage named podman volumes from json or yaml files
+type Volume struct {
+	CommonMethod `mapstructure:",squash"`
+}
+
+/* below is an example volume.yaml file:
+Name: app-data
+Driver: local
+Options:
+  type: tmpfs
+  device: tmpfs
+  o: size=100m
+Labels:
+  app: colors
+*/
+
+type VolumeDef struct {
+	Name    string            `json:"Name" yaml:"Name"`
+	Driver  string            `json:"Driver" yaml:"Driver"`
+	Options map[string]string `json:"Options" yaml:"Options"`
+	Labels  map[string]string `json:"Labels" yaml:"Labels"`
+}
+
+func (v *Volume) GetKind() string {
+	return volumeMethod
+}
+
+func (v *Volume) Process(ctx, conn context.Context, skew int) {
+	log := methodLogger(v)
+	time.Sleep(time.Duration(skew) * time.Millisecond)
+	target := v.GetTarget()
+	target.mu.Lock()
+	defer target.mu.Unlock()
+
+	tags := v.getTags(volumeMethod)
+
+	if v.initialRun {
+		err := getRepo(target)
+		if err != nil {
+			log.Errorf("Failed to clone repository %s: %v", target.url, err)
+			return
+		}
+
+		err = zeroToCurrent(ctx, conn, v, target, tags)
+		if err != nil {
+			log.Errorf("Error moving to current: %v", err)
+			return
+		}
+	}
+
+	err := currentToLatest(ctx, conn, v, target, tags)
+	if err != nil {
+		log.Errorf("Error moving current to latest: %v", err)
+		return
+	}
+
+	v.initialRun = false
+}
+
+func (v *Volume) MethodEngine(ctx context.Context, conn context.Context, change *object.Change, path string) error {
+	prev, err := getChangeString(change)
+	if err != nil {
+		return err
+	}
+	volumeFile, err := getChangeContents(change, path)
+	if err != nil {
+		return err
+	}
+	return v.volumePodman(ctx, conn, path, volumeFile, prev)
+}
+
+func (v *Volume) Apply(ctx, conn context.Context, currentState, desiredState plumbing.Hash, tags *[]string) error {
+	changeMap, err := v.targetChanges(ctx, currentState, desiredState, tags)
+	if err != nil {
+		return err
+	}
+	if err := runChanges(ctx, conn, v, changeMap); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (v *Volume) volumePodman(ctx, conn context.Context, path string, volumeFile []byte, prev *string) error {
+	log := methodLogger(v)
+	var def *VolumeDef
+	if path != deleteFile {
+		var err error
+		def, err = volumeDefFromBytes(volumeFile)
+		if err != nil {
+			return &utils.ValidationError{Err: err}
+		}
+	}
+
+	// Remove the previous volume if it was deleted or renamed
+	if prev != nil {
+		prevDef, err := volumeDefFromBytes([]byte(*prev))
+		if err != nil {
+			return &utils.ValidationError{Err: err}
+		}
+		if def == nil || def.Name != prevDef.Name {
+			if err := removeVolume(conn, prevDef.Name); err != nil {
+				return err
+			}
+		}
+	}
+
+	if def == nil {
+		return nil
+	}
+
+	existing, err := volumes.Inspect(conn, def.Name, nil)
+	if err == nil {
+		if !volumeChanged(*def, existing) {
+			log.Infof("Volume %s is up to date", def.Name)
+			return nil
+		}
+		if err := checkOwned(volumeMethod, def.Name, existing.Labels); err != nil {
+			return err
+		}
+		// Driver and options of a volume can't be changed, so it is recreated
+		log.Warnf("Driver or options of volume %s changed, recreating it, ALL DATA IN THE VOLUME IS LOST", def.Name)
+		if err := removeVolume(conn, def.Name); err != nil {
+			return err
+		}
+	}
+
+	if _, err := volumes.Create(conn, createVolumeOptions(*def), nil); err != nil {
+		return &utils.PodmanError{Err: utils.WrapErr(err, "Error creating volume %s", def.Name)}
+	}
+	log.Infof("Volume %s created.", def.Name)
+	return nil
+}
+
+func createVolumeOptions(def VolumeDef) entities.VolumeCreateOptions {
+	labels := map[string]string{}
+	for k, v := range def.Labels {
+		labels[k] = v
+	}
+	// add a label to signify ownership of fetchit <--> this volume
+	labels["owned-by"] = FetchItLabel
+	return entities.VolumeCreateOptions{
+		Name:    def.Name,
+		Driver:  def.Driver,
+		Options: def.Options,
+		Labels:  labels,
+	}
+}
+
+// volumeChanged reports whether an existing volume differs from its definition
+func volumeChanged(def VolumeDef, existing *entities.VolumeConfigResponse) bool {
+	driver := def.Driver
+	if driver == "" {
+		driver = defaultVolumeDriver
+	}
+	if driver != existing.Driver {
+		return true
+	}
+	if len(def.Options) == 0 && len(existing.Options) == 0 {
+		return false
+	}
+	return !reflect.DeepEqual(def.Options, existing.Options)
+}
+
+// removeVolume removes a volume created by fetchit unless containers are still
+// using it, a volume that does not exist is already removed
+func removeVolume(conn context.Context, name string) error {
+	exists, err := volumes.Exists(conn, name, nil)
+	if err != nil {
+		return &utils.PodmanError{Err: utils.WrapErr(err, "Error checking for volume %s", name)}
+	}
+	if !exists {
+		return nil
+	}
+	existing, err := volumes.Inspect(conn, name, nil)
+	if err != nil {
+		return &utils.PodmanError{Err: utils.WrapErr(err, "Error inspecting volume %s", name)}
+	}
+	if err := checkOwned(volumeMethod, name, existing.Labels); err != nil {
+		return err
+	}
+	users, err := containers.List(conn, new(containers.ListOptions).WithAll(true).WithFilters(map[string][]string{"volume": {name}}))
+	if err != nil {
+		return &utils.PodmanError{Err: utils.WrapErr(err, "Error listing containers using volume %s", name)}
+	}
+	if err := checkNotInUse(volumeMethod, name, users); err != nil {
+		return err
+	}
+	if err := volumes.Remove(conn, name, nil); err != nil {
+		return &utils.PodmanError{Err: utils.WrapErr(err, "Error removing volume %s", name)}
+	}
+	logger.Infof("Volume %s removed.", name)
+	return nil
+}
+
+func volumeDefFromBytes(b []byte) (*VolumeDef, error) {
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 {
+		return nil, errors.New("volume file is empty")
+	}
+	def := VolumeDef{}
+	if b[0] == '{' {
+		if err := json.Unmarshal(b, &def); err != nil {
+			return nil, utils.WrapErr(err, "Unable to unmarshal json")
+		}
+	} else {
+		if err := yaml.Unmarshal(b, &def); err != nil {
+			return nil, utils.WrapErr(err, "Unable to unmarshal yaml")
+		}
+	}
+	if def.Name == "" {
+		return nil, errors.New("volume file must set Name")
+	}
+	return &def, nil
+}
+
+// ensureVolume creates a volume when it does not exist
+func ensureVolume(name string, exists func(name string) (bool, error), create func(name string) error) error {
+	found, err := exists(name)
+	if err != nil {
+		return &utils.PodmanError{Err: utils.WrapErr(err, "Error checking for volume %s", name)}
+	}
+	if found {
+		return nil
+	}
+	if err := create(name); err != nil {
+		return &utils.PodmanError{Err: utils.WrapErr(err, "Error creating volume %s", name)}
+	}
+	logger.Infof("Volume %s created.", name)
+	return nil
+}
+
+// ensureFetchitVolume creates the volume that helper containers mount at /opt
+func ensureFetchitVolume(conn context.Context) error {
+	return ensureVolume(fetchitVolume,
+		func(name string) (bool, error) {
+			return volumes.Exists(conn, name, nil)
+		},
+		func(name string) error {
+			_, err := volumes.Create(conn, entities.VolumeCreateOptions{Name: name}, nil)
+			return err
+		})
+}
@@ -0,0 +1,115 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/containers/fetchit/pkg/engine/utils"
+	"github.com/containers/podman/v4/libpod/define"
+	"github.com/containers/podman/v4/pkg/domain/entities"
+	"go.uber.org/zap"
+)
+
+func TestCreateVolumeOptions(t *testing.T) {
+	def, err := volumeDefFromBytes([]byte(`
+Name: app-data
+Options:
+  type: tmpfs
+  device: tmpfs
+  o: size=100m
+Labels:
+  app: colors
+`))
+	if err != nil {
+		t.Fatalf("Failed: unable to parse volume file: %v", err)
+	}
+
+	opts := createVolumeOptions(*def)
+	if opts.Name != "app-data" || opts.Options["o"] != "size=100m" {
+		t.Fatalf("Failed: options: %+v", opts)
+	}
+	if opts.Labels["app"] != "colors" || opts.Labels["owned-by"] != FetchItLabel {
+		t.Fatalf("Failed: labels: %v", opts.Labels)
+	}
+
+	existing := &entities.VolumeConfigResponse{InspectVolumeData: define.InspectVolumeData{
+		Name:    "app-data",
+		Driver:  defaultVolumeDriver,
+		Options: map[string]string{"type": "tmpfs", "device": "tmpfs", "o": "size=100m"},
+	}}
+	if volumeChanged(*def, existing) {
+		t.Fatalf("Failed: volume should be up to date")
+	}
+	def.Options["o"] = "size=200m"
+	if !volumeChanged(*def, existing) {
+		t.Fatalf("Failed: volume options changed")
+	}
+
+	if _, err := volumeDefFromBytes([]byte(`{"Driver": "local"}`)); err == nil {
+		t.Fatalf("Failed: expected error for volume without name")
+	}
+}
+
+func TestCheckNotInUse(t *testing.T) {
+	if err := checkNotInUse(volumeMethod, "app-data", nil); err != nil {
+		t.Fatalf("Failed: unexpected error: %v", err)
+	}
+
+	err := checkNotInUse(volumeMethod, "app-data", []entities.ListContainer{{Names: []string{"colors1"}}, {Names: []string{"colors2"}}})
+	if err == nil {
+		t.Fatalf("Failed: expected error for volume in use")
+	}
+	expected := "volume app-data is in use by colors1, colors2, not removing"
+	if err.Error() != expected {
+		t.Fatalf("Failed: err: %s != %s", err, expected)
+	}
+	if kind := utils.Classify(err); kind != "validation" {
+		t.Fatalf("Failed: kind: %s != validation", kind)
+	}
+}
+
+func TestEnsureVolume(t *testing.T) {
+	defer func(l *zap.SugaredLogger) { logger = l }(logger)
+	logger = zap.NewNop().Sugar()
+
+	created := []string{}
+	create := func(name string) error {
+		created = append(created, name)
+		return nil
+	}
+
+	missing := func(string) (bool, error) { return false, nil }
+	if err := ensureVolume(fetchitVolume, missing, create); err != nil {
+		t.Fatalf("Failed: unexpected error: %v", err)
+	}
+	if len(created) != 1 || created[0] != fetchitVolume {
+		t.Fatalf("Failed: missing volume was not created: %v", created)
+	}
+
+	created = []string{}
+	present := func(string) (bool, error) { return true, nil }
+	if err := ensureVolume(fetchitVolume, present, create); err != nil {
+		t.Fatalf("Failed: unexpected error: %v", err)
+	}
+	if len(created) != 0 {
+		t.Fatalf("Failed: existing volume was recreated: %v", created)
+	}
+
+	failing := func(string) (bool, error) { return false, errors.New("connection refused") }
+	err := ensureVolume(fetchitVolume, failing, create)
+	if _, ok := err.(*utils.PodmanError); !ok {
+		t.Fatalf("Failed: expected a podman error, got %v", err)
+	}
+}
+
+func TestCheckOwned(t *testing.T) {
+	if err := checkOwned(volumeMethod, "app-data", createVolumeOptions(VolumeDef{Name: "app-data"}).Labels); err != nil {
+		t.Fatalf("Failed: unexpected error for a volume created by fetchit: %v", err)
+	}
+	for _, labels := range []map[string]string{nil, {"app": "colors"}, {"owned-by": "someone"}} {
+		err := checkOwned(volumeMethod, "app-data", labels)
+		if _, ok := err.(*utils.ValidationError); !ok {
+			t.Fatalf("Failed: expected a validation error for labels %v, got %v", labels, err)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package engine
+
+import (
+	"github.com/coreos/go-systemd/v22/daemon"
+	"github.com/go-co-op/gocron"
+)
+
+// watchdogTag tags the heartbeat job so it is not mistaken for a method
+const watchdogTag = "systemd-watchdog"
+
+var (
+	sdNotify          = daemon.SdNotify
+	sdWatchdogEnabled = daemon.SdWatchdogEnabled
+)
+
+// notifySystemd sends state to the systemd service manager. It is a no-op
+// returning false when fetchit is not run by a systemd service with NOTIFY_SOCKET.
+func notifySystemd(state string) bool {
+	sent, err := sdNotify(false, state)
+	if err != nil {
+		logger.Warnf("Unable to notify systemd of %s: %v", state, err)
+	}
+	return sent
+}
+
+// startWatchdog schedules heartbeats on s at half the WatchdogSec of the
+// service, so systemd restarts fetchit when the scheduler stops running
+// jobs, and notifies systemd that fetchit is ready. Without a watchdog only
+// readiness is notified.
+func startWatchdog(s *gocron.Scheduler) {
+	interval, err := sdWatchdogEnabled(false)
+	if err != nil {
+		logger.Warnf("Unable to read the systemd watchdog interval: %v", err)
+	}
+	if interval > 0 {
+		beat := interval / 2
+		if _, err := s.Every(beat).Tag(watchdogTag).Do(notifySystemd, daemon.SdNotifyWatchdog); err != nil {
+			logger.Warnf("Unable to schedule systemd watchdog heartbeats: %v", err)
+		} else {
+			logger.Infof("Sending systemd watchdog heartbeats every %s", beat)
+		}
+	}
+	notifySystemd(daemon.SdNotifyReady)
+}
@@ -0,0 +1,73 @@
+package engine
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/daemon"
+	"github.com/go-co-op/gocron"
+	"go.uber.org/zap"
+)
+
+func TestNotifySystemdWithoutSystemd(t *testing.T) {
+	defer func(l *zap.SugaredLogger) { logger = l }(logger)
+	logger = zap.NewNop().Sugar()
+	if socket, ok := os.LookupEnv("NOTIFY_SOCKET"); ok {
+		defer os.Setenv("NOTIFY_SOCKET", socket)
+		os.Unsetenv("NOTIFY_SOCKET")
+	}
+	if notifySystemd(daemon.SdNotifyReady) {
+		t.Fatalf("Failed: notified systemd without NOTIFY_SOCKET")
+	}
+}
+
+func TestStartWatchdog(t *testing.T) {
+	defer func(l *zap.SugaredLogger) { logger = l }(logger)
+	defer func(n func(bool, string) (bool, error), w func(bool) (time.Duration, error)) {
+		sdNotify, sdWatchdogEnabled = n, w
+	}(sdNotify, sdWatchdogEnabled)
+	logger = zap.NewNop().Sugar()
+
+	var states []string
+	beats := make(chan struct{}, 1)
+	sdNotify = func(unset bool, state string) (bool, error) {
+		// heartbeats are sent from the scheduler's goroutines
+		if state == daemon.SdNotifyWatchdog {
+			select {
+			case beats <- struct{}{}:
+			default:
+			}
+			return true, nil
+		}
+		states = append(states, state)
+		return true, nil
+	}
+
+	// without WatchdogSec only readiness is notified
+	sdWatchdogEnabled = func(bool) (time.Duration, error) { return 0, nil }
+	s := gocron.NewScheduler(time.UTC)
+	startWatchdog(s)
+	if s.Len() != 0 || !reflect.DeepEqual(states, []string{daemon.SdNotifyReady}) {
+		t.Fatalf("Failed: %d jobs scheduled, notified %q", s.Len(), states)
+	}
+
+	states = nil
+	sdWatchdogEnabled = func(bool) (time.Duration, error) { return 2 * time.Second, nil }
+	s = gocron.NewScheduler(time.UTC)
+	startWatchdog(s)
+	if s.Len() != 1 || !reflect.DeepEqual(s.Jobs()[0].Tags(), []string{watchdogTag}) {
+		t.Fatalf("Failed: expected one heartbeat job, got %d jobs", s.Len())
+	}
+	if !reflect.DeepEqual(states, []string{daemon.SdNotifyReady}) {
+		t.Fatalf("Failed: notified %q before the scheduler started", states)
+	}
+	s.StartAsync()
+	defer s.Stop()
+	select {
+	case <-beats:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Failed: no heartbeat sent")
+	}
+}